@@ -66,6 +66,18 @@ class ApiClient {
     });
   }
 
+  async sendVerificationEmail() {
+    return this.request('/account/verify-email', {
+      method: 'POST',
+    });
+  }
+
+  async attachGuestBookings() {
+    return this.request<{ attached: number }>('/account/attach-guest-bookings', {
+      method: 'POST',
+    });
+  }
+
   // Search endpoints
   async getAirports() {
     return this.request('/airports');
@@ -101,7 +113,6 @@ class ApiClient {
     segments: Array<{
       flight_id: number;
       fare_id: number;
-      seat_number?: string;
     }>;
     passengers: Array<{
       first_name: string;
@@ -110,16 +121,24 @@ class ApiClient {
       phone?: string;
       date_of_birth?: string;
       passport?: string;
-      ssr?: string;
     }>;
     seats?: Array<{
-      segment_id: number;
+      passenger: number; // index into passengers
+      segment: number; // index into segments
       seat_id: number;
     }>;
-    extras?: Array<{
-      type: string;
-      price: number;
+    ssrs?: Array<{
+      passenger: number; // index into passengers
+      segment: number; // index into segments
+      code: string; // IATA SSR code, e.g. WCHR
+      text?: string;
+    }>;
+    ancillaries?: Array<{
+      passenger: number;
+      segment: number;
+      ancillary_id: number;
     }>;
+    waitlist_id?: number; // books the seats a waitlist offer holds
   }) {
     return this.request('/bookings', {
       method: 'POST',
@@ -127,24 +146,192 @@ class ApiClient {
     });
   }
 
+  // Books without an account; the response's manage_url is the guest's way back
+  async createGuestBooking(data: Parameters<ApiClient['createBooking']>[0] & { email: string }) {
+    return this.request('/guest/bookings', {
+      method: 'POST',
+      body: JSON.stringify(data),
+    });
+  }
+
+  async sendManageLink(pnr: string, lastName: string) {
+    return this.request(`/bookings/by-pnr/${encodeURIComponent(pnr)}/manage-link`, {
+      method: 'POST',
+      body: JSON.stringify({ last_name: lastName }),
+    });
+  }
+
+  // Manage-link endpoints take the token from the emailed link
+  async getManagedBooking(token: string) {
+    return this.request(`/guest/manage/${token}`);
+  }
+
+  async cancelManagedBooking(token: string) {
+    return this.request(`/guest/manage/${token}/cancel`, {
+      method: 'POST',
+    });
+  }
+
+  async createManagedCheckoutSession(token: string, data: { booking_id: number }) {
+    return this.request(`/guest/manage/${token}/checkout-session`, {
+      method: 'POST',
+      body: JSON.stringify(data),
+    });
+  }
+
+  async getBookings(params: {
+    status?: string;
+    when?: 'upcoming' | 'past';
+    from?: string;
+    to?: string;
+    pnr?: string;
+    passenger?: string;
+    limit?: number;
+    cursor?: string;
+  } = {}) {
+    const query = new URLSearchParams();
+    for (const [key, value] of Object.entries(params)) {
+      if (value !== undefined && value !== '') query.set(key, String(value));
+    }
+    const qs = query.toString();
+    return this.request<{ bookings: any[]; next_cursor: string }>(`/bookings${qs ? `?${qs}` : ''}`);
+  }
+
   async getBooking(bookingId: number) {
     return this.request(`/bookings/${bookingId}`);
   }
 
+  async updateSeats(bookingId: number, assignments: Array<{
+    passenger_id: number;
+    segment_id: number;
+    seat_id: number;
+  }>) {
+    return this.request(`/bookings/${bookingId}/seats`, {
+      method: 'PUT',
+      body: JSON.stringify({ assignments }),
+    });
+  }
+
+  async nameGroupPassengers(bookingId: number, passengers: Array<{
+    passenger_id?: number;
+    first_name: string;
+    last_name: string;
+    email?: string;
+    phone?: string;
+    date_of_birth?: string;
+    nationality?: string;
+    passport?: string;
+    passport_expiry?: string;
+    passport_country?: string;
+  }>) {
+    return this.request(`/bookings/${bookingId}/names`, {
+      method: 'POST',
+      body: JSON.stringify({ passengers }),
+    });
+  }
+
   async issueBooking(bookingId: number) {
     return this.request(`/bookings/${bookingId}/issue`, {
       method: 'POST',
     });
   }
 
+  async downloadItinerary(bookingId: number): Promise<Blob> {
+    const token = browser ? localStorage.getItem('accessToken') : null;
+    const response = await fetch(`${this.baseURL}/bookings/${bookingId}/documents/itinerary.pdf`, {
+      headers: token ? { Authorization: `Bearer ${token}` } : {},
+    });
+
+    if (!response.ok) {
+      const error = await response.json().catch(() => ({ error: 'Unknown error' }));
+      throw new Error(error.error || `HTTP ${response.status}`);
+    }
+
+    return response.blob();
+  }
+
+  async downloadCalendar(bookingId: number): Promise<Blob> {
+    const token = browser ? localStorage.getItem('accessToken') : null;
+    const response = await fetch(`${this.baseURL}/bookings/${bookingId}/calendar.ics`, {
+      headers: token ? { Authorization: `Bearer ${token}` } : {},
+    });
+
+    if (!response.ok) {
+      const error = await response.json().catch(() => ({ error: 'Unknown error' }));
+      throw new Error(error.error || `HTTP ${response.status}`);
+    }
+
+    return response.blob();
+  }
+
+  async getCalendarFeed() {
+    return this.request<{ url: string; webcal_url: string }>('/calendar-feed');
+  }
+
+  async revokeCalendarFeed() {
+    return this.request('/calendar-feed', {
+      method: 'DELETE',
+    });
+  }
+
+  async checkIn(bookingId: number, data: { passenger_id: number; segment_id: number }) {
+    return this.request(`/bookings/${bookingId}/check-in`, {
+      method: 'POST',
+      body: JSON.stringify(data),
+    });
+  }
+
+  async getBoardingPasses(bookingId: number) {
+    return this.request(`/bookings/${bookingId}/boarding-passes`);
+  }
+
+  async getBoardingPassBarcode(bookingId: number, passId: number): Promise<Blob> {
+    const token = browser ? localStorage.getItem('accessToken') : null;
+    const response = await fetch(`${this.baseURL}/bookings/${bookingId}/boarding-passes/${passId}/barcode.png`, {
+      headers: token ? { Authorization: `Bearer ${token}` } : {},
+    });
+
+    if (!response.ok) {
+      const error = await response.json().catch(() => ({ error: 'Unknown error' }));
+      throw new Error(error.error || `HTTP ${response.status}`);
+    }
+
+    return response.blob();
+  }
+
+  async splitBooking(bookingId: number, passengerIds: number[]) {
+    return this.request(`/bookings/${bookingId}/split`, {
+      method: 'POST',
+      body: JSON.stringify({ passenger_ids: passengerIds }),
+    });
+  }
+
   async cancelBooking(bookingId: number) {
     return this.request(`/bookings/${bookingId}/cancel`, {
       method: 'POST',
     });
   }
 
+  // Waitlist endpoints
+  async joinWaitlist(data: { flight_id: number; class: 'economy' | 'business' | 'first'; seats: number }) {
+    return this.request('/waitlist', {
+      method: 'POST',
+      body: JSON.stringify(data),
+    });
+  }
+
+  async getWaitlist() {
+    return this.request('/waitlist');
+  }
+
+  async leaveWaitlist(entryId: number) {
+    return this.request(`/waitlist/${entryId}/cancel`, {
+      method: 'POST',
+    });
+  }
+
   // Payment endpoints
-  async createCheckoutSession(data: { booking_id: number }) {
+  async createCheckoutSession(data: { booking_id: number; installment_id?: number }) {
     return this.request('/payments/checkout-session', {
       method: 'POST',
       body: JSON.stringify(data),
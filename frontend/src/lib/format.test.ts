@@ -0,0 +1,10 @@
+import { describe, it, expect } from 'vitest';
+import { formatPrice } from './format';
+
+describe('formatPrice', () => {
+  it('formats minor units in the currency', () => {
+    expect(formatPrice(39999)).toBe('$399.99');
+    expect(formatPrice(15000, 'JPY')).toBe('¥15,000');
+    expect(formatPrice(1234, 'KWD')).toBe('KWD\u00a01.234');
+  });
+});
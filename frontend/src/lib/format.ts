@@ -0,0 +1,9 @@
+// Prices arrive in minor units (cents, or whole yen for JPY)
+export function formatPrice(price: number, currency: string = 'USD'): string {
+  const formatter = new Intl.NumberFormat('en-US', {
+    style: 'currency',
+    currency: currency
+  });
+  const digits = formatter.resolvedOptions().maximumFractionDigits ?? 2;
+  return formatter.format(price / 10 ** digits);
+}
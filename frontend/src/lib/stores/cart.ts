@@ -4,7 +4,6 @@ export interface FlightSegment {
   id: number;
   flight_id: number;
   fare_id: number;
-  seat_number?: string;
   flight: {
     id: number;
     number: string;
@@ -36,7 +35,7 @@ export interface FlightSegment {
     id: number;
     class: string;
     fare_type: string;
-    base_price: number;
+    base_price: number; // minor units of currency
     currency: string;
     available: number;
   };
@@ -45,7 +44,7 @@ export interface FlightSegment {
 export interface CartItem {
   segments: FlightSegment[];
   passengers: number;
-  totalPrice: number;
+  totalPrice: number; // minor units of currency
   currency: string;
 }
 
@@ -5,7 +5,9 @@ import (
 
 	"skyliner/internal/config"
 	"skyliner/internal/db"
+	"skyliner/internal/fx"
 	"skyliner/internal/http"
+	"skyliner/internal/payments"
 	"skyliner/internal/ws"
 
 	"github.com/gin-gonic/gin"
@@ -29,6 +31,21 @@ func main() {
 		log.Fatal("Failed to run migrations:", err)
 	}
 
+	// Load exchange rates
+	rates, err := fx.NewStaticProvider(cfg.FXRatesFile)
+	if err != nil {
+		log.Fatal("Failed to load FX rates:", err)
+	}
+
+	// Payments go to Stripe; without a key refunds are only simulated
+	var pay payments.Client
+	if cfg.StripeSecretKey != "" {
+		pay = payments.NewStripeClient(cfg.StripeSecretKey)
+	} else {
+		log.Println("STRIPE_SECRET_KEY not set, using a stand-in payments client")
+		pay = payments.NewFakeClient()
+	}
+
 	// Initialize WebSocket hub
 	hub := ws.NewHub()
 	go hub.Run()
@@ -37,7 +54,7 @@ func main() {
 	router := gin.Default()
 
 	// Setup routes
-	http.SetupRoutes(router, database, hub, cfg)
+	http.SetupRoutes(router, database, hub, rates, pay, cfg)
 
 	log.Printf("Server starting on port %s", "8080")
 	if err := router.Run(":" + "8080"); err != nil {
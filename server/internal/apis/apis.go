@@ -0,0 +1,195 @@
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	maxNameLength = 35 // longest name most carriers' APIS messages carry
+	infantAge     = 2  // passengers under this travel on an adult's lap
+	adultAge      = 12
+)
+
+// Requirements are the passenger details the authorities at either end of an
+// itinerary ask for. Domestic itineraries need none beyond a name.
+type Requirements struct {
+	International  bool `json:"international"`
+	Passport       bool `json:"passport"`
+	Nationality    bool `json:"nationality"`
+	PassportExpiry bool `json:"passport_expiry"`
+	DateOfBirth    bool `json:"date_of_birth"`
+}
+
+// Leg is one flight of an itinerary as the rules see it.
+type Leg struct {
+	OriginCountry      string
+	DestinationCountry string
+	Departure          time.Time
+	Arrival            time.Time
+}
+
+// Traveler is the identity and document data held for one passenger.
+type Traveler struct {
+	FirstName       string
+	LastName        string
+	DateOfBirth     *time.Time
+	Gender          *string
+	Nationality     *string
+	Passport        *string
+	PassportExpiry  *time.Time
+	PassportCountry *string
+}
+
+// For derives the requirements of an itinerary: any leg crossing a border
+// makes the whole trip international.
+func For(legs []Leg) Requirements {
+	for _, leg := range legs {
+		if !strings.EqualFold(strings.TrimSpace(leg.OriginCountry), strings.TrimSpace(leg.DestinationCountry)) {
+			return Requirements{International: true, Passport: true, Nationality: true, PassportExpiry: true, DateOfBirth: true}
+		}
+	}
+	return Requirements{}
+}
+
+// Validate checks the details a traveler has given, whether or not the route
+// needs them, against the trip they are for.
+func Validate(t Traveler, legs []Leg) error {
+	if err := ValidateName(t.FirstName); err != nil {
+		return fmt.Errorf("first name: %w", err)
+	}
+	if err := ValidateName(t.LastName); err != nil {
+		return fmt.Errorf("last name: %w", err)
+	}
+
+	if t.DateOfBirth != nil && t.DateOfBirth.After(time.Now()) {
+		return errors.New("date of birth is in the future")
+	}
+	if t.Gender != nil {
+		switch *t.Gender {
+		case "M", "F", "X":
+		default:
+			return errors.New("gender must be M, F or X")
+		}
+	}
+	if t.Nationality != nil && !isCountryCode(*t.Nationality) {
+		return errors.New("nationality must be a three-letter ISO country code")
+	}
+	if t.PassportCountry != nil && !isCountryCode(*t.PassportCountry) {
+		return errors.New("passport country must be a three-letter ISO country code")
+	}
+	if t.Passport != nil && !isDocumentNumber(*t.Passport) {
+		return errors.New("passport number must be 5 to 9 letters or digits")
+	}
+	if t.PassportExpiry != nil {
+		for _, leg := range legs {
+			if !t.PassportExpiry.After(leg.Arrival) {
+				return errors.New("passport expires before the end of travel")
+			}
+		}
+	}
+	return nil
+}
+
+// Missing lists the details req asks for that a traveler has not given.
+func Missing(req Requirements, t Traveler) []string {
+	var missing []string
+	if req.Passport && isBlank(t.Passport) {
+		missing = append(missing, "passport")
+	}
+	if req.Nationality && isBlank(t.Nationality) {
+		missing = append(missing, "nationality")
+	}
+	if req.PassportExpiry && t.PassportExpiry == nil {
+		missing = append(missing, "passport_expiry")
+	}
+	if req.DateOfBirth && t.DateOfBirth == nil {
+		missing = append(missing, "date_of_birth")
+	}
+	return missing
+}
+
+// ValidateName accepts names as they appear in a passport's Latin script:
+// letters, with spaces, hyphens and apostrophes between them.
+func ValidateName(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("is required")
+	}
+	if len([]rune(name)) > maxNameLength {
+		return fmt.Errorf("is longer than %d characters", maxNameLength)
+	}
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case unicode.Is(unicode.Latin, r):
+		case (r == ' ' || r == '-' || r == '\'') && i > 0 && i < len(runes)-1:
+		default:
+			return fmt.Errorf("contains %q; use Latin letters, spaces, hyphens and apostrophes", r)
+		}
+	}
+	return nil
+}
+
+// CheckParty makes sure every infant has an adult to travel with. Ages are
+// taken at the first departure; passengers with no date of birth count as
+// adults.
+func CheckParty(dates []*time.Time, departure time.Time) error {
+	infants, adults := 0, 0
+	for _, dob := range dates {
+		if dob == nil {
+			adults++
+			continue
+		}
+		switch age := Age(*dob, departure); {
+		case age < infantAge:
+			infants++
+		case age >= adultAge:
+			adults++
+		}
+	}
+	if infants > adults {
+		return errors.New("each infant must travel with an adult")
+	}
+	return nil
+}
+
+// Age is how many full years old someone born on dob is at on.
+func Age(dob, on time.Time) int {
+	years := on.Year() - dob.Year()
+	if on.Month() < dob.Month() || on.Month() == dob.Month() && on.Day() < dob.Day() {
+		years--
+	}
+	return years
+}
+
+func isCountryCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isDocumentNumber(number string) bool {
+	if len(number) < 5 || len(number) > 9 {
+		return false
+	}
+	for _, r := range number {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func isBlank(s *string) bool {
+	return s == nil || strings.TrimSpace(*s) == ""
+}
@@ -0,0 +1,79 @@
+package apis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func date(year int, month time.Month, day int) *time.Time {
+	t := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	return &t
+}
+
+func str(s string) *string { return &s }
+
+func TestFor(t *testing.T) {
+	domestic := Leg{OriginCountry: "USA", DestinationCountry: "USA"}
+	international := Leg{OriginCountry: "USA", DestinationCountry: "UK"}
+
+	assert.Equal(t, Requirements{}, For([]Leg{domestic}))
+	req := For([]Leg{domestic, international})
+	assert.True(t, req.International)
+	assert.True(t, req.Passport && req.Nationality && req.PassportExpiry && req.DateOfBirth)
+}
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"Ada", "O'Brien", "Anne-Marie", "De la Cruz", "Zoë"} {
+		assert.NoError(t, ValidateName(name), name)
+	}
+	for _, name := range []string{"", "R2D2", "-Ada", "Ada-", "Иван", "Ada!", "Abcdefghijklmnopqrstuvwxyzabcdefghijk"} {
+		assert.Error(t, ValidateName(name), name)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	departure := time.Date(2030, 6, 1, 10, 0, 0, 0, time.UTC)
+	legs := []Leg{{OriginCountry: "USA", DestinationCountry: "UK", Departure: departure, Arrival: departure.Add(7 * time.Hour)}}
+	valid := Traveler{FirstName: "Ada", LastName: "Lovelace", DateOfBirth: date(1990, 12, 10), Gender: str("F"),
+		Nationality: str("GBR"), Passport: str("123456789"), PassportExpiry: date(2031, 1, 1), PassportCountry: str("GBR")}
+	assert.NoError(t, Validate(valid, legs))
+
+	tests := map[string]func(t *Traveler){
+		"name":        func(t *Traveler) { t.LastName = "L0velace" },
+		"future dob":  func(t *Traveler) { t.DateOfBirth = date(2100, 1, 1) },
+		"gender":      func(t *Traveler) { t.Gender = str("female") },
+		"nationality": func(t *Traveler) { t.Nationality = str("UK") },
+		"passport":    func(t *Traveler) { t.Passport = str("12-34") },
+		"expiry":      func(t *Traveler) { t.PassportExpiry = date(2030, 6, 1) },
+	}
+	for name, change := range tests {
+		traveler := valid
+		change(&traveler)
+		assert.Error(t, Validate(traveler, legs), name)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	req := For([]Leg{{OriginCountry: "USA", DestinationCountry: "UK"}})
+	traveler := Traveler{FirstName: "Ada", LastName: "Lovelace", Passport: str(" ")}
+	assert.Equal(t, []string{"passport", "nationality", "passport_expiry", "date_of_birth"}, Missing(req, traveler))
+	assert.Empty(t, Missing(Requirements{}, traveler))
+}
+
+func TestCheckParty(t *testing.T) {
+	departure := time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)
+	infant, child, adult := date(2029, 7, 1), date(2022, 1, 1), date(1990, 1, 1)
+
+	assert.NoError(t, CheckParty([]*time.Time{adult, infant}, departure))
+	assert.NoError(t, CheckParty([]*time.Time{nil, infant}, departure))
+	assert.Error(t, CheckParty([]*time.Time{child, infant}, departure))
+	assert.Error(t, CheckParty([]*time.Time{adult, infant, infant}, departure))
+}
+
+func TestAge(t *testing.T) {
+	assert.Equal(t, 1, Age(*date(2028, 6, 2), *date(2030, 6, 1)))
+	assert.Equal(t, 2, Age(*date(2028, 6, 1), *date(2030, 6, 1)))
+	assert.Equal(t, 1, Age(*date(2028, 2, 29), *date(2030, 2, 28)))
+}
@@ -0,0 +1,103 @@
+// Package bcbp formats IATA Bar Coded Boarding Pass (Resolution 792) data
+// strings, the text a boarding pass barcode carries.
+package bcbp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Leg is one flight on a boarding pass.
+type Leg struct {
+	PNR          string
+	From, To     string // IATA airport codes
+	Carrier      string // IATA airline designator
+	FlightNumber string // e.g. "200" or "200A"
+	Date         time.Time
+	Compartment  string // e.g. "Y", "J", "F"
+	Seat         string // e.g. "12A"
+	Sequence     int    // check-in sequence number
+}
+
+// BoardingPass holds the mandatory items of format M.
+type BoardingPass struct {
+	PassengerName string // "SURNAME/GIVEN NAMES"
+	Legs          []Leg
+}
+
+// Encode returns the data string with the mandatory items only, so each leg
+// ends with a conditional section size of "00". Passengers hold e-tickets.
+func (p BoardingPass) Encode() (string, error) {
+	if len(p.Legs) < 1 || len(p.Legs) > 4 {
+		return "", fmt.Errorf("bcbp: %d legs, need 1 to 4", len(p.Legs))
+	}
+	var b strings.Builder
+	b.WriteString("M")
+	fmt.Fprintf(&b, "%d", len(p.Legs))
+	b.WriteString(field(p.PassengerName, 20))
+	b.WriteString("E")
+	for _, leg := range p.Legs {
+		flight, err := flightNumber(leg.FlightNumber)
+		if err != nil {
+			return "", err
+		}
+		seat, err := seatNumber(leg.Seat)
+		if err != nil {
+			return "", err
+		}
+		if leg.Sequence < 0 || leg.Sequence > 9999 {
+			return "", fmt.Errorf("bcbp: check-in sequence %d out of range", leg.Sequence)
+		}
+		b.WriteString(field(leg.PNR, 7))
+		b.WriteString(field(leg.From, 3))
+		b.WriteString(field(leg.To, 3))
+		b.WriteString(field(leg.Carrier, 3))
+		b.WriteString(flight)
+		fmt.Fprintf(&b, "%03d", leg.Date.YearDay())
+		b.WriteString(field(leg.Compartment, 1))
+		b.WriteString(seat)
+		fmt.Fprintf(&b, "%04d ", leg.Sequence)
+		b.WriteString("1")  // passenger status: checked in
+		b.WriteString("00") // no conditional items
+	}
+	return b.String(), nil
+}
+
+// field upper-cases s and pads or cuts it to width.
+func field(s string, width int) string {
+	s = strings.ToUpper(s)
+	var b strings.Builder
+	for _, r := range s {
+		if r > unicode.MaxASCII {
+			r = '?'
+		}
+		b.WriteRune(r)
+	}
+	s = b.String()
+	if len(s) > width {
+		return s[:width]
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
+
+// flightNumber is four digits and an optional suffix letter, e.g. "0200 ".
+func flightNumber(s string) (string, error) {
+	digits := strings.TrimRightFunc(s, unicode.IsLetter)
+	suffix := s[len(digits):]
+	if digits == "" || len(digits) > 4 || len(suffix) > 1 || strings.TrimLeftFunc(digits, unicode.IsDigit) != "" {
+		return "", fmt.Errorf("bcbp: invalid flight number %q", s)
+	}
+	return fmt.Sprintf("%04s%s", digits, field(suffix, 1)), nil
+}
+
+// seatNumber is a three-digit row and a column letter, e.g. "012A".
+func seatNumber(s string) (string, error) {
+	row := strings.TrimRightFunc(s, unicode.IsLetter)
+	column := s[len(row):]
+	if row == "" || len(row) > 3 || len(column) != 1 || strings.TrimLeftFunc(row, unicode.IsDigit) != "" {
+		return "", fmt.Errorf("bcbp: invalid seat %q", s)
+	}
+	return fmt.Sprintf("%03s%s", row, strings.ToUpper(column)), nil
+}
@@ -0,0 +1,49 @@
+package bcbp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncode(t *testing.T) {
+	pass := BoardingPass{
+		PassengerName: "Lovelace/Ada",
+		Legs: []Leg{{
+			PNR:          "abc234",
+			From:         "JFK",
+			To:           "LHR",
+			Carrier:      "BA",
+			FlightNumber: "200",
+			Date:         time.Date(2026, 11, 21, 0, 0, 0, 0, time.UTC),
+			Compartment:  "Y",
+			Seat:         "12a",
+			Sequence:     7,
+		}},
+	}
+	data, err := pass.Encode()
+	assert.NoError(t, err)
+	assert.Equal(t, "M1LOVELACE/ADA        EABC234 JFKLHRBA 0200 325Y012A0007 100", data)
+	assert.Len(t, data, 60)
+
+	pass.Legs[0].FlightNumber = "15B"
+	pass.PassengerName = "Wolfeschlegelsteinhausen/Hubert"
+	data, err = pass.Encode()
+	assert.NoError(t, err)
+	assert.Equal(t, "WOLFESCHLEGELSTEINHA", data[2:22])
+	assert.Equal(t, "0015B", data[39:44])
+
+	for _, bad := range []Leg{
+		{FlightNumber: "BA200", Seat: "12A"},
+		{FlightNumber: "12345", Seat: "12A"},
+		{FlightNumber: "200", Seat: "A12"},
+		{FlightNumber: "200", Seat: "1234A"},
+		{FlightNumber: "200", Seat: "12A", Sequence: 10000},
+	} {
+		_, err := BoardingPass{PassengerName: "A/B", Legs: []Leg{bad}}.Encode()
+		assert.Error(t, err, "%+v", bad)
+	}
+	_, err = BoardingPass{PassengerName: "A/B"}.Encode()
+	assert.Error(t, err)
+}
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strings"
 	"time"
+
+	"skyliner/internal/money"
 )
 
 type Config struct {
@@ -16,6 +20,22 @@ type Config struct {
 	StripeWebhookSecret string
 	CORSOrigins         []string
 	Port                string
+	FXRatesFile         string
+	IdempotencyTTL      time.Duration
+	HoldTTL             time.Duration
+	WaiverApprovalLimit money.Money   // waivers above this need an admin
+	CreditValidity      time.Duration // how long travel credits and vouchers can be spent; 0 never expires
+	AutoTicket          bool          // issue tickets as soon as a booking is paid
+	WalletPassTypeID    string        // Apple Wallet pass type and team for boarding passes
+	WalletTeamID        string
+	PublicURL           string // where the API is reached from outside, for links such as calendar feeds
+	SMTPHost            string // mail relay; without one emails are only logged
+	SMTPPort            string
+	SMTPUsername        string
+	SMTPPassword        string
+	MailFrom            string
+	WaitlistOfferTTL    time.Duration // how long seats offered from a waitlist are held
+	SweepInterval       time.Duration // how often expired holds and offers are released; 0 disables
 }
 
 func Load() (*Config, error) {
@@ -28,7 +48,28 @@ func Load() (*Config, error) {
 		GoogleClientID:      getEnv("GOOGLE_CLIENT_ID", ""),
 		StripeSecretKey:     getEnv("STRIPE_SECRET_KEY", ""),
 		StripeWebhookSecret: getEnv("STRIPE_WEBHOOK_SECRET", ""),
+		FXRatesFile:         getEnv("FX_RATES_FILE", ""),
+		IdempotencyTTL:      parseDuration(getEnv("IDEMPOTENCY_TTL", "24h")),
+		HoldTTL:             parseDuration(getEnv("HOLD_TTL", "24h")),
+		CreditValidity:      parseDuration(getEnv("CREDIT_VALIDITY", "8760h")),
+		AutoTicket:          getEnv("AUTO_TICKET", "false") == "true",
+		WalletPassTypeID:    getEnv("WALLET_PASS_TYPE_ID", ""),
+		WalletTeamID:        getEnv("WALLET_TEAM_ID", ""),
+		PublicURL:           getEnv("PUBLIC_URL", "http://localhost:8080"),
+		SMTPHost:            getEnv("SMTP_HOST", ""),
+		SMTPPort:            getEnv("SMTP_PORT", "587"),
+		SMTPUsername:        getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:        getEnv("SMTP_PASSWORD", ""),
+		MailFrom:            getEnv("MAIL_FROM", "Skyliner <no-reply@skyliner.local>"),
+		WaitlistOfferTTL:    parseDuration(getEnv("WAITLIST_OFFER_TTL", "2h")),
+		SweepInterval:       parseDuration(getEnv("SWEEP_INTERVAL", "1m")),
+	}
+
+	limit, err := parseMoney(getEnv("WAIVER_APPROVAL_LIMIT", "100.00 USD"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WAIVER_APPROVAL_LIMIT: %w", err)
 	}
+	config.WaiverApprovalLimit = limit
 
 	return config, nil
 }
@@ -54,3 +95,12 @@ func parseDuration(s string) time.Duration {
 	}
 	return d
 }
+
+// parseMoney reads an amount written as "100.00 USD".
+func parseMoney(s string) (money.Money, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return money.Money{}, fmt.Errorf("expected \"<amount> <currency>\", got %q", s)
+	}
+	return money.Parse(fields[0], fields[1])
+}
@@ -5,6 +5,8 @@ import (
 	"testing"
 	"time"
 
+	"skyliner/internal/money"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,6 +26,24 @@ func TestLoad(t *testing.T) {
 	assert.Equal(t, "change_me", cfg.JWTSecret)
 	assert.Equal(t, 15*time.Minute, cfg.JWTAccessTTL)
 	assert.Equal(t, 168*time.Hour, cfg.JWTRefreshTTL)
+	assert.Equal(t, 24*time.Hour, cfg.IdempotencyTTL)
+	assert.Equal(t, 24*time.Hour, cfg.HoldTTL)
+	assert.Equal(t, 365*24*time.Hour, cfg.CreditValidity)
+	assert.False(t, cfg.AutoTicket)
+	assert.Equal(t, "http://localhost:8080", cfg.PublicURL)
+	assert.Equal(t, "", cfg.SMTPHost)
+	assert.Equal(t, "587", cfg.SMTPPort)
+	assert.Equal(t, 2*time.Hour, cfg.WaitlistOfferTTL)
+	assert.Equal(t, time.Minute, cfg.SweepInterval)
+	assert.Equal(t, money.New(10000, "USD"), cfg.WaiverApprovalLimit)
+}
+
+func TestLoadInvalidWaiverLimit(t *testing.T) {
+	os.Setenv("WAIVER_APPROVAL_LIMIT", "100")
+	defer os.Unsetenv("WAIVER_APPROVAL_LIMIT")
+
+	_, err := Load()
+	assert.Error(t, err)
 }
 
 func TestLoadWithEnvVars(t *testing.T) {
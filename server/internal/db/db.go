@@ -13,12 +13,20 @@ import (
 
 func New(databaseURL string) (*gorm.DB, error) {
 	db, err := gorm.Open(postgres.Open(databaseURL), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger:         logger.Default.LogMode(logger.Info),
+		TranslateError: true,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	if err := migrateMoneyToMinorUnits(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+	if err := dropUserEmailIndex(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
 	// Auto-migrate all models
 	if err := db.AutoMigrate(
 		&models.User{},
@@ -28,15 +36,47 @@ func New(databaseURL string) (*gorm.DB, error) {
 		&models.Fare{},
 		&models.SeatMap{},
 		&models.Seat{},
+		&models.SSRType{},
+		&models.AirlineSSR{},
+		&models.Ancillary{},
 		&models.Booking{},
 		&models.Itinerary{},
 		&models.Segment{},
+		&models.SeatAssignment{},
+		&models.Exchange{},
+		&models.ExchangeSegment{},
 		&models.Passenger{},
+		&models.APISRecord{},
+		&models.SSRRequest{},
+		&models.AncillaryPurchase{},
+		&models.Ticket{},
+		&models.Coupon{},
+		&models.BoardingPass{},
+		&models.GroupTerms{},
+		&models.GroupInstallment{},
+		&models.BookingSplit{},
+		&models.WaitlistEntry{},
+		&models.BookingRemark{},
+		&models.QueueItem{},
 		&models.Payment{},
+		&models.PaymentAllocation{},
+		&models.Refund{},
+		&models.Credit{},
+		&models.CreditTransaction{},
+		&models.Promotion{},
+		&models.PromotionRedemption{},
+		&models.PromotionUsage{},
+		&models.Waiver{},
+		&models.RepriceJob{},
+		&models.RepriceJobItem{},
 		&models.Baggage{},
+		&models.IdempotencyKey{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
+	if err := upperCaseLegacyPNRs(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
 
 	log.Println("Database connected and migrated successfully")
 	return db, nil
@@ -25,12 +25,41 @@ func TestNew(t *testing.T) {
 		&models.Fare{},
 		&models.SeatMap{},
 		&models.Seat{},
+		&models.SSRType{},
+		&models.AirlineSSR{},
+		&models.Ancillary{},
 		&models.Booking{},
 		&models.Itinerary{},
 		&models.Segment{},
+		&models.SeatAssignment{},
+		&models.Exchange{},
+		&models.ExchangeSegment{},
 		&models.Passenger{},
+		&models.APISRecord{},
+		&models.SSRRequest{},
+		&models.AncillaryPurchase{},
+		&models.Ticket{},
+		&models.Coupon{},
+		&models.BoardingPass{},
+		&models.GroupTerms{},
+		&models.GroupInstallment{},
+		&models.BookingSplit{},
+		&models.WaitlistEntry{},
+		&models.BookingRemark{},
+		&models.QueueItem{},
 		&models.Payment{},
+		&models.PaymentAllocation{},
+		&models.Refund{},
+		&models.Credit{},
+		&models.CreditTransaction{},
+		&models.Promotion{},
+		&models.PromotionRedemption{},
+		&models.PromotionUsage{},
+		&models.Waiver{},
+		&models.RepriceJob{},
+		&models.RepriceJobItem{},
 		&models.Baggage{},
+		&models.IdempotencyKey{},
 	)
 	assert.NoError(t, err)
 
@@ -46,3 +75,22 @@ func TestNewWithInvalidURL(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to connect to database")
 }
+
+func TestUpperCaseLegacyPNRs(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Booking{}, &models.BookingSplit{}, &models.RepriceJobItem{}))
+	db.Create(&[]models.Booking{{PNR: "SKYt3k9zq", UserID: 1}, {PNR: "ABC234", UserID: 1}})
+	db.Create(&models.BookingSplit{BookingID: 1, PNR: "SKYt3k9zq", SplitBookingID: 3, SplitPNR: "XYZ789", Currency: "USD"})
+
+	assert.NoError(t, upperCaseLegacyPNRs(db))
+	assert.NoError(t, upperCaseLegacyPNRs(db))
+
+	var codes []string
+	db.Model(&models.Booking{}).Order("id").Pluck("pnr", &codes)
+	assert.Equal(t, []string{"SKYT3K9ZQ", "ABC234"}, codes)
+	var split models.BookingSplit
+	db.First(&split)
+	assert.Equal(t, "SKYT3K9ZQ", split.PNR)
+	assert.Equal(t, "XYZ789", split.SplitPNR)
+}
@@ -1,7 +1,13 @@
 package db
 
 import (
+	"fmt"
 	"log"
+	"sort"
+	"strings"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
 
 	"gorm.io/gorm"
 )
@@ -12,3 +18,106 @@ func Migrate(db *gorm.DB) error {
 	log.Println("Database migrations completed")
 	return nil
 }
+
+// moneyColumns are the amounts that used to be stored as float major units.
+// The currency column decides how many decimals each row is scaled by.
+var moneyColumns = []struct {
+	table, column, currency string
+}{
+	{"bookings", "total_amount", "currency"},
+	{"payments", "amount", "currency"},
+	{"fares", "base_price", "currency"},
+	{"baggages", "price", "'USD'"},
+	{"seats", "price", "'USD'"},
+}
+
+// migrateMoneyToMinorUnits converts legacy float money columns to bigint minor
+// units. It must run before AutoMigrate, which would otherwise cast the old
+// values to bigint without scaling them.
+func migrateMoneyToMinorUnits(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	scale := scaleExpression()
+	for _, col := range moneyColumns {
+		var dataType string
+		err := db.Raw(
+			"SELECT data_type FROM information_schema.columns WHERE table_name = ? AND column_name = ?",
+			col.table, col.column,
+		).Scan(&dataType).Error
+		if err != nil {
+			return err
+		}
+		if dataType != "double precision" && dataType != "real" && dataType != "numeric" {
+			continue
+		}
+
+		stmt := fmt.Sprintf(
+			"ALTER TABLE %s ALTER COLUMN %s TYPE bigint USING round(%s * %s)",
+			col.table, col.column, col.column, fmt.Sprintf(scale, col.currency),
+		)
+		if err := db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("failed to convert %s.%s to minor units: %w", col.table, col.column, err)
+		}
+		log.Printf("Converted %s.%s to minor units", col.table, col.column)
+	}
+	return nil
+}
+
+// scaleExpression builds a CASE over the currency column that yields
+// 10^exponent, with %[1]s standing in for the currency expression.
+func scaleExpression() string {
+	var b strings.Builder
+	b.WriteString("CASE upper(%[1]s)")
+	exponents := money.NonStandardExponents()
+	codes := make([]string, 0, len(exponents))
+	for code := range exponents {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(&b, " WHEN '%s' THEN %d", code, pow10(exponents[code]))
+	}
+	b.WriteString(" ELSE 100 END")
+	return b.String()
+}
+
+func pow10(exp int) int {
+	n := 1
+	for i := 0; i < exp; i++ {
+		n *= 10
+	}
+	return n
+}
+
+// dropUserEmailIndex removes the unique index on users.email, which guest
+// users made for the same email as an account would break. Email is unique
+// per guest flag instead, through idx_users_email_guest.
+func dropUserEmailIndex(db *gorm.DB) error {
+	if !db.Migrator().HasIndex(&models.User{}, "idx_users_email") {
+		return nil
+	}
+	return db.Migrator().DropIndex(&models.User{}, "idx_users_email")
+}
+
+// pnrColumns hold record locators a traveler might type back in.
+var pnrColumns = []struct{ table, column string }{
+	{"bookings", "pnr"},
+	{"booking_splits", "pnr"},
+	{"booking_splits", "split_pnr"},
+	{"reprice_job_items", "pnr"},
+}
+
+// upperCaseLegacyPNRs upper-cases the "SKY" locators issued before locators
+// were random, whose base-36 part was lower case. Lookups normalise what the
+// traveler types to upper case, so these could not be found.
+func upperCaseLegacyPNRs(db *gorm.DB) error {
+	for _, col := range pnrColumns {
+		stmt := fmt.Sprintf("UPDATE %[1]s SET %[2]s = UPPER(%[2]s) WHERE %[2]s LIKE 'SKY%%' AND %[2]s <> UPPER(%[2]s)", col.table, col.column)
+		if err := db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("failed to upper-case %s.%s: %w", col.table, col.column, err)
+		}
+	}
+	return nil
+}
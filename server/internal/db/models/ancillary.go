@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"skyliner/internal/money"
+)
+
+type AncillaryType string
+
+const (
+	AncillaryBaggage          AncillaryType = "baggage"
+	AncillaryMeal             AncillaryType = "meal"
+	AncillaryLounge           AncillaryType = "lounge"
+	AncillaryPriorityBoarding AncillaryType = "priority_boarding"
+)
+
+// Ancillary is a product an airline sells on top of its fares. Route and
+// Cabin narrow where it is sold; empty means on every route or in every cabin.
+type Ancillary struct {
+	ID              uint          `json:"id" gorm:"primaryKey"`
+	AirlineID       uint          `json:"airline_id" gorm:"not null;index"`
+	Type            AncillaryType `json:"type" gorm:"not null"`
+	Code            string        `json:"code" gorm:"not null"` // e.g. BAG23
+	Name            string        `json:"name" gorm:"not null"`
+	Route           string        `json:"route"` // origin and destination codes, e.g. "JFK-LHR"
+	Cabin           string        `json:"cabin"`
+	Price           int64         `json:"price" gorm:"not null"` // minor units of Currency
+	Currency        string        `json:"currency" gorm:"not null"`
+	Weight          int           `json:"weight"`            // checked bag allowance in kg
+	FlightLimit     int           `json:"flight_limit"`      // most sold per flight, 0 for no limit
+	MaxPerPassenger int           `json:"max_per_passenger"` // most one passenger can buy per segment, 0 for no limit
+	Active          bool          `json:"active" gorm:"not null"`
+	CreatedAt       time.Time     `json:"created_at"`
+	UpdatedAt       time.Time     `json:"updated_at"`
+}
+
+func (a Ancillary) Money() money.Money {
+	return money.New(a.Price, a.Currency)
+}
+
+type AncillaryStatus string
+
+const (
+	AncillaryPendingPayment AncillaryStatus = "pending_payment" // added to a paid booking, waiting for its own payment
+	AncillaryActive         AncillaryStatus = "active"
+	AncillaryCancelled      AncillaryStatus = "cancelled"
+)
+
+// AncillaryPurchase is one ancillary bought for one passenger on one segment,
+// at the price it was sold for.
+type AncillaryPurchase struct {
+	ID              uint            `json:"id" gorm:"primaryKey"`
+	BookingID       uint            `json:"booking_id" gorm:"not null;index"`
+	PassengerID     uint            `json:"passenger_id" gorm:"not null"`
+	SegmentID       uint            `json:"segment_id" gorm:"not null"`
+	FlightID        uint            `json:"flight_id" gorm:"not null;index"`
+	AncillaryID     uint            `json:"ancillary_id" gorm:"not null;index"`
+	Type            AncillaryType   `json:"type" gorm:"not null"`
+	Code            string          `json:"code" gorm:"not null"`
+	Name            string          `json:"name"`
+	Price           int64           `json:"price"` // minor units of Currency
+	Currency        string          `json:"currency" gorm:"not null"`
+	Status          AncillaryStatus `json:"status" gorm:"not null;index"`
+	StripeSessionID *string         `json:"stripe_session_id"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+}
+
+func (p AncillaryPurchase) Money() money.Money {
+	return money.New(p.Price, p.Currency)
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// APISRecord is the advance passenger information sent to border authorities
+// for one passenger: who they are and the travel document they carry. Names
+// are upper case as on the document.
+type APISRecord struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	BookingID      uint       `json:"booking_id" gorm:"not null;index"`
+	PassengerID    uint       `json:"passenger_id" gorm:"not null;uniqueIndex"`
+	Surname        string     `json:"surname" gorm:"not null"`
+	GivenNames     string     `json:"given_names" gorm:"not null"`
+	DateOfBirth    *time.Time `json:"date_of_birth"`
+	Gender         *string    `json:"gender"` // M, F or X
+	Nationality    *string    `json:"nationality"`
+	DocumentType   string     `json:"document_type" gorm:"not null;default:P"` // P for passport
+	DocumentNumber *string    `json:"document_number"`
+	DocumentExpiry *time.Time `json:"document_expiry"`
+	IssuingCountry *string    `json:"issuing_country"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"skyliner/internal/money"
 )
 
 type BookingStatus string
@@ -18,17 +20,65 @@ type Booking struct {
 	PNR             string        `json:"pnr" gorm:"uniqueIndex;not null"`
 	UserID          uint          `json:"user_id" gorm:"not null"`
 	Status          BookingStatus `json:"status" gorm:"default:hold"`
-	TotalAmount     float64       `json:"total_amount" gorm:"not null"`
-	Currency        string        `json:"currency" gorm:"default:USD"`
+	TotalAmount     int64         `json:"total_amount" gorm:"not null"` // minor units of Currency
+	Currency        string        `json:"currency" gorm:"default:USD"`  // settlement currency charged to the customer
+	BaseAmount      int64         `json:"base_amount"`                  // minor units of BaseCurrency, before conversion
+	BaseCurrency    string        `json:"base_currency"`                // currency the fares are filed in
+	FXRate          string        `json:"fx_rate,omitempty"`            // BaseCurrency -> Currency rate applied, empty if none
+	FXRateAsOf      *time.Time    `json:"fx_rate_as_of,omitempty"`
 	StripeSessionID *string       `json:"stripe_session_id"`
+	HoldExpiresAt   *time.Time    `json:"hold_expires_at"` // unpaid bookings cannot be paid for after this
 	CreatedAt       time.Time     `json:"created_at"`
 	UpdatedAt       time.Time     `json:"updated_at"`
 
 	// Relations
-	User       User        `json:"user" gorm:"-"`
-	Itinerary  Itinerary   `json:"itinerary" gorm:"-"`
-	Passengers []Passenger `json:"passengers,omitempty" gorm:"-"`
-	Payments   []Payment   `json:"payments,omitempty" gorm:"-"`
+	User         User                  `json:"user"`
+	Itinerary    Itinerary             `json:"itinerary"`
+	Passengers   []Passenger           `json:"passengers,omitempty"`
+	Payments     []Payment             `json:"payments,omitempty"`
+	PaymentsIn   []PaymentAllocation   `json:"payments_in,omitempty" gorm:"foreignKey:ToBookingID"`    // shares of payments made on the booking it was split from
+	PaymentsOut  []PaymentAllocation   `json:"payments_out,omitempty" gorm:"foreignKey:FromBookingID"` // shares given to bookings split from it
+	Discounts    []PromotionRedemption `json:"discounts,omitempty"`
+	Ancillaries  []AncillaryPurchase   `json:"ancillaries,omitempty"`
+	Tickets      []Ticket              `json:"tickets,omitempty"`
+	Group        *GroupTerms           `json:"group,omitempty"`
+	Installments []GroupInstallment    `json:"installments,omitempty"`
+	SplitFrom    *BookingSplit         `json:"split_from,omitempty" gorm:"foreignKey:SplitBookingID"`
+	Splits       []BookingSplit        `json:"splits,omitempty" gorm:"foreignKey:BookingID"`
+	Remarks      []BookingRemark       `json:"remarks,omitempty"`
+}
+
+func (b Booking) Total() money.Money {
+	return money.New(b.TotalAmount, b.Currency)
+}
+
+// Base is the amount in the fare currency. Bookings made before settlement
+// currencies were recorded only have a total, which is then the base.
+func (b Booking) Base() money.Money {
+	if b.BaseCurrency == "" {
+		return b.Total()
+	}
+	return money.New(b.BaseAmount, b.BaseCurrency)
+}
+
+// HoldExpired reports whether an unpaid booking is past its payment deadline.
+func (b Booking) HoldExpired(now time.Time) bool {
+	return b.Status == StatusHold && b.HoldExpiresAt != nil && now.After(*b.HoldExpiresAt)
+}
+
+// BookingSplit records passengers moved off a booking onto a new one, and
+// links the two both ways.
+type BookingSplit struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	BookingID      uint      `json:"booking_id" gorm:"not null;index"` // the booking split
+	PNR            string    `json:"pnr" gorm:"not null"`
+	SplitBookingID uint      `json:"split_booking_id" gorm:"not null;uniqueIndex"`
+	SplitPNR       string    `json:"split_pnr" gorm:"not null"`
+	Passengers     int       `json:"passengers" gorm:"not null"` // how many were moved
+	Amount         int64     `json:"amount"`                     // minor units of Currency moved with them
+	Currency       string    `json:"currency" gorm:"not null"`
+	CreatedBy      uint      `json:"created_by"`
+	CreatedAt      time.Time `json:"created_at"`
 }
 
 type Itinerary struct {
@@ -38,7 +88,7 @@ type Itinerary struct {
 	UpdatedAt time.Time `json:"updated_at"`
 
 	// Relations
-	Segments []Segment `json:"segments,omitempty" gorm:"-"`
+	Segments []Segment `json:"segments,omitempty"`
 }
 
 type Segment struct {
@@ -46,43 +96,99 @@ type Segment struct {
 	ItineraryID uint      `json:"itinerary_id" gorm:"not null"`
 	FlightID    uint      `json:"flight_id" gorm:"not null"`
 	FareID      uint      `json:"fare_id" gorm:"not null"`
-	SeatNumber  *string   `json:"seat_number"`
+	Price       *int64    `json:"price,omitempty"` // negotiated per-passenger price in the fare's currency, instead of the fare's
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Relations
+	Itinerary       Itinerary        `json:"itinerary"`
+	Flight          Flight           `json:"flight"`
+	Fare            Fare             `json:"fare"`
+	SeatAssignments []SeatAssignment `json:"seat_assignments,omitempty"`
+}
+
+// FarePrice is what each passenger pays for the segment. Fare must be loaded.
+func (s Segment) FarePrice() money.Money {
+	if s.Price != nil {
+		return money.New(*s.Price, s.Fare.Currency)
+	}
+	return s.Fare.Price()
+}
+
+// SeatAssignment places one passenger in one seat on one segment.
+type SeatAssignment struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	BookingID   uint      `json:"booking_id" gorm:"not null;index"`
+	PassengerID uint      `json:"passenger_id" gorm:"not null;uniqueIndex:idx_seat_assignment_passenger"`
+	SegmentID   uint      `json:"segment_id" gorm:"not null;uniqueIndex:idx_seat_assignment_passenger"`
+	SeatID      uint      `json:"seat_id" gorm:"not null;uniqueIndex"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 
 	// Relations
-	Itinerary Itinerary `json:"itinerary" gorm:"-"`
-	Flight    Flight    `json:"flight" gorm:"-"`
-	Fare      Fare      `json:"fare" gorm:"-"`
+	Seat Seat `json:"seat"`
 }
 
 type Passenger struct {
 	ID          uint       `json:"id" gorm:"primaryKey"`
 	BookingID   uint       `json:"booking_id" gorm:"not null"`
-	FirstName   string     `json:"first_name" gorm:"not null"`
+	FirstName   string     `json:"first_name" gorm:"not null"` // empty on group seats not yet named
 	LastName    string     `json:"last_name" gorm:"not null"`
 	Email       string     `json:"email"`
 	Phone       string     `json:"phone"`
 	DateOfBirth *time.Time `json:"date_of_birth"`
 	Passport    *string    `json:"passport"`
-	SSR         *string    `json:"ssr"` // Special Service Request
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at"`
 
 	// Relations
-	Booking Booking `json:"booking" gorm:"-"`
+	Booking Booking      `json:"booking"`
+	APIS    *APISRecord  `json:"apis,omitempty" gorm:"foreignKey:PassengerID"`
+	SSRs    []SSRRequest `json:"ssrs,omitempty"`
+}
+
+// Named reports whether the passenger has been named; group seats start
+// without names.
+func (p Passenger) Named() bool {
+	return p.LastName != ""
 }
 
 type Payment struct {
 	ID              uint      `json:"id" gorm:"primaryKey"`
 	BookingID       uint      `json:"booking_id" gorm:"not null"`
-	StripePaymentID string    `json:"stripe_payment_id" gorm:"not null"`
-	Amount          float64   `json:"amount" gorm:"not null"`
+	StripePaymentID string    `json:"stripe_payment_id" gorm:"not null"` // empty for credit payments
+	Amount          int64     `json:"amount" gorm:"not null"`            // minor units of Currency
 	Currency        string    `json:"currency" gorm:"default:USD"`
+	Method          string    `json:"method" gorm:"default:card"` // card or credit
 	Status          string    `json:"status" gorm:"not null"`
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
 
 	// Relations
-	Booking Booking `json:"booking" gorm:"-"`
+	Booking Booking `json:"booking"`
+}
+
+func (p Payment) Money() money.Money {
+	return money.New(p.Amount, p.Currency)
+}
+
+// PaymentAllocation gives part of a payment taken on one booking to another
+// when passengers are split off. The payment stays as captured so refunds
+// against it still add up; a booking's share of a payment is what was paid on
+// it plus what was allocated to it, less what was allocated away.
+type PaymentAllocation struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	PaymentID     uint      `json:"payment_id" gorm:"not null;index"`
+	FromBookingID uint      `json:"from_booking_id" gorm:"not null;index"`
+	ToBookingID   uint      `json:"to_booking_id" gorm:"not null;index"`
+	Amount        int64     `json:"amount" gorm:"not null"` // minor units of Currency
+	Currency      string    `json:"currency" gorm:"not null"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	// Relations
+	Payment *Payment `json:"payment,omitempty"`
+}
+
+func (a PaymentAllocation) Money() money.Money {
+	return money.New(a.Amount, a.Currency)
 }
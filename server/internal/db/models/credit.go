@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"skyliner/internal/money"
+)
+
+type CreditKind string
+
+const (
+	CreditTravel  CreditKind = "credit"  // value kept from the customer's own booking
+	CreditVoucher CreditKind = "voucher" // goodwill issued by staff
+)
+
+type CreditTransactionType string
+
+const (
+	CreditIssued   CreditTransactionType = "issue"
+	CreditRedeemed CreditTransactionType = "redeem"
+	CreditReleased CreditTransactionType = "release" // a redemption given back, e.g. the booking was cancelled unpaid
+)
+
+// Credit is stored value a user can spend on bookings in its currency.
+// Balance is kept alongside the ledger so redemptions can be made with a
+// conditional update; the ledger is the history behind it.
+type Credit struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	Kind      CreditKind `json:"kind" gorm:"not null"`
+	Source    string     `json:"source" gorm:"not null"` // cancellation, exchange, goodwill
+	BookingID *uint      `json:"booking_id"`             // booking the value came from
+	Amount    int64      `json:"amount" gorm:"not null"` // issued, minor units of Currency
+	Balance   int64      `json:"balance" gorm:"not null"`
+	Currency  string     `json:"currency" gorm:"not null"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	IssuedBy  *uint      `json:"issued_by"`
+	Note      string     `json:"note"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+
+	// Relations
+	Transactions []CreditTransaction `json:"transactions,omitempty"`
+}
+
+func (c Credit) Remaining() money.Money {
+	return money.New(c.Balance, c.Currency)
+}
+
+// Expired reports whether the credit can no longer be spent.
+func (c Credit) Expired(now time.Time) bool {
+	return c.ExpiresAt != nil && !now.Before(*c.ExpiresAt)
+}
+
+// CreditTransaction is one ledger entry. Amount is signed: issues and releases
+// add to the balance, redemptions take from it.
+type CreditTransaction struct {
+	ID        uint                  `json:"id" gorm:"primaryKey"`
+	CreditID  uint                  `json:"credit_id" gorm:"not null;index"`
+	BookingID *uint                 `json:"booking_id" gorm:"index"`
+	Type      CreditTransactionType `json:"type" gorm:"not null"`
+	Amount    int64                 `json:"amount" gorm:"not null"`
+	CreatedAt time.Time             `json:"created_at"`
+}
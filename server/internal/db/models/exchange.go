@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"skyliner/internal/money"
+)
+
+type ExchangeStatus string
+
+const (
+	ExchangePendingPayment ExchangeStatus = "pending_payment"
+	ExchangeCompleted      ExchangeStatus = "completed"
+	ExchangeCancelled      ExchangeStatus = "cancelled"
+)
+
+// Exchange is a voluntary change of flights on an existing booking. Amounts
+// are in the booking's settlement currency.
+type Exchange struct {
+	ID              uint           `json:"id" gorm:"primaryKey"`
+	BookingID       uint           `json:"booking_id" gorm:"not null;index"`
+	Status          ExchangeStatus `json:"status" gorm:"not null"`
+	FareDifference  int64          `json:"fare_difference"` // new fares minus old fares, may be negative
+	ChangeFee       int64          `json:"change_fee"`
+	AmountDue       int64          `json:"amount_due"`    // collected before the exchange completes
+	CreditAmount    int64          `json:"credit_amount"` // residual value owed to the customer
+	Currency        string         `json:"currency" gorm:"not null"`
+	StripeSessionID *string        `json:"stripe_session_id"`
+	CompletedAt     *time.Time     `json:"completed_at"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+
+	// Relations
+	Segments []ExchangeSegment `json:"segments,omitempty"`
+}
+
+func (e Exchange) Due() money.Money {
+	return money.New(e.AmountDue, e.Currency)
+}
+
+// ExchangeSegment replaces one booked segment with a new flight and fare.
+type ExchangeSegment struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	ExchangeID   uint      `json:"exchange_id" gorm:"not null;index"`
+	OldSegmentID uint      `json:"old_segment_id" gorm:"not null"`
+	OldFlightID  uint      `json:"old_flight_id" gorm:"not null"`
+	OldFareID    uint      `json:"old_fare_id" gorm:"not null"`
+	NewFlightID  uint      `json:"new_flight_id" gorm:"not null"`
+	NewFareID    uint      `json:"new_fare_id" gorm:"not null"`
+	NewSegmentID *uint     `json:"new_segment_id"` // set once the exchange completes
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
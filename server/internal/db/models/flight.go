@@ -2,6 +2,9 @@ package models
 
 import (
 	"time"
+	_ "time/tzdata" // airport time zones must resolve on images without zoneinfo
+
+	"skyliner/internal/money"
 )
 
 type Airport struct {
@@ -12,6 +15,7 @@ type Airport struct {
 	Country   string    `json:"country"`
 	Latitude  float64   `json:"latitude"`
 	Longitude float64   `json:"longitude"`
+	TimeZone  string    `json:"time_zone"` // IANA name, e.g. Europe/London
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 
@@ -20,13 +24,28 @@ type Airport struct {
 	ArrivalFlights   []Flight `json:"arrival_flights,omitempty" gorm:"foreignKey:DestinationID"`
 }
 
+// Location is the airport's time zone, or UTC when it has none or it is
+// unknown.
+func (a Airport) Location() *time.Location {
+	if a.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(a.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
 type Airline struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	Code      string    `json:"code" gorm:"uniqueIndex;not null"`
-	Name      string    `json:"name" gorm:"not null"`
-	Logo      string    `json:"logo"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	Code         string    `json:"code" gorm:"uniqueIndex;not null"`
+	Name         string    `json:"name" gorm:"not null"`
+	Logo         string    `json:"logo"`
+	TicketPrefix string    `json:"ticket_prefix"`               // 3-digit accounting code that starts ticket numbers, e.g. 125
+	TicketSerial int64     `json:"-" gorm:"not null;default:0"` // last ticket serial issued
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 
 	// Relations
 	Flights []Flight `json:"flights,omitempty" gorm:"foreignKey:AirlineID"`
@@ -42,6 +61,8 @@ type Flight struct {
 	ArrivalTime   time.Time `json:"arrival_time" gorm:"not null"`
 	Duration      int       `json:"duration" gorm:"not null"` // in minutes
 	Stops         int       `json:"stops" gorm:"default:0"`
+	CheckInOpens  int       `json:"check_in_opens" gorm:"not null;default:1440"` // minutes before departure
+	CheckInCloses int       `json:"check_in_closes" gorm:"not null;default:60"`  // minutes before departure
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 
@@ -57,9 +78,9 @@ type Flight struct {
 type Fare struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
 	FlightID  uint      `json:"flight_id" gorm:"not null"`
-	Class     string    `json:"class" gorm:"not null"`     // economy, business, first
-	FareType  string    `json:"fare_type" gorm:"not null"` // basic, standard, flexible
-	BasePrice float64   `json:"base_price" gorm:"not null"`
+	Class     string    `json:"class" gorm:"not null"`      // economy, business, first
+	FareType  string    `json:"fare_type" gorm:"not null"`  // basic, standard, flexible
+	BasePrice int64     `json:"base_price" gorm:"not null"` // minor units of Currency
 	Currency  string    `json:"currency" gorm:"default:USD"`
 	Available int       `json:"available" gorm:"not null"`
 	CreatedAt time.Time `json:"created_at"`
@@ -68,3 +89,7 @@ type Fare struct {
 	// Relations
 	Flight Flight `json:"flight" gorm:"foreignKey:FlightID"`
 }
+
+func (f Fare) Price() money.Money {
+	return money.New(f.BasePrice, f.Currency)
+}
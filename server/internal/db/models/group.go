@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"skyliner/internal/money"
+)
+
+// GroupTerms are what the group desk agreed for a group booking: a block of
+// seats booked before the travelers' names are known.
+type GroupTerms struct {
+	ID                 uint      `json:"id" gorm:"primaryKey"`
+	BookingID          uint      `json:"booking_id" gorm:"not null;uniqueIndex"`
+	Name               string    `json:"name" gorm:"not null"` // e.g. the team or tour
+	Size               int       `json:"size" gorm:"not null"`
+	NamesDueAt         time.Time `json:"names_due_at"`         // names given or changed after this are charged
+	NameChangeDeadline time.Time `json:"name_change_deadline"` // no names can be given or changed after this
+	NameChangeFee      int64     `json:"name_change_fee"`      // minor units of Currency, per name
+	Currency           string    `json:"currency" gorm:"not null"`
+	CreatedBy          uint      `json:"created_by"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+func (g GroupTerms) Fee() money.Money {
+	return money.New(g.NameChangeFee, g.Currency)
+}
+
+type InstallmentStatus string
+
+const (
+	InstallmentDue  InstallmentStatus = "due"
+	InstallmentPaid InstallmentStatus = "paid"
+)
+
+// GroupInstallment is one payment in a group booking's deposit schedule, in
+// the settlement currency. The last one is for whatever is left, so charges
+// added after booking fall into it.
+type GroupInstallment struct {
+	ID              uint              `json:"id" gorm:"primaryKey"`
+	BookingID       uint              `json:"booking_id" gorm:"not null;index"`
+	Sequence        int               `json:"sequence" gorm:"not null"`
+	DueAt           time.Time         `json:"due_at"`
+	Amount          int64             `json:"amount"`
+	Currency        string            `json:"currency" gorm:"not null"`
+	Status          InstallmentStatus `json:"status" gorm:"not null;default:due"`
+	StripeSessionID *string           `json:"-"`
+	PaidAt          *time.Time        `json:"paid_at"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+}
+
+func (i GroupInstallment) Money() money.Money {
+	return money.New(i.Amount, i.Currency)
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// IdempotencyKey remembers the outcome of a request sent with an
+// Idempotency-Key header so a retry gets the original response back.
+type IdempotencyKey struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	UserID       uint      `json:"user_id" gorm:"uniqueIndex:idx_idempotency_user_key;not null"`
+	Key          string    `json:"key" gorm:"column:idempotency_key;uniqueIndex:idx_idempotency_user_key;not null"`
+	Method       string    `json:"method" gorm:"not null"`
+	Path         string    `json:"path" gorm:"not null"`
+	Fingerprint  string    `json:"fingerprint" gorm:"not null"` // sha256 of method, path and body
+	Completed    bool      `json:"completed" gorm:"default:false"`
+	StatusCode   int       `json:"status_code"`
+	ResponseBody []byte    `json:"-"`
+	ContentType  string    `json:"content_type"`
+	ExpiresAt    time.Time `json:"expires_at" gorm:"index;not null"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"skyliner/internal/money"
+)
+
+type DiscountType string
+
+const (
+	DiscountPercent DiscountType = "percent"
+	DiscountFixed   DiscountType = "fixed"
+)
+
+// Promotion is a promo code and the rules a booking must meet to use it.
+// Empty lists and nil windows match everything. Amounts are in minor units of
+// Currency, which is required for fixed discounts and a minimum spend.
+type Promotion struct {
+	ID             uint         `json:"id" gorm:"primaryKey"`
+	Code           string       `json:"code" gorm:"uniqueIndex;not null"`
+	Description    string       `json:"description"`
+	DiscountType   DiscountType `json:"discount_type" gorm:"not null"`
+	Value          int64        `json:"value" gorm:"not null"` // basis points for percent, minor units for fixed
+	Currency       string       `json:"currency"`
+	Routes         []string     `json:"routes" gorm:"serializer:json"`     // e.g. "JFK-LAX"
+	Cabins         []string     `json:"cabins" gorm:"serializer:json"`     // fare classes
+	FareTypes      []string     `json:"fare_types" gorm:"serializer:json"` // basic, standard, flexible
+	TravelFrom     *time.Time   `json:"travel_from"`
+	TravelTo       *time.Time   `json:"travel_to"`
+	BookingFrom    *time.Time   `json:"booking_from"`
+	BookingTo      *time.Time   `json:"booking_to"`
+	MinSpend       int64        `json:"min_spend"`
+	FirstBooking   bool         `json:"first_booking_only"`
+	MaxUses        int          `json:"max_uses"`          // across all users, 0 for no limit
+	MaxUsesPerUser int          `json:"max_uses_per_user"` // 0 for no limit
+	Uses           int          `json:"uses" gorm:"not null;default:0"`
+	Active         bool         `json:"active" gorm:"not null"`
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+// PromotionRedemption is a promo code used on a booking. It is shown on the
+// booking as its own discount line, in the fare currency.
+type PromotionRedemption struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	PromotionID uint      `json:"promotion_id" gorm:"not null;index"`
+	BookingID   uint      `json:"booking_id" gorm:"not null;uniqueIndex"`
+	UserID      uint      `json:"user_id" gorm:"not null;index"`
+	Code        string    `json:"code" gorm:"not null"`
+	Description string    `json:"description"`
+	Amount      int64     `json:"amount" gorm:"not null"` // discount, minor units of Currency
+	Currency    string    `json:"currency" gorm:"not null"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PromotionUsage counts a user's redemptions of a promotion, as Uses counts
+// them across all users, so the per-user limit holds under concurrent
+// checkouts.
+type PromotionUsage struct {
+	PromotionID uint `json:"promotion_id" gorm:"primaryKey;autoIncrement:false"`
+	UserID      uint `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	Uses        int  `json:"uses" gorm:"not null;default:0"`
+}
+
+func (r PromotionRedemption) Money() money.Money {
+	return money.New(r.Amount, r.Currency)
+}
@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+type RemarkVisibility string
+
+const (
+	RemarkInternal RemarkVisibility = "internal" // agents only
+	RemarkCustomer RemarkVisibility = "customer" // shown to the traveler with the booking
+)
+
+// BookingRemark is a note on a booking. Remarks written by the system have
+// no author.
+type BookingRemark struct {
+	ID         uint             `json:"id" gorm:"primaryKey"`
+	BookingID  uint             `json:"booking_id" gorm:"not null;index"`
+	Visibility RemarkVisibility `json:"visibility" gorm:"not null"`
+	Text       string           `json:"text" gorm:"not null"`
+	AuthorID   *uint            `json:"author_id"`
+	CreatedAt  time.Time        `json:"created_at"`
+}
+
+type QueueName string
+
+const (
+	QueueScheduleChange QueueName = "schedule_change"
+	QueueUnpaidHold     QueueName = "unpaid_hold"
+	QueueFailedPayment  QueueName = "failed_payment"
+	QueueSSRPending     QueueName = "ssr_pending"
+)
+
+// Queues are the agent work queues, in the order they are listed.
+var Queues = []QueueName{QueueScheduleChange, QueueUnpaidHold, QueueFailedPayment, QueueSSRPending}
+
+type QueueItemStatus string
+
+const (
+	QueueItemOpen      QueueItemStatus = "open"
+	QueueItemClaimed   QueueItemStatus = "claimed"
+	QueueItemCompleted QueueItemStatus = "completed"
+)
+
+// QueueItem is a booking waiting for an agent to follow up on something. A
+// booking is on each queue at most once until the item is completed.
+type QueueItem struct {
+	ID          uint            `json:"id" gorm:"primaryKey"`
+	BookingID   uint            `json:"booking_id" gorm:"not null;index"`
+	Queue       QueueName       `json:"queue" gorm:"not null;index"`
+	Status      QueueItemStatus `json:"status" gorm:"not null;index"`
+	Reason      string          `json:"reason"`
+	ClaimedBy   *uint           `json:"claimed_by"`
+	ClaimedAt   *time.Time      `json:"claimed_at"`
+	CompletedBy *uint           `json:"completed_by"`
+	CompletedAt *time.Time      `json:"completed_at"`
+	Resolution  string          `json:"resolution"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+
+	// Relations
+	Booking *Booking `json:"booking,omitempty" gorm:"foreignKey:BookingID"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"skyliner/internal/money"
+)
+
+// Refund is money returned against a payment. Status follows Stripe's refund
+// statuses and is updated from the charge.refunded webhook.
+type Refund struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	BookingID      uint      `json:"booking_id" gorm:"not null;index"`
+	PaymentID      uint      `json:"payment_id" gorm:"not null;index"`
+	StripeRefundID *string   `json:"stripe_refund_id" gorm:"uniqueIndex"`
+	Amount         int64     `json:"amount" gorm:"not null"` // minor units of Currency
+	Currency       string    `json:"currency" gorm:"not null"`
+	Status         string    `json:"status" gorm:"not null"`
+	Reason         string    `json:"reason"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (r Refund) Money() money.Money {
+	return money.New(r.Amount, r.Currency)
+}
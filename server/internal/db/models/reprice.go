@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+)
+
+// RepriceJob recalculates booking totals from current fares. Filter is the
+// JSON request that selected the bookings.
+type RepriceJob struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Status      JobStatus  `json:"status" gorm:"not null;index"`
+	DryRun      bool       `json:"dry_run"`
+	Filter      string     `json:"filter" gorm:"type:text"`
+	RequestedBy uint       `json:"requested_by"`
+	Matched     int        `json:"matched"` // bookings the filter selected
+	Changed     int        `json:"changed"` // bookings whose total differs
+	Applied     int        `json:"applied"` // changes written, never more than Changed
+	Error       string     `json:"error,omitempty"`
+	StartedAt   *time.Time `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	// Relations
+	Items []RepriceJobItem `json:"items,omitempty" gorm:"foreignKey:JobID"`
+}
+
+// RepriceJobItem is one line of a job's diff. Totals are in the booking's
+// settlement currency.
+type RepriceJobItem struct {
+	ID         uint          `json:"id" gorm:"primaryKey"`
+	JobID      uint          `json:"job_id" gorm:"not null;index"`
+	BookingID  uint          `json:"booking_id" gorm:"not null"`
+	PNR        string        `json:"pnr"`
+	Status     BookingStatus `json:"status"`
+	OldTotal   int64         `json:"old_total"`
+	NewTotal   int64         `json:"new_total"`
+	Currency   string        `json:"currency"`
+	Applied    bool          `json:"applied"`
+	SkipReason string        `json:"skip_reason,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
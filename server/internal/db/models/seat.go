@@ -32,7 +32,7 @@ type Seat struct {
 	Column    string     `json:"column" gorm:"not null"`
 	Class     string     `json:"class" gorm:"not null"`
 	Status    SeatStatus `json:"status" gorm:"default:available"`
-	Price     *float64   `json:"price"`
+	Price     *int64     `json:"price"` // minor units of the flight's fare currency
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 
@@ -41,13 +41,14 @@ type Seat struct {
 }
 
 type Baggage struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	BookingID uint      `json:"booking_id" gorm:"not null"`
-	Type      string    `json:"type" gorm:"not null"` // checked, carry-on
-	Weight    int       `json:"weight"`               // in kg
-	Price     float64   `json:"price"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	BookingID  uint      `json:"booking_id" gorm:"not null"`
+	PurchaseID *uint     `json:"purchase_id" gorm:"index"` // the ancillary purchase it was bought with
+	Type       string    `json:"type" gorm:"not null"`     // checked, carry-on
+	Weight     int       `json:"weight"`                   // in kg
+	Price      int64     `json:"price"`                    // minor units of the booking currency
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
 
 	// Relations
 	Booking Booking `json:"booking" gorm:"foreignKey:BookingID"`
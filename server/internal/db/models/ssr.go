@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+)
+
+type SSRCategory string
+
+const (
+	SSRMobility SSRCategory = "mobility"
+	SSRMeal     SSRCategory = "meal"
+	SSRMinor    SSRCategory = "minor"
+	SSRAnimal   SSRCategory = "animal"
+	SSRMedical  SSRCategory = "medical"
+	SSROther    SSRCategory = "other"
+)
+
+// SSRType is an IATA special service request code, e.g. WCHR for a
+// wheelchair to the aircraft door or VGML for a vegetarian meal.
+type SSRType struct {
+	ID           uint        `json:"id" gorm:"primaryKey"`
+	Code         string      `json:"code" gorm:"uniqueIndex;not null"`
+	Name         string      `json:"name" gorm:"not null"`
+	Category     SSRCategory `json:"category" gorm:"not null"`
+	RequiresText bool        `json:"requires_text"` // the airline needs details, e.g. the animal's breed and weight
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+}
+
+// AirlineSSR offers an SSR code on an airline's flights. Codes an airline has
+// no row for cannot be requested on its flights.
+type AirlineSSR struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	AirlineID   uint      `json:"airline_id" gorm:"not null;uniqueIndex:idx_airline_ssr"`
+	Code        string    `json:"code" gorm:"not null;uniqueIndex:idx_airline_ssr"`
+	Price       *int64    `json:"price"`        // minor units of the flight's fare currency, nil if free
+	FlightLimit int       `json:"flight_limit"` // most requests per flight, 0 for no limit
+	AutoConfirm bool      `json:"auto_confirm"` // confirmed on request, without an agent
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Relations
+	Type SSRType `json:"type" gorm:"foreignKey:Code;references:Code"`
+}
+
+type SSRStatus string
+
+const (
+	SSRRequested SSRStatus = "requested"
+	SSRConfirmed SSRStatus = "confirmed"
+	SSRRejected  SSRStatus = "rejected"
+	SSRCancelled SSRStatus = "cancelled"
+)
+
+// SSRRequest asks for a special service for one passenger on one segment.
+// Requested and confirmed requests count against the flight's limit.
+type SSRRequest struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	BookingID   uint       `json:"booking_id" gorm:"not null;index"`
+	PassengerID uint       `json:"passenger_id" gorm:"not null;index"`
+	SegmentID   uint       `json:"segment_id" gorm:"not null"`
+	FlightID    uint       `json:"flight_id" gorm:"not null;index"`
+	Code        string     `json:"code" gorm:"not null"`
+	Text        string     `json:"text"`
+	Status      SSRStatus  `json:"status" gorm:"not null;index"`
+	Price       int64      `json:"price"` // minor units of Currency
+	Currency    string     `json:"currency" gorm:"not null"`
+	Note        string     `json:"note"` // the agent's reason for a decision
+	DecidedBy   *uint      `json:"decided_by"`
+	DecidedAt   *time.Time `json:"decided_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Active reports whether the request still stands.
+func (r SSRRequest) Active() bool {
+	return r.Status == SSRRequested || r.Status == SSRConfirmed
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// Ticket is the e-ticket issued to one passenger for a booking. The airline
+// of the first segment validates it.
+type Ticket struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	BookingID   uint      `json:"booking_id" gorm:"not null;index"`
+	PassengerID uint      `json:"passenger_id" gorm:"not null;uniqueIndex"`
+	AirlineID   uint      `json:"airline_id" gorm:"not null"`
+	Number      string    `json:"number" gorm:"uniqueIndex;not null"`
+	IssuedAt    time.Time `json:"issued_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Relations
+	Coupons []Coupon `json:"coupons,omitempty"`
+}
+
+type CouponStatus string
+
+const (
+	CouponOpen      CouponStatus = "open"
+	CouponCheckedIn CouponStatus = "checked_in"
+	CouponFlown     CouponStatus = "flown"
+	CouponExchanged CouponStatus = "exchanged"
+	CouponRefunded  CouponStatus = "refunded"
+)
+
+// Coupon is the part of a ticket good for one segment. Coupons outlive the
+// segment they were issued for, so the flight is kept on the coupon.
+type Coupon struct {
+	ID        uint         `json:"id" gorm:"primaryKey"`
+	TicketID  uint         `json:"ticket_id" gorm:"not null;index"`
+	Number    int          `json:"number" gorm:"not null"` // 1-based, in issue order
+	SegmentID uint         `json:"segment_id" gorm:"not null;index"`
+	FlightID  uint         `json:"flight_id" gorm:"not null"`
+	Status    CouponStatus `json:"status" gorm:"not null;index"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// BoardingPass is issued when a passenger checks in for a segment. BCBP is
+// the IATA bar coded boarding pass data its barcode carries.
+type BoardingPass struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	BookingID   uint      `json:"booking_id" gorm:"not null;index"`
+	PassengerID uint      `json:"passenger_id" gorm:"not null"`
+	SegmentID   uint      `json:"segment_id" gorm:"not null"`
+	FlightID    uint      `json:"flight_id" gorm:"not null;uniqueIndex:idx_boarding_sequence"`
+	CouponID    uint      `json:"coupon_id" gorm:"not null;uniqueIndex"`
+	Sequence    int       `json:"sequence" gorm:"not null;uniqueIndex:idx_boarding_sequence"` // check-in order on the flight
+	Seat        string    `json:"seat" gorm:"not null"`
+	BCBP        string    `json:"bcbp" gorm:"not null"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -14,18 +14,49 @@ const (
 	RoleAdmin    Role = "admin"
 )
 
+// LoyaltyTier orders customers on waitlists; higher tiers are offered seats
+// first.
+type LoyaltyTier string
+
+const (
+	TierNone     LoyaltyTier = "none"
+	TierSilver   LoyaltyTier = "silver"
+	TierGold     LoyaltyTier = "gold"
+	TierPlatinum LoyaltyTier = "platinum"
+)
+
+// Rank is the tier's priority, 0 for none or an unknown tier.
+func (t LoyaltyTier) Rank() int {
+	switch t {
+	case TierSilver:
+		return 1
+	case TierGold:
+		return 2
+	case TierPlatinum:
+		return 3
+	}
+	return 0
+}
+
+// User is a customer account or staff member. Guest users stand in for an
+// email that booked without an account: one per email, sharing the address
+// with the account if one is created later, and unable to log in.
 type User struct {
-	ID           uint           `json:"id" gorm:"primaryKey"`
-	Email        string         `json:"email" gorm:"uniqueIndex;not null"`
-	PasswordHash string         `json:"-" gorm:"not null"`
-	FirstName    string         `json:"first_name"`
-	LastName     string         `json:"last_name"`
-	Role         Role           `json:"role" gorm:"default:traveler"`
-	GoogleID     *string        `json:"-" gorm:"uniqueIndex"`
-	IsActive     bool           `json:"is_active" gorm:"default:true"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
+	ID              uint           `json:"id" gorm:"primaryKey"`
+	Email           string         `json:"email" gorm:"uniqueIndex:idx_users_email_guest;not null"`
+	Guest           bool           `json:"guest" gorm:"uniqueIndex:idx_users_email_guest;not null;default:false"`
+	EmailVerifiedAt *time.Time     `json:"email_verified_at"`
+	PasswordHash    string         `json:"-" gorm:"not null"`
+	FirstName       string         `json:"first_name"`
+	LastName        string         `json:"last_name"`
+	Role            Role           `json:"role" gorm:"default:traveler"`
+	LoyaltyTier     LoyaltyTier    `json:"loyalty_tier" gorm:"default:none"`
+	GoogleID        *string        `json:"-" gorm:"uniqueIndex"`
+	CalendarToken   *string        `json:"-" gorm:"uniqueIndex"` // secret in the calendar feed URL
+	IsActive        bool           `json:"is_active" gorm:"default:true"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `json:"-" gorm:"index"`
 
 	// Relations
 	Bookings []Booking `json:"bookings,omitempty" gorm:"foreignKey:UserID"`
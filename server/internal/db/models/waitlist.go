@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+type WaitlistStatus string
+
+const (
+	WaitlistWaiting   WaitlistStatus = "waiting"
+	WaitlistOffered   WaitlistStatus = "offered"   // seats are held for the customer until OfferExpiresAt
+	WaitlistBooked    WaitlistStatus = "booked"    // the offer was taken up
+	WaitlistExpired   WaitlistStatus = "expired"   // the offer lapsed
+	WaitlistCancelled WaitlistStatus = "cancelled" // left by the customer, or the flight departed
+)
+
+// WaitlistEntry queues a customer for seats in a cabin of a sold-out flight.
+// Entries are offered seats by Priority, then in the order they joined.
+type WaitlistEntry struct {
+	ID             uint           `json:"id" gorm:"primaryKey"`
+	UserID         uint           `json:"user_id" gorm:"not null;index"`
+	FlightID       uint           `json:"flight_id" gorm:"not null;index"`
+	Class          string         `json:"class" gorm:"not null"` // cabin: economy, business or first
+	Seats          int            `json:"seats" gorm:"not null"`
+	Priority       int            `json:"priority"` // the customer's loyalty tier rank when they joined
+	Status         WaitlistStatus `json:"status" gorm:"not null;index"`
+	FareID         *uint          `json:"fare_id"` // fare the offered seats are held in
+	OfferedAt      *time.Time     `json:"offered_at"`
+	OfferExpiresAt *time.Time     `json:"offer_expires_at"`
+	BookingID      *uint          `json:"booking_id"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
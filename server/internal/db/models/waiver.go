@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"skyliner/internal/money"
+)
+
+type WaiverType string
+
+const (
+	WaiverChangeFee           WaiverType = "change_fee"
+	WaiverCancellationPenalty WaiverType = "cancellation_penalty"
+	WaiverBaggage             WaiverType = "baggage"
+	WaiverHoldExpiry          WaiverType = "hold_expiry"
+)
+
+type WaiverStatus string
+
+const (
+	WaiverPendingApproval WaiverStatus = "pending_approval"
+	WaiverApproved        WaiverStatus = "approved" // waiting for the charge it covers
+	WaiverRejected        WaiverStatus = "rejected"
+	WaiverApplied         WaiverStatus = "applied"
+)
+
+// WaiverReasonCodes are the reasons an agent may give for waiving a charge.
+var WaiverReasonCodes = map[string]bool{
+	"schedule_change": true,
+	"medical":         true,
+	"bereavement":     true,
+	"agent_error":     true,
+	"goodwill":        true,
+}
+
+// Waiver forgives a charge on a booking. It is kept as the audit record of
+// who asked for it, who approved it and when it was used. Amount is in the
+// booking's fare currency, where the fees it offsets are computed.
+type Waiver struct {
+	ID          uint         `json:"id" gorm:"primaryKey"`
+	BookingID   uint         `json:"booking_id" gorm:"not null;index"`
+	Type        WaiverType   `json:"type" gorm:"not null"`
+	ReasonCode  string       `json:"reason_code" gorm:"not null"`
+	Note        string       `json:"note"`
+	Amount      int64        `json:"amount"` // most that may be waived, minor units of Currency
+	Currency    string       `json:"currency" gorm:"not null"`
+	Status      WaiverStatus `json:"status" gorm:"not null;index"`
+	RequestedBy uint         `json:"requested_by" gorm:"not null"`
+	DecidedBy   *uint        `json:"decided_by"`
+	DecidedAt   *time.Time   `json:"decided_at"`
+	AppliedAt   *time.Time   `json:"applied_at"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+func (w Waiver) Money() money.Money {
+	return money.New(w.Amount, w.Currency)
+}
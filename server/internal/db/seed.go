@@ -44,6 +44,16 @@ func Seed(db *gorm.DB) error {
 		return err
 	}
 
+	// Create the special service catalog
+	if err := seedSSRs(db); err != nil {
+		return err
+	}
+
+	// Create the add-on catalog
+	if err := seedAncillaries(db); err != nil {
+		return err
+	}
+
 	log.Println("Database seeded successfully")
 	return nil
 }
@@ -100,12 +110,12 @@ func seedAirports(db *gorm.DB) error {
 	}
 
 	airports := []models.Airport{
-		{Code: "JFK", Name: "John F. Kennedy International Airport", City: "New York", Country: "USA", Latitude: 40.6413, Longitude: -73.7781},
-		{Code: "LAX", Name: "Los Angeles International Airport", City: "Los Angeles", Country: "USA", Latitude: 33.9416, Longitude: -118.4085},
-		{Code: "LHR", Name: "London Heathrow Airport", City: "London", Country: "UK", Latitude: 51.4700, Longitude: -0.4543},
-		{Code: "CDG", Name: "Charles de Gaulle Airport", City: "Paris", Country: "France", Latitude: 49.0097, Longitude: 2.5479},
-		{Code: "NRT", Name: "Narita International Airport", City: "Tokyo", Country: "Japan", Latitude: 35.7720, Longitude: 140.3928},
-		{Code: "SFO", Name: "San Francisco International Airport", City: "San Francisco", Country: "USA", Latitude: 37.6213, Longitude: -122.3790},
+		{Code: "JFK", Name: "John F. Kennedy International Airport", City: "New York", Country: "USA", Latitude: 40.6413, Longitude: -73.7781, TimeZone: "America/New_York"},
+		{Code: "LAX", Name: "Los Angeles International Airport", City: "Los Angeles", Country: "USA", Latitude: 33.9416, Longitude: -118.4085, TimeZone: "America/Los_Angeles"},
+		{Code: "LHR", Name: "London Heathrow Airport", City: "London", Country: "UK", Latitude: 51.4700, Longitude: -0.4543, TimeZone: "Europe/London"},
+		{Code: "CDG", Name: "Charles de Gaulle Airport", City: "Paris", Country: "France", Latitude: 49.0097, Longitude: 2.5479, TimeZone: "Europe/Paris"},
+		{Code: "NRT", Name: "Narita International Airport", City: "Tokyo", Country: "Japan", Latitude: 35.7720, Longitude: 140.3928, TimeZone: "Asia/Tokyo"},
+		{Code: "SFO", Name: "San Francisco International Airport", City: "San Francisco", Country: "USA", Latitude: 37.6213, Longitude: -122.3790, TimeZone: "America/Los_Angeles"},
 	}
 
 	return db.Create(&airports).Error
@@ -119,12 +129,12 @@ func seedAirlines(db *gorm.DB) error {
 	}
 
 	airlines := []models.Airline{
-		{Code: "AA", Name: "American Airlines"},
-		{Code: "DL", Name: "Delta Air Lines"},
-		{Code: "UA", Name: "United Airlines"},
-		{Code: "BA", Name: "British Airways"},
-		{Code: "AF", Name: "Air France"},
-		{Code: "JL", Name: "Japan Airlines"},
+		{Code: "AA", Name: "American Airlines", TicketPrefix: "001"},
+		{Code: "DL", Name: "Delta Air Lines", TicketPrefix: "006"},
+		{Code: "UA", Name: "United Airlines", TicketPrefix: "016"},
+		{Code: "BA", Name: "British Airways", TicketPrefix: "125"},
+		{Code: "AF", Name: "Air France", TicketPrefix: "057"},
+		{Code: "JL", Name: "Japan Airlines", TicketPrefix: "131"},
 	}
 
 	return db.Create(&airlines).Error
@@ -192,7 +202,7 @@ func seedFlights(db *gorm.DB) error {
 				FlightID:  flight.ID,
 				Class:     "economy",
 				FareType:  "basic",
-				BasePrice: 299.99,
+				BasePrice: 29999,
 				Currency:  "USD",
 				Available: 50,
 			},
@@ -200,7 +210,7 @@ func seedFlights(db *gorm.DB) error {
 				FlightID:  flight.ID,
 				Class:     "economy",
 				FareType:  "standard",
-				BasePrice: 399.99,
+				BasePrice: 39999,
 				Currency:  "USD",
 				Available: 30,
 			},
@@ -208,7 +218,7 @@ func seedFlights(db *gorm.DB) error {
 				FlightID:  flight.ID,
 				Class:     "business",
 				FareType:  "flexible",
-				BasePrice: 899.99,
+				BasePrice: 89999,
 				Currency:  "USD",
 				Available: 12,
 			},
@@ -264,10 +274,10 @@ func seedSeatMaps(db *gorm.DB) error {
 						Status:    models.SeatAvailable,
 					}
 					if class == "business" {
-						price := 50.0
+						price := int64(5000)
 						seat.Price = &price
 					} else if class == "first" {
-						price := 100.0
+						price := int64(10000)
 						seat.Price = &price
 					}
 					db.Create(&seat)
@@ -278,3 +288,100 @@ func seedSeatMaps(db *gorm.DB) error {
 
 	return nil
 }
+
+func seedSSRs(db *gorm.DB) error {
+	var count int64
+	db.Model(&models.SSRType{}).Count(&count)
+	if count > 0 {
+		return nil
+	}
+
+	types := []models.SSRType{
+		{Code: "WCHR", Name: "Wheelchair - can climb stairs and walk to seat", Category: models.SSRMobility},
+		{Code: "WCHS", Name: "Wheelchair - cannot climb stairs", Category: models.SSRMobility},
+		{Code: "WCHC", Name: "Wheelchair - fully immobile", Category: models.SSRMobility},
+		{Code: "BLND", Name: "Blind passenger", Category: models.SSRMedical},
+		{Code: "DEAF", Name: "Deaf passenger", Category: models.SSRMedical},
+		{Code: "MEDA", Name: "Medical case", Category: models.SSRMedical, RequiresText: true},
+		{Code: "VGML", Name: "Vegetarian meal", Category: models.SSRMeal},
+		{Code: "AVML", Name: "Asian vegetarian meal", Category: models.SSRMeal},
+		{Code: "KSML", Name: "Kosher meal", Category: models.SSRMeal},
+		{Code: "MOML", Name: "Muslim meal", Category: models.SSRMeal},
+		{Code: "DBML", Name: "Diabetic meal", Category: models.SSRMeal},
+		{Code: "GFML", Name: "Gluten-free meal", Category: models.SSRMeal},
+		{Code: "CHML", Name: "Child meal", Category: models.SSRMeal},
+		{Code: "UMNR", Name: "Unaccompanied minor", Category: models.SSRMinor, RequiresText: true},
+		{Code: "PETC", Name: "Pet in cabin", Category: models.SSRAnimal, RequiresText: true},
+		{Code: "AVIH", Name: "Animal in hold", Category: models.SSRAnimal, RequiresText: true},
+		{Code: "SVAN", Name: "Service animal", Category: models.SSRAnimal, RequiresText: true},
+		{Code: "OTHS", Name: "Other service", Category: models.SSROther, RequiresText: true},
+	}
+	if err := db.Create(&types).Error; err != nil {
+		return err
+	}
+
+	var airlines []models.Airline
+	db.Find(&airlines)
+
+	umnrPrice, petcPrice, avihPrice := int64(15000), int64(12500), int64(25000)
+	for _, airline := range airlines {
+		offers := []models.AirlineSSR{
+			{AirlineID: airline.ID, Code: "WCHR"},
+			{AirlineID: airline.ID, Code: "WCHS"},
+			{AirlineID: airline.ID, Code: "WCHC", FlightLimit: 2},
+			{AirlineID: airline.ID, Code: "BLND"},
+			{AirlineID: airline.ID, Code: "DEAF"},
+			{AirlineID: airline.ID, Code: "MEDA"},
+			{AirlineID: airline.ID, Code: "VGML", AutoConfirm: true},
+			{AirlineID: airline.ID, Code: "AVML", AutoConfirm: true},
+			{AirlineID: airline.ID, Code: "KSML", AutoConfirm: true},
+			{AirlineID: airline.ID, Code: "MOML", AutoConfirm: true},
+			{AirlineID: airline.ID, Code: "DBML", AutoConfirm: true},
+			{AirlineID: airline.ID, Code: "GFML", AutoConfirm: true},
+			{AirlineID: airline.ID, Code: "CHML", AutoConfirm: true},
+			{AirlineID: airline.ID, Code: "UMNR", Price: &umnrPrice, FlightLimit: 4},
+			{AirlineID: airline.ID, Code: "SVAN"},
+			{AirlineID: airline.ID, Code: "OTHS"},
+		}
+		// Not every carrier takes animals in the cabin or the hold
+		switch airline.Code {
+		case "AA", "DL", "UA", "AF":
+			offers = append(offers, models.AirlineSSR{AirlineID: airline.ID, Code: "PETC", Price: &petcPrice, FlightLimit: 4})
+		}
+		switch airline.Code {
+		case "AA", "UA", "AF", "JL":
+			offers = append(offers, models.AirlineSSR{AirlineID: airline.ID, Code: "AVIH", Price: &avihPrice, FlightLimit: 2})
+		}
+		if err := db.Create(&offers).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func seedAncillaries(db *gorm.DB) error {
+	var count int64
+	db.Model(&models.Ancillary{}).Count(&count)
+	if count > 0 {
+		return nil
+	}
+
+	var airlines []models.Airline
+	db.Find(&airlines)
+
+	for _, airline := range airlines {
+		ancillaries := []models.Ancillary{
+			{AirlineID: airline.ID, Type: models.AncillaryBaggage, Code: "BAG23", Name: "Checked bag up to 23kg", Cabin: ClassEconomy, Price: 6000, Currency: "USD", Weight: 23, MaxPerPassenger: 3, Active: true},
+			{AirlineID: airline.ID, Type: models.AncillaryBaggage, Code: "BAG32", Name: "Heavy checked bag up to 32kg", Price: 9500, Currency: "USD", Weight: 32, MaxPerPassenger: 2, Active: true},
+			{AirlineID: airline.ID, Type: models.AncillaryMeal, Code: "HOTMEAL", Name: "Hot meal", Cabin: ClassEconomy, Price: 1800, Currency: "USD", MaxPerPassenger: 1, Active: true},
+			{AirlineID: airline.ID, Type: models.AncillaryLounge, Code: "LOUNGE", Name: "Departure lounge pass", Cabin: ClassEconomy, Price: 5500, Currency: "USD", FlightLimit: 20, MaxPerPassenger: 1, Active: true},
+			{AirlineID: airline.ID, Type: models.AncillaryPriorityBoarding, Code: "PRIORITY", Name: "Priority boarding", Cabin: ClassEconomy, Price: 2500, Currency: "USD", FlightLimit: 30, MaxPerPassenger: 1, Active: true},
+		}
+		if err := db.Create(&ancillaries).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
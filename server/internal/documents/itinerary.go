@@ -0,0 +1,197 @@
+// Package documents renders the documents customers receive for a booking.
+package documents
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
+	"skyliner/internal/pdf"
+)
+
+const (
+	margin     = 50.0
+	lineHeight = 14.0
+	right      = pdf.PageWidth - margin
+	timeLayout = "Mon 02 Jan 2006 15:04"
+)
+
+// Itinerary writes the itinerary and receipt for a booking as a PDF. The
+// booking needs its itinerary, flights with their airline and airports,
+// fares, seats, passengers with special services, add-ons, discounts,
+// payments and tickets preloaded.
+func Itinerary(w io.Writer, booking *models.Booking) error {
+	p := &page{doc: pdf.New()}
+	p.newPage()
+
+	p.doc.Text(margin, p.y, pdf.Bold, 20, "Itinerary and receipt")
+	p.doc.TextRight(right, p.y, pdf.Bold, 14, booking.PNR)
+	p.y -= 18
+	p.doc.Text(margin, p.y, pdf.Regular, 10, fmt.Sprintf("Booked %s", booking.CreatedAt.UTC().Format("02 Jan 2006")))
+	p.doc.TextRight(right, p.y, pdf.Regular, 10, "Status: "+string(booking.Status))
+	p.y -= 10
+
+	tickets := map[uint]string{}
+	for _, ticket := range booking.Tickets {
+		tickets[ticket.PassengerID] = ticket.Number
+	}
+	names := map[uint]string{}
+	p.heading("Passengers")
+	for _, passenger := range booking.Passengers {
+		name := strings.ToUpper(passenger.LastName) + "/" + strings.ToUpper(passenger.FirstName)
+		names[passenger.ID] = name
+		p.row(name, ticketLabel(tickets[passenger.ID]))
+	}
+
+	currency := booking.Base().Currency
+	var fares, extras []charge
+	p.heading("Flights")
+	for _, segment := range booking.Itinerary.Segments {
+		flight := segment.Flight
+		p.ensure(4 * lineHeight)
+		p.doc.Text(margin, p.y, pdf.Bold, 11, fmt.Sprintf("%s  %s (%s) to %s (%s)",
+			flight.Number, place(flight.Origin), flight.Origin.Code, place(flight.Destination), flight.Destination.Code))
+		p.doc.TextRight(right, p.y, pdf.Regular, 10, flight.Airline.Name)
+		p.y -= lineHeight
+		// Local times at each airport, as on the departure boards
+		p.row(fmt.Sprintf("Departs %s   Arrives %s", flight.DepartureTime.In(flight.Origin.Location()).Format(timeLayout), flight.ArrivalTime.In(flight.Destination.Location()).Format(timeLayout)),
+			fmt.Sprintf("%s, %s fare", title(segment.Fare.Class), segment.Fare.FareType))
+		for _, assignment := range segment.SeatAssignments {
+			p.row("    "+names[assignment.PassengerID], fmt.Sprintf("Seat %d%s", assignment.Seat.Row, assignment.Seat.Column))
+			if assignment.Seat.Price != nil && *assignment.Seat.Price > 0 {
+				extras = append(extras, charge{fmt.Sprintf("Seat %d%s, %s", assignment.Seat.Row, assignment.Seat.Column, flight.Number), money.New(*assignment.Seat.Price, currency)})
+			}
+		}
+		p.y -= 4
+
+		fare := segment.FarePrice().Mul(int64(len(booking.Passengers)))
+		fares = append(fares, charge{fmt.Sprintf("Fare %s, %d x %s", flight.Number, len(booking.Passengers), segment.FarePrice()), fare})
+	}
+	// Fares first, then everything bought on top
+	charges := append(fares, extras...)
+
+	for _, passenger := range booking.Passengers {
+		for _, ssr := range passenger.SSRs {
+			if ssr.Active() && ssr.Price > 0 {
+				charges = append(charges, charge{fmt.Sprintf("%s, %s", ssr.Code, names[passenger.ID]), money.New(ssr.Price, ssr.Currency)})
+			}
+		}
+	}
+	for _, purchase := range booking.Ancillaries {
+		if purchase.Status == models.AncillaryActive {
+			charges = append(charges, charge{fmt.Sprintf("%s, %s", purchase.Name, names[purchase.PassengerID]), purchase.Money()})
+		}
+	}
+	for _, discount := range booking.Discounts {
+		charges = append(charges, charge{"Promotion " + discount.Code, discount.Money().Neg()})
+	}
+
+	p.heading("Charges")
+	for _, c := range charges {
+		p.row(c.label, c.amount.String())
+	}
+	p.rule()
+	p.boldRow("Total", booking.Base().String())
+	if booking.FXRate != "" {
+		p.row(fmt.Sprintf("Charged in %s at %s %s per %s", booking.Currency, booking.FXRate, booking.Currency, booking.BaseCurrency), booking.Total().String())
+	}
+
+	if len(booking.Payments)+len(booking.PaymentsIn)+len(booking.PaymentsOut) > 0 {
+		p.heading("Payments")
+		for _, payment := range booking.Payments {
+			p.row(fmt.Sprintf("%s  %s, %s", payment.CreatedAt.UTC().Format("02 Jan 2006"), payment.Method, payment.Status),
+				money.New(payment.Amount, payment.Currency).String())
+		}
+		for _, share := range booking.PaymentsIn {
+			label := "Share of payment"
+			if booking.SplitFrom != nil {
+				label += " on " + booking.SplitFrom.PNR
+			}
+			p.row(fmt.Sprintf("%s  %s", share.CreatedAt.UTC().Format("02 Jan 2006"), label), share.Money().String())
+		}
+		for _, share := range booking.PaymentsOut {
+			label := "Moved to split booking"
+			for _, split := range booking.Splits {
+				if split.SplitBookingID == share.ToBookingID {
+					label = "Moved to " + split.SplitPNR
+				}
+			}
+			p.row(fmt.Sprintf("%s  %s", share.CreatedAt.UTC().Format("02 Jan 2006"), label), share.Money().Neg().String())
+		}
+	}
+
+	_, err := p.doc.WriteTo(w)
+	return err
+}
+
+type charge struct {
+	label  string
+	amount money.Money
+}
+
+func ticketLabel(number string) string {
+	if number == "" {
+		return "Not yet ticketed"
+	}
+	return "E-ticket " + number
+}
+
+func place(airport models.Airport) string {
+	if airport.City != "" {
+		return airport.City
+	}
+	return airport.Name
+}
+
+func title(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// page tracks where the next line goes and starts a new page when one is full.
+type page struct {
+	doc *pdf.Document
+	y   float64
+}
+
+func (p *page) newPage() {
+	p.doc.AddPage()
+	p.y = pdf.PageHeight - margin - 10
+}
+
+func (p *page) ensure(height float64) {
+	if p.y-height < margin {
+		p.newPage()
+	}
+}
+
+func (p *page) heading(text string) {
+	p.ensure(3 * lineHeight)
+	p.y -= 16
+	p.doc.Text(margin, p.y, pdf.Bold, 12, text)
+	p.y -= 5
+	p.rule()
+}
+
+func (p *page) rule() {
+	p.doc.Line(margin, p.y, right, p.y)
+	p.y -= lineHeight
+}
+
+func (p *page) row(left, value string) {
+	p.ensure(lineHeight)
+	p.doc.Text(margin, p.y, pdf.Regular, 10, left)
+	p.doc.TextRight(right, p.y, pdf.Regular, 10, value)
+	p.y -= lineHeight
+}
+
+func (p *page) boldRow(left, value string) {
+	p.ensure(lineHeight)
+	p.doc.Text(margin, p.y, pdf.Bold, 11, left)
+	p.doc.TextRight(right, p.y, pdf.Bold, 11, value)
+	p.y -= lineHeight
+}
@@ -0,0 +1,107 @@
+package documents
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"skyliner/internal/db/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testBooking(passengers int) *models.Booking {
+	seatPrice := int64(2500)
+	departure := time.Date(2026, 11, 20, 18, 30, 0, 0, time.UTC)
+	booking := &models.Booking{
+		PNR:          "ABC234",
+		Status:       models.StatusTicketed,
+		TotalAmount:  78338,
+		Currency:     "EUR",
+		BaseAmount:   84998,
+		BaseCurrency: "USD",
+		FXRate:       "0.921646",
+		CreatedAt:    time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC),
+		Itinerary: models.Itinerary{Segments: []models.Segment{{
+			ID: 1,
+			Flight: models.Flight{
+				Number:        "BA200",
+				Airline:       models.Airline{Name: "British Airways"},
+				Origin:        models.Airport{Code: "JFK", City: "New York"},
+				Destination:   models.Airport{Code: "LHR", City: "London"},
+				DepartureTime: departure,
+				ArrivalTime:   departure.Add(7 * time.Hour),
+			},
+			Fare:            models.Fare{Class: "economy", FareType: "standard", BasePrice: 39999, Currency: "USD"},
+			SeatAssignments: []models.SeatAssignment{{PassengerID: 1, Seat: models.Seat{Row: 12, Column: "A", Price: &seatPrice}}},
+		}}},
+		Ancillaries: []models.AncillaryPurchase{
+			{PassengerID: 1, Name: "Checked bag", Price: 6000, Currency: "USD", Status: models.AncillaryActive},
+			{PassengerID: 1, Name: "Lounge pass", Price: 5500, Currency: "USD", Status: models.AncillaryCancelled},
+		},
+		Discounts: []models.PromotionRedemption{{Code: "AUTUMN", Amount: 1000, Currency: "USD"}},
+		Payments:  []models.Payment{{Amount: 78338, Currency: "EUR", Method: "card", Status: "succeeded"}},
+		Tickets:   []models.Ticket{{PassengerID: 1, Number: "1250000000011"}},
+	}
+	for i := 1; i <= passengers; i++ {
+		booking.Passengers = append(booking.Passengers, models.Passenger{ID: uint(i), FirstName: "Ada", LastName: fmt.Sprintf("Lovelace%d", i)})
+	}
+	return booking
+}
+
+func TestItinerary(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, Itinerary(&out, testBooking(2)))
+	pdf := out.String()
+
+	assert.True(t, strings.HasPrefix(pdf, "%PDF-"))
+	for _, text := range []string{
+		"(ABC234)",
+		"(LOVELACE1/ADA)",
+		"(E-ticket 1250000000011)",
+		"(Not yet ticketed)",
+		"(BA200  New York \\(JFK\\) to London \\(LHR\\))",
+		"(Departs Fri 20 Nov 2026 18:30   Arrives Sat 21 Nov 2026 01:30)",
+		"(Seat 12A)",
+		"(Fare BA200, 2 x 399.99 USD)",
+		"(799.98 USD)",
+		"(Seat 12A, BA200)",
+		"(Checked bag, LOVELACE1/ADA)",
+		"(Promotion AUTUMN)",
+		"(-10.00 USD)",
+		"(849.98 USD)",
+		"(783.38 EUR)",
+	} {
+		assert.Contains(t, pdf, text)
+	}
+	assert.NotContains(t, pdf, "Lounge pass", "cancelled add-ons are not charged")
+	assert.Contains(t, pdf, "/Count 1")
+}
+
+func TestItineraryBreaksPages(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, Itinerary(&out, testBooking(60)))
+	assert.Contains(t, out.String(), "/Count 2")
+	assert.Contains(t, out.String(), "(LOVELACE60/ADA)")
+}
+
+func TestItineraryListsSplitShares(t *testing.T) {
+	booking := testBooking(1)
+	booking.Payments = nil
+	booking.SplitFrom = &models.BookingSplit{PNR: "XYZ789"}
+	booking.PaymentsIn = []models.PaymentAllocation{{Amount: 42499, Currency: "USD"}}
+	var out bytes.Buffer
+	assert.NoError(t, Itinerary(&out, booking))
+	assert.Contains(t, out.String(), "Share of payment on XYZ789")
+	assert.Contains(t, out.String(), "(424.99 USD)")
+
+	booking = testBooking(2)
+	booking.Splits = []models.BookingSplit{{SplitBookingID: 7, SplitPNR: "XYZ789"}}
+	booking.PaymentsOut = []models.PaymentAllocation{{ToBookingID: 7, Amount: 42499, Currency: "USD"}}
+	out.Reset()
+	assert.NoError(t, Itinerary(&out, booking))
+	assert.Contains(t, out.String(), "Moved to XYZ789")
+	assert.Contains(t, out.String(), "(-424.99 USD)")
+}
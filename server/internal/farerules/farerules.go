@@ -0,0 +1,94 @@
+package farerules
+
+import (
+	"time"
+
+	"skyliner/internal/money"
+)
+
+const (
+	FareBasic    = "basic"
+	FareStandard = "standard"
+	FareFlexible = "flexible"
+)
+
+// Rules are the conditions filed with a fare type. Fees are a share of the
+// fare in basis points so they work in whatever currency the fare is filed in.
+type Rules struct {
+	FareType string `json:"fare_type"`
+
+	Changeable   bool          `json:"changeable"`
+	ChangeFeeBPS int64         `json:"change_fee_bps"`
+	ChangeCutoff time.Duration `json:"change_cutoff"` // no changes closer than this to departure
+
+	Refundable   bool          `json:"refundable"`
+	CancelFeeBPS int64         `json:"cancel_fee_bps"`
+	RefundCutoff time.Duration `json:"refund_cutoff"` // nothing is refunded closer than this to departure
+}
+
+var rules = map[string]Rules{
+	FareBasic: {
+		FareType:   FareBasic,
+		Changeable: false,
+	},
+	FareStandard: {
+		FareType:     FareStandard,
+		Changeable:   true,
+		ChangeFeeBPS: 1500,
+		ChangeCutoff: 3 * time.Hour,
+		Refundable:   true,
+		CancelFeeBPS: 2500,
+		RefundCutoff: 24 * time.Hour,
+	},
+	FareFlexible: {
+		FareType:     FareFlexible,
+		Changeable:   true,
+		ChangeFeeBPS: 0,
+		ChangeCutoff: time.Hour,
+		Refundable:   true,
+		CancelFeeBPS: 0,
+		RefundCutoff: 0,
+	},
+}
+
+// For returns the rules of a fare type. Unknown types get the most
+// restrictive rules rather than the most generous.
+func For(fareType string) Rules {
+	if r, ok := rules[fareType]; ok {
+		return r
+	}
+	r := rules[FareBasic]
+	r.FareType = fareType
+	return r
+}
+
+// CanChange reports whether a segment departing at departure may still be
+// changed at now.
+func (r Rules) CanChange(departure, now time.Time) bool {
+	return r.Changeable && departure.Sub(now) >= r.ChangeCutoff
+}
+
+// ChangeFee is the fee for changing a segment booked at fare.
+func (r Rules) ChangeFee(fare money.Money) money.Money {
+	return fare.Percent(r.ChangeFeeBPS)
+}
+
+// CancellationPenalty is what is kept from fare when a segment departing at
+// departure is cancelled at now: the cancellation fee, or the whole fare when
+// it is non-refundable or past the refund cutoff.
+func (r Rules) CancellationPenalty(fare money.Money, departure, now time.Time) money.Money {
+	if !r.Refundable || departure.Sub(now) < r.RefundCutoff {
+		return fare
+	}
+	return fare.Percent(r.CancelFeeBPS)
+}
+
+// CancellationCredit is the part of fare returned as travel credit instead of
+// money when a segment departing at departure is cancelled at now: all of it
+// for a non-refundable fare, until its refund cutoff or departure.
+func (r Rules) CancellationCredit(fare money.Money, departure, now time.Time) money.Money {
+	if r.Refundable || !now.Before(departure) || departure.Sub(now) < r.RefundCutoff {
+		return money.Zero(fare.Currency)
+	}
+	return fare
+}
@@ -0,0 +1,46 @@
+package farerules
+
+import (
+	"testing"
+	"time"
+
+	"skyliner/internal/money"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanChange(t *testing.T) {
+	now := time.Now()
+
+	assert.False(t, For(FareBasic).CanChange(now.Add(72*time.Hour), now))
+	assert.True(t, For(FareStandard).CanChange(now.Add(4*time.Hour), now))
+	assert.False(t, For(FareStandard).CanChange(now.Add(2*time.Hour), now))
+	assert.True(t, For(FareFlexible).CanChange(now.Add(2*time.Hour), now))
+	assert.False(t, For("mystery").CanChange(now.Add(72*time.Hour), now))
+}
+
+func TestChangeFee(t *testing.T) {
+	fare := money.New(39999, "USD")
+	assert.Equal(t, money.New(6000, "USD"), For(FareStandard).ChangeFee(fare))
+	assert.Equal(t, money.Zero("USD"), For(FareFlexible).ChangeFee(fare))
+}
+
+func TestCancellationPenalty(t *testing.T) {
+	now := time.Now()
+	fare := money.New(40000, "USD")
+
+	assert.Equal(t, fare, For(FareBasic).CancellationPenalty(fare, now.Add(72*time.Hour), now))
+	assert.Equal(t, money.New(10000, "USD"), For(FareStandard).CancellationPenalty(fare, now.Add(72*time.Hour), now))
+	assert.Equal(t, fare, For(FareStandard).CancellationPenalty(fare, now.Add(12*time.Hour), now))
+	assert.Equal(t, money.Zero("USD"), For(FareFlexible).CancellationPenalty(fare, now.Add(time.Minute), now))
+}
+
+func TestCancellationCredit(t *testing.T) {
+	now := time.Now()
+	fare := money.New(40000, "USD")
+
+	assert.Equal(t, fare, For(FareBasic).CancellationCredit(fare, now.Add(time.Hour), now))
+	assert.Equal(t, money.Zero("USD"), For(FareBasic).CancellationCredit(fare, now, now))
+	assert.Equal(t, money.Zero("USD"), For(FareBasic).CancellationCredit(fare, now.Add(-48*time.Hour), now))
+	assert.Equal(t, money.Zero("USD"), For(FareStandard).CancellationCredit(fare, now.Add(72*time.Hour), now))
+}
@@ -0,0 +1,198 @@
+package fx
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"skyliner/internal/money"
+)
+
+var ErrUnsupportedCurrency = errors.New("unsupported currency")
+
+//go:embed rates.json
+var defaultRates []byte
+
+// Rate converts one unit of From into To. Value is kept as an exact decimal
+// so the figure recorded on a booking is the one that was actually applied.
+type Rate struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Value  *big.Rat  `json:"-"`
+	AsOf   time.Time `json:"as_of"`
+	Source string    `json:"source"`
+}
+
+// String renders the rate with enough precision to reproduce conversions.
+func (r Rate) String() string {
+	return r.Value.FloatString(8)
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	type alias Rate
+	return json.Marshal(struct {
+		alias
+		Value string `json:"rate"`
+	}{alias: alias(r), Value: r.String()})
+}
+
+// Convert applies the rate to an amount in From, rounding half away from zero
+// to the minor unit of To.
+func (r Rate) Convert(m money.Money) (money.Money, error) {
+	if m.Currency != r.From {
+		return money.Money{}, fmt.Errorf("%w: rate is for %s, amount is %s", money.ErrCurrencyMismatch, r.From, m.Currency)
+	}
+
+	// minor(To) = minor(From) * rate * 10^(exp(To) - exp(From))
+	v := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), r.Value)
+	shift := money.Exponent(r.To) - money.Exponent(r.From)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+	if shift >= 0 {
+		v.Mul(v, scale)
+	} else {
+		v.Quo(v, scale)
+	}
+	return money.New(roundHalfAway(v), r.To), nil
+}
+
+// ParseRate rebuilds a rate previously rendered with Rate.String, e.g. the
+// one recorded on a booking, so later charges use the same conversion.
+func ParseRate(from, to, value string, asOf time.Time) (Rate, error) {
+	v, ok := new(big.Rat).SetString(value)
+	if !ok || v.Sign() <= 0 {
+		return Rate{}, fmt.Errorf("invalid FX rate %q", value)
+	}
+	return Rate{
+		From:   strings.ToUpper(from),
+		To:     strings.ToUpper(to),
+		Value:  v,
+		AsOf:   asOf,
+		Source: "recorded",
+	}, nil
+}
+
+// Provider supplies exchange rates between ISO 4217 currencies.
+type Provider interface {
+	Rate(ctx context.Context, from, to string) (Rate, error)
+}
+
+// StaticProvider serves rates from a fixed table quoted against a base
+// currency, for offline use and tests.
+type StaticProvider struct {
+	base  string
+	asOf  time.Time
+	rates map[string]*big.Rat
+}
+
+type rateFile struct {
+	Base  string            `json:"base"`
+	AsOf  time.Time         `json:"as_of"`
+	Rates map[string]string `json:"rates"`
+}
+
+// NewStaticProvider loads rates from a JSON file. An empty path uses the
+// rates bundled with the server.
+func NewStaticProvider(path string) (*StaticProvider, error) {
+	data := defaultRates
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read FX rates: %w", err)
+		}
+	}
+	return ParseStatic(data)
+}
+
+// ParseStatic builds a provider from the JSON rate file format.
+func ParseStatic(data []byte) (*StaticProvider, error) {
+	var file rateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse FX rates: %w", err)
+	}
+	if file.Base == "" {
+		return nil, errors.New("FX rates file has no base currency")
+	}
+
+	p := &StaticProvider{
+		base:  strings.ToUpper(file.Base),
+		asOf:  file.AsOf,
+		rates: map[string]*big.Rat{},
+	}
+	for code, value := range file.Rates {
+		rate, ok := new(big.Rat).SetString(value)
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid FX rate for %s: %q", code, value)
+		}
+		p.rates[strings.ToUpper(code)] = rate
+	}
+	p.rates[p.base] = big.NewRat(1, 1)
+	return p, nil
+}
+
+// Default returns a provider with the bundled rates.
+func Default() *StaticProvider {
+	p, err := ParseStatic(defaultRates)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p *StaticProvider) Rate(_ context.Context, from, to string) (Rate, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	fromRate, ok := p.rates[from]
+	if !ok {
+		return Rate{}, fmt.Errorf("%w: %s", ErrUnsupportedCurrency, from)
+	}
+	toRate, ok := p.rates[to]
+	if !ok {
+		return Rate{}, fmt.Errorf("%w: %s", ErrUnsupportedCurrency, to)
+	}
+
+	// Both are quoted per unit of base, so from->to is to/from. Round to the
+	// precision of String so the recorded rate reproduces every conversion.
+	value := new(big.Rat).Quo(toRate, fromRate)
+	value.SetString(value.FloatString(8))
+	return Rate{
+		From:   from,
+		To:     to,
+		Value:  value,
+		AsOf:   p.asOf,
+		Source: "static",
+	}, nil
+}
+
+// Supports reports whether the currency has a rate.
+func (p *StaticProvider) Supports(currency string) bool {
+	_, ok := p.rates[strings.ToUpper(currency)]
+	return ok
+}
+
+func roundHalfAway(v *big.Rat) int64 {
+	num := new(big.Int).Set(v.Num())
+	den := v.Denom()
+	negative := num.Sign() < 0
+	num.Abs(num)
+
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Mul(r, big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if negative {
+		q.Neg(q)
+	}
+	return q.Int64()
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,83 @@
+package fx
+
+import (
+	"context"
+	"testing"
+
+	"skyliner/internal/money"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRates = `{
+  "base": "USD",
+  "as_of": "2025-01-02T00:00:00Z",
+  "rates": {"EUR": "0.9", "GBP": "0.8", "JPY": "150", "KWD": "0.308"}
+}`
+
+func TestStaticProviderRate(t *testing.T) {
+	p, err := ParseStatic([]byte(testRates))
+	assert.NoError(t, err)
+
+	rate, err := p.Rate(context.Background(), "usd", "EUR")
+	assert.NoError(t, err)
+	assert.Equal(t, "USD", rate.From)
+	assert.Equal(t, "EUR", rate.To)
+	assert.Equal(t, "0.90000000", rate.String())
+
+	// Cross rate through the base currency
+	rate, err = p.Rate(context.Background(), "GBP", "EUR")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.12500000", rate.String())
+
+	_, err = p.Rate(context.Background(), "USD", "XXX")
+	assert.ErrorIs(t, err, ErrUnsupportedCurrency)
+}
+
+func TestRateConvert(t *testing.T) {
+	p, err := ParseStatic([]byte(testRates))
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		amount   money.Money
+		to       string
+		expected money.Money
+	}{
+		{name: "usd to eur", amount: money.New(29999, "USD"), to: "EUR", expected: money.New(26999, "EUR")},
+		{name: "usd to jpy drops decimals", amount: money.New(29999, "USD"), to: "JPY", expected: money.New(44999, "JPY")},
+		{name: "jpy to usd adds decimals", amount: money.New(15000, "JPY"), to: "USD", expected: money.New(10000, "USD")},
+		{name: "usd to kwd uses three decimals", amount: money.New(10000, "USD"), to: "KWD", expected: money.New(30800, "KWD")},
+		{name: "rounds half away from zero", amount: money.New(5, "USD"), to: "EUR", expected: money.New(5, "EUR")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, err := p.Rate(ctx, tt.amount.Currency, tt.to)
+			assert.NoError(t, err)
+			converted, err := rate.Convert(tt.amount)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, converted)
+		})
+	}
+
+	rate, _ := p.Rate(ctx, "USD", "EUR")
+	_, err = rate.Convert(money.New(100, "GBP"))
+	assert.ErrorIs(t, err, money.ErrCurrencyMismatch)
+}
+
+func TestParseStaticRejectsBadRates(t *testing.T) {
+	_, err := ParseStatic([]byte(`{"base": "USD", "rates": {"EUR": "abc"}}`))
+	assert.Error(t, err)
+
+	_, err = ParseStatic([]byte(`{"rates": {"EUR": "0.9"}}`))
+	assert.Error(t, err)
+}
+
+func TestDefault(t *testing.T) {
+	p := Default()
+	for _, code := range []string{"USD", "GBP", "EUR", "JPY"} {
+		assert.True(t, p.Supports(code), code)
+	}
+}
@@ -0,0 +1,562 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errAncillaryUnavailable = errors.New("add-on is not sold on this segment")
+	errAncillarySoldOut     = errors.New("add-on is sold out on this flight")
+	errAncillaryLimit       = errors.New("passenger already has as many of this add-on as allowed")
+)
+
+type AncillaryHandler struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+func NewAncillaryHandler(db *gorm.DB, cfg *config.Config) *AncillaryHandler {
+	return &AncillaryHandler{db: db, cfg: cfg}
+}
+
+// AncillaryRequest creates or replaces a catalog entry. See models.Ancillary
+// for what each field means.
+type AncillaryRequest struct {
+	AirlineID       uint                 `json:"airline_id" binding:"required"`
+	Type            models.AncillaryType `json:"type" binding:"required,oneof=baggage meal lounge priority_boarding"`
+	Code            string               `json:"code" binding:"required"`
+	Name            string               `json:"name" binding:"required"`
+	Route           string               `json:"route"`
+	Cabin           string               `json:"cabin"`
+	Price           int64                `json:"price" binding:"min=0"`
+	Currency        string               `json:"currency" binding:"required,len=3"`
+	Weight          int                  `json:"weight" binding:"min=0"`
+	FlightLimit     int                  `json:"flight_limit" binding:"min=0"`
+	MaxPerPassenger int                  `json:"max_per_passenger" binding:"min=0"`
+	Active          *bool                `json:"active"` // defaults to true
+}
+
+func (r AncillaryRequest) ancillary() models.Ancillary {
+	return models.Ancillary{
+		AirlineID:       r.AirlineID,
+		Type:            r.Type,
+		Code:            strings.ToUpper(strings.TrimSpace(r.Code)),
+		Name:            r.Name,
+		Route:           strings.ToUpper(strings.TrimSpace(r.Route)),
+		Cabin:           strings.ToLower(strings.TrimSpace(r.Cabin)),
+		Price:           r.Price,
+		Currency:        strings.ToUpper(r.Currency),
+		Weight:          r.Weight,
+		FlightLimit:     r.FlightLimit,
+		MaxPerPassenger: r.MaxPerPassenger,
+		Active:          r.Active == nil || *r.Active,
+	}
+}
+
+// AncillaryItemRequest buys an add-on while booking. Like seats, it refers to
+// passengers and segments by their position in the request.
+type AncillaryItemRequest struct {
+	Passenger   int  `json:"passenger" binding:"min=0"` // index into passengers
+	Segment     int  `json:"segment" binding:"min=0"`   // index into segments
+	AncillaryID uint `json:"ancillary_id" binding:"required"`
+}
+
+// AddAncillariesRequest buys add-ons for an existing booking.
+type AddAncillariesRequest struct {
+	Items []AncillaryPurchaseRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+type AncillaryPurchaseRequest struct {
+	PassengerID uint `json:"passenger_id" binding:"required"`
+	SegmentID   uint `json:"segment_id" binding:"required"`
+	AncillaryID uint `json:"ancillary_id" binding:"required"`
+}
+
+// AncillaryOffer is an add-on that can be bought on a flight.
+type AncillaryOffer struct {
+	models.Ancillary
+	Remaining *int `json:"remaining"` // nil when the flight has no limit
+}
+
+// GetAncillaries lists the catalog, optionally for one airline.
+func (h *AncillaryHandler) GetAncillaries(c *gin.Context) {
+	query := h.db.Order("airline_id, type, code")
+	if airlineID := c.Query("airline_id"); airlineID != "" {
+		query = query.Where("airline_id = ?", airlineID)
+	}
+
+	var ancillaries []models.Ancillary
+	if err := query.Find(&ancillaries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch add-ons"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ancillaries": ancillaries})
+}
+
+// CreateAncillary adds a product to the catalog. Admins only.
+func (h *AncillaryHandler) CreateAncillary(c *gin.Context) {
+	if c.GetString("role") != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+
+	var req AncillaryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ancillary := req.ancillary()
+	if err := h.db.First(&models.Airline{}, ancillary.AirlineID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown airline"})
+		return
+	}
+
+	if err := h.db.Create(&ancillary).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create add-on"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"ancillary": ancillary})
+}
+
+// UpdateAncillary replaces a catalog entry, e.g. to reprice it or stop
+// selling it with active false. Purchases keep the price they were sold at.
+// Admins only.
+func (h *AncillaryHandler) UpdateAncillary(c *gin.Context) {
+	if c.GetString("role") != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+
+	ancillaryID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid add-on ID"})
+		return
+	}
+	var req AncillaryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var ancillary models.Ancillary
+	if err := h.db.First(&ancillary, uint(ancillaryID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Add-on not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch add-on"})
+		return
+	}
+	update := req.ancillary()
+	update.ID = ancillary.ID
+	update.CreatedAt = ancillary.CreatedAt
+
+	if err := h.db.Save(&update).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update add-on"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ancillary": update})
+}
+
+// GetFlightAncillaries lists the add-ons sold on a flight, optionally for one
+// ?cabin=, with how many are left where the flight has a limit.
+func (h *AncillaryHandler) GetFlightAncillaries(c *gin.Context) {
+	flightID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid flight ID"})
+		return
+	}
+
+	var flight models.Flight
+	if err := h.db.Preload("Origin").Preload("Destination").First(&flight, uint(flightID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Flight not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch flight"})
+		return
+	}
+
+	query := h.db.Where("airline_id = ? AND active = ? AND (route = '' OR route = ?)", flight.AirlineID, true, flightRoute(flight))
+	if cabin := c.Query("cabin"); cabin != "" {
+		query = query.Where("cabin = '' OR cabin = ?", strings.ToLower(cabin))
+	}
+	var ancillaries []models.Ancillary
+	if err := query.Order("type, code").Find(&ancillaries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch add-ons"})
+		return
+	}
+
+	offers := make([]AncillaryOffer, len(ancillaries))
+	for i, ancillary := range ancillaries {
+		offers[i] = AncillaryOffer{Ancillary: ancillary}
+		if ancillary.FlightLimit > 0 {
+			sold, err := countAncillaries(h.db.Where("flight_id = ? AND ancillary_id = ?", flight.ID, ancillary.ID), ancillaryHoldCutoff(h.cfg.HoldTTL))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch add-ons"})
+				return
+			}
+			remaining := max(ancillary.FlightLimit-sold, 0)
+			offers[i].Remaining = &remaining
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ancillaries": offers})
+}
+
+// GetBookingAncillaries lists the add-ons bought on a booking.
+func (h *BookingHandler) GetBookingAncillaries(c *gin.Context) {
+	booking, ok := h.loadOwnedBooking(c, h.db)
+	if !ok {
+		return
+	}
+
+	var purchases []models.AncillaryPurchase
+	if err := h.db.Where("booking_id = ?", booking.ID).Order("id").Find(&purchases).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch add-ons"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ancillaries": purchases})
+}
+
+// AddAncillaries buys add-ons after booking. Unpaid bookings are repriced.
+// On paid bookings the add-ons wait for their own payment, made by passing
+// their IDs to the checkout session endpoint, and hold their inventory for
+// HOLD_TTL meanwhile.
+func (h *BookingHandler) AddAncillaries(c *gin.Context) {
+	var req AddAncillariesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := h.db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	booking, ok := h.loadOwnedBooking(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+	if booking.Status == models.StatusCancelled {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Booking is cancelled"})
+		return
+	}
+	status := models.AncillaryActive
+	if booking.Status != models.StatusHold {
+		status = models.AncillaryPendingPayment
+	}
+
+	purchases := make([]models.AncillaryPurchase, 0, len(req.Items))
+	charges := money.Zero(booking.Base().Currency)
+	for _, item := range req.Items {
+		var passenger models.Passenger
+		if err := tx.Where("id = ? AND booking_id = ?", item.PassengerID, booking.ID).First(&passenger).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Passenger is not on this booking"})
+			return
+		}
+		var segment models.Segment
+		if err := tx.Preload("Fare").
+			Joins("JOIN itineraries ON itineraries.id = segments.itinerary_id").
+			Where("segments.id = ? AND itineraries.booking_id = ?", item.SegmentID, booking.ID).
+			First(&segment).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Segment is not on this booking"})
+			return
+		}
+
+		purchase, err := sellAncillary(tx, &booking, passenger.ID, &segment, item.AncillaryID, status, h.holdCutoff())
+		if err != nil {
+			tx.Rollback()
+			c.JSON(ancillaryErrorStatus(err), gin.H{"error": ancillaryErrorMessage(err)})
+			return
+		}
+		if charges, err = charges.Add(purchase.Money()); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Add-on prices must be in the fare currency"})
+			return
+		}
+		purchases = append(purchases, *purchase)
+	}
+
+	if status == models.AncillaryActive && !charges.IsZero() {
+		if err := chargeBooking(tx, &booking, charges); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update booking total"})
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add add-ons"})
+		return
+	}
+
+	if status == models.AncillaryPendingPayment {
+		due, err := settle(&booking, charges)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert currency"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"ancillaries": purchases, "amount_due": due})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"ancillaries": purchases, "total_amount": booking.Total()})
+}
+
+// CancelAncillary removes an add-on that has not been paid for: either from
+// an unpaid booking, which is repriced, or one still waiting for its own
+// payment. Paid add-ons are not refunded.
+func (h *BookingHandler) CancelAncillary(c *gin.Context) {
+	purchaseID, err := strconv.ParseUint(c.Param("purchase_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid add-on ID"})
+		return
+	}
+
+	tx := h.db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	booking, ok := h.loadOwnedBooking(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	var purchase models.AncillaryPurchase
+	if err := tx.Where("id = ? AND booking_id = ?", uint(purchaseID), booking.ID).First(&purchase).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Add-on not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch add-on"})
+		return
+	}
+	unpaid := purchase.Status == models.AncillaryPendingPayment ||
+		purchase.Status == models.AncillaryActive && booking.Status == models.StatusHold
+	if !unpaid {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only unpaid add-ons can be cancelled"})
+		return
+	}
+
+	if purchase.Status == models.AncillaryActive {
+		if err := chargeBooking(tx, &booking, purchase.Money().Neg()); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update booking total"})
+			return
+		}
+		if err := tx.Where("purchase_id = ?", purchase.ID).Delete(&models.Baggage{}).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel add-on"})
+			return
+		}
+	}
+	purchase.Status = models.AncillaryCancelled
+	if err := tx.Save(&purchase).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel add-on"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel add-on"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ancillary": purchase, "total_amount": booking.Total()})
+}
+
+func (h *BookingHandler) holdCutoff() time.Time {
+	return ancillaryHoldCutoff(h.cfg.HoldTTL)
+}
+
+// ancillaryHoldCutoff is when add-ons still waiting for payment stop holding
+// their inventory; the zero time when holds do not expire.
+func ancillaryHoldCutoff(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-ttl)
+}
+
+// sellAncillary buys an add-on for a passenger on a segment, checking it is
+// sold there and is within its limits. segment.Fare must be loaded. Active
+// baggage also gets its bag record.
+func sellAncillary(tx *gorm.DB, booking *models.Booking, passengerID uint, segment *models.Segment, ancillaryID uint, status models.AncillaryStatus, cutoff time.Time) (*models.AncillaryPurchase, error) {
+	var ancillary models.Ancillary
+	if err := tx.Where("id = ? AND active = ?", ancillaryID, true).First(&ancillary).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errAncillaryUnavailable
+		}
+		return nil, err
+	}
+	var flight models.Flight
+	if err := tx.Preload("Origin").Preload("Destination").First(&flight, segment.FlightID).Error; err != nil {
+		return nil, err
+	}
+	if ancillary.AirlineID != flight.AirlineID ||
+		ancillary.Route != "" && ancillary.Route != flightRoute(flight) ||
+		ancillary.Cabin != "" && ancillary.Cabin != segment.Fare.Class ||
+		ancillary.Currency != segment.Fare.Currency {
+		return nil, errAncillaryUnavailable
+	}
+
+	if ancillary.MaxPerPassenger > 0 {
+		bought, err := countAncillaries(tx.Where("passenger_id = ? AND segment_id = ? AND ancillary_id = ?", passengerID, segment.ID, ancillary.ID), cutoff)
+		if err != nil {
+			return nil, err
+		}
+		if bought >= ancillary.MaxPerPassenger {
+			return nil, errAncillaryLimit
+		}
+	}
+	if ancillary.FlightLimit > 0 {
+		sold, err := countAncillaries(tx.Where("flight_id = ? AND ancillary_id = ?", segment.FlightID, ancillary.ID), cutoff)
+		if err != nil {
+			return nil, err
+		}
+		if sold >= ancillary.FlightLimit {
+			return nil, errAncillarySoldOut
+		}
+	}
+
+	purchase := models.AncillaryPurchase{
+		BookingID:   booking.ID,
+		PassengerID: passengerID,
+		SegmentID:   segment.ID,
+		FlightID:    segment.FlightID,
+		AncillaryID: ancillary.ID,
+		Type:        ancillary.Type,
+		Code:        ancillary.Code,
+		Name:        ancillary.Name,
+		Price:       ancillary.Price,
+		Currency:    ancillary.Currency,
+		Status:      status,
+	}
+	if err := tx.Create(&purchase).Error; err != nil {
+		return nil, err
+	}
+	if status == models.AncillaryActive {
+		if err := addPurchasedBaggage(tx, &purchase); err != nil {
+			return nil, err
+		}
+	}
+	return &purchase, nil
+}
+
+// activateAncillaries marks add-ons paid for separately as bought. It returns
+// how many were still waiting, so a repeated webhook changes nothing.
+func activateAncillaries(tx *gorm.DB, bookingID uint, ids []uint) (int, error) {
+	var purchases []models.AncillaryPurchase
+	if err := tx.Where("id IN ? AND booking_id = ? AND status = ?", ids, bookingID, models.AncillaryPendingPayment).
+		Find(&purchases).Error; err != nil {
+		return 0, err
+	}
+	for i := range purchases {
+		if err := tx.Model(&purchases[i]).Update("status", models.AncillaryActive).Error; err != nil {
+			return 0, err
+		}
+		if err := addPurchasedBaggage(tx, &purchases[i]); err != nil {
+			return 0, err
+		}
+	}
+	return len(purchases), nil
+}
+
+// addPurchasedBaggage records the checked bag bought with a purchase.
+func addPurchasedBaggage(tx *gorm.DB, purchase *models.AncillaryPurchase) error {
+	if purchase.Type != models.AncillaryBaggage {
+		return nil
+	}
+	var ancillary models.Ancillary
+	if err := tx.First(&ancillary, purchase.AncillaryID).Error; err != nil {
+		return err
+	}
+	return tx.Create(&models.Baggage{
+		BookingID:  purchase.BookingID,
+		PurchaseID: &purchase.ID,
+		Type:       "checked",
+		Weight:     ancillary.Weight,
+		Price:      purchase.Price,
+	}).Error
+}
+
+// cancelAncillaries closes a booking's add-ons, or those on one of its
+// segments when segmentID is not zero, giving their inventory back.
+func cancelAncillaries(tx *gorm.DB, bookingID, segmentID uint) error {
+	query := tx.Model(&models.AncillaryPurchase{}).
+		Where("booking_id = ? AND status IN ?", bookingID, []models.AncillaryStatus{models.AncillaryActive, models.AncillaryPendingPayment})
+	if segmentID != 0 {
+		query = query.Where("segment_id = ?", segmentID)
+	}
+	return query.Update("status", models.AncillaryCancelled).Error
+}
+
+// countAncillaries counts the purchases matched by query that hold inventory:
+// those bought, and those waiting for payment since after cutoff.
+func countAncillaries(query *gorm.DB, cutoff time.Time) (int, error) {
+	var count int64
+	err := query.Model(&models.AncillaryPurchase{}).
+		Where("status = ? OR (status = ? AND created_at > ?)", models.AncillaryActive, models.AncillaryPendingPayment, cutoff).
+		Count(&count).Error
+	return int(count), err
+}
+
+// bookingAncillaryCharges is what a booking's bought add-ons cost, in its
+// fare currency.
+func bookingAncillaryCharges(db *gorm.DB, bookingID uint, currency string) (money.Money, error) {
+	var amount int64
+	if err := db.Model(&models.AncillaryPurchase{}).
+		Where("booking_id = ? AND currency = ? AND status = ?", bookingID, currency, models.AncillaryActive).
+		Select("COALESCE(SUM(price), 0)").Scan(&amount).Error; err != nil {
+		return money.Money{}, err
+	}
+	return money.New(amount, currency), nil
+}
+
+func flightRoute(flight models.Flight) string {
+	return flight.Origin.Code + "-" + flight.Destination.Code
+}
+
+func ancillaryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errAncillaryUnavailable):
+		return http.StatusBadRequest
+	case errors.Is(err, errAncillarySoldOut), errors.Is(err, errAncillaryLimit):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func ancillaryErrorMessage(err error) string {
+	if ancillaryErrorStatus(err) == http.StatusInternalServerError {
+		return "Failed to add add-on"
+	}
+	return err.Error()
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v76"
+	"gorm.io/gorm"
+)
+
+type testCatalog struct {
+	bag, lounge, businessMeal, otherRoute, retired models.Ancillary
+}
+
+// setupAncillaryTestRouter serves the traveler routes as user 1 and the
+// admin routes as the agent, or the admin with X-Test-Admin.
+func setupAncillaryTestRouter(db *gorm.DB) (*gin.Engine, testCatalog) {
+	gin.SetMode(gin.TestMode)
+	catalog := testCatalog{
+		bag:          models.Ancillary{AirlineID: 1, Type: models.AncillaryBaggage, Code: "BAG23", Name: "Checked bag", Cabin: "economy", Price: 6000, Currency: "USD", Weight: 23, MaxPerPassenger: 2, Active: true},
+		lounge:       models.Ancillary{AirlineID: 1, Type: models.AncillaryLounge, Code: "LOUNGE", Name: "Lounge pass", Price: 5500, Currency: "USD", FlightLimit: 1, Active: true},
+		businessMeal: models.Ancillary{AirlineID: 1, Type: models.AncillaryMeal, Code: "CHEF", Name: "Chef's menu", Cabin: "business", Price: 3000, Currency: "USD", Active: true},
+		otherRoute:   models.Ancillary{AirlineID: 1, Type: models.AncillaryMeal, Code: "BENTO", Name: "Bento box", Route: "LAX-NRT", Price: 1500, Currency: "USD", Active: true},
+		retired:      models.Ancillary{AirlineID: 1, Type: models.AncillaryPriorityBoarding, Code: "PRIORITY", Name: "Priority boarding", Price: 2000, Currency: "USD"},
+	}
+	for _, a := range []*models.Ancillary{&catalog.bag, &catalog.lounge, &catalog.businessMeal, &catalog.otherRoute, &catalog.retired} {
+		db.Create(a)
+	}
+
+	cfg := &config.Config{HoldTTL: 24 * time.Hour}
+	bookingHandler := NewBookingHandler(db, cfg, fx.Default(), payments.NewFakeClient(), nil)
+	ancillaryHandler := NewAncillaryHandler(db, cfg)
+	paymentHandler := NewPaymentHandler(db, cfg)
+
+	router := gin.New()
+	router.GET("/flights/:id/ancillaries", ancillaryHandler.GetFlightAncillaries)
+
+	protected := router.Group("")
+	protected.Use(func(c *gin.Context) {
+		c.Set("user_id", uint(1))
+		c.Next()
+	})
+	protected.POST("/bookings", bookingHandler.CreateBooking)
+	protected.POST("/bookings/:id/cancel", bookingHandler.CancelBooking)
+	protected.GET("/bookings/:id/ancillaries", bookingHandler.GetBookingAncillaries)
+	protected.POST("/bookings/:id/ancillaries", bookingHandler.AddAncillaries)
+	protected.POST("/bookings/:id/ancillaries/:purchase_id/cancel", bookingHandler.CancelAncillary)
+	protected.POST("/payments/checkout-session", paymentHandler.CreateCheckoutSession)
+
+	admin := router.Group("/admin")
+	admin.Use(testStaffRole)
+	admin.POST("/ancillaries", ancillaryHandler.CreateAncillary)
+	admin.PUT("/ancillaries/:id", ancillaryHandler.UpdateAncillary)
+	return router, catalog
+}
+
+func ancillaryBooking(items ...map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"segments":    []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers":  []map[string]interface{}{{"first_name": "Ada", "last_name": "Lovelace"}},
+		"ancillaries": items,
+	}
+}
+
+func TestBookingHandler_CreateBookingWithAncillaries(t *testing.T) {
+	db := setupBookingTestDB()
+	router, catalog := setupAncillaryTestRouter(db)
+
+	booking := createTestBooking(t, router, ancillaryBooking(
+		map[string]interface{}{"passenger": 0, "segment": 0, "ancillary_id": catalog.bag.ID},
+		map[string]interface{}{"passenger": 0, "segment": 0, "ancillary_id": catalog.lounge.ID},
+	))
+	assert.Equal(t, int64(39999+6000+5500), booking.TotalAmount)
+	if assert.Len(t, booking.Ancillaries, 2) {
+		assert.Equal(t, models.AncillaryActive, booking.Ancillaries[0].Status)
+		assert.Equal(t, booking.Passengers[0].ID, booking.Ancillaries[0].PassengerID)
+	}
+
+	var bags []models.Baggage
+	db.Where("booking_id = ?", booking.ID).Find(&bags)
+	if assert.Len(t, bags, 1) {
+		assert.Equal(t, 23, bags[0].Weight)
+		assert.Equal(t, int64(6000), bags[0].Price)
+	}
+
+	tests := []struct {
+		name   string
+		item   map[string]interface{}
+		status int
+	}{
+		{"other cabin", map[string]interface{}{"passenger": 0, "segment": 0, "ancillary_id": catalog.businessMeal.ID}, http.StatusBadRequest},
+		{"other route", map[string]interface{}{"passenger": 0, "segment": 0, "ancillary_id": catalog.otherRoute.ID}, http.StatusBadRequest},
+		{"no longer sold", map[string]interface{}{"passenger": 0, "segment": 0, "ancillary_id": catalog.retired.ID}, http.StatusBadRequest},
+		{"unknown segment", map[string]interface{}{"passenger": 0, "segment": 1, "ancillary_id": catalog.bag.ID}, http.StatusBadRequest},
+		{"sold out on the flight", map[string]interface{}{"passenger": 0, "segment": 0, "ancillary_id": catalog.lounge.ID}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postBooking(router, ancillaryBooking(tt.item))
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+		})
+	}
+
+	bag := map[string]interface{}{"passenger": 0, "segment": 0, "ancillary_id": catalog.bag.ID}
+	w := postBooking(router, ancillaryBooking(bag, bag, bag))
+	assert.Equal(t, http.StatusConflict, w.Code, "at most two bags per passenger")
+
+	// Client-supplied prices are no longer accepted
+	w = postBooking(router, map[string]interface{}{
+		"segments":   []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers": []map[string]interface{}{{"first_name": "Ada", "last_name": "Lovelace"}},
+		"extras":     []map[string]interface{}{{"type": "baggage", "price": 1}},
+	})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var response BookingResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, int64(39999), response.TotalAmount.Amount)
+}
+
+func TestBookingHandler_FlightAncillaries(t *testing.T) {
+	db := setupBookingTestDB()
+	router, catalog := setupAncillaryTestRouter(db)
+	createTestBooking(t, router, ancillaryBooking(map[string]interface{}{"passenger": 0, "segment": 0, "ancillary_id": catalog.lounge.ID}))
+
+	req, _ := http.NewRequest("GET", "/flights/1/ancillaries?cabin=economy", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Ancillaries []AncillaryOffer `json:"ancillaries"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	codes := map[string]*int{}
+	for _, offer := range response.Ancillaries {
+		codes[offer.Code] = offer.Remaining
+	}
+	assert.Len(t, codes, 2, "business, other-route and retired add-ons are not offered")
+	assert.Nil(t, codes["BAG23"])
+	if assert.NotNil(t, codes["LOUNGE"]) {
+		assert.Equal(t, 0, *codes["LOUNGE"])
+	}
+}
+
+func TestBookingHandler_AddAncillariesToHeldBooking(t *testing.T) {
+	db := setupBookingTestDB()
+	router, catalog := setupAncillaryTestRouter(db)
+	booking := createTestBooking(t, router, nil)
+	path := fmt.Sprintf("/bookings/%d/ancillaries", booking.ID)
+
+	w := postJSON(router, path, gin.H{"items": []gin.H{{"passenger_id": booking.Passengers[0].ID, "segment_id": booking.Itinerary.Segments[0].ID, "ancillary_id": catalog.bag.ID}}})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var added struct {
+		Ancillaries []models.AncillaryPurchase `json:"ancillaries"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &added))
+
+	var updated models.Booking
+	db.First(&updated, booking.ID)
+	assert.Equal(t, int64(39999+6000), updated.TotalAmount)
+	price, err := priceBooking(db, &updated)
+	assert.NoError(t, err)
+	assert.Equal(t, updated.BaseAmount, price.Amount)
+
+	w = postJSON(router, fmt.Sprintf("%s/%d/cancel", path, added.Ancillaries[0].ID), nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	db.First(&updated, booking.ID)
+	assert.Equal(t, int64(39999), updated.TotalAmount)
+	var bags int64
+	db.Model(&models.Baggage{}).Where("booking_id = ?", booking.ID).Count(&bags)
+	assert.Zero(t, bags)
+}
+
+func TestBookingHandler_AddAncillariesToPaidBooking(t *testing.T) {
+	db := setupBookingTestDB()
+	router, catalog := setupAncillaryTestRouter(db)
+	booking := createTestBooking(t, router, nil)
+	db.Model(&booking).Update("status", models.StatusPaid)
+	path := fmt.Sprintf("/bookings/%d/ancillaries", booking.ID)
+
+	w := postJSON(router, path, gin.H{"items": []gin.H{{"passenger_id": booking.Passengers[0].ID, "segment_id": booking.Itinerary.Segments[0].ID, "ancillary_id": catalog.lounge.ID}}})
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"amount_due":{"amount":5500,"currency":"USD"}`)
+	var added struct {
+		Ancillaries []models.AncillaryPurchase `json:"ancillaries"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &added))
+	purchase := added.Ancillaries[0]
+	assert.Equal(t, models.AncillaryPendingPayment, purchase.Status)
+
+	// The booking total is unchanged and the pass is held for the customer
+	var updated models.Booking
+	db.First(&updated, booking.ID)
+	assert.Equal(t, int64(39999), updated.TotalAmount)
+	w = postJSON(router, path, gin.H{"items": []gin.H{{"passenger_id": booking.Passengers[0].ID, "segment_id": booking.Itinerary.Segments[0].ID, "ancillary_id": catalog.lounge.ID}}})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	h := NewPaymentHandler(db, &config.Config{})
+	session := &stripe.CheckoutSession{
+		Metadata:      map[string]string{"booking_id": strconv.Itoa(int(booking.ID)), "ancillary_ids": strconv.Itoa(int(purchase.ID))},
+		PaymentIntent: &stripe.PaymentIntent{ID: "pi_addons"},
+		AmountTotal:   5500,
+		Currency:      "usd",
+	}
+	h.handleCheckoutSessionCompleted(session)
+	h.handleCheckoutSessionCompleted(session) // Stripe retries
+
+	db.First(&purchase, purchase.ID)
+	assert.Equal(t, models.AncillaryActive, purchase.Status)
+	var paid []models.Payment
+	db.Where("booking_id = ?", booking.ID).Find(&paid)
+	if assert.Len(t, paid, 1) {
+		assert.Equal(t, int64(5500), paid[0].Amount)
+	}
+	db.First(&updated, booking.ID)
+	assert.Equal(t, models.StatusPaid, updated.Status)
+
+	// Once paid it is neither payable again nor cancellable
+	w = postJSON(router, "/payments/checkout-session", gin.H{"booking_id": booking.ID, "ancillary_ids": []uint{purchase.ID}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = postJSON(router, fmt.Sprintf("%s/%d/cancel", path, purchase.ID), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestBookingHandler_ExpiredAncillaryHold(t *testing.T) {
+	db := setupBookingTestDB()
+	router, catalog := setupAncillaryTestRouter(db)
+	booking := createTestBooking(t, router, nil)
+	db.Model(&booking).Update("status", models.StatusPaid)
+	item := gin.H{"items": []gin.H{{"passenger_id": booking.Passengers[0].ID, "segment_id": booking.Itinerary.Segments[0].ID, "ancillary_id": catalog.lounge.ID}}}
+	path := fmt.Sprintf("/bookings/%d/ancillaries", booking.ID)
+
+	w := postJSON(router, path, item)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	db.Model(&models.AncillaryPurchase{}).Where("booking_id = ?", booking.ID).Update("created_at", time.Now().Add(-48*time.Hour))
+
+	w = postJSON(router, "/payments/checkout-session", gin.H{"booking_id": booking.ID, "ancillary_ids": []uint{1}})
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+	// The unpaid pass no longer holds the flight's only one
+	w = postJSON(router, path, item)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+}
+
+func TestAncillaryHandler_ManageCatalog(t *testing.T) {
+	db := setupBookingTestDB()
+	router, catalog := setupAncillaryTestRouter(db)
+	body := gin.H{"airline_id": 1, "type": "lounge", "code": "lounge2", "name": "Lounge pass", "price": 4000, "currency": "usd", "flight_limit": 10}
+
+	w := adminRequest(router, "POST", "/admin/ancillaries", body, false)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = adminRequest(router, "POST", "/admin/ancillaries", body, true)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"code":"LOUNGE2"`)
+
+	body["type"] = "spa"
+	w = adminRequest(router, "POST", "/admin/ancillaries", body, true)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Repricing the catalog does not change what was already sold
+	booking := createTestBooking(t, router, ancillaryBooking(map[string]interface{}{"passenger": 0, "segment": 0, "ancillary_id": catalog.bag.ID}))
+	w = adminRequest(router, "PUT", fmt.Sprintf("/admin/ancillaries/%d", catalog.bag.ID),
+		gin.H{"airline_id": 1, "type": "baggage", "code": "BAG23", "name": "Checked bag", "price": 7000, "currency": "USD", "weight": 23}, true)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var purchase models.AncillaryPurchase
+	db.Where("booking_id = ?", booking.ID).First(&purchase)
+	assert.Equal(t, int64(6000), purchase.Price)
+}
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"skyliner/internal/apis"
+	"skyliner/internal/db/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// APISRequest adds or corrects a passenger's travel document details. Only
+// the fields given are changed.
+type APISRequest struct {
+	DateOfBirth     *time.Time `json:"date_of_birth"`
+	Gender          *string    `json:"gender"`
+	Nationality     *string    `json:"nationality"`
+	Passport        *string    `json:"passport"`
+	PassportExpiry  *time.Time `json:"passport_expiry"`
+	PassportCountry *string    `json:"passport_country"`
+}
+
+// PassengerAPIS is a passenger's APIS record and what it still lacks for the
+// booking's itinerary.
+type PassengerAPIS struct {
+	PassengerID uint               `json:"passenger_id"`
+	FirstName   string             `json:"first_name"`
+	LastName    string             `json:"last_name"`
+	Record      *models.APISRecord `json:"record"`
+	Missing     []string           `json:"missing"`
+}
+
+// GetAPIS shows the document requirements of a booking's itinerary and how
+// far each passenger meets them.
+func (h *BookingHandler) GetAPIS(c *gin.Context) {
+	booking, ok := h.loadOwnedBooking(c, h.db)
+	if !ok {
+		return
+	}
+
+	req, passengers, err := bookingAPIS(h.db, booking.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch passenger details"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"requirements": req, "passengers": passengers})
+}
+
+// UpdateAPIS records a passenger's travel document details.
+func (h *BookingHandler) UpdateAPIS(c *gin.Context) {
+	passengerID, err := strconv.ParseUint(c.Param("passenger_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passenger ID"})
+		return
+	}
+	var req APISRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	booking, ok := h.loadOwnedBooking(c, h.db)
+	if !ok {
+		return
+	}
+	if booking.Status == models.StatusCancelled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Booking is cancelled"})
+		return
+	}
+
+	var passengers []models.Passenger
+	if err := h.db.Preload("APIS").Where("booking_id = ?", booking.ID).Order("id").Find(&passengers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch passengers"})
+		return
+	}
+	index := -1
+	for i := range passengers {
+		if passengers[i].ID == uint(passengerID) {
+			index = i
+		}
+	}
+	if index < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Passenger not found"})
+		return
+	}
+	passenger := &passengers[index]
+
+	legs, err := itineraryLegs(h.db, booking.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch itinerary"})
+		return
+	}
+
+	traveler := passengerTraveler(*passenger)
+	req.merge(&traveler)
+	normalizeTraveler(&traveler)
+	if err := apis.Validate(traveler, legs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	dates := make([]*time.Time, len(passengers))
+	for i, p := range passengers {
+		dates[i] = p.DateOfBirth
+	}
+	dates[index] = traveler.DateOfBirth
+	if len(legs) > 0 {
+		if err := apis.CheckParty(dates, legs[0].Departure); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	record := apisRecord(booking.ID, passenger.ID, traveler)
+	if passenger.APIS != nil {
+		record.ID = passenger.APIS.ID
+		record.CreatedAt = passenger.APIS.CreatedAt
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(passenger).Updates(map[string]interface{}{
+			"date_of_birth": traveler.DateOfBirth,
+			"passport":      traveler.Passport,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Save(&record).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save passenger details"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"record": record, "missing": apis.Missing(apis.For(legs), traveler)})
+}
+
+func (r APISRequest) merge(t *apis.Traveler) {
+	if r.DateOfBirth != nil {
+		t.DateOfBirth = r.DateOfBirth
+	}
+	if r.Gender != nil {
+		t.Gender = r.Gender
+	}
+	if r.Nationality != nil {
+		t.Nationality = r.Nationality
+	}
+	if r.Passport != nil {
+		t.Passport = r.Passport
+	}
+	if r.PassportExpiry != nil {
+		t.PassportExpiry = r.PassportExpiry
+	}
+	if r.PassportCountry != nil {
+		t.PassportCountry = r.PassportCountry
+	}
+}
+
+// requestTraveler is the traveler described by a booking request.
+func requestTraveler(req PassengerRequest) apis.Traveler {
+	t := apis.Traveler{
+		FirstName:       req.FirstName,
+		LastName:        req.LastName,
+		DateOfBirth:     req.DateOfBirth,
+		Gender:          req.Gender,
+		Nationality:     req.Nationality,
+		Passport:        req.Passport,
+		PassportExpiry:  req.PassportExpiry,
+		PassportCountry: req.PassportCountry,
+	}
+	normalizeTraveler(&t)
+	return t
+}
+
+// passengerTraveler is what is on file for a passenger.
+func passengerTraveler(p models.Passenger) apis.Traveler {
+	t := apis.Traveler{
+		FirstName:   p.FirstName,
+		LastName:    p.LastName,
+		DateOfBirth: p.DateOfBirth,
+		Passport:    p.Passport,
+	}
+	if p.APIS != nil {
+		t.Gender = p.APIS.Gender
+		t.Nationality = p.APIS.Nationality
+		t.PassportExpiry = p.APIS.DocumentExpiry
+		t.PassportCountry = p.APIS.IssuingCountry
+		if p.APIS.DocumentNumber != nil {
+			t.Passport = p.APIS.DocumentNumber
+		}
+	}
+	return t
+}
+
+// normalizeTraveler upper-cases codes and document numbers as they are
+// printed, so "gbr" and "GBR" are the same nationality.
+func normalizeTraveler(t *apis.Traveler) {
+	for _, field := range []**string{&t.Gender, &t.Nationality, &t.Passport, &t.PassportCountry} {
+		if *field == nil {
+			continue
+		}
+		value := strings.ToUpper(strings.TrimSpace(**field))
+		*field = &value
+	}
+}
+
+func apisRecord(bookingID, passengerID uint, t apis.Traveler) models.APISRecord {
+	return models.APISRecord{
+		BookingID:      bookingID,
+		PassengerID:    passengerID,
+		Surname:        strings.ToUpper(strings.TrimSpace(t.LastName)),
+		GivenNames:     strings.ToUpper(strings.TrimSpace(t.FirstName)),
+		DateOfBirth:    t.DateOfBirth,
+		Gender:         t.Gender,
+		Nationality:    t.Nationality,
+		DocumentType:   "P",
+		DocumentNumber: t.Passport,
+		DocumentExpiry: t.PassportExpiry,
+		IssuingCountry: t.PassportCountry,
+	}
+}
+
+// flightLegs loads flights in departure order as legs for the APIS rules.
+func flightLegs(db *gorm.DB, flightIDs []uint) ([]apis.Leg, error) {
+	var flights []models.Flight
+	if err := db.Preload("Origin").Preload("Destination").Where("id IN ?", flightIDs).Find(&flights).Error; err != nil {
+		return nil, err
+	}
+	if len(flights) != len(uniqueIDs(flightIDs)) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	sort.Slice(flights, func(i, j int) bool { return flights[i].DepartureTime.Before(flights[j].DepartureTime) })
+
+	legs := make([]apis.Leg, len(flights))
+	for i, flight := range flights {
+		legs[i] = apis.Leg{
+			OriginCountry:      flight.Origin.Country,
+			DestinationCountry: flight.Destination.Country,
+			Departure:          flight.DepartureTime,
+			Arrival:            flight.ArrivalTime,
+		}
+	}
+	return legs, nil
+}
+
+// itineraryLegs is the booking's current flights as legs.
+func itineraryLegs(db *gorm.DB, bookingID uint) ([]apis.Leg, error) {
+	var flightIDs []uint
+	if err := db.Model(&models.Segment{}).
+		Joins("JOIN itineraries ON itineraries.id = segments.itinerary_id").
+		Where("itineraries.booking_id = ?", bookingID).
+		Pluck("segments.flight_id", &flightIDs).Error; err != nil {
+		return nil, err
+	}
+	if len(flightIDs) == 0 {
+		return nil, nil
+	}
+	return flightLegs(db, flightIDs)
+}
+
+// bookingAPIS checks every passenger on a booking against its itinerary.
+func bookingAPIS(db *gorm.DB, bookingID uint) (apis.Requirements, []PassengerAPIS, error) {
+	legs, err := itineraryLegs(db, bookingID)
+	if err != nil {
+		return apis.Requirements{}, nil, err
+	}
+	req := apis.For(legs)
+
+	var passengers []models.Passenger
+	if err := db.Preload("APIS").Where("booking_id = ?", bookingID).Order("id").Find(&passengers).Error; err != nil {
+		return apis.Requirements{}, nil, err
+	}
+	result := make([]PassengerAPIS, len(passengers))
+	for i, p := range passengers {
+		result[i] = PassengerAPIS{
+			PassengerID: p.ID,
+			FirstName:   p.FirstName,
+			LastName:    p.LastName,
+			Record:      p.APIS,
+			Missing:     apis.Missing(req, passengerTraveler(p)),
+		}
+		if !p.Named() {
+			result[i].Missing = append([]string{"name"}, result[i].Missing...)
+		}
+	}
+	return req, result, nil
+}
+
+// incompleteAPIS lists the passengers still missing details the itinerary
+// needs; it is empty when the booking can be ticketed.
+func incompleteAPIS(db *gorm.DB, bookingID uint) ([]PassengerAPIS, error) {
+	_, passengers, err := bookingAPIS(db, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	var incomplete []PassengerAPIS
+	for _, p := range passengers {
+		if len(p.Missing) > 0 {
+			incomplete = append(incomplete, p)
+		}
+	}
+	return incomplete, nil
+}
+
+func uniqueIDs(ids []uint) map[uint]bool {
+	set := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
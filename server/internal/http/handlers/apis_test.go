@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupAPISTestRouter(db *gorm.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	bookingHandler := NewBookingHandler(db, &config.Config{}, fx.Default(), payments.NewFakeClient(), nil)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", uint(1))
+		c.Next()
+	})
+	router.POST("/bookings/:id/issue", bookingHandler.IssueBooking)
+	router.GET("/bookings/:id/apis", bookingHandler.GetAPIS)
+	router.PUT("/bookings/:id/passengers/:passenger_id/apis", bookingHandler.UpdateAPIS)
+	return router
+}
+
+func putAPIS(router *gin.Engine, path string, body gin.H) *httptest.ResponseRecorder {
+	jsonData, _ := json.Marshal(body)
+	req, _ := http.NewRequest("PUT", path, bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestBookingHandler_PassengerValidation(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupBookingTestRouter(db)
+	infant := time.Now().AddDate(-1, 0, 0)
+	child := time.Now().AddDate(-8, 0, 0)
+	soon := time.Now().Add(10 * 24 * time.Hour)
+
+	tests := []map[string]interface{}{
+		{"first_name": "Ada", "last_name": "L0velace"},
+		{"first_name": "Ada", "last_name": "Lovelace", "nationality": "England"},
+		{"first_name": "Ada", "last_name": "Lovelace", "passport": "12", "passport_expiry": soon},
+		{"first_name": "Ada", "last_name": "Lovelace", "passport_expiry": soon}, // expires before the flight
+	}
+	for i, passenger := range tests {
+		w := postBooking(router, map[string]interface{}{
+			"segments":   []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+			"passengers": []map[string]interface{}{passenger},
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code, "case %d: %s", i, w.Body.String())
+	}
+
+	// An infant needs an adult, not just an older child
+	w := postBooking(router, map[string]interface{}{
+		"segments": []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers": []map[string]interface{}{
+			{"first_name": "Tom", "last_name": "Lovelace", "date_of_birth": child},
+			{"first_name": "Ann", "last_name": "Lovelace", "date_of_birth": infant},
+		},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "infant")
+	assert.Equal(t, 30, fareAvailable(db, 1))
+}
+
+func TestBookingHandler_APISBeforeTicketing(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupAPISTestRouter(db)
+	expiry := time.Now().AddDate(5, 0, 0)
+	createTestBooking(t, setupBookingTestRouter(db), map[string]interface{}{
+		"segments": []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers": []map[string]interface{}{
+			{"first_name": "Ada", "last_name": "Lovelace", "date_of_birth": "1990-12-10T00:00:00Z", "nationality": "gbr",
+				"passport": "c1234567", "passport_expiry": expiry, "passport_country": "GBR", "gender": "F"},
+			{"first_name": "Charles", "last_name": "Babbage"},
+		},
+	})
+	db.Model(&models.Booking{}).Where("id = ?", 1).Update("status", models.StatusPaid)
+
+	// JFK to LHR crosses a border, so documents are needed for everyone
+	req, _ := http.NewRequest("GET", "/bookings/1/apis", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Requirements struct {
+			International bool `json:"international"`
+		} `json:"requirements"`
+		Passengers []PassengerAPIS `json:"passengers"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.True(t, response.Requirements.International)
+	if assert.Len(t, response.Passengers, 2) {
+		assert.Empty(t, response.Passengers[0].Missing)
+		assert.Equal(t, "LOVELACE", response.Passengers[0].Record.Surname)
+		assert.Equal(t, "GBR", *response.Passengers[0].Record.Nationality)
+		assert.Equal(t, "C1234567", *response.Passengers[0].Record.DocumentNumber)
+		assert.Equal(t, []string{"passport", "nationality", "passport_expiry", "date_of_birth"}, response.Passengers[1].Missing)
+	}
+
+	req, _ = http.NewRequest("POST", "/bookings/1/issue", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"passenger_id":2`)
+
+	w = putAPIS(router, "/bookings/1/passengers/2/apis", gin.H{"passport": "x"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = putAPIS(router, "/bookings/1/passengers/9/apis", gin.H{"passport": "123456789"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = putAPIS(router, "/bookings/1/passengers/2/apis", gin.H{"passport": "123456789", "nationality": "GBR", "passport_expiry": expiry})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"missing":["date_of_birth"]`)
+	w = putAPIS(router, "/bookings/1/passengers/2/apis", gin.H{"date_of_birth": "1971-12-26T00:00:00Z"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var record models.APISRecord
+	db.Where("passenger_id = ?", 2).First(&record)
+	assert.Equal(t, "123456789", *record.DocumentNumber)
+	assert.NotNil(t, record.DateOfBirth)
+
+	req, _ = http.NewRequest("POST", "/bookings/1/issue", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"skyliner/internal/config"
 	"skyliner/internal/db/models"
+	"skyliner/internal/mail"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -14,12 +18,14 @@ import (
 )
 
 type AuthHandler struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db     *gorm.DB
+	cfg    *config.Config
+	mailer mail.Sender
 }
 
 func NewAuthHandler(db *gorm.DB, cfg *config.Config) *AuthHandler {
-	return &AuthHandler{db: db, cfg: cfg}
+	mailer := mail.New(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	return &AuthHandler{db: db, cfg: cfg, mailer: mailer}
 }
 
 type SignupRequest struct {
@@ -47,9 +53,9 @@ func (h *AuthHandler) Signup(c *gin.Context) {
 		return
 	}
 
-	// Check if user already exists
+	// Check if user already exists; guest bookings with the email do not count
 	var existingUser models.User
-	if err := h.db.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+	if err := h.db.Where("email = ? AND guest = ?", req.Email, false).First(&existingUser).Error; err == nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
 		return
 	}
@@ -82,6 +88,7 @@ func (h *AuthHandler) Signup(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
 		return
 	}
+	h.sendVerificationEmail(user)
 
 	c.JSON(http.StatusCreated, AuthResponse{
 		User:         user,
@@ -99,7 +106,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 
 	// Find user
 	var user models.User
-	if err := h.db.Where("email = ?", req.Email).First(&user).Error; err != nil {
+	if err := h.db.Where("email = ? AND guest = ?", req.Email, false).First(&user).Error; err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
@@ -130,6 +137,78 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	})
 }
 
+// SendVerificationEmail emails the user a link that confirms they own their
+// email address.
+func (h *AuthHandler) SendVerificationEmail(c *gin.Context) {
+	var user models.User
+	if err := h.db.First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified"})
+		return
+	}
+	h.sendVerificationEmail(user)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
+
+// VerifyEmail follows the link from the verification email. Bookings made as
+// a guest with the address are attached to the account.
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	claims, err := parseToken(h.cfg.JWTSecret, verifyEmailToken, c.Query("token"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+	userID, _ := claims["uid"].(float64)
+	email, _ := claims["email"].(string)
+
+	// A link sent before the address was changed does not verify the new one
+	var user models.User
+	if err := h.db.Where("id = ? AND email = ? AND guest = ?", uint(userID), email, false).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+	if user.EmailVerifiedAt == nil {
+		if err := h.db.Model(&user).Update("email_verified_at", time.Now()).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+	}
+
+	attached, err := attachGuestBookings(h.db, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to attach bookings"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": user, "attached": attached})
+}
+
+// sendVerificationEmail mails the verification link. Failures are logged; the
+// user can ask for another.
+func (h *AuthHandler) sendVerificationEmail(user models.User) {
+	token, err := signToken(h.cfg.JWTSecret, verifyEmailToken, jwt.MapClaims{"uid": user.ID, "email": user.Email}, verifyEmailTTL)
+	if err != nil {
+		log.Printf("Failed to sign verification link for user %d: %v", user.ID, err)
+		return
+	}
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: "Confirm this is your email address by opening this link within a day:\n" +
+			strings.TrimRight(h.cfg.PublicURL, "/") + "/api/v1/auth/verify-email?token=" + token + "\n\n" +
+			"Any bookings made as a guest with this address will then show up in your account.\n",
+	}
+	if err := h.mailer.Send(msg); err != nil {
+		log.Printf("Failed to email verification link to user %d: %v", user.ID, err)
+	}
+}
+
 func (h *AuthHandler) GoogleAuth(c *gin.Context) {
 	// TODO: Implement Google OAuth
 	c.JSON(http.StatusNotImplemented, gin.H{"error": "Google OAuth not implemented yet"})
@@ -1,62 +1,86 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"skyliner/internal/apis"
 	"skyliner/internal/config"
 	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/http/middleware"
+	"skyliner/internal/mail"
+	"skyliner/internal/money"
+	"skyliner/internal/payments"
+	"skyliner/internal/pnr"
+	"skyliner/internal/promotions"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type BookingHandler struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db       *gorm.DB
+	cfg      *config.Config
+	rates    fx.Provider
+	payments payments.Client
+	events   EventPublisher
+	mailer   mail.Sender
 }
 
-func NewBookingHandler(db *gorm.DB, cfg *config.Config) *BookingHandler {
-	return &BookingHandler{db: db, cfg: cfg}
+func NewBookingHandler(db *gorm.DB, cfg *config.Config, rates fx.Provider, pay payments.Client, events EventPublisher) *BookingHandler {
+	mailer := mail.New(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	return &BookingHandler{db: db, cfg: cfg, rates: rates, payments: pay, events: events, mailer: mailer}
 }
 
 type CreateBookingRequest struct {
-	Segments   []SegmentRequest   `json:"segments" binding:"required"`
-	Passengers []PassengerRequest `json:"passengers" binding:"required"`
-	Seats      []SeatRequest      `json:"seats"`
-	Extras     []ExtraRequest     `json:"extras"`
+	Segments    []SegmentRequest       `json:"segments" binding:"required,min=1"`
+	Passengers  []PassengerRequest     `json:"passengers" binding:"required,min=1"`
+	Seats       []SeatRequest          `json:"seats"`
+	SSRs        []SSRItemRequest       `json:"ssrs" binding:"dive"`
+	Ancillaries []AncillaryItemRequest `json:"ancillaries" binding:"dive"`
+	Currency    string                 `json:"currency"`    // settlement currency, defaults to the fare currency
+	PromoCode   string                 `json:"promo_code"`  // discount taken off the fares, shown as its own line
+	WaitlistID  *uint                  `json:"waitlist_id"` // waitlist offer whose held seats this booking takes up
 }
 
 type SegmentRequest struct {
-	FlightID   uint    `json:"flight_id" binding:"required"`
-	FareID     uint    `json:"fare_id" binding:"required"`
-	SeatNumber *string `json:"seat_number"`
+	FlightID uint `json:"flight_id" binding:"required"`
+	FareID   uint `json:"fare_id" binding:"required"`
 }
 
+// PassengerRequest names a traveler. International itineraries also need the
+// passport details, which can be given here or added before ticketing.
 type PassengerRequest struct {
-	FirstName   string     `json:"first_name" binding:"required"`
-	LastName    string     `json:"last_name" binding:"required"`
-	Email       string     `json:"email"`
-	Phone       string     `json:"phone"`
-	DateOfBirth *time.Time `json:"date_of_birth"`
-	Passport    *string    `json:"passport"`
-	SSR         *string    `json:"ssr"`
+	FirstName       string     `json:"first_name" binding:"required"`
+	LastName        string     `json:"last_name" binding:"required"`
+	Email           string     `json:"email"`
+	Phone           string     `json:"phone"`
+	DateOfBirth     *time.Time `json:"date_of_birth"`
+	Gender          *string    `json:"gender"`      // M, F or X
+	Nationality     *string    `json:"nationality"` // ISO 3166 alpha-3
+	Passport        *string    `json:"passport"`
+	PassportExpiry  *time.Time `json:"passport_expiry"`
+	PassportCountry *string    `json:"passport_country"` // issuing country, ISO 3166 alpha-3
 }
 
+// SeatRequest picks a seat while booking. Segments and passengers have no IDs
+// yet, so they are referenced by their position in the request.
 type SeatRequest struct {
-	SegmentID uint `json:"segment_id" binding:"required"`
+	Passenger int  `json:"passenger" binding:"min=0"` // index into passengers
+	Segment   int  `json:"segment" binding:"min=0"`   // index into segments
 	SeatID    uint `json:"seat_id" binding:"required"`
 }
 
-type ExtraRequest struct {
-	Type  string  `json:"type" binding:"required"` // baggage, meal, etc.
-	Price float64 `json:"price" binding:"required"`
-}
-
 type BookingResponse struct {
 	Booking     models.Booking `json:"booking"`
-	TotalAmount float64        `json:"total_amount"`
+	TotalAmount money.Money    `json:"total_amount"`
+	ManageURL   string         `json:"manage_url,omitempty"` // guest bookings only
 }
 
 func (h *BookingHandler) CreateBooking(c *gin.Context) {
@@ -75,40 +99,159 @@ func (h *BookingHandler) CreateBooking(c *gin.Context) {
 		}
 	}()
 
-	// Generate PNR
-	pnr := h.generatePNR()
+	// Calculate total amount in the currency the fares are filed in.
+	// Fares are per passenger and each one holds a seat of inventory.
+	partySize := len(req.Passengers)
+
+	// A waitlist offer already holds seats in one of the fares
+	var offer *models.WaitlistEntry
+	if req.WaitlistID != nil {
+		var entry models.WaitlistEntry
+		if err := tx.Where("id = ? AND user_id = ? AND status = ?", *req.WaitlistID, userID, models.WaitlistOffered).First(&entry).Error; err != nil || !entry.OfferExpiresAt.After(time.Now()) {
+			tx.Rollback()
+			c.JSON(http.StatusConflict, gin.H{"error": "Waitlist offer is not open"})
+			return
+		}
+		if partySize > entry.Seats {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("The waitlist offer holds %d seats", entry.Seats)})
+			return
+		}
+		// Claim it before touching its seats, so a second booking racing for
+		// the same offer, or the sweeper expiring it, finds it gone
+		result := tx.Model(&models.WaitlistEntry{}).
+			Where("id = ? AND status = ? AND offer_expires_at > ?", entry.ID, models.WaitlistOffered, time.Now()).
+			Update("status", models.WaitlistBooked)
+		if result.Error != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update waitlist"})
+			return
+		}
+		if result.RowsAffected != 1 {
+			tx.Rollback()
+			c.JSON(http.StatusConflict, gin.H{"error": "Waitlist offer is not open"})
+			return
+		}
+		offer = &entry
+	}
 
-	// Calculate total amount
-	totalAmount := 0.0
-	for _, segment := range req.Segments {
-		var fare models.Fare
-		if err := tx.First(&fare, segment.FareID).Error; err != nil {
+	var baseAmount money.Money
+	fares := make([]models.Fare, len(req.Segments))
+	for i, segment := range req.Segments {
+		fare := &fares[i]
+		if err := tx.Where("flight_id = ?", segment.FlightID).First(fare, segment.FareID).Error; err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fare ID"})
 			return
 		}
-		totalAmount += fare.BasePrice
+		if i == 0 {
+			baseAmount = money.Zero(fare.Currency)
+		}
+		sum, err := baseAmount.Add(fare.Price().Mul(int64(partySize)))
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "All fares must be priced in " + baseAmount.Currency})
+			return
+		}
+		baseAmount = sum
+
+		if offer != nil && offer.Status == models.WaitlistOffered && *offer.FareID == fare.ID {
+			// Seats the offer holds beyond the party go back
+			offer.Status = models.WaitlistBooked
+			err = releaseFare(tx, fare.ID, offer.Seats-partySize)
+		} else {
+			err = holdFare(tx, fare.ID, partySize)
+		}
+		if err != nil {
+			tx.Rollback()
+			if errors.Is(err, errFareSoldOut) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Not enough seats left at this fare"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hold fare inventory"})
+			return
+		}
+	}
+	if offer != nil && offer.Status != models.WaitlistBooked {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The waitlist offer is for another fare"})
+		return
 	}
 
-	// Add extras
-	for _, extra := range req.Extras {
-		totalAmount += extra.Price
+	// Check names and any document details against the itinerary
+	flightIDs := make([]uint, len(req.Segments))
+	for i, segment := range req.Segments {
+		flightIDs[i] = segment.FlightID
+	}
+	legs, err := flightLegs(tx, flightIDs)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch flights"})
+		return
+	}
+	travelers := make([]apis.Traveler, len(req.Passengers))
+	dates := make([]*time.Time, len(req.Passengers))
+	for i, passengerReq := range req.Passengers {
+		travelers[i] = requestTraveler(passengerReq)
+		dates[i] = travelers[i].DateOfBirth
+		if err := apis.Validate(travelers[i], legs); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Passenger %d: %s", i+1, err)})
+			return
+		}
+	}
+	if err := apis.CheckParty(dates, legs[0].Departure); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	// Create booking
 	booking := models.Booking{
-		PNR:         pnr,
-		UserID:      userID,
-		Status:      models.StatusHold,
-		TotalAmount: totalAmount,
-		Currency:    "USD",
+		UserID:       userID,
+		Status:       models.StatusHold,
+		TotalAmount:  baseAmount.Amount,
+		Currency:     baseAmount.Currency,
+		BaseAmount:   baseAmount.Amount,
+		BaseCurrency: baseAmount.Currency,
+	}
+	if h.cfg.HoldTTL > 0 {
+		expiresAt := time.Now().Add(h.cfg.HoldTTL)
+		booking.HoldExpiresAt = &expiresAt
+	}
+
+	// Convert to the settlement currency if the customer asked for another one
+	if req.Currency != "" && !strings.EqualFold(req.Currency, baseAmount.Currency) {
+		rate, err := h.rates.Rate(c.Request.Context(), baseAmount.Currency, req.Currency)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency: " + req.Currency})
+			return
+		}
+		totalAmount, err := rate.Convert(baseAmount)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert currency"})
+			return
+		}
+		booking.TotalAmount = totalAmount.Amount
+		booking.Currency = totalAmount.Currency
+		booking.FXRate = rate.String()
+		booking.FXRateAsOf = &rate.AsOf
 	}
 
-	if err := tx.Create(&booking).Error; err != nil {
+	if err := createWithPNR(tx, &booking); err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create booking"})
 		return
 	}
+	if offer != nil {
+		if err := tx.Model(&models.WaitlistEntry{}).Where("id = ?", offer.ID).Update("booking_id", booking.ID).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update waitlist"})
+			return
+		}
+	}
 
 	// Create itinerary
 	itinerary := models.Itinerary{
@@ -121,22 +264,24 @@ func (h *BookingHandler) CreateBooking(c *gin.Context) {
 	}
 
 	// Create segments
-	for _, segmentReq := range req.Segments {
-		segment := models.Segment{
-			ItineraryID: itinerary.ID,
-			FlightID:    segmentReq.FlightID,
-			FareID:      segmentReq.FareID,
-			SeatNumber:  segmentReq.SeatNumber,
-		}
-		if err := tx.Create(&segment).Error; err != nil {
+	segments := make([]models.Segment, len(req.Segments))
+	for i, segmentReq := range req.Segments {
+		segment := &segments[i]
+		segment.ItineraryID = itinerary.ID
+		segment.FlightID = segmentReq.FlightID
+		segment.FareID = segmentReq.FareID
+		if err := tx.Create(segment).Error; err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create segment"})
 			return
 		}
+		// Set after Create so GORM does not try to upsert the fare
+		segment.Fare = fares[i]
 	}
 
 	// Create passengers
-	for _, passengerReq := range req.Passengers {
+	passengers := make([]models.Passenger, 0, len(req.Passengers))
+	for i, passengerReq := range req.Passengers {
 		passenger := models.Passenger{
 			BookingID:   booking.ID,
 			FirstName:   passengerReq.FirstName,
@@ -144,37 +289,132 @@ func (h *BookingHandler) CreateBooking(c *gin.Context) {
 			Email:       passengerReq.Email,
 			Phone:       passengerReq.Phone,
 			DateOfBirth: passengerReq.DateOfBirth,
-			Passport:    passengerReq.Passport,
-			SSR:         passengerReq.SSR,
+			Passport:    travelers[i].Passport,
 		}
 		if err := tx.Create(&passenger).Error; err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create passenger"})
 			return
 		}
+		record := apisRecord(booking.ID, passenger.ID, travelers[i])
+		if err := tx.Create(&record).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create passenger"})
+			return
+		}
+		passengers = append(passengers, passenger)
 	}
 
-	// Create baggage for extras
-	for _, extra := range req.Extras {
-		if extra.Type == "baggage" {
-			baggage := models.Baggage{
-				BookingID: booking.ID,
-				Type:      "checked",
-				Price:     extra.Price,
+	// Assign seats and add any seat charges to the total
+	if len(req.Seats) > 0 {
+		seatCharges := money.Zero(booking.Base().Currency)
+		for _, seatReq := range req.Seats {
+			if seatReq.Passenger >= len(passengers) || seatReq.Segment >= len(segments) {
+				tx.Rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Seat refers to an unknown passenger or segment"})
+				return
+			}
+			charge, err := assignSeat(tx, &booking, passengers[seatReq.Passenger].ID, &segments[seatReq.Segment], seatReq.SeatID)
+			if err != nil {
+				tx.Rollback()
+				c.JSON(seatErrorStatus(err), gin.H{"error": seatErrorMessage(err)})
+				return
+			}
+			if seatCharges, err = seatCharges.Add(charge); err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Seat prices must be in the fare currency"})
+				return
+			}
+		}
+
+		if !seatCharges.IsZero() {
+			if err := adjustBase(&booking, seatCharges); err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price seats"})
+				return
 			}
-			if err := tx.Create(&baggage).Error; err != nil {
+			if err := tx.Model(&booking).Updates(map[string]interface{}{
+				"base_amount":  booking.BaseAmount,
+				"total_amount": booking.TotalAmount,
+			}).Error; err != nil {
 				tx.Rollback()
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create baggage"})
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update booking total"})
 				return
 			}
 		}
 	}
 
-	// Update seat availability
-	for _, seatReq := range req.Seats {
-		if err := tx.Model(&models.Seat{}).Where("id = ?", seatReq.SeatID).Update("status", models.SeatSelected).Error; err != nil {
+	// Request special services and add any charges to the total
+	if len(req.SSRs) > 0 {
+		ssrCharges := money.Zero(booking.Base().Currency)
+		for _, ssrReq := range req.SSRs {
+			if ssrReq.Passenger >= len(passengers) || ssrReq.Segment >= len(segments) {
+				tx.Rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Service request refers to an unknown passenger or segment"})
+				return
+			}
+			ssr, err := requestSSR(tx, &booking, passengers[ssrReq.Passenger].ID, &segments[ssrReq.Segment], ssrReq.Code, ssrReq.Text)
+			if err != nil {
+				tx.Rollback()
+				c.JSON(ssrErrorStatus(err), gin.H{"error": ssrErrorMessage(err)})
+				return
+			}
+			if ssrCharges, err = ssrCharges.Add(money.New(ssr.Price, ssr.Currency)); err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Service prices must be in the fare currency"})
+				return
+			}
+		}
+
+		if !ssrCharges.IsZero() {
+			if err := chargeBooking(tx, &booking, ssrCharges); err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update booking total"})
+				return
+			}
+		}
+	}
+
+	// Sell add-ons from the catalog at its prices
+	if len(req.Ancillaries) > 0 {
+		ancillaryCharges := money.Zero(booking.Base().Currency)
+		for _, item := range req.Ancillaries {
+			if item.Passenger >= len(passengers) || item.Segment >= len(segments) {
+				tx.Rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Add-on refers to an unknown passenger or segment"})
+				return
+			}
+			purchase, err := sellAncillary(tx, &booking, passengers[item.Passenger].ID, &segments[item.Segment], item.AncillaryID, models.AncillaryActive, h.holdCutoff())
+			if err != nil {
+				tx.Rollback()
+				c.JSON(ancillaryErrorStatus(err), gin.H{"error": ancillaryErrorMessage(err)})
+				return
+			}
+			if ancillaryCharges, err = ancillaryCharges.Add(purchase.Money()); err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Add-on prices must be in the fare currency"})
+				return
+			}
+		}
+
+		if !ancillaryCharges.IsZero() {
+			if err := chargeBooking(tx, &booking, ancillaryCharges); err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update booking total"})
+				return
+			}
+		}
+	}
+
+	// Take off any promotion once everything it can depend on is priced
+	if req.PromoCode != "" {
+		if _, err := applyPromotion(tx, &booking, req.PromoCode, segments, partySize, time.Now()); err != nil {
 			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update seat status"})
+			if errors.Is(err, promotions.ErrNotEligible) {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply promo code"})
 			return
 		}
 	}
@@ -186,15 +426,36 @@ func (h *BookingHandler) CreateBooking(c *gin.Context) {
 	}
 
 	// Load booking with relations
-	if err := h.db.Preload("User").Preload("Itinerary.Segments.Flight").Preload("Itinerary.Segments.Fare").Preload("Passengers").First(&booking, booking.ID).Error; err != nil {
+	if err := h.db.Preload("User").Preload("Itinerary.Segments.Flight").Preload("Itinerary.Segments.Fare").Preload("Itinerary.Segments.SeatAssignments.Seat").Preload("Passengers.SSRs").Preload("Discounts").Preload("Ancillaries").First(&booking, booking.ID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load booking"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, BookingResponse{
+	response := BookingResponse{
 		Booking:     booking,
-		TotalAmount: totalAmount,
-	})
+		TotalAmount: booking.Total(),
+	}
+	// Guests have no account to come back to, so they get a link instead
+	if c.GetBool("guest") {
+		if response.ManageURL, err = h.manageURL(booking); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create manage link"})
+			return
+		}
+		h.sendManageLink(booking)
+
+		// The guest's email is no secret, so a retry under the same key gets
+		// the booking without the link; it is in the email
+		replay := response
+		replay.ManageURL = ""
+		body, err := json.Marshal(replay)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create manage link"})
+			return
+		}
+		c.Set(middleware.IdempotencyReplayKey, body)
+	}
+
+	c.JSON(http.StatusCreated, response)
 }
 
 func (h *BookingHandler) GetBooking(c *gin.Context) {
@@ -208,7 +469,7 @@ func (h *BookingHandler) GetBooking(c *gin.Context) {
 	userID := c.GetUint("user_id")
 
 	var booking models.Booking
-	if err := h.db.Preload("User").Preload("Itinerary.Segments.Flight.Airline").Preload("Itinerary.Segments.Flight.Origin").Preload("Itinerary.Segments.Flight.Destination").Preload("Itinerary.Segments.Fare").Preload("Passengers").Preload("Payments").Where("id = ? AND user_id = ?", uint(bookingID), userID).First(&booking).Error; err != nil {
+	if err := withBookingDetails(h.db).Preload("User").Where("id = ? AND user_id = ?", uint(bookingID), userID).First(&booking).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
 			return
@@ -220,6 +481,84 @@ func (h *BookingHandler) GetBooking(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"booking": booking})
 }
 
+// GetBookingByPNR lets a traveler without an account retrieve a booking with
+// its record locator and any passenger's last name.
+func (h *BookingHandler) GetBookingByPNR(c *gin.Context) {
+	code := pnr.Normalize(c.Param("pnr"))
+	lastName := strings.TrimSpace(c.Query("last_name"))
+	if lastName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "last_name is required"})
+		return
+	}
+	if !pnr.Valid(code) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return
+	}
+
+	var booking models.Booking
+	err := h.db.Preload("Itinerary.Segments.Flight.Airline").
+		Preload("Itinerary.Segments.Flight.Origin").
+		Preload("Itinerary.Segments.Flight.Destination").
+		Preload("Itinerary.Segments.Fare").
+		Preload("Itinerary.Segments.SeatAssignments.Seat").
+		Preload("Passengers").
+		Preload("Remarks", "visibility = ?", models.RemarkCustomer).
+		Where("pnr = ?", code).
+		Where("EXISTS (SELECT 1 FROM passengers WHERE passengers.booking_id = bookings.id AND LOWER(passengers.last_name) = LOWER(?))", lastName).
+		First(&booking).Error
+	if err != nil {
+		// Same response for a wrong PNR and a wrong name so neither can be probed
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"booking": publicBooking(booking)})
+}
+
+// PublicBooking is what a PNR and a last name reveal about a booking: the
+// trip, not the account, the payments or the passengers' documents.
+type PublicBooking struct {
+	ID            uint                   `json:"id"`
+	PNR           string                 `json:"pnr"`
+	Status        models.BookingStatus   `json:"status"`
+	TotalAmount   int64                  `json:"total_amount"`
+	Currency      string                 `json:"currency"`
+	HoldExpiresAt *time.Time             `json:"hold_expires_at"`
+	CreatedAt     time.Time              `json:"created_at"`
+	Itinerary     models.Itinerary       `json:"itinerary"`
+	Passengers    []PublicPassenger      `json:"passengers"`
+	Remarks       []models.BookingRemark `json:"remarks,omitempty"`
+}
+
+type PublicPassenger struct {
+	ID        uint   `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+func publicBooking(b models.Booking) PublicBooking {
+	view := PublicBooking{
+		ID:            b.ID,
+		PNR:           b.PNR,
+		Status:        b.Status,
+		TotalAmount:   b.TotalAmount,
+		Currency:      b.Currency,
+		HoldExpiresAt: b.HoldExpiresAt,
+		CreatedAt:     b.CreatedAt,
+		Itinerary:     b.Itinerary,
+		Passengers:    make([]PublicPassenger, len(b.Passengers)),
+		Remarks:       b.Remarks,
+	}
+	for i, p := range b.Passengers {
+		view.Passengers[i] = PublicPassenger{ID: p.ID, FirstName: p.FirstName, LastName: p.LastName}
+	}
+	return view
+}
+
 func (h *BookingHandler) IssueBooking(c *gin.Context) {
 	bookingIDStr := c.Param("id")
 	bookingID, err := strconv.ParseUint(bookingIDStr, 10, 32)
@@ -240,18 +579,34 @@ func (h *BookingHandler) IssueBooking(c *gin.Context) {
 		return
 	}
 
-	if booking.Status != models.StatusPaid {
+	// Issuing a ticketed booking again returns its tickets
+	if booking.Status != models.StatusPaid && booking.Status != models.StatusTicketed {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Booking must be paid before issuing"})
 		return
 	}
 
-	// Update booking status to ticketed
-	if err := h.db.Model(&booking).Update("status", models.StatusTicketed).Error; err != nil {
+	// Border authorities need every passenger's documents before travel
+	incomplete, err := incompleteAPIS(h.db, booking.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check passenger details"})
+		return
+	}
+	if len(incomplete) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Passenger details are incomplete", "passengers": incomplete})
+		return
+	}
+
+	tickets, err := ticketBooking(h.db, booking.ID)
+	if errors.Is(err, errNoTicketPrefix) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Airline cannot issue tickets"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue booking"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Booking issued successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Booking issued successfully", "tickets": tickets})
 }
 
 func (h *BookingHandler) CancelBooking(c *gin.Context) {
@@ -279,37 +634,173 @@ func (h *BookingHandler) CancelBooking(c *gin.Context) {
 		return
 	}
 
-	// Update booking status to cancelled
-	if err := h.db.Model(&booking).Update("status", models.StatusCancelled).Error; err != nil {
+	// Update booking status to cancelled and give the seats and fares back
+	var quote RefundQuote
+	var refunds []models.Refund
+	var credit *models.Credit
+	var offers []models.WaitlistEntry
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&booking, booking.ID).Error; err != nil {
+			return err
+		}
+		if booking.Status == models.StatusCancelled {
+			return errBookingCancelled
+		}
+		if started, err := travelStarted(tx, booking.ID); err != nil {
+			return err
+		} else if started {
+			return errTravelStarted
+		}
+		// Only the request that changes the status refunds, so a booking is
+		// never paid back twice
+		result := tx.Model(&models.Booking{}).Where("id = ? AND status = ?", booking.ID, booking.Status).Update("status", models.StatusCancelled)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errBookingCancelled
+		}
+
+		// Paid bookings get back whatever the fare rules allow
+		var err error
+		if quote, err = quoteRefund(tx, &booking, time.Now()); err != nil {
+			return err
+		}
+		creditAmount := quote.Credit.Amount
+		if !quote.Refundable.IsZero() {
+			if refunds, err = allocateRefund(tx, &booking, quote.Refundable, "cancellation"); err != nil {
+				return err
+			}
+			// What was paid with credit goes back as credit
+			creditAmount += quote.Refundable.Amount
+			for _, refund := range refunds {
+				creditAmount -= refund.Amount
+			}
+		}
+		if creditAmount > 0 {
+			credit = &models.Credit{
+				UserID:    booking.UserID,
+				Kind:      models.CreditTravel,
+				Source:    "cancellation",
+				BookingID: &booking.ID,
+				Amount:    creditAmount,
+				Currency:  quote.Credit.Currency,
+				ExpiresAt: creditExpiry(h.cfg.CreditValidity, time.Now()),
+			}
+			if err := issueCredit(tx, credit); err != nil {
+				return err
+			}
+		}
+		if booking.Status == models.StatusHold {
+			if err := releaseCredits(tx, booking.ID); err != nil {
+				return err
+			}
+			if err := releasePromotion(tx, booking.ID); err != nil {
+				return err
+			}
+		}
+		if err := markWaiversApplied(tx, quote.WaiverIDs); err != nil {
+			return err
+		}
+		if err := releaseBookingInventory(tx, booking.ID); err != nil {
+			return err
+		}
+		if err := cancelPendingExchanges(tx, booking.ID); err != nil {
+			return err
+		}
+		if err := cancelSSRs(tx, booking.ID, 0); err != nil {
+			return err
+		}
+		if err := cancelAncillaries(tx, booking.ID, 0); err != nil {
+			return err
+		}
+		if err := refundCoupons(tx, booking.ID); err != nil {
+			return err
+		}
+		if err := releaseBookingSeats(tx, booking.ID); err != nil {
+			return err
+		}
+		// The seats given back go to the waitlist first
+		offers, err = offerBookingWaitlists(tx, booking.ID, h.cfg.WaitlistOfferTTL, time.Now())
+		return err
+	})
+	switch {
+	case errors.Is(err, errBookingCancelled):
+		c.JSON(http.StatusConflict, gin.H{"error": "Booking already cancelled"})
+		return
+	case errors.Is(err, errTravelStarted):
+		c.JSON(http.StatusConflict, gin.H{"error": "Passengers have checked in, so the booking can no longer be cancelled"})
+		return
+	case err != nil:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel booking"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Booking cancelled successfully"})
-}
+	h.issueRefunds(c.Request.Context(), refunds)
+	h.notifyOffers(offers)
 
-func (h *BookingHandler) GetAllBookings(c *gin.Context) {
-	// Admin/Agent only
-	var bookings []models.Booking
-	if err := h.db.Preload("User").Preload("Itinerary.Segments.Flight").Preload("Passengers").Find(&bookings).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookings"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"bookings": bookings})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Booking cancelled successfully",
+		"refund":  quote,
+		"refunds": refunds,
+		"credit":  credit,
+	})
 }
 
-func (h *BookingHandler) WaiveBooking(c *gin.Context) {
-	// TODO: Implement waiver logic
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Waiver not implemented yet"})
-}
+// maxPNRAttempts bounds retries on record locator collisions. With ~887M
+// possible codes a second attempt is already rare.
+const maxPNRAttempts = 5
+
+// newPNR is swapped out in tests to force collisions.
+var newPNR = pnr.Generate
+
+// createWithPNR inserts the booking under a freshly generated record locator.
+// Each attempt runs under a savepoint so a unique-index collision does not
+// abort the surrounding transaction.
+func createWithPNR(tx *gorm.DB, booking *models.Booking) error {
+	for attempt := 0; attempt < maxPNRAttempts; attempt++ {
+		code, err := newPNR()
+		if err != nil {
+			return err
+		}
+		booking.PNR = code
 
-func (h *BookingHandler) RepriceBookings(c *gin.Context) {
-	// TODO: Implement reprice logic
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Reprice not implemented yet"})
+		if err := tx.SavePoint("create_booking").Error; err != nil {
+			return err
+		}
+		err = tx.Create(booking).Error
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			return err
+		}
+		if err := tx.RollbackTo("create_booking").Error; err != nil {
+			return err
+		}
+		booking.ID = 0
+	}
+	return errors.New("could not allocate a unique PNR")
 }
 
-func (h *BookingHandler) generatePNR() string {
-	// Simple PNR generation - in production, use a more sophisticated method
-	return "SKY" + strconv.FormatInt(time.Now().Unix(), 36)
+// withBookingDetails preloads everything needed to show a booking to its owner.
+func withBookingDetails(db *gorm.DB) *gorm.DB {
+	return db.Preload("Itinerary.Segments.Flight.Airline").
+		Preload("Itinerary.Segments.Flight.Origin").
+		Preload("Itinerary.Segments.Flight.Destination").
+		Preload("Itinerary.Segments.Fare").
+		Preload("Itinerary.Segments.SeatAssignments.Seat").
+		Preload("Passengers.APIS").
+		Preload("Passengers.SSRs").
+		Preload("Payments").
+		Preload("PaymentsIn.Payment").
+		Preload("PaymentsOut").
+		Preload("Discounts").
+		Preload("Ancillaries").
+		Preload("Tickets.Coupons", func(db *gorm.DB) *gorm.DB { return db.Order("number") }).
+		Preload("Group").
+		Preload("Installments", func(db *gorm.DB) *gorm.DB { return db.Order("sequence") }).
+		Preload("SplitFrom").
+		Preload("Splits").
+		Preload("Remarks", "visibility = ?", models.RemarkCustomer)
 }
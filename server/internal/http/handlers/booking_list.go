@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/pnr"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultBookingPageSize = 20
+	maxBookingPageSize     = 100
+)
+
+// BookingSummary is the short form of a booking used in lists.
+type BookingSummary struct {
+	ID            uint                 `json:"id"`
+	PNR           string               `json:"pnr"`
+	UserID        uint                 `json:"user_id"`
+	UserEmail     string               `json:"user_email,omitempty"` // admin views only
+	Status        models.BookingStatus `json:"status"`
+	TotalAmount   int64                `json:"total_amount"`
+	Currency      string               `json:"currency"`
+	HoldExpiresAt *time.Time           `json:"hold_expires_at"`
+	CreatedAt     time.Time            `json:"created_at"`
+	Passengers    []string             `json:"passengers"`
+	Flights       []FlightSummary      `json:"flights"`
+}
+
+type FlightSummary struct {
+	Number        string    `json:"number"`
+	Origin        string    `json:"origin"`
+	Destination   string    `json:"destination"`
+	DepartureTime time.Time `json:"departure_time"`
+	ArrivalTime   time.Time `json:"arrival_time"`
+}
+
+// GetBookings lists the caller's bookings, newest first, filtered as described
+// at filterBookings. Pages hold ?limit= bookings and continue from ?cursor=,
+// the next_cursor of the last page.
+func (h *BookingHandler) GetBookings(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	query, err := filterBookings(c, h.db.Model(&models.Booking{}).Where("bookings.user_id = ?", userID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	bookings, nextCursor, ok := pageBookings(c, query)
+	if !ok {
+		return
+	}
+
+	summaries, err := summarizeBookings(h.db, bookings)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"bookings": summaries, "next_cursor": nextCursor})
+}
+
+// filterBookings applies the booking list filters: ?status=paid,ticketed,
+// ?when=upcoming|past, ?from= and ?to= (travel dates, YYYY-MM-DD), ?pnr= and
+// ?passenger= (part of a passenger's name).
+func filterBookings(c *gin.Context, query *gorm.DB) (*gorm.DB, error) {
+	if status := c.Query("status"); status != "" {
+		var statuses []models.BookingStatus
+		for _, s := range strings.Split(status, ",") {
+			switch st := models.BookingStatus(strings.TrimSpace(s)); st {
+			case models.StatusHold, models.StatusPaid, models.StatusTicketed, models.StatusCancelled:
+				statuses = append(statuses, st)
+			default:
+				return nil, errors.New("Invalid status " + s)
+			}
+		}
+		query = query.Where("bookings.status IN ?", statuses)
+	}
+
+	// A booking is upcoming while any of its flights has yet to depart
+	const departsAfter = "EXISTS (SELECT 1 FROM segments JOIN itineraries ON itineraries.id = segments.itinerary_id JOIN flights ON flights.id = segments.flight_id WHERE itineraries.booking_id = bookings.id AND flights.departure_time > ?)"
+	switch c.Query("when") {
+	case "":
+	case "upcoming":
+		query = query.Where(departsAfter, time.Now())
+	case "past":
+		query = query.Where("NOT "+departsAfter, time.Now())
+	default:
+		return nil, errors.New("when must be upcoming or past")
+	}
+
+	from, to, err := dateRange(c, "from", "to")
+	if err != nil {
+		return nil, err
+	}
+	if !from.IsZero() || !to.IsZero() {
+		travel := "EXISTS (SELECT 1 FROM segments JOIN itineraries ON itineraries.id = segments.itinerary_id JOIN flights ON flights.id = segments.flight_id WHERE itineraries.booking_id = bookings.id"
+		var args []interface{}
+		if !from.IsZero() {
+			travel += " AND flights.departure_time >= ?"
+			args = append(args, from)
+		}
+		if !to.IsZero() {
+			travel += " AND flights.departure_time < ?"
+			args = append(args, to)
+		}
+		query = query.Where(travel+")", args...)
+	}
+
+	if code := c.Query("pnr"); code != "" {
+		query = query.Where("bookings.pnr = ?", pnr.Normalize(code))
+	}
+	if name := strings.TrimSpace(c.Query("passenger")); name != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM passengers WHERE passengers.booking_id = bookings.id AND LOWER(passengers.first_name || ' ' || passengers.last_name) LIKE ?)",
+			"%"+strings.ToLower(name)+"%")
+	}
+	return query, nil
+}
+
+// pageBookings fetches one page of bookings, newest first, answering the
+// request itself when the page parameters are invalid or the query fails.
+func pageBookings(c *gin.Context, query *gorm.DB) ([]models.Booking, string, bool) {
+	limit := defaultBookingPageSize
+	if l := c.Query("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxBookingPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return nil, "", false
+		}
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return nil, "", false
+		}
+		query = query.Where("bookings.id < ?", after)
+	}
+
+	// One extra row tells whether there is another page
+	var bookings []models.Booking
+	if err := query.Order("bookings.id DESC").Limit(limit + 1).Find(&bookings).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookings"})
+		return nil, "", false
+	}
+	nextCursor := ""
+	if len(bookings) > limit {
+		bookings = bookings[:limit]
+		nextCursor = encodeCursor(bookings[limit-1].ID)
+	}
+	return bookings, nextCursor, true
+}
+
+// summarizeBookings loads just the flights and passenger names of bookings.
+func summarizeBookings(db *gorm.DB, bookings []models.Booking) ([]BookingSummary, error) {
+	summaries := make([]BookingSummary, len(bookings))
+	index := map[uint]*BookingSummary{}
+	ids := make([]uint, len(bookings))
+	for i, b := range bookings {
+		summaries[i] = BookingSummary{
+			ID:            b.ID,
+			PNR:           b.PNR,
+			UserID:        b.UserID,
+			Status:        b.Status,
+			TotalAmount:   b.TotalAmount,
+			Currency:      b.Currency,
+			HoldExpiresAt: b.HoldExpiresAt,
+			CreatedAt:     b.CreatedAt,
+			Passengers:    []string{},
+			Flights:       []FlightSummary{},
+		}
+		index[b.ID] = &summaries[i]
+		ids[i] = b.ID
+	}
+	if len(ids) == 0 {
+		return summaries, nil
+	}
+
+	var flights []struct {
+		BookingID uint
+		FlightSummary
+	}
+	if err := db.Table("segments").
+		Select("itineraries.booking_id, flights.number, origin.code AS origin, destination.code AS destination, flights.departure_time, flights.arrival_time").
+		Joins("JOIN itineraries ON itineraries.id = segments.itinerary_id").
+		Joins("JOIN flights ON flights.id = segments.flight_id").
+		Joins("JOIN airports origin ON origin.id = flights.origin_id").
+		Joins("JOIN airports destination ON destination.id = flights.destination_id").
+		Where("itineraries.booking_id IN ?", ids).
+		Order("flights.departure_time").
+		Scan(&flights).Error; err != nil {
+		return nil, err
+	}
+	for _, f := range flights {
+		index[f.BookingID].Flights = append(index[f.BookingID].Flights, f.FlightSummary)
+	}
+
+	var passengers []models.Passenger
+	if err := db.Select("booking_id", "first_name", "last_name").
+		Where("booking_id IN ?", ids).Order("id").Find(&passengers).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range passengers {
+		index[p.BookingID].Passengers = append(index[p.BookingID].Passengers, p.FirstName+" "+p.LastName)
+	}
+	return summaries, nil
+}
+
+// dateRange reads a pair of YYYY-MM-DD query parameters. The end is
+// inclusive, so it is returned as the start of the following day.
+func dateRange(c *gin.Context, fromKey, toKey string) (from, to time.Time, err error) {
+	if value := c.Query(fromKey); value != "" {
+		if from, err = time.Parse("2006-01-02", value); err != nil {
+			return from, to, fmt.Errorf("Invalid %s date, use YYYY-MM-DD", fromKey)
+		}
+	}
+	if value := c.Query(toKey); value != "" {
+		if to, err = time.Parse("2006-01-02", value); err != nil {
+			return from, to, fmt.Errorf("Invalid %s date, use YYYY-MM-DD", toKey)
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to, nil
+}
+
+// Cursors are opaque to clients so the ordering can change without breaking
+// them.
+func encodeCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(id), 10)))
+}
+
+func decodeCursor(cursor string) (uint, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(string(b), 10, 32)
+	return uint(id), err
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"skyliner/internal/db/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type bookingList struct {
+	Bookings   []BookingSummary `json:"bookings"`
+	NextCursor string           `json:"next_cursor"`
+}
+
+func listBookings(t *testing.T, router *gin.Engine, query string) bookingList {
+	t.Helper()
+	w := request(router, "GET", "/bookings"+query)
+	if !assert.Equal(t, http.StatusOK, w.Code, w.Body.String()) {
+		t.FailNow()
+	}
+	var list bookingList
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	return list
+}
+
+func bookingIDs(list bookingList) []uint {
+	ids := []uint{}
+	for _, b := range list.Bookings {
+		ids = append(ids, b.ID)
+	}
+	return ids
+}
+
+func TestBookingHandler_GetBookings(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupBookingTestRouter(db)
+	addExchangeFlight(db)
+
+	ada := createTestBooking(t, router, nil)
+	createTestBooking(t, router, map[string]interface{}{
+		"segments":   []map[string]interface{}{{"flight_id": 2, "fare_id": 2}},
+		"passengers": []map[string]interface{}{{"first_name": "Grace", "last_name": "Hopper"}},
+	})
+	createTestBooking(t, router, nil)
+	db.Model(&models.Booking{}).Where("id = ?", 1).Update("status", models.StatusPaid)
+	db.Model(&models.Booking{}).Where("id = ?", 3).Update("status", models.StatusCancelled)
+	// Someone else's booking never shows up
+	db.Create(&models.User{Email: "other@example.com", PasswordHash: "x"})
+	db.Create(&models.Booking{PNR: "ZZZ999", UserID: 2, TotalAmount: 100})
+	// Flight 2 has already left
+	db.Model(&models.Flight{}).Where("id = ?", 2).Updates(map[string]interface{}{
+		"departure_time": time.Now().Add(-48 * time.Hour),
+		"arrival_time":   time.Now().Add(-41 * time.Hour),
+	})
+
+	list := listBookings(t, router, "")
+	assert.Equal(t, []uint{3, 2, 1}, bookingIDs(list))
+	assert.Empty(t, list.NextCursor)
+	first := list.Bookings[2]
+	assert.Equal(t, ada.PNR, first.PNR)
+	assert.Equal(t, models.StatusPaid, first.Status)
+	assert.Equal(t, []string{"Ada Lovelace"}, first.Passengers)
+	if assert.Len(t, first.Flights, 1) {
+		assert.Equal(t, "BA200", first.Flights[0].Number)
+		assert.Equal(t, "JFK", first.Flights[0].Origin)
+		assert.Equal(t, "LHR", first.Flights[0].Destination)
+	}
+
+	assert.Equal(t, []uint{3, 1}, bookingIDs(listBookings(t, router, "?status=paid,cancelled")))
+	assert.Equal(t, []uint{3, 1}, bookingIDs(listBookings(t, router, "?when=upcoming")))
+	assert.Equal(t, []uint{2}, bookingIDs(listBookings(t, router, "?when=past")))
+	assert.Equal(t, []uint{1}, bookingIDs(listBookings(t, router, "?pnr="+strings.ToLower(ada.PNR))))
+	assert.Equal(t, []uint{2}, bookingIDs(listBookings(t, router, "?passenger=grace%20HOP")))
+
+	var flight models.Flight
+	db.First(&flight, 1)
+	day := flight.DepartureTime.UTC().Format("2006-01-02")
+	assert.Equal(t, []uint{3, 1}, bookingIDs(listBookings(t, router, "?from="+day+"&to="+day)))
+	assert.Equal(t, []uint{2}, bookingIDs(listBookings(t, router, "?to="+flight.DepartureTime.AddDate(0, 0, -10).Format("2006-01-02"))))
+
+	page := listBookings(t, router, "?limit=2")
+	assert.Equal(t, []uint{3, 2}, bookingIDs(page))
+	assert.NotEmpty(t, page.NextCursor)
+	page = listBookings(t, router, "?limit=2&cursor="+page.NextCursor)
+	assert.Equal(t, []uint{1}, bookingIDs(page))
+	assert.Empty(t, page.NextCursor)
+
+	for _, query := range []string{"?status=lost", "?when=soon", "?from=20260101", "?limit=0", "?limit=101", "?cursor=not-a-cursor!"} {
+		w := request(router, "GET", "/bookings"+query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
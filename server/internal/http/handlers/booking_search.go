@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// exportBatchSize is how many bookings an export reads and writes at a time;
+// tests shrink it.
+var exportBatchSize = 500
+
+// SearchBookings lets agents find any booking. On top of the traveler filters
+// it takes ?email= (part of the account email), ?flight= (flight number),
+// ?created_from= and ?created_to= (YYYY-MM-DD), and ?min_total= and
+// ?max_total= with ?currency= (amounts as decimals, e.g. 250.00).
+func (h *BookingHandler) SearchBookings(c *gin.Context) {
+	query, err := adminBookingQuery(c, h.db)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	bookings, nextCursor, ok := pageBookings(c, query)
+	if !ok {
+		return
+	}
+
+	summaries, err := summarizeBookings(h.db, bookings)
+	if err == nil {
+		err = addUserEmails(h.db, summaries)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"bookings": summaries, "next_cursor": nextCursor})
+}
+
+// ExportBookings streams every booking matching the search filters as CSV or,
+// with ?format=ndjson, one JSON summary per line. Bookings are read in batches
+// so exports of any size run in constant memory.
+func (h *BookingHandler) ExportBookings(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "ndjson" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or ndjson"})
+		return
+	}
+	query, err := adminBookingQuery(c, h.db)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var write func(BookingSummary) error
+	var flush func()
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="bookings.csv"`)
+		w := csv.NewWriter(c.Writer)
+		if err := w.Write([]string{"id", "pnr", "status", "user_email", "created_at", "total", "currency", "passengers", "flights", "first_departure"}); err != nil {
+			return
+		}
+		write = func(b BookingSummary) error { return w.Write(csvRecord(b)) }
+		flush = w.Flush
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="bookings.ndjson"`)
+		enc := json.NewEncoder(c.Writer)
+		write = func(b BookingSummary) error { return enc.Encode(b) }
+		flush = func() {}
+	}
+	c.Status(http.StatusOK)
+
+	var after uint
+	for {
+		batch := query.Session(&gorm.Session{})
+		if after > 0 {
+			batch = batch.Where("bookings.id < ?", after)
+		}
+		var bookings []models.Booking
+		if err := batch.Order("bookings.id DESC").Limit(exportBatchSize).Find(&bookings).Error; err != nil {
+			// Headers are gone by now, so a failure can only cut the file short
+			log.Printf("Booking export failed: %v", err)
+			return
+		}
+		summaries, err := summarizeBookings(h.db, bookings)
+		if err == nil {
+			err = addUserEmails(h.db, summaries)
+		}
+		if err != nil {
+			log.Printf("Booking export failed: %v", err)
+			return
+		}
+		for _, summary := range summaries {
+			if err := write(summary); err != nil {
+				return
+			}
+		}
+		flush()
+		c.Writer.Flush()
+
+		if len(bookings) < exportBatchSize {
+			return
+		}
+		after = bookings[len(bookings)-1].ID
+	}
+}
+
+// adminBookingQuery builds the search across all users from the request.
+func adminBookingQuery(c *gin.Context, db *gorm.DB) (*gorm.DB, error) {
+	query, err := filterBookings(c, db.Model(&models.Booking{}))
+	if err != nil {
+		return nil, err
+	}
+
+	if email := strings.TrimSpace(c.Query("email")); email != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM users WHERE users.id = bookings.user_id AND LOWER(users.email) LIKE ?)",
+			"%"+strings.ToLower(email)+"%")
+	}
+	if flight := strings.TrimSpace(c.Query("flight")); flight != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM segments JOIN itineraries ON itineraries.id = segments.itinerary_id JOIN flights ON flights.id = segments.flight_id WHERE itineraries.booking_id = bookings.id AND flights.number = ?)",
+			strings.ToUpper(flight))
+	}
+
+	from, to, err := dateRange(c, "created_from", "created_to")
+	if err != nil {
+		return nil, err
+	}
+	if !from.IsZero() {
+		query = query.Where("bookings.created_at >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("bookings.created_at < ?", to)
+	}
+
+	minTotal, maxTotal := c.Query("min_total"), c.Query("max_total")
+	if minTotal != "" || maxTotal != "" {
+		// Amounts only compare within one currency
+		currency := strings.ToUpper(c.Query("currency"))
+		if currency == "" {
+			return nil, errors.New("currency is required with min_total or max_total")
+		}
+		query = query.Where("bookings.currency = ?", currency)
+		if minTotal != "" {
+			amount, err := money.Parse(minTotal, currency)
+			if err != nil {
+				return nil, errors.New("Invalid min_total")
+			}
+			query = query.Where("bookings.total_amount >= ?", amount.Amount)
+		}
+		if maxTotal != "" {
+			amount, err := money.Parse(maxTotal, currency)
+			if err != nil {
+				return nil, errors.New("Invalid max_total")
+			}
+			query = query.Where("bookings.total_amount <= ?", amount.Amount)
+		}
+	} else if currency := c.Query("currency"); currency != "" {
+		query = query.Where("bookings.currency = ?", strings.ToUpper(currency))
+	}
+
+	return query, nil
+}
+
+func addUserEmails(db *gorm.DB, summaries []BookingSummary) error {
+	ids := make([]uint, 0, len(summaries))
+	for _, s := range summaries {
+		ids = append(ids, s.UserID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var users []models.User
+	if err := db.Select("id", "email").Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return err
+	}
+	emails := map[uint]string{}
+	for _, u := range users {
+		emails[u.ID] = u.Email
+	}
+	for i := range summaries {
+		summaries[i].UserEmail = emails[summaries[i].UserID]
+	}
+	return nil
+}
+
+func csvRecord(b BookingSummary) []string {
+	total, _, _ := strings.Cut(money.New(b.TotalAmount, b.Currency).String(), " ")
+
+	flights := make([]string, len(b.Flights))
+	firstDeparture := ""
+	for i, f := range b.Flights {
+		flights[i] = f.Number + " " + f.Origin + "-" + f.Destination
+		if i == 0 {
+			firstDeparture = f.DepartureTime.UTC().Format(time.RFC3339)
+		}
+	}
+
+	return []string{
+		strconv.FormatUint(uint64(b.ID), 10),
+		b.PNR,
+		string(b.Status),
+		b.UserEmail,
+		b.CreatedAt.UTC().Format(time.RFC3339),
+		total,
+		b.Currency,
+		strings.Join(b.Passengers, "; "),
+		strings.Join(flights, "; "),
+		firstDeparture,
+	}
+}
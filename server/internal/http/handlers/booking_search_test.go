@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupBookingSearchTestRouter(t *testing.T, db *gorm.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	bookingHandler := NewBookingHandler(db, &config.Config{}, fx.Default(), payments.NewFakeClient(), nil)
+
+	router := gin.New()
+	router.GET("/admin/bookings", bookingHandler.SearchBookings)
+	router.GET("/admin/bookings/export", bookingHandler.ExportBookings)
+
+	// Ada on BA200 (paid), Grace on BA202 (hold), and Alan on BA200 for
+	// another account
+	addExchangeFlight(db)
+	bookings := setupBookingTestRouter(db)
+	createTestBooking(t, bookings, nil)
+	createTestBooking(t, bookings, map[string]interface{}{
+		"segments":   []map[string]interface{}{{"flight_id": 2, "fare_id": 2}},
+		"passengers": []map[string]interface{}{{"first_name": "Grace", "last_name": "Hopper"}},
+	})
+	createTestBooking(t, bookings, map[string]interface{}{
+		"segments":   []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers": []map[string]interface{}{{"first_name": "Alan", "last_name": "Turing"}},
+	})
+	db.Create(&models.User{Email: "alan@example.org", PasswordHash: "x"})
+	db.Model(&models.Booking{}).Where("id = ?", 3).Update("user_id", 2)
+	db.Model(&models.Booking{}).Where("id = ?", 1).Update("status", models.StatusPaid)
+	return router
+}
+
+func TestBookingHandler_SearchBookings(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupBookingSearchTestRouter(t, db)
+
+	list := listAdminBookings(t, router, "")
+	assert.Equal(t, []uint{3, 2, 1}, bookingIDs(list))
+	assert.Equal(t, "alan@example.org", list.Bookings[0].UserEmail)
+	assert.Equal(t, "traveler@example.com", list.Bookings[2].UserEmail)
+
+	today := time.Now().UTC().Format("2006-01-02")
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	for query, want := range map[string][]uint{
+		"?email=ALAN@":                           {3},
+		"?flight=ba202":                          {2},
+		"?status=paid":                           {1},
+		"?passenger=turing":                      {3},
+		"?created_from=" + today:                 {3, 2, 1},
+		"?created_to=" + yesterday:               {},
+		"?min_total=400&currency=usd":            {2},
+		"?max_total=399.99&currency=USD":         {3, 1},
+		"?min_total=400&currency=EUR":            {},
+		"?email=example.com&flight=BA200":        {1},
+		"?limit=1&cursor=" + encodeCursor(3):     {2},
+		"?flight=BA200&status=hold,paid&limit=5": {3, 1},
+	} {
+		assert.Equal(t, want, bookingIDs(listAdminBookings(t, router, query)), query)
+	}
+
+	for _, query := range []string{"?min_total=400", "?min_total=4.001&currency=USD", "?created_from=yesterday", "?status=lost"} {
+		w := request(router, "GET", "/admin/bookings"+query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestBookingHandler_ExportBookings(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupBookingSearchTestRouter(t, db)
+
+	w := request(router, "GET", "/admin/bookings/export?flight=BA200")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	records, err := csv.NewReader(w.Body).ReadAll()
+	assert.NoError(t, err)
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, []string{"id", "pnr", "status", "user_email", "created_at", "total", "currency", "passengers", "flights", "first_departure"}, records[0])
+		ada := records[2]
+		assert.Equal(t, "1", ada[0])
+		assert.Equal(t, "paid", ada[2])
+		assert.Equal(t, "traveler@example.com", ada[3])
+		assert.Equal(t, "399.99", ada[5])
+		assert.Equal(t, "USD", ada[6])
+		assert.Equal(t, "Ada Lovelace", ada[7])
+		assert.Equal(t, "BA200 JFK-LHR", ada[8])
+	}
+
+	w = request(router, "GET", "/admin/bookings/export?format=ndjson")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	var ids []uint
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var summary BookingSummary
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &summary))
+		ids = append(ids, summary.ID)
+	}
+	assert.Equal(t, []uint{3, 2, 1}, ids)
+
+	// Several batches give the same rows, each once
+	exportBatchSize = 2
+	defer func() { exportBatchSize = 500 }()
+	w = request(router, "GET", "/admin/bookings/export?status=hold,paid")
+	records, err = csv.NewReader(w.Body).ReadAll()
+	assert.NoError(t, err)
+	var rows []string
+	for _, record := range records[1:] {
+		rows = append(rows, record[0])
+	}
+	assert.Equal(t, []string{"3", "2", "1"}, rows)
+
+	w = request(router, "GET", "/admin/bookings/export?format=xlsx")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = request(router, "GET", "/admin/bookings/export?max_total=10")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func listAdminBookings(t *testing.T, router *gin.Engine, query string) bookingList {
+	t.Helper()
+	w := request(router, "GET", "/admin/bookings"+query)
+	if !assert.Equal(t, http.StatusOK, w.Code, w.Body.String()) {
+		t.FailNow()
+	}
+	var list bookingList
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	return list
+}
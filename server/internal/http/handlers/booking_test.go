@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/payments"
+	"skyliner/internal/pnr"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupBookingTestDB() *gorm.DB {
+	db, _ := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{TranslateError: true})
+	_ = db.AutoMigrate(
+		&models.User{},
+		&models.Airport{},
+		&models.Airline{},
+		&models.Flight{},
+		&models.Fare{},
+		&models.SeatMap{},
+		&models.Seat{},
+		&models.SSRType{},
+		&models.AirlineSSR{},
+		&models.Ancillary{},
+		&models.Booking{},
+		&models.Itinerary{},
+		&models.Segment{},
+		&models.SeatAssignment{},
+		&models.Exchange{},
+		&models.ExchangeSegment{},
+		&models.Passenger{},
+		&models.APISRecord{},
+		&models.SSRRequest{},
+		&models.AncillaryPurchase{},
+		&models.Ticket{},
+		&models.Coupon{},
+		&models.BoardingPass{},
+		&models.GroupTerms{},
+		&models.GroupInstallment{},
+		&models.BookingSplit{},
+		&models.WaitlistEntry{},
+		&models.BookingRemark{},
+		&models.QueueItem{},
+		&models.Payment{},
+		&models.PaymentAllocation{},
+		&models.Refund{},
+		&models.Credit{},
+		&models.CreditTransaction{},
+		&models.Promotion{},
+		&models.PromotionRedemption{},
+		&models.PromotionUsage{},
+		&models.Waiver{},
+		&models.RepriceJob{},
+		&models.RepriceJobItem{},
+		&models.Baggage{},
+		&models.IdempotencyKey{},
+	)
+
+	db.Create(&models.User{Email: "traveler@example.com", PasswordHash: "x", FirstName: "John", LastName: "Traveler"})
+
+	airports := []models.Airport{
+		{Code: "JFK", Name: "John F. Kennedy International Airport", City: "New York", Country: "USA", TimeZone: "America/New_York"},
+		{Code: "LHR", Name: "Heathrow Airport", City: "London", Country: "UK", TimeZone: "Europe/London"},
+	}
+	db.Create(&airports)
+	airline := models.Airline{Code: "BA", Name: "British Airways", TicketPrefix: "125"}
+	db.Create(&airline)
+
+	flight := models.Flight{
+		Number:        "BA200",
+		AirlineID:     airline.ID,
+		OriginID:      airports[0].ID,
+		DestinationID: airports[1].ID,
+		DepartureTime: time.Now().Add(30 * 24 * time.Hour),
+		ArrivalTime:   time.Now().Add(30*24*time.Hour + 7*time.Hour),
+		Duration:      420,
+	}
+	db.Create(&flight)
+	db.Create(&models.Fare{FlightID: flight.ID, Class: "economy", FareType: "standard", BasePrice: 39999, Currency: "USD", Available: 30})
+
+	// Seats 1-3 are economy (3 costs extra), 4 is business, 5 is on another flight
+	economy := models.SeatMap{FlightID: flight.ID, Class: "economy"}
+	business := models.SeatMap{FlightID: flight.ID, Class: "business"}
+	otherFlight := models.SeatMap{FlightID: flight.ID + 100, Class: "economy"}
+	db.Create(&economy)
+	db.Create(&business)
+	db.Create(&otherFlight)
+	exitRowPrice := int64(2500)
+	db.Create(&[]models.Seat{
+		{SeatMapID: economy.ID, Row: 20, Column: "A", Class: "economy", Status: models.SeatAvailable},
+		{SeatMapID: economy.ID, Row: 20, Column: "B", Class: "economy", Status: models.SeatAvailable},
+		{SeatMapID: economy.ID, Row: 12, Column: "C", Class: "economy", Status: models.SeatAvailable, Price: &exitRowPrice},
+		{SeatMapID: business.ID, Row: 2, Column: "A", Class: "business", Status: models.SeatAvailable},
+		{SeatMapID: otherFlight.ID, Row: 20, Column: "A", Class: "economy", Status: models.SeatAvailable},
+	})
+
+	return db
+}
+
+func setupBookingTestRouter(db *gorm.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	bookingHandler := NewBookingHandler(db, &config.Config{}, fx.Default(), payments.NewFakeClient(), nil)
+
+	router := gin.New()
+	router.GET("/bookings/by-pnr/:pnr", bookingHandler.GetBookingByPNR)
+
+	protected := router.Group("")
+	protected.Use(func(c *gin.Context) {
+		c.Set("user_id", uint(1))
+		c.Next()
+	})
+	protected.POST("/bookings", bookingHandler.CreateBooking)
+	protected.GET("/bookings", bookingHandler.GetBookings)
+	protected.GET("/bookings/:id", bookingHandler.GetBooking)
+	protected.POST("/bookings/:id/cancel", bookingHandler.CancelBooking)
+	protected.GET("/bookings/:id/seats", bookingHandler.GetSeatAssignments)
+	protected.PUT("/bookings/:id/seats", bookingHandler.UpdateSeatAssignments)
+	protected.POST("/bookings/:id/exchange/quote", bookingHandler.QuoteExchange)
+	protected.POST("/bookings/:id/exchanges", bookingHandler.CreateExchange)
+	protected.POST("/bookings/:id/exchanges/:exchange_id/cancel", bookingHandler.CancelExchange)
+
+	return router
+}
+
+func createTestBooking(t *testing.T, router *gin.Engine, body map[string]interface{}) models.Booking {
+	t.Helper()
+	if body == nil {
+		body = map[string]interface{}{
+			"segments":   []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+			"passengers": []map[string]interface{}{{"first_name": "Ada", "last_name": "Lovelace"}},
+		}
+	}
+	w := postBooking(router, body)
+	if !assert.Equal(t, http.StatusCreated, w.Code, w.Body.String()) {
+		t.FailNow()
+	}
+
+	var response BookingResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.Booking
+}
+
+func postBooking(router *gin.Engine, body map[string]interface{}) *httptest.ResponseRecorder {
+	jsonData, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", "/bookings", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestBookingHandler_CreateBookingSettlementCurrency(t *testing.T) {
+	router := setupBookingTestRouter(setupBookingTestDB())
+
+	booking := createTestBooking(t, router, nil)
+	assert.Equal(t, int64(39999), booking.TotalAmount)
+	assert.Equal(t, "USD", booking.Currency)
+	assert.Empty(t, booking.FXRate)
+
+	booking = createTestBooking(t, router, map[string]interface{}{
+		"segments":   []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers": []map[string]interface{}{{"first_name": "Ada", "last_name": "Lovelace"}},
+		"currency":   "gbp",
+	})
+	assert.Equal(t, "GBP", booking.Currency)
+	assert.Equal(t, int64(39999), booking.BaseAmount)
+	assert.Equal(t, "USD", booking.BaseCurrency)
+	assert.NotEmpty(t, booking.FXRate)
+	assert.NotNil(t, booking.FXRateAsOf)
+	assert.NotEqual(t, booking.BaseAmount, booking.TotalAmount)
+}
+
+func TestBookingHandler_CreateBookingPNR(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupBookingTestRouter(db)
+
+	first := createTestBooking(t, router, nil)
+	second := createTestBooking(t, router, nil)
+	assert.True(t, pnr.Valid(first.PNR), first.PNR)
+	assert.True(t, pnr.Valid(second.PNR), second.PNR)
+	assert.NotEqual(t, first.PNR, second.PNR)
+
+	// Force the generator to hand out the taken code before a fresh one
+	codes := []string{first.PNR, first.PNR, "ZZZ222"}
+	newPNR = func() (string, error) {
+		code := codes[0]
+		codes = codes[1:]
+		return code, nil
+	}
+	defer func() { newPNR = pnr.Generate }()
+
+	third := createTestBooking(t, router, nil)
+	assert.Equal(t, "ZZZ222", third.PNR)
+	assert.Len(t, third.Passengers, 1)
+
+	var count int64
+	db.Model(&models.Booking{}).Count(&count)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestBookingHandler_GetBookingByPNR(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupBookingTestRouter(db)
+	booking := createTestBooking(t, router, nil)
+	legacy := createTestBooking(t, router, nil)
+	db.Model(&legacy).Update("pnr", "SKYT3K9ZQ")
+
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+	}{
+		{name: "matching pnr and last name", url: "/bookings/by-pnr/" + booking.PNR + "?last_name=Lovelace", expectedStatus: http.StatusOK},
+		{name: "case insensitive", url: "/bookings/by-pnr/" + booking.PNR + "?last_name=LOVELACE", expectedStatus: http.StatusOK},
+		{name: "wrong last name", url: "/bookings/by-pnr/" + booking.PNR + "?last_name=Babbage", expectedStatus: http.StatusNotFound},
+		{name: "unknown pnr", url: "/bookings/by-pnr/AAAAAA?last_name=Lovelace", expectedStatus: http.StatusNotFound},
+		{name: "malformed pnr", url: "/bookings/by-pnr/ABC123?last_name=Lovelace", expectedStatus: http.StatusNotFound},
+		{name: "legacy pnr", url: "/bookings/by-pnr/skyt3k9zq?last_name=Lovelace", expectedStatus: http.StatusOK},
+		{name: "missing last name", url: "/bookings/by-pnr/" + booking.PNR, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedStatus == http.StatusOK {
+				var response struct {
+					Booking models.Booking `json:"booking"`
+				}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Contains(t, []uint{booking.ID, legacy.ID}, response.Booking.ID)
+				assert.Len(t, response.Booking.Itinerary.Segments, 1)
+				assert.Equal(t, "BA200", response.Booking.Itinerary.Segments[0].Flight.Number)
+				assert.Equal(t, "Lovelace", response.Booking.Passengers[0].LastName)
+
+				// Only the trip is shown, not the account or anyone's documents
+				assert.NotContains(t, w.Body.String(), "traveler@example.com")
+				assert.NotContains(t, w.Body.String(), `"user"`)
+				assert.NotContains(t, w.Body.String(), `"payments"`)
+				assert.NotContains(t, w.Body.String(), `"date_of_birth"`)
+			}
+		})
+	}
+}
+
+func TestBookingHandler_GetBooking(t *testing.T) {
+	router := setupBookingTestRouter(setupBookingTestDB())
+	booking := createTestBooking(t, router, nil)
+
+	req, _ := http.NewRequest("GET", "/bookings/1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Booking models.Booking `json:"booking"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, booking.PNR, response.Booking.PNR)
+	assert.Equal(t, "traveler@example.com", response.Booking.User.Email)
+	assert.Equal(t, "JFK", response.Booking.Itinerary.Segments[0].Flight.Origin.Code)
+}
+
+func twoPassengerBooking(seats []map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"segments": []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers": []map[string]interface{}{
+			{"first_name": "Ada", "last_name": "Lovelace"},
+			{"first_name": "Charles", "last_name": "Babbage"},
+		},
+		"seats": seats,
+	}
+}
+
+func seatStatus(db *gorm.DB, seatID uint) models.SeatStatus {
+	var seat models.Seat
+	db.First(&seat, seatID)
+	return seat.Status
+}
+
+func TestBookingHandler_CreateBookingWithSeats(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupBookingTestRouter(db)
+
+	booking := createTestBooking(t, router, twoPassengerBooking([]map[string]interface{}{
+		{"passenger": 0, "segment": 0, "seat_id": 1},
+		{"passenger": 1, "segment": 0, "seat_id": 3},
+	}))
+
+	assignments := booking.Itinerary.Segments[0].SeatAssignments
+	assert.Len(t, assignments, 2)
+	assert.Equal(t, models.SeatSelected, seatStatus(db, 1))
+	assert.Equal(t, models.SeatSelected, seatStatus(db, 3))
+	// Two fares plus the exit row charge
+	assert.Equal(t, int64(2*39999+2500), booking.TotalAmount)
+
+	tests := []struct {
+		name           string
+		seats          []map[string]interface{}
+		expectedStatus int
+	}{
+		{name: "seat already taken", seats: []map[string]interface{}{{"passenger": 0, "segment": 0, "seat_id": 1}}, expectedStatus: http.StatusConflict},
+		{name: "wrong cabin", seats: []map[string]interface{}{{"passenger": 0, "segment": 0, "seat_id": 4}}, expectedStatus: http.StatusBadRequest},
+		{name: "wrong flight", seats: []map[string]interface{}{{"passenger": 0, "segment": 0, "seat_id": 5}}, expectedStatus: http.StatusBadRequest},
+		{name: "unknown seat", seats: []map[string]interface{}{{"passenger": 0, "segment": 0, "seat_id": 99}}, expectedStatus: http.StatusBadRequest},
+		{name: "unknown passenger", seats: []map[string]interface{}{{"passenger": 5, "segment": 0, "seat_id": 2}}, expectedStatus: http.StatusBadRequest},
+		{name: "two seats for one passenger", seats: []map[string]interface{}{
+			{"passenger": 0, "segment": 0, "seat_id": 2},
+			{"passenger": 0, "segment": 0, "seat_id": 2},
+		}, expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonData, _ := json.Marshal(twoPassengerBooking(tt.seats))
+			req, _ := http.NewRequest("POST", "/bookings", bytes.NewBuffer(jsonData))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if tt.expectedStatus == http.StatusOK {
+				// Repeating the same seat for a passenger is a no-op, not a second seat
+				assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+				return
+			}
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+		})
+	}
+
+	// Failed bookings must not leave seats behind
+	assert.Equal(t, models.SeatAvailable, seatStatus(db, 4))
+	assert.Equal(t, models.SeatAvailable, seatStatus(db, 5))
+}
+
+func TestBookingHandler_UpdateSeatAssignments(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupBookingTestRouter(db)
+
+	booking := createTestBooking(t, router, twoPassengerBooking([]map[string]interface{}{
+		{"passenger": 0, "segment": 0, "seat_id": 1},
+	}))
+	segmentID := booking.Itinerary.Segments[0].ID
+	ada, charles := booking.Passengers[0].ID, booking.Passengers[1].ID
+
+	update := func(assignments []map[string]interface{}) *httptest.ResponseRecorder {
+		jsonData, _ := json.Marshal(map[string]interface{}{"assignments": assignments})
+		req, _ := http.NewRequest("PUT", "/bookings/1/seats", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Move Ada to the exit row and seat Charles in her old seat in one go
+	w := update([]map[string]interface{}{
+		{"passenger_id": ada, "segment_id": segmentID, "seat_id": 3},
+		{"passenger_id": charles, "segment_id": segmentID, "seat_id": 1},
+	})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, models.SeatSelected, seatStatus(db, 1))
+	assert.Equal(t, models.SeatSelected, seatStatus(db, 3))
+
+	var updated models.Booking
+	db.First(&updated, booking.ID)
+	assert.Equal(t, int64(2*39999+2500), updated.TotalAmount)
+
+	// A seat on a passenger's own booking cannot be double assigned
+	w = update([]map[string]interface{}{{"passenger_id": ada, "segment_id": segmentID, "seat_id": 1}})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Once paid, moving to a cheaper seat is fine but not to a dearer one
+	db.Model(&models.Booking{}).Where("id = ?", booking.ID).Update("status", models.StatusPaid)
+	w = update([]map[string]interface{}{{"passenger_id": ada, "segment_id": segmentID, "seat_id": 2}})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, models.SeatAvailable, seatStatus(db, 3))
+	w = update([]map[string]interface{}{{"passenger_id": charles, "segment_id": segmentID, "seat_id": 3}})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, models.SeatSelected, seatStatus(db, 1), "rejected change must keep the old seat")
+	assert.Equal(t, models.SeatAvailable, seatStatus(db, 3))
+
+	// Passengers and segments from elsewhere are rejected
+	w = update([]map[string]interface{}{{"passenger_id": 999, "segment_id": segmentID, "seat_id": 1}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = update([]map[string]interface{}{{"passenger_id": ada, "segment_id": 999, "seat_id": 1}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, _ := http.NewRequest("GET", "/bookings/1/seats", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listed struct {
+		SeatAssignments []models.SeatAssignment `json:"seat_assignments"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Len(t, listed.SeatAssignments, 2)
+}
+
+func TestBookingHandler_CancelReleasesSeats(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupBookingTestRouter(db)
+
+	createTestBooking(t, router, twoPassengerBooking([]map[string]interface{}{
+		{"passenger": 0, "segment": 0, "seat_id": 1},
+		{"passenger": 1, "segment": 0, "seat_id": 2},
+	}))
+
+	req, _ := http.NewRequest("POST", "/bookings/1/cancel", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, models.SeatAvailable, seatStatus(db, 1))
+	assert.Equal(t, models.SeatAvailable, seatStatus(db, 2))
+	var count int64
+	db.Model(&models.SeatAssignment{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/ical"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const calendarContentType = "text/calendar; charset=utf-8"
+
+// GetBookingCalendar downloads a booking's flights as an iCalendar file.
+func (h *BookingHandler) GetBookingCalendar(c *gin.Context) {
+	bookingID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID"})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+
+	var booking models.Booking
+	if err := withBookingDetails(h.db).Where("id = ? AND user_id = ?", uint(bookingID), userID).First(&booking).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return
+	}
+
+	cal := ical.Calendar{Name: "Trip " + booking.PNR, Events: bookingEvents(&booking, time.Time{})}
+	var out bytes.Buffer
+	if _, err := cal.WriteTo(&out); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate calendar"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="trip-%s.ics"`, booking.PNR))
+	c.Data(http.StatusOK, calendarContentType, out.Bytes())
+}
+
+// GetCalendarFeed returns the user's calendar subscription URL, creating the
+// feed on first use.
+func (h *BookingHandler) GetCalendarFeed(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.CalendarToken == nil {
+		token, err := calendarToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar feed"})
+			return
+		}
+		if err := h.db.Model(&user).Update("calendar_token", token).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar feed"})
+			return
+		}
+		user.CalendarToken = &token
+	}
+
+	url := strings.TrimRight(h.cfg.PublicURL, "/") + "/api/v1/calendar-feeds/" + *user.CalendarToken + ".ics"
+	c.JSON(http.StatusOK, gin.H{
+		"url":        url,
+		"webcal_url": "webcal://" + strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://"),
+	})
+}
+
+// RevokeCalendarFeed stops the current subscription URL working. Getting the
+// feed again issues a new one.
+func (h *BookingHandler) RevokeCalendarFeed(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	if err := h.db.Model(&models.User{}).Where("id = ?", userID).Update("calendar_token", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke calendar feed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar feed revoked"})
+}
+
+// GetCalendarFeedEvents serves a user's upcoming flights to calendar apps.
+// It is public; the token in the URL is the only credential.
+func (h *BookingHandler) GetCalendarFeedEvents(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+
+	var user models.User
+	if token == "" || h.db.Where("calendar_token = ?", token).First(&user).Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar feed not found"})
+		return
+	}
+
+	var bookings []models.Booking
+	if err := withBookingDetails(h.db).
+		Where("user_id = ? AND status IN ?", user.ID, []models.BookingStatus{models.StatusPaid, models.StatusTicketed}).
+		Order("id").Find(&bookings).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookings"})
+		return
+	}
+
+	cal := ical.Calendar{Name: "Skyliner trips"}
+	now := time.Now()
+	for i := range bookings {
+		cal.Events = append(cal.Events, bookingEvents(&bookings[i], now)...)
+	}
+
+	var out bytes.Buffer
+	if _, err := cal.WriteTo(&out); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate calendar"})
+		return
+	}
+	c.Data(http.StatusOK, calendarContentType, out.Bytes())
+}
+
+// bookingEvents has an event per flight landing after since, in the local time
+// of each airport.
+func bookingEvents(booking *models.Booking, since time.Time) []ical.Event {
+	status := ical.StatusConfirmed
+	switch booking.Status {
+	case models.StatusHold:
+		status = ical.StatusTentative
+	case models.StatusCancelled:
+		status = ical.StatusCancelled
+	}
+
+	names := map[uint]string{}
+	for _, passenger := range booking.Passengers {
+		names[passenger.ID] = strings.ToUpper(passenger.LastName + "/" + passenger.FirstName)
+	}
+
+	var events []ical.Event
+	for _, segment := range booking.Itinerary.Segments {
+		flight := segment.Flight
+		if flight.ArrivalTime.Before(since) {
+			continue
+		}
+
+		description := []string{
+			fmt.Sprintf("%s flight %s from %s to %s", flight.Airline.Name, flight.Number, flight.Origin.Name, flight.Destination.Name),
+			"Booking reference " + booking.PNR,
+		}
+		for _, assignment := range segment.SeatAssignments {
+			description = append(description, fmt.Sprintf("Seat %d%s %s", assignment.Seat.Row, assignment.Seat.Column, names[assignment.PassengerID]))
+		}
+
+		events = append(events, ical.Event{
+			UID:         fmt.Sprintf("segment-%d@skyliner", segment.ID),
+			Summary:     fmt.Sprintf("%s %s to %s (%s)", flight.Number, flight.Origin.Code, flight.Destination.Code, booking.PNR),
+			Location:    flight.Origin.Name,
+			Description: strings.Join(description, "\n"),
+			Start:       flight.DepartureTime.In(flight.Origin.Location()),
+			End:         flight.ArrivalTime.In(flight.Destination.Location()),
+			Status:      status,
+		})
+	}
+	return events
+}
+
+func calendarToken() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupCalendarTestRouter(db *gorm.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	bookingHandler := NewBookingHandler(db, &config.Config{PublicURL: "https://api.skyliner.test/"}, fx.Default(), payments.NewFakeClient(), nil)
+
+	router := gin.New()
+	router.GET("/api/v1/calendar-feeds/:token", bookingHandler.GetCalendarFeedEvents)
+	protected := router.Group("")
+	protected.Use(func(c *gin.Context) {
+		c.Set("user_id", uint(1))
+		c.Next()
+	})
+	protected.GET("/bookings/:id/calendar.ics", bookingHandler.GetBookingCalendar)
+	protected.GET("/calendar-feed", bookingHandler.GetCalendarFeed)
+	protected.DELETE("/calendar-feed", bookingHandler.RevokeCalendarFeed)
+	return router
+}
+
+func request(router *gin.Engine, method, path string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestBookingHandler_GetBookingCalendar(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupCalendarTestRouter(db)
+	booking := createTestBooking(t, setupBookingTestRouter(db), nil)
+
+	var flight models.Flight
+	db.First(&flight, 1)
+	newYork, _ := time.LoadLocation("America/New_York")
+
+	w := request(router, "GET", "/bookings/1/calendar.ics")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "text/calendar; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "trip-"+booking.PNR+".ics")
+	body := w.Body.String()
+	assert.Contains(t, body, "SUMMARY:BA200 JFK to LHR ("+booking.PNR+")\r\n")
+	assert.Contains(t, body, "UID:segment-1@skyliner\r\n")
+	assert.Contains(t, body, "DTSTART;TZID=America/New_York:"+flight.DepartureTime.In(newYork).Format("20060102T150405")+"\r\n")
+	assert.Contains(t, body, "TZID:Europe/London\r\n")
+	// Unpaid holds may still lapse
+	assert.Contains(t, body, "STATUS:TENTATIVE\r\n")
+
+	w = request(router, "GET", "/bookings/2/calendar.ics")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestBookingHandler_CalendarFeed(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupCalendarTestRouter(db)
+	booking := createTestBooking(t, setupBookingTestRouter(db), nil)
+	createTestBooking(t, setupBookingTestRouter(db), nil)
+	db.Model(&models.Booking{}).Where("id = ?", booking.ID).Update("status", models.StatusPaid)
+
+	w := request(router, "GET", "/calendar-feed")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var feed struct {
+		URL       string `json:"url"`
+		WebcalURL string `json:"webcal_url"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &feed))
+	assert.True(t, strings.HasPrefix(feed.URL, "https://api.skyliner.test/api/v1/calendar-feeds/"), feed.URL)
+	assert.True(t, strings.HasSuffix(feed.URL, ".ics"))
+	assert.Equal(t, "webcal://"+strings.TrimPrefix(feed.URL, "https://"), feed.WebcalURL)
+
+	// The same URL until it is revoked
+	w = request(router, "GET", "/calendar-feed")
+	assert.Contains(t, w.Body.String(), feed.URL)
+
+	path := strings.TrimPrefix(feed.URL, "https://api.skyliner.test")
+	w = request(router, "GET", path)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	// Only the paid booking; the hold is left out
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "BEGIN:VEVENT"))
+	assert.Contains(t, w.Body.String(), booking.PNR)
+	assert.Contains(t, w.Body.String(), "STATUS:CONFIRMED")
+
+	// Flights that have landed drop off the feed
+	db.Model(&models.Flight{}).Where("id = ?", 1).Updates(map[string]interface{}{
+		"departure_time": time.Now().Add(-10 * time.Hour),
+		"arrival_time":   time.Now().Add(-3 * time.Hour),
+	})
+	w = request(router, "GET", path)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "BEGIN:VEVENT")
+
+	w = request(router, "DELETE", "/calendar-feed")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(router, "GET", path)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = request(router, "GET", "/calendar-feed")
+	assert.NotContains(t, w.Body.String(), feed.URL)
+}
@@ -0,0 +1,462 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"skyliner/internal/bcbp"
+	"skyliner/internal/db/models"
+	"skyliner/internal/qr"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CheckInRequest struct {
+	PassengerID uint `json:"passenger_id" binding:"required"`
+	SegmentID   uint `json:"segment_id" binding:"required"`
+}
+
+type CheckInWindowRequest struct {
+	Opens  int `json:"check_in_opens" binding:"required,min=1"` // minutes before departure
+	Closes int `json:"check_in_closes" binding:"min=0"`
+}
+
+// WalletPass is the pass.json of an Apple Wallet boarding pass. It still has
+// to be signed and packaged as a .pkpass with the pass type certificate.
+type WalletPass struct {
+	FormatVersion      int             `json:"formatVersion"`
+	PassTypeIdentifier string          `json:"passTypeIdentifier"`
+	TeamIdentifier     string          `json:"teamIdentifier"`
+	SerialNumber       string          `json:"serialNumber"`
+	OrganizationName   string          `json:"organizationName"`
+	Description        string          `json:"description"`
+	RelevantDate       time.Time       `json:"relevantDate"`
+	Barcodes           []WalletBarcode `json:"barcodes"`
+	BoardingPass       WalletFields    `json:"boardingPass"`
+}
+
+type WalletBarcode struct {
+	Format          string `json:"format"`
+	Message         string `json:"message"`
+	MessageEncoding string `json:"messageEncoding"`
+}
+
+type WalletFields struct {
+	TransitType     string        `json:"transitType"`
+	HeaderFields    []WalletField `json:"headerFields"`
+	PrimaryFields   []WalletField `json:"primaryFields"`
+	SecondaryFields []WalletField `json:"secondaryFields"`
+	AuxiliaryFields []WalletField `json:"auxiliaryFields"`
+	BackFields      []WalletField `json:"backFields"`
+}
+
+type WalletField struct {
+	Key       string `json:"key"`
+	Label     string `json:"label"`
+	Value     string `json:"value"`
+	DateStyle string `json:"dateStyle,omitempty"`
+	TimeStyle string `json:"timeStyle,omitempty"`
+}
+
+// CheckIn checks a passenger in for one segment of a ticketed booking and
+// issues the boarding pass. Checking in again returns the same pass.
+func (h *BookingHandler) CheckIn(c *gin.Context) {
+	bookingID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID"})
+		return
+	}
+
+	var req CheckInRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+
+	var booking models.Booking
+	if err := h.db.Where("id = ? AND user_id = ?", uint(bookingID), userID).First(&booking).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return
+	}
+
+	if booking.Status != models.StatusTicketed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Booking must be ticketed before check-in"})
+		return
+	}
+
+	var coupon models.Coupon
+	if err := h.db.Joins("JOIN tickets ON tickets.id = coupons.ticket_id").
+		Where("tickets.booking_id = ? AND tickets.passenger_id = ? AND coupons.segment_id = ?", booking.ID, req.PassengerID, req.SegmentID).
+		First(&coupon).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No ticket for this passenger on this segment"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ticket"})
+		return
+	}
+
+	if coupon.Status == models.CouponCheckedIn {
+		var pass models.BoardingPass
+		if err := h.db.Where("coupon_id = ?", coupon.ID).First(&pass).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch boarding pass"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"boarding_pass": pass})
+		return
+	}
+	if coupon.Status != models.CouponOpen {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Ticket coupon is %s", coupon.Status)})
+		return
+	}
+
+	var segment models.Segment
+	if err := h.db.Preload("Flight.Airline").Preload("Flight.Origin").Preload("Flight.Destination").Preload("Fare").
+		First(&segment, req.SegmentID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch segment"})
+		return
+	}
+
+	flight := segment.Flight
+	now := time.Now()
+	opensAt := flight.DepartureTime.Add(-time.Duration(flight.CheckInOpens) * time.Minute)
+	closesAt := flight.DepartureTime.Add(-time.Duration(flight.CheckInCloses) * time.Minute)
+	if now.Before(opensAt) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Check-in is not open yet", "opens_at": opensAt})
+		return
+	}
+	if !now.Before(closesAt) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Check-in has closed"})
+		return
+	}
+
+	var assignment models.SeatAssignment
+	if err := h.db.Preload("Seat").Where("passenger_id = ? AND segment_id = ?", req.PassengerID, req.SegmentID).First(&assignment).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusConflict, gin.H{"error": "Choose a seat before checking in"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch seat"})
+		return
+	}
+
+	incomplete, err := incompleteAPIS(h.db, booking.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check passenger details"})
+		return
+	}
+	for _, p := range incomplete {
+		if p.PassengerID == req.PassengerID {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Passenger details are incomplete", "passengers": []PassengerAPIS{p}})
+			return
+		}
+	}
+
+	var passenger models.Passenger
+	if err := h.db.First(&passenger, req.PassengerID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch passenger"})
+		return
+	}
+
+	seat := fmt.Sprintf("%d%s", assignment.Seat.Row, assignment.Seat.Column)
+	pass := models.BoardingPass{
+		BookingID:   booking.ID,
+		PassengerID: passenger.ID,
+		SegmentID:   segment.ID,
+		FlightID:    flight.ID,
+		CouponID:    coupon.ID,
+		Seat:        seat,
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&coupon).Where("status = ?", models.CouponOpen).Update("status", models.CouponCheckedIn)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errCouponTaken
+		}
+
+		return createWithSequence(tx, &pass, func(sequence int) (string, error) {
+			return bcbp.BoardingPass{
+				PassengerName: passenger.LastName + "/" + passenger.FirstName,
+				Legs: []bcbp.Leg{{
+					PNR:          booking.PNR,
+					From:         flight.Origin.Code,
+					To:           flight.Destination.Code,
+					Carrier:      flight.Airline.Code,
+					FlightNumber: strings.TrimPrefix(flight.Number, flight.Airline.Code),
+					Date:         flight.DepartureTime.In(flight.Origin.Location()),
+					Compartment:  compartment(segment.Fare.Class),
+					Seat:         seat,
+					Sequence:     sequence,
+				}},
+			}.Encode()
+		})
+	})
+	if errors.Is(err, errCouponTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Passenger is already checked in"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check in"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"boarding_pass": pass})
+}
+
+var errCouponTaken = errors.New("coupon is no longer open")
+
+// maxSequenceAttempts bounds retries when passengers check in for the same
+// flight at once and take the same sequence number.
+const maxSequenceAttempts = 5
+
+// createWithSequence saves a boarding pass with the next check-in sequence
+// number on its flight, encoding its BCBP with that number. The unique index
+// on (flight_id, sequence) settles concurrent check-ins; the loser retries.
+func createWithSequence(tx *gorm.DB, pass *models.BoardingPass, encode func(sequence int) (string, error)) error {
+	for attempt := 0; attempt < maxSequenceAttempts; attempt++ {
+		var last int
+		if err := tx.Model(&models.BoardingPass{}).Where("flight_id = ?", pass.FlightID).
+			Select("COALESCE(MAX(sequence), 0)").Scan(&last).Error; err != nil {
+			return err
+		}
+		pass.Sequence = last + 1
+		data, err := encode(pass.Sequence)
+		if err != nil {
+			return err
+		}
+		pass.BCBP = data
+
+		if err := tx.SavePoint("create_boarding_pass").Error; err != nil {
+			return err
+		}
+		err = tx.Create(pass).Error
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			return err
+		}
+		if err := tx.RollbackTo("create_boarding_pass").Error; err != nil {
+			return err
+		}
+		pass.ID = 0
+	}
+	return errors.New("could not allocate a boarding sequence number")
+}
+
+// compartment is the IATA booking compartment code for a cabin.
+func compartment(class string) string {
+	switch class {
+	case "first":
+		return "F"
+	case "business":
+		return "J"
+	case "premium_economy":
+		return "W"
+	default:
+		return "Y"
+	}
+}
+
+// GetBoardingPasses lists the boarding passes issued on a booking.
+func (h *BookingHandler) GetBoardingPasses(c *gin.Context) {
+	bookingID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID"})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+
+	var booking models.Booking
+	if err := h.db.Where("id = ? AND user_id = ?", uint(bookingID), userID).First(&booking).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return
+	}
+
+	var passes []models.BoardingPass
+	if err := h.db.Where("booking_id = ?", booking.ID).Order("id").Find(&passes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch boarding passes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"boarding_passes": passes})
+}
+
+// GetBoardingPassBarcode renders a boarding pass's BCBP data as a QR code.
+func (h *BookingHandler) GetBoardingPassBarcode(c *gin.Context) {
+	pass, ok := h.findBoardingPass(c)
+	if !ok {
+		return
+	}
+
+	scale, err := strconv.Atoi(c.DefaultQuery("scale", "6"))
+	if err != nil || scale < 1 || scale > 20 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scale must be between 1 and 20"})
+		return
+	}
+
+	code, err := qr.Encode([]byte(pass.BCBP))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode barcode"})
+		return
+	}
+	var img bytes.Buffer
+	if err := png.Encode(&img, code.Image(scale)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render barcode"})
+		return
+	}
+
+	c.Data(http.StatusOK, "image/png", img.Bytes())
+}
+
+// GetBoardingPassWallet returns the pass.json for adding a boarding pass to
+// Apple Wallet.
+func (h *BookingHandler) GetBoardingPassWallet(c *gin.Context) {
+	pass, ok := h.findBoardingPass(c)
+	if !ok {
+		return
+	}
+
+	var booking models.Booking
+	var passenger models.Passenger
+	var flight models.Flight
+	var ticket models.Ticket
+	err := h.db.First(&booking, pass.BookingID).Error
+	if err == nil {
+		err = h.db.First(&passenger, pass.PassengerID).Error
+	}
+	if err == nil {
+		err = h.db.Preload("Airline").Preload("Origin").Preload("Destination").First(&flight, pass.FlightID).Error
+	}
+	if err == nil {
+		err = h.db.Where("passenger_id = ?", pass.PassengerID).First(&ticket).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch boarding pass details"})
+		return
+	}
+
+	c.JSON(http.StatusOK, WalletPass{
+		FormatVersion:      1,
+		PassTypeIdentifier: h.cfg.WalletPassTypeID,
+		TeamIdentifier:     h.cfg.WalletTeamID,
+		SerialNumber:       fmt.Sprintf("%s-%d", booking.PNR, pass.ID),
+		OrganizationName:   flight.Airline.Name,
+		Description:        "Boarding pass for flight " + flight.Number,
+		RelevantDate:       flight.DepartureTime,
+		Barcodes:           []WalletBarcode{{Format: "PKBarcodeFormatQR", Message: pass.BCBP, MessageEncoding: "iso-8859-1"}},
+		BoardingPass: WalletFields{
+			TransitType:  "PKTransitTypeAir",
+			HeaderFields: []WalletField{{Key: "flight", Label: "Flight", Value: flight.Number}},
+			PrimaryFields: []WalletField{
+				{Key: "origin", Label: flight.Origin.City, Value: flight.Origin.Code},
+				{Key: "destination", Label: flight.Destination.City, Value: flight.Destination.Code},
+			},
+			SecondaryFields: []WalletField{{Key: "passenger", Label: "Passenger", Value: passenger.FirstName + " " + passenger.LastName}},
+			AuxiliaryFields: []WalletField{
+				{Key: "departs", Label: "Departs", Value: flight.DepartureTime.Format(time.RFC3339), DateStyle: "PKDateStyleMedium", TimeStyle: "PKDateStyleShort"},
+				{Key: "seat", Label: "Seat", Value: pass.Seat},
+				{Key: "sequence", Label: "Seq", Value: strconv.Itoa(pass.Sequence)},
+			},
+			BackFields: []WalletField{
+				{Key: "pnr", Label: "Booking reference", Value: booking.PNR},
+				{Key: "ticket", Label: "E-ticket", Value: ticket.Number},
+			},
+		},
+	})
+}
+
+// findBoardingPass loads the boarding pass in the URL if it belongs to one of
+// the caller's bookings, writing the error response otherwise.
+func (h *BookingHandler) findBoardingPass(c *gin.Context) (*models.BoardingPass, bool) {
+	bookingID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID"})
+		return nil, false
+	}
+	passID, err := strconv.ParseUint(c.Param("pass_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid boarding pass ID"})
+		return nil, false
+	}
+
+	userID := c.GetUint("user_id")
+
+	var pass models.BoardingPass
+	if err := h.db.Joins("JOIN bookings ON bookings.id = boarding_passes.booking_id").
+		Where("boarding_passes.id = ? AND boarding_passes.booking_id = ? AND bookings.user_id = ?", uint(passID), uint(bookingID), userID).
+		First(&pass).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Boarding pass not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch boarding pass"})
+		return nil, false
+	}
+	return &pass, true
+}
+
+// UpdateCheckInWindow sets how long before departure check-in opens and
+// closes for a flight. Admins only.
+func (h *BookingHandler) UpdateCheckInWindow(c *gin.Context) {
+	if c.GetString("role") != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+
+	flightID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid flight ID"})
+		return
+	}
+
+	var req CheckInWindowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Closes >= req.Opens {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Check-in must close after it opens"})
+		return
+	}
+
+	var flight models.Flight
+	if err := h.db.First(&flight, uint(flightID)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Flight not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch flight"})
+		return
+	}
+
+	if err := h.db.Model(&flight).Updates(map[string]interface{}{
+		"check_in_opens":  req.Opens,
+		"check_in_closes": req.Closes,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update flight"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"flight": flight})
+}
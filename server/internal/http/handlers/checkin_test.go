@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupCheckInTestRouter(db *gorm.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	bookingHandler := NewBookingHandler(db, &config.Config{WalletPassTypeID: "pass.com.skyliner.boarding", WalletTeamID: "TEAM123"}, fx.Default(), payments.NewFakeClient(), nil)
+
+	router := gin.New()
+	protected := router.Group("")
+	protected.Use(func(c *gin.Context) {
+		c.Set("user_id", uint(1))
+		c.Next()
+	})
+	protected.PUT("/bookings/:id/seats", bookingHandler.UpdateSeatAssignments)
+	protected.POST("/bookings/:id/check-in", bookingHandler.CheckIn)
+	protected.GET("/bookings/:id/boarding-passes", bookingHandler.GetBoardingPasses)
+	protected.GET("/bookings/:id/boarding-passes/:pass_id/barcode.png", bookingHandler.GetBoardingPassBarcode)
+	protected.GET("/bookings/:id/boarding-passes/:pass_id/wallet.json", bookingHandler.GetBoardingPassWallet)
+
+	admin := router.Group("/admin")
+	admin.Use(testStaffRole)
+	admin.PUT("/flights/:id/check-in", bookingHandler.UpdateCheckInWindow)
+	return router
+}
+
+// createCheckInBooking books Ada (documents, seat 20A), Charles (seat 20B, no
+// documents) and Mary (documents, no seat), and tickets them.
+func createCheckInBooking(t *testing.T, db *gorm.DB) models.Booking {
+	booking := createTestBooking(t, setupBookingTestRouter(db), map[string]interface{}{
+		"segments": []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers": []map[string]interface{}{
+			documentedPassenger("Ada", "Lovelace"),
+			{"first_name": "Charles", "last_name": "Babbage"},
+			documentedPassenger("Mary", "Somerville"),
+		},
+		"seats": []map[string]interface{}{
+			{"passenger": 0, "segment": 0, "seat_id": 1},
+			{"passenger": 1, "segment": 0, "seat_id": 2},
+		},
+	})
+	db.Model(&models.Booking{}).Where("id = ?", booking.ID).Update("status", models.StatusPaid)
+	_, err := ticketBooking(db, booking.ID)
+	assert.NoError(t, err)
+	return booking
+}
+
+func checkIn(router *gin.Engine, passengerID uint) *httptest.ResponseRecorder {
+	return postJSON(router, "/bookings/1/check-in", gin.H{"passenger_id": passengerID, "segment_id": 1})
+}
+
+func TestBookingHandler_CheckIn(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupCheckInTestRouter(db)
+	booking := createCheckInBooking(t, db)
+
+	// The flight leaves in 30 days and check-in opens 24 hours before
+	w := checkIn(router, 1)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "not open yet")
+
+	window := gin.H{"check_in_opens": 31 * 24 * 60, "check_in_closes": 45}
+	w = adminRequest(router, "PUT", "/admin/flights/1/check-in", window, false)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = adminRequest(router, "PUT", "/admin/flights/1/check-in", gin.H{"check_in_opens": 60, "check_in_closes": 60}, true)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = adminRequest(router, "PUT", "/admin/flights/1/check-in", window, true)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"check_in_opens":44640`)
+
+	w = checkIn(router, 3)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "seat")
+	w = checkIn(router, 2)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"passenger_id":2`)
+
+	w = checkIn(router, 1)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var response struct {
+		BoardingPass models.BoardingPass `json:"boarding_pass"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	pass := response.BoardingPass
+	assert.Equal(t, 1, pass.Sequence)
+	assert.Equal(t, "20A", pass.Seat)
+	var flight models.Flight
+	db.First(&flight, 1)
+	assert.Equal(t, fmt.Sprintf("M1LOVELACE/ADA        E%-7sJFKLHRBA 0200 %03dY020A0001 100", booking.PNR, flight.DepartureTime.In(models.Airport{TimeZone: "America/New_York"}.Location()).YearDay()), pass.BCBP)
+
+	var coupon models.Coupon
+	db.First(&coupon, pass.CouponID)
+	assert.Equal(t, models.CouponCheckedIn, coupon.Status)
+
+	// Checking in again hands back the same pass
+	w = checkIn(router, 1)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), fmt.Sprintf(`"id":%d`, pass.ID))
+
+	req, _ := http.NewRequest("GET", "/bookings/1/boarding-passes", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), pass.BCBP)
+
+	// Nor can Ada change seats, so 20A stays hers; Mary, not checked in, can
+	spare := models.Seat{SeatMapID: 1, Row: 20, Column: "C", Class: "economy", Status: models.SeatAvailable}
+	db.Create(&spare)
+	move := func(passengerID uint) *httptest.ResponseRecorder {
+		assignment := gin.H{"passenger_id": passengerID, "segment_id": 1, "seat_id": spare.ID}
+		return adminRequest(router, "PUT", "/bookings/1/seats", gin.H{"assignments": []gin.H{assignment}}, false)
+	}
+	w = move(1)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "checked in")
+	assert.Equal(t, models.SeatSelected, seatStatus(db, 1))
+	assert.Equal(t, models.SeatAvailable, seatStatus(db, spare.ID))
+	w = move(3)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// A checked-in flight can no longer be exchanged
+	addExchangeFlight(db)
+	w = postExchange(setupBookingTestRouter(db), "/bookings/1/exchange/quote", 2)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+	// Check-in closes 45 minutes before departure
+	db.Model(&models.Flight{}).Where("id = ?", 1).Update("departure_time", time.Now().Add(30*time.Minute))
+	w = checkIn(router, 3)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "closed")
+}
+
+func TestBookingHandler_CheckInRequiresTicket(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupCheckInTestRouter(db)
+	createTestBooking(t, setupBookingTestRouter(db), nil)
+
+	w := checkIn(router, 1)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "ticketed")
+}
+
+func TestCreateWithSequenceRetries(t *testing.T) {
+	db := setupBookingTestDB()
+	pass := models.BoardingPass{BookingID: 1, PassengerID: 1, SegmentID: 1, FlightID: 1, CouponID: 1, Seat: "12A"}
+	raced := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return createWithSequence(tx, &pass, func(sequence int) (string, error) {
+			if !raced {
+				// Another passenger checks in with the same number first
+				raced = true
+				tx.Create(&models.BoardingPass{BookingID: 2, PassengerID: 2, SegmentID: 2, FlightID: 1, CouponID: 2, Sequence: sequence, Seat: "12B", BCBP: "M1"})
+			}
+			return fmt.Sprintf("M1 %03d", sequence), nil
+		})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, pass.Sequence)
+	assert.Equal(t, "M1 002", pass.BCBP)
+
+	var sequences []int
+	db.Model(&models.BoardingPass{}).Order("sequence").Pluck("sequence", &sequences)
+	assert.Equal(t, []int{1, 2}, sequences)
+}
+
+func TestBookingHandler_BoardingPassDocuments(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupCheckInTestRouter(db)
+	booking := createCheckInBooking(t, db)
+	db.Model(&models.Flight{}).Where("id = ?", 1).Update("departure_time", time.Now().Add(6*time.Hour))
+	w := checkIn(router, 1)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	req, _ := http.NewRequest("GET", "/bookings/1/boarding-passes/1/barcode.png?scale=2", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	img, err := png.Decode(w.Body)
+	if assert.NoError(t, err) {
+		// A 60-character pass fits a version 4 symbol: 33 modules and the quiet zone
+		assert.Equal(t, (33+8)*2, img.Bounds().Dx())
+	}
+
+	req, _ = http.NewRequest("GET", "/bookings/1/boarding-passes/1/wallet.json", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var wallet WalletPass
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &wallet))
+	assert.Equal(t, "pass.com.skyliner.boarding", wallet.PassTypeIdentifier)
+	assert.Equal(t, "TEAM123", wallet.TeamIdentifier)
+	assert.Equal(t, booking.PNR+"-1", wallet.SerialNumber)
+	assert.Equal(t, "PKTransitTypeAir", wallet.BoardingPass.TransitType)
+	if assert.Len(t, wallet.Barcodes, 1) {
+		assert.True(t, strings.HasPrefix(wallet.Barcodes[0].Message, "M1LOVELACE/ADA"))
+	}
+	assert.Equal(t, "JFK", wallet.BoardingPass.PrimaryFields[0].Value)
+	assert.Len(t, wallet.BoardingPass.BackFields[1].Value, 13)
+
+	for _, path := range []string{
+		"/bookings/1/boarding-passes/2/barcode.png",
+		"/bookings/2/boarding-passes/1/wallet.json",
+	} {
+		req, _ = http.NewRequest("GET", path, nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+}
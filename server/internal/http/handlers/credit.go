@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CreditHandler struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+func NewCreditHandler(db *gorm.DB, cfg *config.Config) *CreditHandler {
+	return &CreditHandler{db: db, cfg: cfg}
+}
+
+// VoucherRequest issues goodwill value to a user. Amount is in minor units of
+// Currency; ExpiresAt defaults to CREDIT_VALIDITY from now.
+type VoucherRequest struct {
+	Amount    int64      `json:"amount" binding:"required,gt=0"`
+	Currency  string     `json:"currency" binding:"required,len=3"`
+	Note      string     `json:"note"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// GetCredits lists the user's credits and vouchers with the balance they can
+// still spend in each currency.
+func (h *CreditHandler) GetCredits(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var credits []models.Credit
+	if err := h.db.Preload("Transactions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Where("user_id = ?", userID).Order("id").Find(&credits).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch credits"})
+		return
+	}
+
+	now := time.Now()
+	totals := map[string]int64{}
+	for _, credit := range credits {
+		if credit.Balance > 0 && !credit.Expired(now) {
+			totals[credit.Currency] += credit.Balance
+		}
+	}
+	balances := make([]money.Money, 0, len(totals))
+	for currency, amount := range totals {
+		balances = append(balances, money.New(amount, currency))
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].Currency < balances[j].Currency })
+
+	c.JSON(http.StatusOK, gin.H{"credits": credits, "balances": balances})
+}
+
+// IssueVoucher gives a user a goodwill voucher. Admins only.
+func (h *CreditHandler) IssueVoucher(c *gin.Context) {
+	if c.GetString("role") != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req VoucherRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	expiresAt := creditExpiry(h.cfg.CreditValidity, now)
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+		expiresAt = req.ExpiresAt
+	}
+
+	var user models.User
+	if err := h.db.First(&user, uint(userID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	issuedBy := c.GetUint("user_id")
+	voucher := models.Credit{
+		UserID:    user.ID,
+		Kind:      models.CreditVoucher,
+		Source:    "goodwill",
+		Amount:    req.Amount,
+		Currency:  strings.ToUpper(req.Currency),
+		ExpiresAt: expiresAt,
+		IssuedBy:  &issuedBy,
+		Note:      req.Note,
+	}
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		return issueCredit(tx, &voucher)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue voucher"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"voucher": voucher})
+}
+
+// creditExpiry is when a credit issued at now stops being spendable.
+func creditExpiry(validity time.Duration, now time.Time) *time.Time {
+	if validity <= 0 {
+		return nil
+	}
+	expiresAt := now.Add(validity)
+	return &expiresAt
+}
+
+// issueCredit records a new credit with its whole amount spendable.
+func issueCredit(tx *gorm.DB, credit *models.Credit) error {
+	credit.Balance = credit.Amount
+	if err := tx.Create(credit).Error; err != nil {
+		return err
+	}
+	return tx.Create(&models.CreditTransaction{
+		CreditID:  credit.ID,
+		BookingID: credit.BookingID,
+		Type:      models.CreditIssued,
+		Amount:    credit.Amount,
+	}).Error
+}
+
+// applyCredits spends the user's credits in due's currency on a booking, those
+// expiring soonest first, and returns how much was spent. Each balance is
+// taken with a conditional update so concurrent checkouts cannot overspend.
+func applyCredits(tx *gorm.DB, userID, bookingID uint, due money.Money, now time.Time) (money.Money, error) {
+	spent := money.Zero(due.Currency)
+	if due.Amount <= 0 {
+		return spent, nil
+	}
+
+	var credits []models.Credit
+	if err := tx.Where("user_id = ? AND currency = ? AND balance > 0 AND (expires_at IS NULL OR expires_at > ?)", userID, due.Currency, now).
+		Order("expires_at IS NULL, expires_at, id").Find(&credits).Error; err != nil {
+		return money.Money{}, err
+	}
+
+	for _, credit := range credits {
+		remaining := due.Amount - spent.Amount
+		if remaining <= 0 {
+			break
+		}
+		take := min(credit.Balance, remaining)
+		result := tx.Model(&models.Credit{}).
+			Where("id = ? AND balance >= ?", credit.ID, take).
+			Update("balance", gorm.Expr("balance - ?", take))
+		if result.Error != nil {
+			return money.Money{}, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue // spent elsewhere in the meantime
+		}
+		if err := tx.Create(&models.CreditTransaction{
+			CreditID:  credit.ID,
+			BookingID: &bookingID,
+			Type:      models.CreditRedeemed,
+			Amount:    -take,
+		}).Error; err != nil {
+			return money.Money{}, err
+		}
+		spent.Amount += take
+	}
+	return spent, nil
+}
+
+// creditsApplied is how much credit is currently spent on a booking.
+func creditsApplied(db *gorm.DB, bookingID uint, currency string) (money.Money, error) {
+	var net int64
+	if err := db.Model(&models.CreditTransaction{}).
+		Joins("JOIN credits ON credits.id = credit_transactions.credit_id").
+		Where("credit_transactions.booking_id = ? AND credit_transactions.type IN ? AND credits.currency = ?",
+			bookingID, []models.CreditTransactionType{models.CreditRedeemed, models.CreditReleased}, currency).
+		Select("COALESCE(SUM(credit_transactions.amount), 0)").Scan(&net).Error; err != nil {
+		return money.Money{}, err
+	}
+	return money.New(-net, currency), nil
+}
+
+// releaseCredits gives back the credit spent on a booking that was never
+// paid for, to the credits it came from.
+func releaseCredits(tx *gorm.DB, bookingID uint) error {
+	var spent []struct {
+		CreditID uint
+		Amount   int64
+	}
+	if err := tx.Model(&models.CreditTransaction{}).
+		Where("booking_id = ? AND type IN ?", bookingID, []models.CreditTransactionType{models.CreditRedeemed, models.CreditReleased}).
+		Group("credit_id").Select("credit_id, -SUM(amount) AS amount").Scan(&spent).Error; err != nil {
+		return err
+	}
+
+	for _, s := range spent {
+		if s.Amount <= 0 {
+			continue
+		}
+		if err := tx.Model(&models.Credit{}).Where("id = ?", s.CreditID).
+			Update("balance", gorm.Expr("balance + ?", s.Amount)).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.CreditTransaction{
+			CreditID:  s.CreditID,
+			BookingID: &bookingID,
+			Type:      models.CreditReleased,
+			Amount:    s.Amount,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupCreditTestRouter(db *gorm.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{CreditValidity: 365 * 24 * time.Hour}
+	creditHandler := NewCreditHandler(db, cfg)
+	paymentHandler := NewPaymentHandler(db, cfg)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", uint(1))
+		c.Next()
+	})
+	router.GET("/credits", creditHandler.GetCredits)
+	router.POST("/payments/checkout-session", paymentHandler.CreateCheckoutSession)
+	return router
+}
+
+func giveCredit(t *testing.T, db *gorm.DB, amount int64, expiresAt *time.Time) models.Credit {
+	t.Helper()
+	credit := models.Credit{UserID: 1, Kind: models.CreditTravel, Source: "cancellation", Amount: amount, Currency: "USD", ExpiresAt: expiresAt}
+	assert.NoError(t, issueCredit(db, &credit))
+	return credit
+}
+
+func creditBalance(db *gorm.DB, id uint) int64 {
+	var credit models.Credit
+	db.First(&credit, id)
+	return credit.Balance
+}
+
+func TestBookingHandler_CancelNonRefundableIssuesCredit(t *testing.T) {
+	db := setupBookingTestDB()
+	db.Create(&models.Fare{FlightID: 1, Class: "economy", FareType: "basic", BasePrice: 19999, Currency: "USD", Available: 10})
+	pay := payments.NewFakeClient()
+	paidBooking(t, db, 2)
+
+	w := cancelBooking(setupRefundTestRouter(db, pay), "1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"credit":{"amount":19999,"currency":"USD"}`)
+	assert.Empty(t, pay.Refunds)
+
+	req, _ := http.NewRequest("GET", "/credits", nil)
+	w = httptest.NewRecorder()
+	setupCreditTestRouter(db).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Credits  []models.Credit `json:"credits"`
+		Balances []money.Money   `json:"balances"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	if assert.Len(t, response.Credits, 1) {
+		credit := response.Credits[0]
+		assert.Equal(t, models.CreditTravel, credit.Kind)
+		assert.Equal(t, int64(19999), credit.Balance)
+		assert.Equal(t, uint(1), *credit.BookingID)
+		assert.Len(t, credit.Transactions, 1)
+	}
+	assert.Equal(t, []money.Money{money.New(19999, "USD")}, response.Balances)
+}
+
+func TestBookingHandler_CancelAfterTravel(t *testing.T) {
+	db := setupBookingTestDB()
+	db.Create(&models.Fare{FlightID: 1, Class: "economy", FareType: "basic", BasePrice: 19999, Currency: "USD", Available: 10})
+	pay := payments.NewFakeClient()
+	router := setupRefundTestRouter(db, pay)
+	paidBooking(t, db, 2)
+	checkedIn := paidBooking(t, db, 1)
+	db.Model(&models.Flight{}).Where("id = ?", 1).Update("departure_time", time.Now().Add(-48*time.Hour))
+
+	// A non-refundable fare is worth nothing once the flight has left
+	w := cancelBooking(router, "1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"credit":null`)
+	assert.Empty(t, pay.Refunds)
+	var credits int64
+	db.Model(&models.Credit{}).Count(&credits)
+	assert.Zero(t, credits)
+
+	// And a booking someone has checked in on cannot be cancelled at all
+	db.Create(&models.BoardingPass{BookingID: checkedIn.ID, PassengerID: 2, SegmentID: 2, FlightID: 1, CouponID: 1, Sequence: 1, Seat: "12A", BCBP: "M1"})
+	w = cancelBooking(router, "2")
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	db.First(&checkedIn, checkedIn.ID)
+	assert.Equal(t, models.StatusPaid, checkedIn.Status)
+}
+
+func TestPaymentHandler_CheckoutPaidWithCredits(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupCreditTestRouter(db)
+	credit := giveCredit(t, db, 50000, nil)
+	createTestBooking(t, setupBookingTestRouter(db), nil)
+
+	jsonData, _ := json.Marshal(gin.H{"booking_id": 1, "apply_credits": true})
+	req, _ := http.NewRequest("POST", "/payments/checkout-session", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response CheckoutSessionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.True(t, response.Paid)
+	assert.Empty(t, response.SessionID)
+	assert.Equal(t, money.New(39999, "USD"), *response.CreditsApplied)
+	assert.Equal(t, int64(50000-39999), creditBalance(db, credit.ID))
+
+	var booking models.Booking
+	db.First(&booking, 1)
+	assert.Equal(t, models.StatusPaid, booking.Status)
+	var payment models.Payment
+	db.First(&payment)
+	assert.Equal(t, "credit", payment.Method)
+	assert.Equal(t, int64(39999), payment.Amount)
+
+	// Nothing was charged to a card, so the refundable part comes back as credit
+	pay := payments.NewFakeClient()
+	w = cancelBooking(setupRefundTestRouter(db, pay), "1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(t, pay.Refunds)
+
+	var refundCredit models.Credit
+	db.Last(&refundCredit)
+	assert.NotEqual(t, credit.ID, refundCredit.ID)
+	assert.Equal(t, int64(29999), refundCredit.Amount)
+}
+
+func TestApplyCreditsAndRelease(t *testing.T) {
+	db := setupBookingTestDB()
+	now := time.Now()
+	later, sooner, past := now.Add(48*time.Hour), now.Add(24*time.Hour), now.Add(-time.Hour)
+	first := giveCredit(t, db, 10000, &later)
+	second := giveCredit(t, db, 5000, &sooner)
+	expired := giveCredit(t, db, 99999, &past)
+	booking := createTestBooking(t, setupBookingTestRouter(db), nil)
+
+	// The credit expiring soonest is spent first; expired credit is not spent
+	spent, err := applyCredits(db, 1, booking.ID, money.New(12000, "USD"), now)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(12000, "USD"), spent)
+	assert.Equal(t, int64(3000), creditBalance(db, first.ID))
+	assert.Equal(t, int64(0), creditBalance(db, second.ID))
+	assert.Equal(t, int64(99999), creditBalance(db, expired.ID))
+
+	spent, err = applyCredits(db, 1, booking.ID, money.New(12000, "EUR"), now)
+	assert.NoError(t, err)
+	assert.True(t, spent.IsZero())
+
+	applied, err := creditsApplied(db, booking.ID, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(12000, "USD"), applied)
+
+	// Cancelling the unpaid booking gives the credit back
+	w := cancelBooking(setupRefundTestRouter(db, payments.NewFakeClient()), "1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, int64(10000), creditBalance(db, first.ID))
+	assert.Equal(t, int64(5000), creditBalance(db, second.ID))
+
+	applied, err = creditsApplied(db, booking.ID, "USD")
+	assert.NoError(t, err)
+	assert.True(t, applied.IsZero())
+}
+
+func TestCreditHandler_IssueVoucher(t *testing.T) {
+	db := setupBookingTestDB()
+	setupWaiverTestRouter(db, payments.NewFakeClient()) // adds the agent and admin users
+	creditHandler := NewCreditHandler(db, &config.Config{CreditValidity: 24 * time.Hour})
+	admin := gin.New()
+	admin.POST("/admin/users/:id/vouchers", testStaffRole, creditHandler.IssueVoucher)
+
+	body := gin.H{"amount": 2500, "currency": "usd", "note": "Delayed baggage"}
+	w := adminRequest(admin, "POST", "/admin/users/1/vouchers", body, false)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = adminRequest(admin, "POST", "/admin/users/99/vouchers", body, true)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = adminRequest(admin, "POST", "/admin/users/1/vouchers", gin.H{"amount": -5, "currency": "USD"}, true)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = adminRequest(admin, "POST", "/admin/users/1/vouchers", body, true)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var voucher models.Credit
+	db.First(&voucher)
+	assert.Equal(t, models.CreditVoucher, voucher.Kind)
+	assert.Equal(t, "USD", voucher.Currency)
+	assert.Equal(t, int64(2500), voucher.Balance)
+	assert.Equal(t, adminID, *voucher.IssuedBy)
+	if assert.NotNil(t, voucher.ExpiresAt) {
+		assert.WithinDuration(t, time.Now().Add(24*time.Hour), *voucher.ExpiresAt, time.Minute)
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/documents"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetItineraryDocument downloads the itinerary and receipt for a paid booking
+// as a PDF.
+func (h *BookingHandler) GetItineraryDocument(c *gin.Context) {
+	bookingID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID"})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+
+	var booking models.Booking
+	if err := withBookingDetails(h.db).Where("id = ? AND user_id = ?", uint(bookingID), userID).First(&booking).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return
+	}
+
+	if booking.Status == models.StatusHold {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Booking must be paid before documents are available"})
+		return
+	}
+
+	var pdf bytes.Buffer
+	if err := documents.Itinerary(&pdf, &booking); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate itinerary"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="itinerary-%s.pdf"`, booking.PNR))
+	c.Data(http.StatusOK, "application/pdf", pdf.Bytes())
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBookingHandler_GetItineraryDocument(t *testing.T) {
+	db := setupBookingTestDB()
+	createTicketableBooking(t, db)
+	db.Model(&models.Booking{}).Where("id = ?", 1).Update("status", models.StatusHold)
+
+	bookingHandler := NewBookingHandler(db, &config.Config{}, fx.Default(), payments.NewFakeClient(), nil)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if c.GetHeader("X-Test-User") == "2" {
+			c.Set("user_id", uint(2))
+		} else {
+			c.Set("user_id", uint(1))
+		}
+		c.Next()
+	})
+	router.GET("/bookings/:id/documents/itinerary.pdf", bookingHandler.GetItineraryDocument)
+	download := func(user string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/bookings/1/documents/itinerary.pdf", nil)
+		req.Header.Set("X-Test-User", user)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := download("1")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "unpaid bookings have no receipt")
+
+	db.Model(&models.Booking{}).Where("id = ?", 1).Update("status", models.StatusPaid)
+	tickets, err := ticketBooking(db, 1)
+	assert.NoError(t, err)
+
+	w = download("1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	var booking models.Booking
+	db.First(&booking, 1)
+	assert.Equal(t, `attachment; filename="itinerary-`+booking.PNR+`.pdf"`, w.Header().Get("Content-Disposition"))
+	assert.Contains(t, w.Body.String(), "(E-ticket "+tickets[0].Number+")")
+	assert.Contains(t, w.Body.String(), "(BABBAGE/CHARLES)")
+	assert.Contains(t, w.Body.String(), "(British Airways)")
+
+	w = download("2")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
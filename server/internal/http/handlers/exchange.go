@@ -0,0 +1,559 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/farerules"
+	"skyliner/internal/money"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errExchangeInvalid    = errors.New("invalid exchange")
+	errExchangeNotAllowed = errors.New("exchange not allowed")
+	errExchangePending    = errors.New("booking already has an exchange awaiting payment")
+)
+
+type ExchangeRequest struct {
+	Segments []ExchangeSegmentRequest `json:"segments" binding:"required,min=1,dive"`
+}
+
+type ExchangeSegmentRequest struct {
+	SegmentID uint `json:"segment_id" binding:"required"` // booked segment to replace
+	FlightID  uint `json:"flight_id" binding:"required"`
+	FareID    uint `json:"fare_id" binding:"required"`
+}
+
+// ExchangeQuote prices a change against the original itinerary. All amounts
+// are in the booking's settlement currency and cover the whole party.
+type ExchangeQuote struct {
+	Segments       []ExchangeQuoteSegment `json:"segments"`
+	FareDifference money.Money            `json:"fare_difference"`
+	ChangeFee      money.Money            `json:"change_fee"` // after waivers
+	FeeWaived      money.Money            `json:"fee_waived"`
+	AmountDue      money.Money            `json:"amount_due"`
+	Credit         money.Money            `json:"credit"`
+	WaiverIDs      []uint                 `json:"waiver_ids,omitempty"`
+}
+
+type ExchangeQuoteSegment struct {
+	SegmentID   uint        `json:"segment_id"`
+	NewFlightID uint        `json:"new_flight_id"`
+	NewFareID   uint        `json:"new_fare_id"`
+	OldFare     money.Money `json:"old_fare"`
+	NewFare     money.Money `json:"new_fare"`
+	ChangeFee   money.Money `json:"change_fee"`
+}
+
+type ExchangeResponse struct {
+	Exchange models.Exchange `json:"exchange"`
+	Quote    ExchangeQuote   `json:"quote"`
+}
+
+// QuoteExchange prices a change without holding anything.
+func (h *BookingHandler) QuoteExchange(c *gin.Context) {
+	var req ExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	booking, ok := h.loadOwnedBooking(c, h.db)
+	if !ok {
+		return
+	}
+
+	quote, _, err := quoteExchange(h.db, &booking, req, time.Now())
+	if err != nil {
+		c.JSON(exchangeErrorStatus(err), gin.H{"error": exchangeErrorMessage(err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"quote": quote})
+}
+
+// CreateExchange commits to a change. Unpaid bookings and changes with nothing
+// to pay complete immediately; otherwise the new fares are held and the
+// exchange waits for the difference to be paid through checkout.
+func (h *BookingHandler) CreateExchange(c *gin.Context) {
+	var req ExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := h.db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	booking, ok := h.loadOwnedBooking(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	var pending int64
+	tx.Model(&models.Exchange{}).Where("booking_id = ? AND status = ?", booking.ID, models.ExchangePendingPayment).Count(&pending)
+	if pending > 0 {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": errExchangePending.Error()})
+		return
+	}
+
+	quote, changes, err := quoteExchange(tx, &booking, req, time.Now())
+	if err != nil {
+		tx.Rollback()
+		c.JSON(exchangeErrorStatus(err), gin.H{"error": exchangeErrorMessage(err)})
+		return
+	}
+
+	exchange := models.Exchange{
+		BookingID:      booking.ID,
+		Status:         models.ExchangePendingPayment,
+		FareDifference: quote.FareDifference.Amount,
+		ChangeFee:      quote.ChangeFee.Amount,
+		AmountDue:      quote.AmountDue.Amount,
+		CreditAmount:   quote.Credit.Amount,
+		Currency:       booking.Currency,
+		Segments:       changes,
+	}
+	if err := tx.Create(&exchange).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create exchange"})
+		return
+	}
+
+	if err := markWaiversApplied(tx, quote.WaiverIDs); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create exchange"})
+		return
+	}
+
+	// Hold the new fares now so they cannot sell out while payment is pending
+	partySize, err := countPassengers(tx, booking.ID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create exchange"})
+		return
+	}
+	for _, change := range changes {
+		if err := holdFare(tx, change.NewFareID, partySize); err != nil {
+			tx.Rollback()
+			if errors.Is(err, errFareSoldOut) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Not enough seats left at the new fare"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hold fare inventory"})
+			return
+		}
+	}
+
+	status := http.StatusAccepted
+	if booking.Status == models.StatusHold || exchange.AmountDue == 0 {
+		if err := completeExchange(tx, &exchange, h.cfg.CreditValidity); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply exchange"})
+			return
+		}
+		status = http.StatusOK
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit exchange"})
+		return
+	}
+
+	c.JSON(status, ExchangeResponse{Exchange: exchange, Quote: quote})
+}
+
+// GetExchanges lists the changes made to a booking.
+func (h *BookingHandler) GetExchanges(c *gin.Context) {
+	booking, ok := h.loadOwnedBooking(c, h.db)
+	if !ok {
+		return
+	}
+
+	var exchanges []models.Exchange
+	if err := h.db.Preload("Segments").Where("booking_id = ?", booking.ID).Order("id").Find(&exchanges).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchanges"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"exchanges": exchanges})
+}
+
+// CancelExchange abandons an exchange that is still waiting for payment and
+// gives back the fares it was holding.
+func (h *BookingHandler) CancelExchange(c *gin.Context) {
+	exchangeID, err := strconv.ParseUint(c.Param("exchange_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid exchange ID"})
+		return
+	}
+
+	tx := h.db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	booking, ok := h.loadOwnedBooking(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	var exchange models.Exchange
+	if err := tx.Preload("Segments").Where("id = ? AND booking_id = ?", uint(exchangeID), booking.ID).First(&exchange).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Exchange not found"})
+		return
+	}
+	if exchange.Status != models.ExchangePendingPayment {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only exchanges awaiting payment can be cancelled"})
+		return
+	}
+
+	if err := cancelExchange(tx, &exchange); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel exchange"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel exchange"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"exchange": exchange})
+}
+
+// quoteExchange validates a change against the fare rules of the segments
+// being replaced and prices it. Unpaid bookings are repriced without a fee.
+func quoteExchange(db *gorm.DB, booking *models.Booking, req ExchangeRequest, now time.Time) (ExchangeQuote, []models.ExchangeSegment, error) {
+	if booking.Status == models.StatusCancelled {
+		return ExchangeQuote{}, nil, fmt.Errorf("%w: booking is cancelled", errExchangeNotAllowed)
+	}
+	// Negotiated group fares can only be rebooked by the group desk
+	var groups int64
+	if err := db.Model(&models.GroupTerms{}).Where("booking_id = ?", booking.ID).Count(&groups).Error; err != nil {
+		return ExchangeQuote{}, nil, err
+	}
+	if groups > 0 {
+		return ExchangeQuote{}, nil, fmt.Errorf("%w: group bookings are changed by the group desk", errExchangeNotAllowed)
+	}
+
+	partySize, err := countPassengers(db, booking.ID)
+	if err != nil {
+		return ExchangeQuote{}, nil, err
+	}
+
+	var segments []models.Segment
+	if err := db.Preload("Flight").Preload("Fare").
+		Joins("JOIN itineraries ON itineraries.id = segments.itinerary_id").
+		Where("itineraries.booking_id = ?", booking.ID).
+		Find(&segments).Error; err != nil {
+		return ExchangeQuote{}, nil, err
+	}
+	booked := make(map[uint]models.Segment, len(segments))
+	for _, segment := range segments {
+		booked[segment.ID] = segment
+	}
+
+	currency := booking.Base().Currency
+	diff := money.Zero(currency)
+	fee := money.Zero(currency)
+	quote := ExchangeQuote{}
+	var changes []models.ExchangeSegment
+	seen := map[uint]bool{}
+
+	for _, r := range req.Segments {
+		old, ok := booked[r.SegmentID]
+		if !ok || seen[r.SegmentID] {
+			return ExchangeQuote{}, nil, fmt.Errorf("%w: segment %d is not on this booking or listed twice", errExchangeInvalid, r.SegmentID)
+		}
+		seen[r.SegmentID] = true
+
+		rules := farerules.For(old.Fare.FareType)
+		if !rules.CanChange(old.Flight.DepartureTime, now) {
+			return ExchangeQuote{}, nil, fmt.Errorf("%w: %s fare on flight %s cannot be changed", errExchangeNotAllowed, old.Fare.FareType, old.Flight.Number)
+		}
+
+		var checkedIn int64
+		if err := db.Model(&models.Coupon{}).Where("segment_id = ? AND status = ?", old.ID, models.CouponCheckedIn).Count(&checkedIn).Error; err != nil {
+			return ExchangeQuote{}, nil, err
+		}
+		if checkedIn > 0 {
+			return ExchangeQuote{}, nil, fmt.Errorf("%w: passengers are checked in on flight %s", errExchangeNotAllowed, old.Flight.Number)
+		}
+
+		var fare models.Fare
+		if err := db.Preload("Flight").Where("id = ? AND flight_id = ?", r.FareID, r.FlightID).First(&fare).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ExchangeQuote{}, nil, fmt.Errorf("%w: fare %d is not sold on flight %d", errExchangeInvalid, r.FareID, r.FlightID)
+			}
+			return ExchangeQuote{}, nil, err
+		}
+		if !fare.Flight.DepartureTime.After(now) {
+			return ExchangeQuote{}, nil, fmt.Errorf("%w: flight %s has already departed", errExchangeInvalid, fare.Flight.Number)
+		}
+		if fare.Available < partySize {
+			return ExchangeQuote{}, nil, fmt.Errorf("%w: not enough seats left at the new fare", errExchangeNotAllowed)
+		}
+
+		oldFare := old.FarePrice().Mul(int64(partySize))
+		newFare := fare.Price().Mul(int64(partySize))
+		segmentFee := money.Zero(currency)
+		if booking.Status != models.StatusHold {
+			segmentFee = rules.ChangeFee(old.FarePrice()).Mul(int64(partySize))
+		}
+
+		segmentDiff, err := newFare.Sub(oldFare)
+		if err == nil {
+			diff, err = diff.Add(segmentDiff)
+		}
+		if err == nil {
+			fee, err = fee.Add(segmentFee)
+		}
+		if err != nil {
+			return ExchangeQuote{}, nil, fmt.Errorf("%w: new fare must be priced in %s", errExchangeInvalid, currency)
+		}
+
+		quoted := ExchangeQuoteSegment{SegmentID: old.ID, NewFlightID: fare.FlightID, NewFareID: fare.ID}
+		if quoted.OldFare, err = settle(booking, oldFare); err != nil {
+			return ExchangeQuote{}, nil, err
+		}
+		if quoted.NewFare, err = settle(booking, newFare); err != nil {
+			return ExchangeQuote{}, nil, err
+		}
+		if quoted.ChangeFee, err = settle(booking, segmentFee); err != nil {
+			return ExchangeQuote{}, nil, err
+		}
+		quote.Segments = append(quote.Segments, quoted)
+
+		changes = append(changes, models.ExchangeSegment{
+			OldSegmentID: old.ID,
+			OldFlightID:  old.FlightID,
+			OldFareID:    old.FareID,
+			NewFlightID:  fare.FlightID,
+			NewFareID:    fare.ID,
+		})
+	}
+
+	if quote.FareDifference, err = settle(booking, diff); err != nil {
+		return ExchangeQuote{}, nil, err
+	}
+	// Approved change fee waivers come off the fee for the whole party
+	waived, waiverIDs, err := claimWaivers(db, booking.ID, models.WaiverChangeFee, fee)
+	if err != nil {
+		return ExchangeQuote{}, nil, err
+	}
+	if fee, err = fee.Sub(waived); err != nil {
+		return ExchangeQuote{}, nil, err
+	}
+	if quote.FeeWaived, err = settle(booking, waived); err != nil {
+		return ExchangeQuote{}, nil, err
+	}
+	quote.WaiverIDs = waiverIDs
+	if quote.ChangeFee, err = settle(booking, fee); err != nil {
+		return ExchangeQuote{}, nil, err
+	}
+
+	net, err := quote.FareDifference.Add(quote.ChangeFee)
+	if err != nil {
+		return ExchangeQuote{}, nil, err
+	}
+	quote.AmountDue = money.Zero(net.Currency)
+	quote.Credit = money.Zero(net.Currency)
+	if net.IsNegative() {
+		quote.Credit = net.Neg()
+	} else {
+		quote.AmountDue = net
+	}
+	// Nothing has been paid on a held booking, so there is nothing to credit
+	if booking.Status == models.StatusHold {
+		quote.AmountDue = money.Zero(net.Currency)
+		quote.Credit = money.Zero(net.Currency)
+	}
+
+	return quote, changes, nil
+}
+
+// cancelExchange gives back the fares held by a pending exchange.
+func cancelExchange(tx *gorm.DB, exchange *models.Exchange) error {
+	partySize, err := countPassengers(tx, exchange.BookingID)
+	if err != nil {
+		return err
+	}
+	for _, change := range exchange.Segments {
+		if err := releaseFare(tx, change.NewFareID, partySize); err != nil {
+			return err
+		}
+	}
+	exchange.Status = models.ExchangeCancelled
+	return tx.Model(exchange).Update("status", exchange.Status).Error
+}
+
+// cancelPendingExchanges abandons any exchange still awaiting payment, e.g.
+// when the booking itself is cancelled.
+func cancelPendingExchanges(tx *gorm.DB, bookingID uint) error {
+	var exchanges []models.Exchange
+	if err := tx.Preload("Segments").Where("booking_id = ? AND status = ?", bookingID, models.ExchangePendingPayment).Find(&exchanges).Error; err != nil {
+		return err
+	}
+	for i := range exchanges {
+		if err := cancelExchange(tx, &exchanges[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// completeExchange swaps the booked segments for the new ones, releasing the
+// old seats and fare inventory, and reprices the booking. The new fares must
+// already be held.
+func completeExchange(tx *gorm.DB, exchange *models.Exchange, creditValidity time.Duration) error {
+	var booking models.Booking
+	if err := tx.First(&booking, exchange.BookingID).Error; err != nil {
+		return err
+	}
+	partySize, err := countPassengers(tx, booking.ID)
+	if err != nil {
+		return err
+	}
+
+	if len(exchange.Segments) == 0 {
+		if err := tx.Where("exchange_id = ?", exchange.ID).Find(&exchange.Segments).Error; err != nil {
+			return err
+		}
+	}
+
+	diff := money.Zero(booking.Base().Currency)
+	for i := range exchange.Segments {
+		change := &exchange.Segments[i]
+
+		var old models.Segment
+		if err := tx.Preload("Fare").First(&old, change.OldSegmentID).Error; err != nil {
+			return err
+		}
+		var fare models.Fare
+		if err := tx.First(&fare, change.NewFareID).Error; err != nil {
+			return err
+		}
+
+		var assignments []models.SeatAssignment
+		if err := tx.Where("segment_id = ?", old.ID).Find(&assignments).Error; err != nil {
+			return err
+		}
+		for j := range assignments {
+			if err := releaseSeat(tx, &assignments[j]); err != nil {
+				return err
+			}
+		}
+		// Services and add-ons were arranged with the old flight's airline
+		if err := cancelSSRs(tx, booking.ID, old.ID); err != nil {
+			return err
+		}
+		if err := cancelAncillaries(tx, booking.ID, old.ID); err != nil {
+			return err
+		}
+		if err := releaseFare(tx, old.FareID, partySize); err != nil {
+			return err
+		}
+
+		replacement := models.Segment{
+			ItineraryID: old.ItineraryID,
+			FlightID:    change.NewFlightID,
+			FareID:      change.NewFareID,
+		}
+		if err := tx.Delete(&old).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&replacement).Error; err != nil {
+			return err
+		}
+		if err := exchangeCoupons(tx, booking.ID, old.ID, replacement); err != nil {
+			return err
+		}
+		change.NewSegmentID = &replacement.ID
+		if err := tx.Model(change).Update("new_segment_id", replacement.ID).Error; err != nil {
+			return err
+		}
+
+		segmentDiff, err := fare.Price().Sub(old.FarePrice())
+		if err != nil {
+			return err
+		}
+		if diff, err = diff.Add(segmentDiff.Mul(int64(partySize))); err != nil {
+			return err
+		}
+	}
+
+	if err := adjustBase(&booking, diff); err != nil {
+		return err
+	}
+	updates := map[string]interface{}{
+		"base_amount":  booking.BaseAmount,
+		"total_amount": booking.TotalAmount,
+	}
+	// A checkout session created before the change would charge the old total
+	if booking.Status == models.StatusHold {
+		updates["stripe_session_id"] = nil
+	}
+	if err := tx.Model(&booking).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	// A cheaper itinerary on a paid booking leaves the difference as credit
+	if exchange.CreditAmount > 0 {
+		if err := issueCredit(tx, &models.Credit{
+			UserID:    booking.UserID,
+			Kind:      models.CreditTravel,
+			Source:    "exchange",
+			BookingID: &booking.ID,
+			Amount:    exchange.CreditAmount,
+			Currency:  exchange.Currency,
+			ExpiresAt: creditExpiry(creditValidity, now),
+		}); err != nil {
+			return err
+		}
+	}
+
+	exchange.Status = models.ExchangeCompleted
+	exchange.CompletedAt = &now
+	return tx.Model(exchange).Updates(map[string]interface{}{
+		"status":       exchange.Status,
+		"completed_at": exchange.CompletedAt,
+	}).Error
+}
+
+func exchangeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errExchangeInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, errExchangeNotAllowed):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func exchangeErrorMessage(err error) string {
+	if exchangeErrorStatus(err) == http.StatusInternalServerError {
+		return "Failed to price exchange"
+	}
+	return err.Error()
+}
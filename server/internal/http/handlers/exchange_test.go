@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"skyliner/internal/db/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// addExchangeFlight adds a later BA flight with standard fares at 499.99 (id 2)
+// and 299.99 (id 3), plus a basic fare on the original flight (id 4).
+func addExchangeFlight(db *gorm.DB) {
+	flight := models.Flight{
+		Number:        "BA202",
+		AirlineID:     1,
+		OriginID:      1,
+		DestinationID: 2,
+		DepartureTime: time.Now().Add(31 * 24 * time.Hour),
+		ArrivalTime:   time.Now().Add(31*24*time.Hour + 7*time.Hour),
+		Duration:      420,
+	}
+	db.Create(&flight)
+	db.Create(&models.Fare{FlightID: flight.ID, Class: "economy", FareType: "standard", BasePrice: 49999, Currency: "USD", Available: 10})
+	db.Create(&models.Fare{FlightID: flight.ID, Class: "economy", FareType: "standard", BasePrice: 29999, Currency: "USD", Available: 10})
+	db.Create(&models.Fare{FlightID: 1, Class: "economy", FareType: "basic", BasePrice: 19999, Currency: "USD", Available: 10})
+}
+
+func postExchange(router *gin.Engine, path string, fareID uint) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{
+		"segments": []map[string]interface{}{{"segment_id": 1, "flight_id": 2, "fare_id": fareID}},
+	})
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func fareAvailable(db *gorm.DB, fareID uint) int {
+	var fare models.Fare
+	db.First(&fare, fareID)
+	return fare.Available
+}
+
+func TestBookingHandler_ExchangeHoldBooking(t *testing.T) {
+	db := setupBookingTestDB()
+	addExchangeFlight(db)
+	router := setupBookingTestRouter(db)
+
+	createTestBooking(t, router, twoPassengerBooking([]map[string]interface{}{
+		{"passenger": 0, "segment": 0, "seat_id": 1},
+	}))
+	assert.Equal(t, 28, fareAvailable(db, 1))
+
+	w := postExchange(router, "/bookings/1/exchange/quote", 2)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var quoted struct {
+		Quote ExchangeQuote `json:"quote"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &quoted))
+	assert.Equal(t, int64(20000), quoted.Quote.FareDifference.Amount)
+	assert.Equal(t, int64(0), quoted.Quote.ChangeFee.Amount)
+	assert.Equal(t, int64(0), quoted.Quote.AmountDue.Amount)
+
+	w = postExchange(router, "/bookings/1/exchanges", 2)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response ExchangeResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.ExchangeCompleted, response.Exchange.Status)
+
+	// Old fare and seat are released, the new fare is held and priced in
+	var booking models.Booking
+	db.First(&booking, 1)
+	assert.Equal(t, int64(2*49999), booking.TotalAmount)
+	assert.Equal(t, 30, fareAvailable(db, 1))
+	assert.Equal(t, 8, fareAvailable(db, 2))
+	assert.Equal(t, models.SeatAvailable, seatStatus(db, 1))
+
+	var segments []models.Segment
+	db.Find(&segments)
+	if assert.Len(t, segments, 1) {
+		assert.Equal(t, uint(2), segments[0].FlightID)
+		assert.Equal(t, uint(2), segments[0].FareID)
+	}
+}
+
+func TestBookingHandler_ExchangePaidBooking(t *testing.T) {
+	db := setupBookingTestDB()
+	addExchangeFlight(db)
+	router := setupBookingTestRouter(db)
+
+	createTestBooking(t, router, nil)
+	db.Model(&models.Booking{}).Where("id = ?", 1).Update("status", models.StatusPaid)
+
+	// Upgrading collects the difference plus a 15% change fee on the old fare
+	w := postExchange(router, "/bookings/1/exchanges", 2)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var response ExchangeResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.ExchangePendingPayment, response.Exchange.Status)
+	assert.Equal(t, int64(6000), response.Quote.ChangeFee.Amount)
+	assert.Equal(t, int64(16000), response.Quote.AmountDue.Amount)
+	assert.Equal(t, 9, fareAvailable(db, 2))
+
+	w = postExchange(router, "/bookings/1/exchanges", 2)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	req, _ := http.NewRequest("POST", "/bookings/1/exchanges/1/cancel", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 10, fareAvailable(db, 2))
+
+	// Downgrading leaves a credit and completes straight away
+	w = postExchange(router, "/bookings/1/exchanges", 3)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.ExchangeCompleted, response.Exchange.Status)
+	assert.Equal(t, int64(4000), response.Exchange.CreditAmount)
+
+	var booking models.Booking
+	db.First(&booking, 1)
+	assert.Equal(t, int64(29999), booking.TotalAmount)
+	assert.Equal(t, models.StatusPaid, booking.Status)
+}
+
+func TestBookingHandler_ExchangeFareRules(t *testing.T) {
+	db := setupBookingTestDB()
+	addExchangeFlight(db)
+	router := setupBookingTestRouter(db)
+
+	createTestBooking(t, router, map[string]interface{}{
+		"segments":   []map[string]interface{}{{"flight_id": 1, "fare_id": 4}},
+		"passengers": []map[string]interface{}{{"first_name": "Ada", "last_name": "Lovelace"}},
+	})
+
+	w := postExchange(router, "/bookings/1/exchange/quote", 2)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// A fare from a different flight is rejected
+	createTestBooking(t, router, nil)
+	body, _ := json.Marshal(map[string]interface{}{
+		"segments": []map[string]interface{}{{"segment_id": 2, "flight_id": 1, "fare_id": 2}},
+	})
+	req, _ := http.NewRequest("POST", "/bookings/2/exchange/quote", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestBookingHandler_CreateBookingSoldOut(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupBookingTestRouter(db)
+	db.Model(&models.Fare{}).Where("id = ?", 1).Update("available", 1)
+
+	jsonData, _ := json.Marshal(twoPassengerBooking(nil))
+	req, _ := http.NewRequest("POST", "/bookings", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, 1, fareAvailable(db, 1))
+}
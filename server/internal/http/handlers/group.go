@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"skyliner/internal/apis"
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CreateGroupRequest books a block of seats for a group at negotiated fares.
+// Names are given later through NameGroupPassengers.
+type CreateGroupRequest struct {
+	UserID             uint                  `json:"user_id" binding:"required"` // account that manages the group
+	Name               string                `json:"name" binding:"required"`
+	Size               int                   `json:"size" binding:"required,min=10,max=50"`
+	Segments           []GroupSegmentRequest `json:"segments" binding:"required,min=1,dive"`
+	Installments       []InstallmentRequest  `json:"installments" binding:"required,min=1,dive"`
+	NamesDueAt         time.Time             `json:"names_due_at" binding:"required"`
+	NameChangeDeadline time.Time             `json:"name_change_deadline" binding:"required"`
+	NameChangeFee      string                `json:"name_change_fee"` // per name given or changed after names are due, e.g. "50.00"
+}
+
+type GroupSegmentRequest struct {
+	FlightID uint   `json:"flight_id" binding:"required"`
+	FareID   uint   `json:"fare_id" binding:"required"`
+	Price    string `json:"price" binding:"required"` // negotiated per-seat price in the fare's currency, e.g. "249.00"
+}
+
+// InstallmentRequest is one payment of the deposit schedule, as a share of
+// the total.
+type InstallmentRequest struct {
+	DueAt   time.Time `json:"due_at" binding:"required"`
+	Percent int       `json:"percent" binding:"required,min=1,max=100"`
+}
+
+// GroupNamesRequest names group seats. Entries with a passenger_id rename that
+// passenger; the others fill the next seats without a name.
+type GroupNamesRequest struct {
+	Passengers []GroupNameRequest `json:"passengers" binding:"required,min=1,dive"`
+}
+
+type GroupNameRequest struct {
+	PassengerID *uint `json:"passenger_id"`
+	PassengerRequest
+}
+
+var (
+	errGroupInvalid = errors.New("invalid group booking")
+	errNamesClosed  = errors.New("names closed")
+)
+
+// CreateGroup lets the group desk block seats for a group at negotiated
+// fares, with a deposit schedule and deadlines for the names.
+func (h *BookingHandler) CreateGroup(c *gin.Context) {
+	var req CreateGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var booking models.Booking
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		booking, err = createGroup(tx, req, c.GetUint("user_id"), time.Now())
+		return err
+	})
+	switch {
+	case errors.Is(err, errGroupInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": strings.TrimPrefix(err.Error(), errGroupInvalid.Error()+": ")})
+		return
+	case errors.Is(err, errFareSoldOut):
+		c.JSON(http.StatusConflict, gin.H{"error": "Not enough seats left at this fare"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group booking"})
+		return
+	}
+
+	if err := withBookingDetails(h.db).First(&booking, booking.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return
+	}
+	c.JSON(http.StatusCreated, BookingResponse{Booking: booking, TotalAmount: booking.Total()})
+}
+
+func createGroup(tx *gorm.DB, req CreateGroupRequest, agentID uint, now time.Time) (models.Booking, error) {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: "+format, append([]interface{}{errGroupInvalid}, args...)...)
+	}
+
+	var user models.User
+	if err := tx.First(&user, req.UserID).Error; err != nil {
+		return models.Booking{}, invalid("User not found")
+	}
+
+	// Negotiated prices replace the fares' own, which still set the cabin,
+	// rules and inventory
+	fares := make([]models.Fare, len(req.Segments))
+	prices := make([]money.Money, len(req.Segments))
+	var base money.Money
+	for i, segment := range req.Segments {
+		if err := tx.Where("flight_id = ?", segment.FlightID).First(&fares[i], segment.FareID).Error; err != nil {
+			return models.Booking{}, invalid("Invalid fare ID")
+		}
+		price, err := money.Parse(segment.Price, fares[i].Currency)
+		if err != nil || price.Amount <= 0 {
+			return models.Booking{}, invalid("Invalid price for segment %d", i+1)
+		}
+		prices[i] = price
+		if i == 0 {
+			base = money.Zero(price.Currency)
+		}
+		if base, err = base.Add(price.Mul(int64(req.Size))); err != nil {
+			return models.Booking{}, invalid("All fares must be priced in %s", base.Currency)
+		}
+	}
+
+	flightIDs := make([]uint, len(req.Segments))
+	for i, segment := range req.Segments {
+		flightIDs[i] = segment.FlightID
+	}
+	legs, err := flightLegs(tx, flightIDs)
+	if err != nil {
+		return models.Booking{}, err
+	}
+	departure := legs[0].Departure
+
+	fee := money.Zero(base.Currency)
+	if req.NameChangeFee != "" {
+		if fee, err = money.Parse(req.NameChangeFee, base.Currency); err != nil || fee.IsNegative() {
+			return models.Booking{}, invalid("Invalid name_change_fee")
+		}
+	}
+	if !req.NamesDueAt.After(now) || req.NameChangeDeadline.Before(req.NamesDueAt) || !req.NameChangeDeadline.Before(departure) {
+		return models.Booking{}, invalid("Names must be due before the name change deadline, which must be before departure")
+	}
+
+	schedule := append([]InstallmentRequest(nil), req.Installments...)
+	sort.SliceStable(schedule, func(i, j int) bool { return schedule[i].DueAt.Before(schedule[j].DueAt) })
+	percent := 0
+	for _, installment := range schedule {
+		percent += installment.Percent
+	}
+	if percent != 100 {
+		return models.Booking{}, invalid("Installments must add up to 100 percent")
+	}
+	if !schedule[0].DueAt.After(now) || !schedule[len(schedule)-1].DueAt.Before(departure) {
+		return models.Booking{}, invalid("Installments must fall due between now and departure")
+	}
+
+	for _, fare := range fares {
+		if err := holdFare(tx, fare.ID, req.Size); err != nil {
+			return models.Booking{}, err
+		}
+	}
+
+	// The booking stays on hold until the first installment is due and moves
+	// on with each payment
+	firstDue := schedule[0].DueAt
+	booking := models.Booking{
+		UserID:        req.UserID,
+		Status:        models.StatusHold,
+		TotalAmount:   base.Amount,
+		Currency:      base.Currency,
+		BaseAmount:    base.Amount,
+		BaseCurrency:  base.Currency,
+		HoldExpiresAt: &firstDue,
+	}
+	if err := createWithPNR(tx, &booking); err != nil {
+		return models.Booking{}, err
+	}
+
+	itinerary := models.Itinerary{BookingID: booking.ID}
+	if err := tx.Create(&itinerary).Error; err != nil {
+		return models.Booking{}, err
+	}
+	for i, segmentReq := range req.Segments {
+		price := prices[i].Amount
+		segment := models.Segment{
+			ItineraryID: itinerary.ID,
+			FlightID:    segmentReq.FlightID,
+			FareID:      segmentReq.FareID,
+			Price:       &price,
+		}
+		if err := tx.Create(&segment).Error; err != nil {
+			return models.Booking{}, err
+		}
+	}
+
+	seats := make([]models.Passenger, req.Size)
+	for i := range seats {
+		seats[i].BookingID = booking.ID
+	}
+	if err := tx.Create(&seats).Error; err != nil {
+		return models.Booking{}, err
+	}
+
+	terms := models.GroupTerms{
+		BookingID:          booking.ID,
+		Name:               req.Name,
+		Size:               req.Size,
+		NamesDueAt:         req.NamesDueAt,
+		NameChangeDeadline: req.NameChangeDeadline,
+		NameChangeFee:      fee.Amount,
+		Currency:           fee.Currency,
+		CreatedBy:          agentID,
+	}
+	if err := tx.Create(&terms).Error; err != nil {
+		return models.Booking{}, err
+	}
+
+	// The last installment takes whatever rounding leaves
+	var scheduled int64
+	for i, installment := range schedule {
+		amount := base.Amount - scheduled
+		if i < len(schedule)-1 {
+			amount = base.Amount * int64(installment.Percent) / 100
+		}
+		scheduled += amount
+		if err := tx.Create(&models.GroupInstallment{
+			BookingID: booking.ID,
+			Sequence:  i + 1,
+			DueAt:     installment.DueAt,
+			Amount:    amount,
+			Currency:  base.Currency,
+			Status:    models.InstallmentDue,
+		}).Error; err != nil {
+			return models.Booking{}, err
+		}
+	}
+
+	return booking, nil
+}
+
+// NameGroupPassengers names seats on a group booking, or corrects names, and
+// can be called as often as names come in. Names given or changed after they
+// are due cost the agreed fee each, and none can be changed after the name
+// change deadline or once a passenger is ticketed.
+func (h *BookingHandler) NameGroupPassengers(c *gin.Context) {
+	var req GroupNamesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	booking, ok := h.loadOwnedBooking(c, h.db)
+	if !ok {
+		return
+	}
+	if booking.Status == models.StatusCancelled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Booking is cancelled"})
+		return
+	}
+	var terms models.GroupTerms
+	if err := h.db.Where("booking_id = ?", booking.ID).First(&terms).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only group bookings can name passengers later"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group"})
+		return
+	}
+
+	var passengers []models.Passenger
+	var fees money.Money
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		passengers, fees, err = nameGroupPassengers(tx, &booking, terms, req.Passengers, time.Now())
+		return err
+	})
+	if err != nil {
+		var invalid apisError
+		switch {
+		case errors.As(err, &invalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		case errors.Is(err, errNamesClosed):
+			c.JSON(http.StatusConflict, gin.H{"error": strings.TrimPrefix(err.Error(), errNamesClosed.Error()+": ")})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Passenger not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to name passengers"})
+		}
+		return
+	}
+
+	unnamed := 0
+	for _, p := range passengers {
+		if !p.Named() {
+			unnamed++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"passengers":   passengers,
+		"unnamed":      unnamed,
+		"fees":         fees,
+		"total_amount": booking.Total(),
+	})
+}
+
+// apisError is a name or document detail that failed validation.
+type apisError struct{ error }
+
+func nameGroupPassengers(tx *gorm.DB, booking *models.Booking, terms models.GroupTerms, names []GroupNameRequest, now time.Time) ([]models.Passenger, money.Money, error) {
+	fees := money.Zero(terms.Currency)
+	if now.After(terms.NameChangeDeadline) {
+		return nil, fees, fmt.Errorf("%w: Names can no longer be given or changed", errNamesClosed)
+	}
+
+	var passengers []models.Passenger
+	if err := tx.Preload("APIS").Where("booking_id = ?", booking.ID).Order("id").Find(&passengers).Error; err != nil {
+		return nil, fees, err
+	}
+	var ticketIDs []uint
+	if err := tx.Model(&models.Ticket{}).Where("booking_id = ?", booking.ID).Pluck("passenger_id", &ticketIDs).Error; err != nil {
+		return nil, fees, err
+	}
+	ticketed := uniqueIDs(ticketIDs)
+	legs, err := itineraryLegs(tx, booking.ID)
+	if err != nil {
+		return nil, fees, err
+	}
+
+	index := make(map[uint]int, len(passengers))
+	for i, p := range passengers {
+		index[p.ID] = i
+	}
+	travelers := map[int]apis.Traveler{}
+	requests := map[int]GroupNameRequest{}
+	for n, name := range names {
+		i := -1
+		if name.PassengerID != nil {
+			found, ok := index[*name.PassengerID]
+			if !ok {
+				return nil, fees, gorm.ErrRecordNotFound
+			}
+			i = found
+		} else {
+			for j, p := range passengers {
+				if _, taken := travelers[j]; !taken && !p.Named() {
+					i = j
+					break
+				}
+			}
+			if i < 0 {
+				return nil, fees, fmt.Errorf("%w: Every seat already has a name", errNamesClosed)
+			}
+		}
+		if _, taken := travelers[i]; taken {
+			return nil, fees, apisError{fmt.Errorf("Passenger %d is named twice", passengers[i].ID)}
+		}
+		if ticketed[passengers[i].ID] {
+			return nil, fees, fmt.Errorf("%w: Ticketed passengers cannot be renamed", errNamesClosed)
+		}
+
+		traveler := requestTraveler(name.PassengerRequest)
+		if err := apis.Validate(traveler, legs); err != nil {
+			return nil, fees, apisError{fmt.Errorf("Passenger %d: %s", n+1, err)}
+		}
+		travelers[i] = traveler
+		requests[i] = name
+	}
+
+	dates := make([]*time.Time, len(passengers))
+	for i, p := range passengers {
+		dates[i] = p.DateOfBirth
+		if t, ok := travelers[i]; ok {
+			dates[i] = t.DateOfBirth
+		}
+	}
+	if len(legs) > 0 {
+		if err := apis.CheckParty(dates, legs[0].Departure); err != nil {
+			return nil, fees, apisError{err}
+		}
+	}
+
+	for i, traveler := range travelers {
+		passenger := &passengers[i]
+		name := requests[i]
+		passenger.FirstName = strings.TrimSpace(traveler.FirstName)
+		passenger.LastName = strings.TrimSpace(traveler.LastName)
+		passenger.Email = name.Email
+		passenger.Phone = name.Phone
+		passenger.DateOfBirth = traveler.DateOfBirth
+		passenger.Passport = traveler.Passport
+		if err := tx.Model(passenger).Updates(map[string]interface{}{
+			"first_name":    passenger.FirstName,
+			"last_name":     passenger.LastName,
+			"email":         passenger.Email,
+			"phone":         passenger.Phone,
+			"date_of_birth": passenger.DateOfBirth,
+			"passport":      passenger.Passport,
+		}).Error; err != nil {
+			return nil, fees, err
+		}
+
+		record := apisRecord(booking.ID, passenger.ID, traveler)
+		if passenger.APIS != nil {
+			record.ID = passenger.APIS.ID
+			record.CreatedAt = passenger.APIS.CreatedAt
+		}
+		if err := tx.Save(&record).Error; err != nil {
+			return nil, fees, err
+		}
+		passenger.APIS = &record
+	}
+
+	if now.After(terms.NamesDueAt) {
+		fees = terms.Fee().Mul(int64(len(travelers)))
+		if !fees.IsZero() {
+			// A paid group has no open payment for the fee to go into
+			if booking.Status != models.StatusHold {
+				return nil, fees, fmt.Errorf("%w: Names are past due; contact the group desk", errNamesClosed)
+			}
+			if err := chargeBooking(tx, booking, fees); err != nil {
+				return nil, fees, err
+			}
+		}
+	}
+	return passengers, fees, nil
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v76"
+	"gorm.io/gorm"
+)
+
+func setupGroupTestRouter(db *gorm.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	bookingHandler := NewBookingHandler(db, &config.Config{}, fx.Default(), payments.NewFakeClient(), nil)
+
+	router := gin.New()
+	admin := router.Group("/admin")
+	admin.Use(testStaffRole)
+	admin.POST("/groups", bookingHandler.CreateGroup)
+
+	protected := router.Group("")
+	protected.Use(func(c *gin.Context) {
+		c.Set("user_id", uint(1))
+		c.Next()
+	})
+	protected.POST("/bookings/:id/names", bookingHandler.NameGroupPassengers)
+	protected.POST("/bookings/:id/issue", bookingHandler.IssueBooking)
+	protected.POST("/bookings/:id/exchange/quote", bookingHandler.QuoteExchange)
+	return router
+}
+
+func groupRequest() map[string]interface{} {
+	return map[string]interface{}{
+		"user_id":  1,
+		"name":     "Chess Club",
+		"size":     10,
+		"segments": []map[string]interface{}{{"flight_id": 1, "fare_id": 1, "price": "249.00"}},
+		"installments": []map[string]interface{}{
+			{"due_at": inDays(20), "percent": 70},
+			{"due_at": inDays(2), "percent": 30},
+		},
+		"names_due_at":         inDays(10),
+		"name_change_deadline": inDays(25),
+		"name_change_fee":      "50.00",
+	}
+}
+
+func inDays(n int) time.Time {
+	return time.Now().Add(time.Duration(n) * 24 * time.Hour)
+}
+
+func createTestGroup(t *testing.T, router *gin.Engine) models.Booking {
+	t.Helper()
+	w := adminRequest(router, "POST", "/admin/groups", groupRequest(), false)
+	if !assert.Equal(t, http.StatusCreated, w.Code, w.Body.String()) {
+		t.FailNow()
+	}
+	var response BookingResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.Booking
+}
+
+type namesResponse struct {
+	Passengers []models.Passenger `json:"passengers"`
+	Unnamed    int                `json:"unnamed"`
+	Fees       struct {
+		Amount int64 `json:"amount"`
+	} `json:"fees"`
+}
+
+func nameGroup(router *gin.Engine, passengers ...map[string]interface{}) (*httptest.ResponseRecorder, namesResponse) {
+	w := postJSON(router, "/bookings/1/names", map[string]interface{}{"passengers": passengers})
+	var response namesResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w, response
+}
+
+func TestBookingHandler_CreateGroup(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupGroupTestRouter(db)
+
+	booking := createTestGroup(t, router)
+	assert.Equal(t, models.StatusHold, booking.Status)
+	assert.Equal(t, int64(249000), booking.TotalAmount)
+	assert.Len(t, booking.Passengers, 10)
+	assert.False(t, booking.Passengers[0].Named())
+	if assert.NotNil(t, booking.Group) {
+		assert.Equal(t, "Chess Club", booking.Group.Name)
+		assert.Equal(t, int64(5000), booking.Group.NameChangeFee)
+	}
+	if assert.Len(t, booking.Installments, 2) {
+		// Sorted by due date, with the hold running to the first
+		assert.Equal(t, int64(74700), booking.Installments[0].Amount)
+		assert.Equal(t, int64(174300), booking.Installments[1].Amount)
+		assert.WithinDuration(t, booking.Installments[0].DueAt, *booking.HoldExpiresAt, time.Second)
+	}
+	assert.Equal(t, 20, fareAvailable(db, 1))
+
+	var segment models.Segment
+	db.First(&segment)
+	assert.Equal(t, int64(24900), segment.FarePrice().Amount)
+
+	tests := []struct {
+		name   string
+		change func(map[string]interface{})
+		status int
+	}{
+		{"too small", func(r map[string]interface{}) { r["size"] = 9 }, http.StatusBadRequest},
+		{"unknown user", func(r map[string]interface{}) { r["user_id"] = 99 }, http.StatusBadRequest},
+		{"fare from another flight", func(r map[string]interface{}) {
+			r["segments"] = []map[string]interface{}{{"flight_id": 2, "fare_id": 1, "price": "249.00"}}
+		}, http.StatusBadRequest},
+		{"free seats", func(r map[string]interface{}) {
+			r["segments"] = []map[string]interface{}{{"flight_id": 1, "fare_id": 1, "price": "0"}}
+		}, http.StatusBadRequest},
+		{"schedule short of the total", func(r map[string]interface{}) {
+			r["installments"] = []map[string]interface{}{{"due_at": inDays(2), "percent": 90}}
+		}, http.StatusBadRequest},
+		{"installment after departure", func(r map[string]interface{}) {
+			r["installments"] = []map[string]interface{}{{"due_at": inDays(40), "percent": 100}}
+		}, http.StatusBadRequest},
+		{"names due after the deadline", func(r map[string]interface{}) { r["names_due_at"] = inDays(26) }, http.StatusBadRequest},
+		{"deadline after departure", func(r map[string]interface{}) { r["name_change_deadline"] = inDays(31) }, http.StatusBadRequest},
+		{"not enough seats", func(r map[string]interface{}) { r["size"] = 21 }, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := groupRequest()
+			tt.change(req)
+			w := adminRequest(router, "POST", "/admin/groups", req, false)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+		})
+	}
+	assert.Equal(t, 20, fareAvailable(db, 1))
+}
+
+func TestBookingHandler_NameGroupPassengers(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupGroupTestRouter(db)
+	createTestGroup(t, router)
+
+	w, response := nameGroup(router, documentedPassenger("Ada", "Lovelace"), documentedPassenger("Charles", "Babbage"))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 8, response.Unnamed)
+	assert.Equal(t, int64(0), response.Fees.Amount)
+	assert.Equal(t, "Ada", response.Passengers[0].FirstName)
+	assert.Equal(t, "Babbage", response.Passengers[1].LastName)
+
+	// A name can be corrected until it is due, free of charge
+	rename := documentedPassenger("Augusta Ada", "King")
+	rename["passenger_id"] = response.Passengers[0].ID
+	w, response = nameGroup(router, rename)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "King", response.Passengers[0].LastName)
+	assert.Equal(t, 8, response.Unnamed)
+
+	missing := documentedPassenger("Alan", "Turing")
+	missing["passenger_id"] = 99
+	w, _ = nameGroup(router, missing)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = nameGroup(router, rename, rename)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = nameGroup(router, map[string]interface{}{"first_name": "Alan", "last_name": "Turing", "passport": "bad"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Tickets wait for every name
+	db.Model(&models.Booking{}).Where("id = ?", 1).Update("status", models.StatusPaid)
+	w = postJSON(router, "/bookings/1/issue", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// Names given after they are due cost the fee, and a paid group has no
+	// payment left to put it on
+	db.Model(&models.GroupTerms{}).Where("booking_id = ?", 1).Update("names_due_at", time.Now().Add(-time.Hour))
+	w, _ = nameGroup(router, documentedPassenger("Alan", "Turing"))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	db.Model(&models.Booking{}).Where("id = ?", 1).Update("status", models.StatusHold)
+
+	rest := make([]map[string]interface{}, 8)
+	for i := range rest {
+		rest[i] = documentedPassenger("Grace", "Hopper")
+	}
+	w, response = nameGroup(router, rest...)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 0, response.Unnamed)
+	assert.Equal(t, int64(40000), response.Fees.Amount)
+	var booking models.Booking
+	db.First(&booking, 1)
+	assert.Equal(t, int64(249000+40000), booking.TotalAmount)
+
+	w, _ = nameGroup(router, documentedPassenger("Alan", "Turing"))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	db.Model(&models.Booking{}).Where("id = ?", 1).Update("status", models.StatusPaid)
+	w = postJSON(router, "/bookings/1/issue", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Ticketed passengers keep their names, and after the deadline nobody
+	// can be renamed
+	w, _ = nameGroup(router, rename)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	db.Model(&models.GroupTerms{}).Where("booking_id = ?", 1).Update("name_change_deadline", time.Now().Add(-time.Minute))
+	w, _ = nameGroup(router, rename)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Group itineraries are changed by the group desk
+	w = postJSON(router, "/bookings/1/exchange/quote", map[string]interface{}{
+		"segments": []map[string]interface{}{{"segment_id": 1, "flight_id": 1, "fare_id": 1}},
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestBookingHandler_NameGroupPassengersNotGroup(t *testing.T) {
+	db := setupBookingTestDB()
+	createTestBooking(t, setupBookingTestRouter(db), nil)
+	router := setupGroupTestRouter(db)
+
+	w, _ := nameGroup(router, documentedPassenger("Ada", "Lovelace"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPaymentHandler_GroupInstallments(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupGroupTestRouter(db)
+	booking := createTestGroup(t, router)
+
+	names := make([]map[string]interface{}, 10)
+	for i := range names {
+		names[i] = documentedPassenger("Grace", "Hopper")
+	}
+	w, _ := nameGroup(router, names...)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	installment, amount, err := nextInstallment(db, &booking, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, installment.Sequence)
+	assert.Equal(t, int64(74700), amount.Amount)
+	unknown := uint(99)
+	_, _, err = nextInstallment(db, &booking, &unknown)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	h := NewPaymentHandler(db, &config.Config{AutoTicket: true})
+	pay := func(id string, amount int64) {
+		session := &stripe.CheckoutSession{
+			Metadata:      map[string]string{"booking_id": "1", "installment_id": id},
+			PaymentIntent: &stripe.PaymentIntent{ID: "pi_" + id},
+			AmountTotal:   amount,
+			Currency:      "usd",
+		}
+		h.handleCheckoutSessionCompleted(session)
+		h.handleCheckoutSessionCompleted(session) // Stripe retries
+	}
+
+	pay("1", 74700)
+	db.First(&booking, 1)
+	assert.Equal(t, models.StatusHold, booking.Status)
+	var second models.GroupInstallment
+	db.Where("booking_id = ? AND sequence = ?", 1, 2).First(&second)
+	assert.WithinDuration(t, second.DueAt, *booking.HoldExpiresAt, time.Second)
+
+	// The last installment settles charges added since booking
+	db.Model(&booking).Update("total_amount", booking.TotalAmount+5000)
+	installment, amount, err = nextInstallment(db, &booking, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, second.ID, installment.ID)
+	assert.Equal(t, int64(174300+5000), amount.Amount)
+
+	pay("2", 179300)
+	db.First(&booking, 1)
+	assert.Equal(t, models.StatusTicketed, booking.Status)
+	var payments []models.Payment
+	db.Where("booking_id = ?", 1).Order("id").Find(&payments)
+	if assert.Len(t, payments, 2) {
+		assert.Equal(t, int64(179300), payments[1].Amount)
+	}
+	var tickets int64
+	db.Model(&models.Ticket{}).Where("booking_id = ?", 1).Count(&tickets)
+	assert.Equal(t, int64(10), tickets)
+
+	_, _, err = nextInstallment(db, &booking, nil)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/http/middleware"
+	"skyliner/internal/mail"
+	"skyliner/internal/pnr"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// GuestCheckoutRequest is the part of a guest booking request that
+// CreateBooking does not read.
+type GuestCheckoutRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ManageLinkRequest struct {
+	LastName string `json:"last_name" binding:"required"`
+}
+
+// Signed links act for a customer without a login. Their claims never include
+// user_id, so AuthRequired does not accept them as access tokens.
+const (
+	manageBookingToken = "manage_booking"
+	verifyEmailToken   = "verify_email"
+
+	manageLinkTTL  = 365 * 24 * time.Hour // outlasts the travel on any booking
+	verifyEmailTTL = 24 * time.Hour
+)
+
+var errInvalidToken = errors.New("invalid token")
+
+func signToken(secret, kind string, claims jwt.MapClaims, ttl time.Duration) (string, error) {
+	claims["type"] = kind
+	claims["exp"] = time.Now().Add(ttl).Unix()
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
+func parseToken(secret, kind, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired()); err != nil {
+		return nil, err
+	}
+	if claims["type"] != kind {
+		return nil, errInvalidToken
+	}
+	return claims, nil
+}
+
+// GuestCheckout lets someone book without an account. The booking belongs to
+// the guest user for the email given, created on first use. It runs ahead of
+// CreateBooking, which reads the rest of the request and replies with a
+// manage-booking link.
+func (h *BookingHandler) GuestCheckout(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var req GuestCheckoutRequest
+	if err := binding.JSON.BindBody(body, &req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	guest, err := guestUser(h.db, req.Email)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create guest"})
+		return
+	}
+	c.Set("user_id", guest.ID)
+	c.Set("guest", true)
+	c.Next()
+}
+
+// guestUser finds or creates the guest user for an email.
+func guestUser(db *gorm.DB, email string) (models.User, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	guest := models.User{Email: email, Guest: true, Role: models.RoleTraveler, IsActive: true}
+	err := db.Where("email = ? AND guest = ?", email, true).FirstOrCreate(&guest).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// Another checkout for the same email created it first
+		err = db.Where("email = ? AND guest = ?", email, true).First(&guest).Error
+	}
+	return guest, err
+}
+
+// ManageBooking accepts a manage-booking link in place of a login, for the
+// one booking it was issued for. The handlers after it see the booking's
+// owner as the caller and the booking as :id.
+func (h *BookingHandler) ManageBooking(c *gin.Context) {
+	claims, err := parseToken(h.cfg.JWTSecret, manageBookingToken, c.Param("token"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+	bookingID, _ := claims["booking_id"].(float64)
+
+	var booking models.Booking
+	if err := h.db.Select("id", "user_id").First(&booking, uint(bookingID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return
+	}
+
+	c.Set("user_id", booking.UserID)
+	c.Set("manage_booking_id", booking.ID)
+	c.Set(middleware.IdempotencyScopeKey, "booking:"+strconv.FormatUint(uint64(booking.ID), 10))
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: strconv.FormatUint(uint64(booking.ID), 10)})
+	c.Next()
+}
+
+// SendManageLink emails a booking's manage link to the address it was booked
+// with, for a traveler who has the PNR and a passenger's last name. The reply
+// is the same whether or not the booking exists.
+func (h *BookingHandler) SendManageLink(c *gin.Context) {
+	var req ManageLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	code := pnr.Normalize(c.Param("pnr"))
+	var booking models.Booking
+	err := h.db.Preload("User").
+		Where("pnr = ?", code).
+		Where("EXISTS (SELECT 1 FROM passengers WHERE passengers.booking_id = bookings.id AND LOWER(passengers.last_name) = LOWER(?))", strings.TrimSpace(req.LastName)).
+		First(&booking).Error
+	switch {
+	case err == nil:
+		h.sendManageLink(booking)
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the booking exists, a link to manage it has been sent to its email address"})
+}
+
+// AttachGuestBookings moves the bookings made as a guest with the account's
+// email to the account. The email must be verified first, so nobody can take
+// a booking by signing up with someone else's address.
+func (h *BookingHandler) AttachGuestBookings(c *gin.Context) {
+	var user models.User
+	if err := h.db.First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+	if user.Guest || user.EmailVerifiedAt == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Verify your email address first"})
+		return
+	}
+
+	attached, err := attachGuestBookings(h.db, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to attach bookings"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"attached": attached})
+}
+
+// attachGuestBookings gives a verified account the bookings of the guest
+// user with its email, along with their credits and promotion uses.
+func attachGuestBookings(db *gorm.DB, user models.User) (int64, error) {
+	var guest models.User
+	err := db.Where("email = ? AND guest = ?", strings.ToLower(strings.TrimSpace(user.Email)), true).First(&guest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var attached int64
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Booking{}).Where("user_id = ?", guest.ID).Update("user_id", user.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		attached = result.RowsAffected
+		for _, model := range []interface{}{&models.Credit{}, &models.PromotionRedemption{}} {
+			if err := tx.Model(model).Where("user_id = ?", guest.ID).Update("user_id", user.ID).Error; err != nil {
+				return err
+			}
+		}
+		// Recount the account's promotion uses with the redemptions it gained;
+		// promotions it has not used yet are counted on first use
+		if err := tx.Where("user_id = ?", guest.ID).Delete(&models.PromotionUsage{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.PromotionUsage{}).Where("user_id = ?", user.ID).
+			Update("uses", gorm.Expr("(SELECT COUNT(*) FROM promotion_redemptions WHERE promotion_redemptions.promotion_id = promotion_usages.promotion_id AND promotion_redemptions.user_id = promotion_usages.user_id)")).Error
+	})
+	return attached, err
+}
+
+// manageURL is the link a guest uses to come back to their booking.
+func (h *BookingHandler) manageURL(booking models.Booking) (string, error) {
+	token, err := signToken(h.cfg.JWTSecret, manageBookingToken, jwt.MapClaims{"booking_id": booking.ID}, manageLinkTTL)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(h.cfg.PublicURL, "/") + "/api/v1/guest/manage/" + token, nil
+}
+
+// sendManageLink emails the manage link to the booking's owner. booking.User
+// must be loaded. Failures are logged; the traveler can ask again.
+func (h *BookingHandler) sendManageLink(booking models.Booking) {
+	url, err := h.manageURL(booking)
+	if err != nil {
+		log.Printf("Failed to sign manage link for booking %d: %v", booking.ID, err)
+		return
+	}
+	msg := mail.Message{
+		To:      booking.User.Email,
+		Subject: fmt.Sprintf("Your booking %s", booking.PNR),
+		Body: fmt.Sprintf("Your booking reference is %s.\n\nView, pay for, change or cancel your booking here:\n%s\n\n"+
+			"Anyone with this link can manage the booking, so keep it to yourself.\n", booking.PNR, url),
+	}
+	if err := h.mailer.Send(msg); err != nil {
+		log.Printf("Failed to email manage link for booking %d: %v", booking.ID, err)
+	}
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/http/middleware"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+const guestPublicURL = "https://skyliner.example.com"
+
+// setupGuestTest serves the guest routes, and the account routes behind the
+// real access-token check.
+func setupGuestTest(db *gorm.DB) (*gin.Engine, *sentMail) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{JWTSecret: "test-secret", JWTAccessTTL: time.Hour, PublicURL: guestPublicURL}
+	mailer := &sentMail{}
+	h := NewBookingHandler(db, cfg, fx.Default(), payments.NewFakeClient(), &recordedEvents{})
+	h.mailer = mailer
+	auth := NewAuthHandler(db, cfg)
+	auth.mailer = mailer
+	pay := NewPaymentHandler(db, cfg)
+
+	router := gin.New()
+	router.POST("/auth/signup", auth.Signup)
+	router.POST("/auth/login", auth.Login)
+	router.GET("/auth/verify-email", auth.VerifyEmail)
+	router.POST("/bookings/by-pnr/:pnr/manage-link", h.SendManageLink)
+	idempotent := middleware.Idempotency(db, time.Hour)
+	router.POST("/guest/bookings", h.GuestCheckout, idempotent, h.CreateBooking)
+
+	manage := router.Group("/guest/manage/:token")
+	manage.Use(h.ManageBooking)
+	manage.GET("", h.GetBooking)
+	manage.POST("/cancel", h.CancelBooking)
+	manage.POST("/checkout-session", idempotent, pay.CreateCheckoutSession)
+
+	protected := router.Group("")
+	protected.Use(middleware.AuthRequired(cfg.JWTSecret))
+	protected.GET("/bookings", h.GetBookings)
+	protected.POST("/account/attach-guest-bookings", h.AttachGuestBookings)
+	return router, mailer
+}
+
+func guestBooking(email, lastName string) map[string]interface{} {
+	return map[string]interface{}{
+		"email":      email,
+		"segments":   []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers": []map[string]interface{}{{"first_name": "Ada", "last_name": lastName}},
+	}
+}
+
+// lastLink returns the link in the most recent email.
+func lastLink(t *testing.T, mailer *sentMail, prefix string) string {
+	t.Helper()
+	if !assert.NotEmpty(t, mailer.messages) {
+		t.FailNow()
+	}
+	body := mailer.messages[len(mailer.messages)-1].Body
+	start := strings.Index(body, prefix)
+	if !assert.GreaterOrEqual(t, start, 0, body) {
+		t.FailNow()
+	}
+	return strings.Fields(body[start:])[0]
+}
+
+func bearerRequest(router *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestBookingHandler_GuestCheckout(t *testing.T) {
+	db := setupBookingTestDB()
+	router, mailer := setupGuestTest(db)
+
+	w := postJSON(router, "/guest/bookings", guestBooking("", "Lovelace"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = postJSON(router, "/guest/bookings", guestBooking("Ada@Example.com", "Lovelace"))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var response BookingResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	manageURL := response.ManageURL
+	assert.True(t, strings.HasPrefix(manageURL, guestPublicURL+"/api/v1/guest/manage/"), manageURL)
+
+	// The link is emailed too, and a second booking reuses the guest
+	if assert.Len(t, mailer.messages, 1) {
+		assert.Equal(t, "ada@example.com", mailer.messages[0].To)
+		assert.Contains(t, mailer.messages[0].Body, manageURL)
+		assert.Contains(t, mailer.messages[0].Body, response.Booking.PNR)
+	}
+	w = postJSON(router, "/guest/bookings", guestBooking("ada@example.com", "Lovelace"))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var guests int64
+	db.Model(&models.User{}).Where("guest = ?", true).Count(&guests)
+	assert.Equal(t, int64(1), guests)
+
+	// The link manages that booking and no other
+	path := strings.TrimPrefix(manageURL, guestPublicURL+"/api/v1")
+	w = request(router, "GET", path)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var managed BookingResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &managed))
+	assert.Equal(t, response.Booking.ID, managed.Booking.ID)
+
+	w = request(router, "GET", path+"x")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = postJSON(router, path+"/checkout-session", gin.H{"booking_id": 2})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Nor is it a login
+	token := manageTokenOf(path)
+	w = bearerRequest(router, "GET", "/bookings", token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = postJSON(router, path+"/cancel", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var booking models.Booking
+	db.First(&booking, response.Booking.ID)
+	assert.Equal(t, models.StatusCancelled, booking.Status)
+
+	// A lost link is sent again to the address booked with, to nobody else
+	w = postJSON(router, "/bookings/by-pnr/"+strings.ToLower(booking.PNR)+"/manage-link", gin.H{"last_name": "lovelace"})
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	if assert.Len(t, mailer.messages, 3) {
+		assert.Equal(t, "ada@example.com", mailer.messages[2].To)
+		assert.Contains(t, mailer.messages[2].Body, booking.PNR)
+	}
+	w = postJSON(router, "/bookings/by-pnr/"+booking.PNR+"/manage-link", gin.H{"last_name": "Byron"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Len(t, mailer.messages, 3)
+}
+
+func TestAuthHandler_VerifyEmailAttachesGuestBookings(t *testing.T) {
+	db := setupBookingTestDB()
+	router, mailer := setupGuestTest(db)
+
+	w := postJSON(router, "/guest/bookings", guestBooking("ada@example.com", "Lovelace"))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var guestResponse BookingResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &guestResponse))
+	managePath := strings.TrimPrefix(guestResponse.ManageURL, guestPublicURL+"/api/v1")
+
+	// The guest cannot log in, and the address is still free to sign up with
+	w = postJSON(router, "/auth/login", gin.H{"email": "ada@example.com", "password": "password123"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	signup := gin.H{"email": "ada@example.com", "password": "password123", "first_name": "Ada", "last_name": "Lovelace"}
+	w = postJSON(router, "/auth/signup", signup)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var account AuthResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &account))
+	assert.False(t, account.User.Guest)
+	w = postJSON(router, "/auth/signup", signup)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Bookings follow only once the account proves it owns the address
+	w = bearerRequest(router, "POST", "/account/attach-guest-bookings", account.AccessToken)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	link := lastLink(t, mailer, guestPublicURL+"/api/v1/auth/verify-email?token=")
+	w = request(router, "GET", "/auth/verify-email?token="+manageTokenOf(managePath))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = request(router, "GET", strings.TrimPrefix(link, guestPublicURL+"/api/v1"))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"attached":1`)
+
+	var booking models.Booking
+	db.First(&booking, guestResponse.Booking.ID)
+	assert.Equal(t, account.User.ID, booking.UserID)
+	w = bearerRequest(router, "GET", "/bookings", account.AccessToken)
+	assert.Contains(t, w.Body.String(), booking.PNR)
+
+	w = bearerRequest(router, "POST", "/account/attach-guest-bookings", account.AccessToken)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"attached":0}`, w.Body.String())
+
+	// The emailed link keeps working after the booking moves
+	w = request(router, "GET", managePath)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func manageTokenOf(path string) string {
+	return strings.TrimPrefix(path, "/guest/manage/")
+}
+
+func TestBookingHandler_GuestCannotSpendCredits(t *testing.T) {
+	db := setupBookingTestDB()
+	router, _ := setupGuestTest(db)
+
+	// The victim's guest bookings earned a credit
+	w := postJSON(router, "/guest/bookings", guestBooking("ada@example.com", "Lovelace"))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var guest models.User
+	db.Where("email = ? AND guest = ?", "ada@example.com", true).First(&guest)
+	expires := time.Now().Add(24 * time.Hour)
+	db.Create(&models.Credit{UserID: guest.ID, Kind: models.CreditTravel, Source: "cancellation", Amount: 10000, Currency: "USD", ExpiresAt: &expires})
+
+	// Someone else books with the same email and tries to spend it
+	w = postJSON(router, "/guest/bookings", guestBooking("ada@example.com", "Byron"))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var response BookingResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	path := strings.TrimPrefix(response.ManageURL, guestPublicURL+"/api/v1")
+	w = postJSON(router, path+"/checkout-session", gin.H{"booking_id": response.Booking.ID, "apply_credits": true})
+	assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+
+	var credit models.Credit
+	db.First(&credit)
+	assert.Equal(t, int64(10000), credit.Amount)
+	var applied int64
+	db.Model(&models.Payment{}).Where("method = ?", "credit").Count(&applied)
+	assert.Zero(t, applied)
+}
+
+func TestBookingHandler_GuestIdempotencyKeys(t *testing.T) {
+	db := setupBookingTestDB()
+	router, _ := setupGuestTest(db)
+
+	post := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", "/guest/bookings", strings.NewReader(string(raw)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.IdempotencyKeyHeader, "checkout-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post(guestBooking("ada@example.com", "Lovelace"))
+	assert.Equal(t, http.StatusCreated, first.Code, first.Body.String())
+	var created BookingResponse
+	assert.NoError(t, json.Unmarshal(first.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.ManageURL)
+
+	// The key belongs to the guest, so it cannot be reused for another request
+	other := post(guestBooking("ada@example.com", "Byron"))
+	assert.Equal(t, http.StatusUnprocessableEntity, other.Code, other.Body.String())
+
+	// A retry gets the booking, but the manage link only by email: anyone can
+	// send the same request for the same address
+	retry := post(guestBooking("ada@example.com", "Lovelace"))
+	assert.Equal(t, http.StatusCreated, retry.Code, retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get(middleware.IdempotentReplayedHeader))
+	var replayed BookingResponse
+	assert.NoError(t, json.Unmarshal(retry.Body.Bytes(), &replayed))
+	assert.Equal(t, created.Booking.ID, replayed.Booking.ID)
+	assert.Empty(t, replayed.ManageURL)
+	assert.NotContains(t, retry.Body.String(), manageTokenOf(strings.TrimPrefix(created.ManageURL, guestPublicURL+"/api/v1")))
+
+	var bookings int64
+	db.Model(&models.Booking{}).Count(&bookings)
+	assert.Equal(t, int64(1), bookings)
+}
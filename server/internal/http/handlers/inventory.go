@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+
+	"skyliner/internal/db/models"
+
+	"gorm.io/gorm"
+)
+
+var errFareSoldOut = errors.New("not enough seats left at this fare")
+
+// holdFare takes seats out of a fare's inventory. The conditional update keeps
+// concurrent bookings from overselling the last seats.
+func holdFare(tx *gorm.DB, fareID uint, seats int) error {
+	result := tx.Model(&models.Fare{}).
+		Where("id = ? AND available >= ?", fareID, seats).
+		Update("available", gorm.Expr("available - ?", seats))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errFareSoldOut
+	}
+	return nil
+}
+
+// releaseFare puts seats back into a fare's inventory.
+func releaseFare(tx *gorm.DB, fareID uint, seats int) error {
+	return tx.Model(&models.Fare{}).
+		Where("id = ?", fareID).
+		Update("available", gorm.Expr("available + ?", seats)).Error
+}
+
+// releaseBookingInventory returns the fare inventory held by every segment of
+// a booking.
+func releaseBookingInventory(tx *gorm.DB, bookingID uint) error {
+	passengers, err := countPassengers(tx, bookingID)
+	if err != nil {
+		return err
+	}
+
+	var segments []models.Segment
+	if err := tx.Joins("JOIN itineraries ON itineraries.id = segments.itinerary_id").
+		Where("itineraries.booking_id = ?", bookingID).
+		Find(&segments).Error; err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if err := releaseFare(tx, segment.FareID, passengers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func countPassengers(db *gorm.DB, bookingID uint) (int, error) {
+	var count int64
+	err := db.Model(&models.Passenger{}).Where("booking_id = ?", bookingID).Count(&count).Error
+	return int(count), err
+}
@@ -2,12 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"skyliner/internal/config"
 	"skyliner/internal/db/models"
+	"skyliner/internal/money"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stripe/stripe-go/v76"
@@ -30,12 +34,20 @@ func NewPaymentHandler(db *gorm.DB, cfg *config.Config) *PaymentHandler {
 }
 
 type CheckoutSessionRequest struct {
-	BookingID uint `json:"booking_id" binding:"required"`
+	BookingID     uint   `json:"booking_id" binding:"required"`
+	ExchangeID    *uint  `json:"exchange_id"`    // pay the amount due on a pending exchange instead
+	AncillaryIDs  []uint `json:"ancillary_ids"`  // pay for add-ons bought after the booking was paid instead
+	InstallmentID *uint  `json:"installment_id"` // group bookings: the installment to pay, by default the next one due
+	ApplyCredits  bool   `json:"apply_credits"`  // spend the user's travel credits first
 }
 
+// CheckoutSessionResponse has no session when credits covered the whole
+// amount; the booking is then already paid.
 type CheckoutSessionResponse struct {
-	SessionID string `json:"session_id"`
-	URL       string `json:"url"`
+	SessionID      string       `json:"session_id"`
+	URL            string       `json:"url"`
+	CreditsApplied *money.Money `json:"credits_applied,omitempty"`
+	Paid           bool         `json:"paid,omitempty"`
 }
 
 type BillingPortalRequest struct {
@@ -53,6 +65,11 @@ func (h *PaymentHandler) CreateCheckoutSession(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// A manage-booking link pays for its own booking only
+	if id, ok := c.Get("manage_booking_id"); ok && id.(uint) != req.BookingID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return
+	}
 
 	// Get booking
 	var booking models.Booking
@@ -64,10 +81,117 @@ func (h *PaymentHandler) CreateCheckoutSession(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
 		return
 	}
+	// Credits are spent only by a signed-in, verified account: anyone can book
+	// as a guest with someone's email, and a manage link is not a login
+	if _, managed := c.Get("manage_booking_id"); req.ApplyCredits && (managed || booking.User.Guest) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Sign in to spend travel credits"})
+		return
+	}
 
-	if booking.Status != models.StatusHold {
+	amount := booking.Total()
+	description := fmt.Sprintf("Flight Booking - %s", booking.PNR)
+	metadata := map[string]string{
+		"booking_id": strconv.Itoa(int(booking.ID)),
+	}
+
+	var exchange models.Exchange
+	var purchases []models.AncillaryPurchase
+	if len(req.AncillaryIDs) > 0 {
+		if err := h.db.Where("id IN ? AND booking_id = ? AND status = ?", req.AncillaryIDs, booking.ID, models.AncillaryPendingPayment).
+			Find(&purchases).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch add-ons"})
+			return
+		}
+		if len(purchases) != len(uniqueIDs(req.AncillaryIDs)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Add-ons are not awaiting payment"})
+			return
+		}
+		due := money.Zero(booking.Base().Currency)
+		cutoff := ancillaryHoldCutoff(h.cfg.HoldTTL)
+		ids := make([]string, len(purchases))
+		for i, purchase := range purchases {
+			if purchase.CreatedAt.Before(cutoff) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Add-on hold has expired"})
+				return
+			}
+			var err error
+			if due, err = due.Add(purchase.Money()); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price add-ons"})
+				return
+			}
+			ids[i] = strconv.Itoa(int(purchase.ID))
+		}
+		var err error
+		if amount, err = settle(&booking, due); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert currency"})
+			return
+		}
+		description = fmt.Sprintf("Add-ons - %s", booking.PNR)
+		metadata["ancillary_ids"] = strings.Join(ids, ",")
+	} else if req.ExchangeID != nil {
+		if err := h.db.Where("id = ? AND booking_id = ?", *req.ExchangeID, booking.ID).First(&exchange).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Exchange not found"})
+			return
+		}
+		if exchange.Status != models.ExchangePendingPayment {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Exchange is not awaiting payment"})
+			return
+		}
+		amount = exchange.Due()
+		description = fmt.Sprintf("Flight Change - %s", booking.PNR)
+		metadata["exchange_id"] = strconv.Itoa(int(exchange.ID))
+	} else if booking.Status != models.StatusHold {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Booking is not in hold status"})
 		return
+	} else if booking.HoldExpired(time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Booking hold has expired"})
+		return
+	}
+
+	// Group bookings are paid by installment
+	var installment *models.GroupInstallment
+	if req.ExchangeID == nil && len(purchases) == 0 {
+		var err error
+		installment, amount, err = nextInstallment(h.db, &booking, req.InstallmentID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Installment is not due for payment"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch installments"})
+			return
+		}
+		if installment != nil {
+			description = fmt.Sprintf("Group Payment %d - %s", installment.Sequence, booking.PNR)
+			metadata["installment_id"] = strconv.Itoa(int(installment.ID))
+		}
+	}
+
+	// Credit already spent on the booking, plus any more the customer asks to
+	// use now, comes off what Stripe charges
+	var credits *money.Money
+	if req.ExchangeID == nil && len(purchases) == 0 && installment == nil {
+		applied, err := h.redeemCredits(&booking, amount, req.ApplyCredits)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply credits"})
+			return
+		}
+		if !applied.IsZero() {
+			credits = &applied
+		}
+		if applied.Amount >= amount.Amount {
+			if err := h.payWithCredits(&booking, applied); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to pay with credits"})
+				return
+			}
+			h.autoTicket(booking.ID)
+			c.JSON(http.StatusOK, CheckoutSessionResponse{CreditsApplied: credits, Paid: true})
+			return
+		}
+		if amount, err = amount.Sub(applied); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply credits"})
+			return
+		}
 	}
 
 	// Create or get Stripe customer
@@ -84,11 +208,11 @@ func (h *PaymentHandler) CreateCheckoutSession(c *gin.Context) {
 		LineItems: []*stripe.CheckoutSessionLineItemParams{
 			{
 				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-					Currency: stripe.String(booking.Currency),
+					Currency: stripe.String(strings.ToLower(amount.Currency)),
 					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
-						Name: stripe.String(fmt.Sprintf("Flight Booking - %s", booking.PNR)),
+						Name: stripe.String(description),
 					},
-					UnitAmount: stripe.Int64(int64(booking.TotalAmount * 100)), // Convert to cents
+					UnitAmount: stripe.Int64(amount.Amount), // Stripe also takes minor units
 				},
 				Quantity: stripe.Int64(1),
 			},
@@ -96,9 +220,9 @@ func (h *PaymentHandler) CreateCheckoutSession(c *gin.Context) {
 		Mode:       stripe.String(string(stripe.CheckoutSessionModePayment)),
 		SuccessURL: stripe.String("http://localhost:5193/booking/" + strconv.Itoa(int(booking.ID)) + "?success=true"),
 		CancelURL:  stripe.String("http://localhost:5193/booking/" + strconv.Itoa(int(booking.ID)) + "?cancelled=true"),
-		Metadata: map[string]string{
-			"booking_id": strconv.Itoa(int(booking.ID)),
-		},
+		Metadata:   metadata,
+		// Failed attempts are reported on the payment intent, not the session
+		PaymentIntentData: &stripe.CheckoutSessionPaymentIntentDataParams{Metadata: metadata},
 	}
 
 	session, err := session.New(params)
@@ -107,18 +231,85 @@ func (h *PaymentHandler) CreateCheckoutSession(c *gin.Context) {
 		return
 	}
 
-	// Update booking with session ID
-	if err := h.db.Model(&booking).Update("stripe_session_id", session.ID).Error; err != nil {
+	// Record the session ID on whatever is being paid for
+	target := h.db.Model(&booking)
+	switch {
+	case len(purchases) > 0:
+		target = h.db.Model(&models.AncillaryPurchase{}).Where("id IN ?", req.AncillaryIDs)
+	case req.ExchangeID != nil:
+		target = h.db.Model(&exchange)
+	case installment != nil:
+		target = h.db.Model(installment)
+	}
+	if err := target.Update("stripe_session_id", session.ID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update booking"})
 		return
 	}
 
 	c.JSON(http.StatusOK, CheckoutSessionResponse{
-		SessionID: session.ID,
-		URL:       session.URL,
+		SessionID:      session.ID,
+		URL:            session.URL,
+		CreditsApplied: credits,
 	})
 }
 
+// redeemCredits returns the credit spent on the booking, first spending more
+// of the owner's credits towards total when spend is set.
+func (h *PaymentHandler) redeemCredits(booking *models.Booking, total money.Money, spend bool) (money.Money, error) {
+	var applied money.Money
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if applied, err = creditsApplied(tx, booking.ID, total.Currency); err != nil {
+			return err
+		}
+		if !spend || applied.Amount >= total.Amount {
+			return nil
+		}
+		due, err := total.Sub(applied)
+		if err != nil {
+			return err
+		}
+		more, err := applyCredits(tx, booking.UserID, booking.ID, due, time.Now())
+		if err != nil {
+			return err
+		}
+		applied, err = applied.Add(more)
+		return err
+	})
+	return applied, err
+}
+
+// payWithCredits marks a held booking paid when credits cover all of it.
+func (h *PaymentHandler) payWithCredits(booking *models.Booking, applied money.Money) error {
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Booking{}).
+			Where("id = ? AND status = ?", booking.ID, models.StatusHold).
+			Updates(map[string]interface{}{"status": models.StatusPaid, "stripe_session_id": nil})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("booking is no longer on hold")
+		}
+		return recordCreditPayment(tx, booking.ID, applied)
+	})
+}
+
+// recordCreditPayment records the credit spent on a booking as a payment, so
+// it counts towards what was paid when the booking is later cancelled.
+func recordCreditPayment(tx *gorm.DB, bookingID uint, applied money.Money) error {
+	if applied.Amount <= 0 {
+		return nil
+	}
+	return tx.Create(&models.Payment{
+		BookingID: bookingID,
+		Amount:    applied.Amount,
+		Currency:  applied.Currency,
+		Method:    "credit",
+		Status:    "succeeded",
+	}).Error
+}
+
 func (h *PaymentHandler) CreateBillingPortal(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	var req BillingPortalRequest
@@ -126,6 +317,11 @@ func (h *PaymentHandler) CreateBillingPortal(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// A manage-booking link pays for its own booking only
+	if id, ok := c.Get("manage_booking_id"); ok && id.(uint) != req.BookingID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return
+	}
 
 	// Get booking
 	var booking models.Booking
@@ -185,6 +381,13 @@ func (h *PaymentHandler) HandleStripeWebhook(c *gin.Context) {
 			return
 		}
 		h.handleCheckoutSessionCompleted(&session)
+	case "charge.refunded":
+		var charge stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid charge data"})
+			return
+		}
+		h.handleChargeRefunded(&charge)
 	case "payment_intent.succeeded":
 		var paymentIntent stripe.PaymentIntent
 		if err := json.Unmarshal(event.Data.Raw, &paymentIntent); err != nil {
@@ -192,6 +395,13 @@ func (h *PaymentHandler) HandleStripeWebhook(c *gin.Context) {
 			return
 		}
 		h.handlePaymentIntentSucceeded(&paymentIntent)
+	case "payment_intent.payment_failed":
+		var paymentIntent stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &paymentIntent); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment intent data"})
+			return
+		}
+		h.handlePaymentIntentFailed(&paymentIntent)
 	default:
 		fmt.Printf("Unhandled event type: %s\n", event.Type)
 	}
@@ -229,8 +439,21 @@ func (h *PaymentHandler) handleCheckoutSessionCompleted(session *stripe.Checkout
 		return
 	}
 
-	// Update booking status to paid
-	if err := h.db.Model(&models.Booking{}).Where("id = ?", uint(bookingID)).Update("status", models.StatusPaid).Error; err != nil {
+	if exchangeIDStr, ok := session.Metadata["exchange_id"]; ok {
+		h.handleExchangePaid(uint(bookingID), exchangeIDStr, session)
+		return
+	}
+	if ancillaryIDs, ok := session.Metadata["ancillary_ids"]; ok {
+		h.handleAncillariesPaid(uint(bookingID), ancillaryIDs, session)
+		return
+	}
+	if installmentID, ok := session.Metadata["installment_id"]; ok {
+		h.handleInstallmentPaid(uint(bookingID), installmentID, session)
+		return
+	}
+
+	// Update booking status to paid; a retried webhook must not undo ticketing
+	if err := h.db.Model(&models.Booking{}).Where("id = ? AND status <> ?", uint(bookingID), models.StatusTicketed).Update("status", models.StatusPaid).Error; err != nil {
 		fmt.Printf("Failed to update booking status: %v\n", err)
 		return
 	}
@@ -239,8 +462,8 @@ func (h *PaymentHandler) handleCheckoutSessionCompleted(session *stripe.Checkout
 	payment := models.Payment{
 		BookingID:       uint(bookingID),
 		StripePaymentID: session.PaymentIntent.ID,
-		Amount:          float64(session.AmountTotal) / 100, // Convert from cents
-		Currency:        string(session.Currency),
+		Amount:          session.AmountTotal,
+		Currency:        strings.ToUpper(string(session.Currency)),
 		Status:          "succeeded",
 	}
 
@@ -249,10 +472,272 @@ func (h *PaymentHandler) handleCheckoutSessionCompleted(session *stripe.Checkout
 		return
 	}
 
+	// Credits spent before checkout paid for the rest
+	applied, err := creditsApplied(h.db, payment.BookingID, payment.Currency)
+	if err == nil {
+		err = recordCreditPayment(h.db, payment.BookingID, applied)
+	}
+	if err != nil {
+		fmt.Printf("Failed to record credit payment: %v\n", err)
+	}
+
 	fmt.Printf("Booking %d marked as paid\n", bookingID)
+	h.autoTicket(uint(bookingID))
+}
+
+// autoTicket issues tickets for a booking that was just paid, when configured
+// to. Bookings still missing passenger details wait for IssueBooking.
+func (h *PaymentHandler) autoTicket(bookingID uint) {
+	if !h.cfg.AutoTicket {
+		return
+	}
+	incomplete, err := incompleteAPIS(h.db, bookingID)
+	if err != nil {
+		fmt.Printf("Failed to check passenger details on booking %d: %v\n", bookingID, err)
+		return
+	}
+	if len(incomplete) > 0 {
+		fmt.Printf("Booking %d not ticketed: passenger details are incomplete\n", bookingID)
+		return
+	}
+	if _, err := ticketBooking(h.db, bookingID); err != nil {
+		fmt.Printf("Failed to ticket booking %d: %v\n", bookingID, err)
+		return
+	}
+	fmt.Printf("Booking %d ticketed\n", bookingID)
+}
+
+// handleExchangePaid completes an exchange once its amount due is collected.
+func (h *PaymentHandler) handleExchangePaid(bookingID uint, exchangeIDStr string, session *stripe.CheckoutSession) {
+	exchangeID, err := strconv.ParseUint(exchangeIDStr, 10, 32)
+	if err != nil {
+		fmt.Printf("Invalid exchange_id in session metadata: %s\n", exchangeIDStr)
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var exchange models.Exchange
+		if err := tx.Where("id = ? AND booking_id = ?", uint(exchangeID), bookingID).First(&exchange).Error; err != nil {
+			return err
+		}
+		if exchange.Status != models.ExchangePendingPayment {
+			return nil // already handled; Stripe retries webhooks
+		}
+		if err := completeExchange(tx, &exchange, h.cfg.CreditValidity); err != nil {
+			return err
+		}
+		return tx.Create(&models.Payment{
+			BookingID:       bookingID,
+			StripePaymentID: session.PaymentIntent.ID,
+			Amount:          session.AmountTotal,
+			Currency:        strings.ToUpper(string(session.Currency)),
+			Status:          "succeeded",
+		}).Error
+	})
+	if err != nil {
+		fmt.Printf("Failed to complete exchange %d: %v\n", exchangeID, err)
+		return
+	}
+
+	fmt.Printf("Exchange %d on booking %d completed\n", exchangeID, bookingID)
+}
+
+// handleAncillariesPaid marks add-ons bought on a paid booking as paid for.
+func (h *PaymentHandler) handleAncillariesPaid(bookingID uint, ancillaryIDs string, session *stripe.CheckoutSession) {
+	var ids []uint
+	for _, field := range strings.Split(ancillaryIDs, ",") {
+		id, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			fmt.Printf("Invalid ancillary_ids in session metadata: %s\n", ancillaryIDs)
+			return
+		}
+		ids = append(ids, uint(id))
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		activated, err := activateAncillaries(tx, bookingID, ids)
+		if err != nil || activated == 0 {
+			return err // nothing left to do when Stripe retries the webhook
+		}
+		return tx.Create(&models.Payment{
+			BookingID:       bookingID,
+			StripePaymentID: session.PaymentIntent.ID,
+			Amount:          session.AmountTotal,
+			Currency:        strings.ToUpper(string(session.Currency)),
+			Status:          "succeeded",
+		}).Error
+	})
+	if err != nil {
+		fmt.Printf("Failed to record add-ons paid on booking %d: %v\n", bookingID, err)
+		return
+	}
+
+	fmt.Printf("Add-ons %s on booking %d paid\n", ancillaryIDs, bookingID)
+}
+
+// handleInstallmentPaid records a group booking installment. The booking is
+// paid with the last one; until then its hold runs to the next due date.
+func (h *PaymentHandler) handleInstallmentPaid(bookingID uint, installmentIDStr string, session *stripe.CheckoutSession) {
+	installmentID, err := strconv.ParseUint(installmentIDStr, 10, 32)
+	if err != nil {
+		fmt.Printf("Invalid installment_id in session metadata: %s\n", installmentIDStr)
+		return
+	}
+
+	paid := false
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var installment models.GroupInstallment
+		if err := tx.Where("id = ? AND booking_id = ?", uint(installmentID), bookingID).First(&installment).Error; err != nil {
+			return err
+		}
+		if installment.Status == models.InstallmentPaid {
+			return nil // already handled; Stripe retries webhooks
+		}
+		now := time.Now()
+		if err := tx.Model(&installment).Updates(map[string]interface{}{
+			"status":  models.InstallmentPaid,
+			"paid_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.Payment{
+			BookingID:       bookingID,
+			StripePaymentID: session.PaymentIntent.ID,
+			Amount:          session.AmountTotal,
+			Currency:        strings.ToUpper(string(session.Currency)),
+			Status:          "succeeded",
+		}).Error; err != nil {
+			return err
+		}
+
+		var next models.GroupInstallment
+		err := tx.Where("booking_id = ? AND status = ?", bookingID, models.InstallmentDue).Order("sequence").First(&next).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			paid = true
+			return tx.Model(&models.Booking{}).Where("id = ? AND status = ?", bookingID, models.StatusHold).Update("status", models.StatusPaid).Error
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.Booking{}).Where("id = ?", bookingID).Update("hold_expires_at", next.DueAt).Error
+	})
+	if err != nil {
+		fmt.Printf("Failed to record installment %d on booking %d: %v\n", installmentID, bookingID, err)
+		return
+	}
+
+	fmt.Printf("Installment %d on booking %d paid\n", installmentID, bookingID)
+	if paid {
+		h.autoTicket(bookingID)
+	}
+}
+
+// nextInstallment finds the group installment to pay, the given one or the
+// next due, and what it costs. Bookings without installments get nil and
+// their total.
+func nextInstallment(db *gorm.DB, booking *models.Booking, id *uint) (*models.GroupInstallment, money.Money, error) {
+	var installments []models.GroupInstallment
+	if err := db.Where("booking_id = ?", booking.ID).Order("sequence").Find(&installments).Error; err != nil {
+		return nil, money.Money{}, err
+	}
+	if len(installments) == 0 {
+		if id != nil {
+			return nil, money.Money{}, gorm.ErrRecordNotFound
+		}
+		return nil, booking.Total(), nil
+	}
+
+	var installment *models.GroupInstallment
+	paid := money.Zero(booking.Currency)
+	unpaid := 0
+	for i := range installments {
+		if installments[i].Status == models.InstallmentPaid {
+			var err error
+			if paid, err = paid.Add(installments[i].Money()); err != nil {
+				return nil, money.Money{}, err
+			}
+			continue
+		}
+		unpaid++
+		if installment == nil && (id == nil || installments[i].ID == *id) {
+			installment = &installments[i]
+		}
+	}
+	if installment == nil {
+		return nil, money.Money{}, gorm.ErrRecordNotFound
+	}
+
+	// The final payment settles the balance, including charges added since
+	if unpaid == 1 {
+		due, err := booking.Total().Sub(paid)
+		return installment, due, err
+	}
+	return installment, installment.Money(), nil
+}
+
+// handleChargeRefunded syncs refund statuses from Stripe. Refunds made outside
+// the app, e.g. from the Stripe dashboard, are recorded against the payment.
+func (h *PaymentHandler) handleChargeRefunded(charge *stripe.Charge) {
+	if charge.Refunds == nil || charge.PaymentIntent == nil {
+		return
+	}
+
+	for _, r := range charge.Refunds.Data {
+		// Refunds we issued carry our ID, which also covers the webhook
+		// arriving before the Stripe refund ID has been saved
+		query := h.db.Model(&models.Refund{}).Where("stripe_refund_id = ?", r.ID)
+		if id, ok := r.Metadata["refund_id"]; ok {
+			query = h.db.Model(&models.Refund{}).Where("id = ?", id)
+		}
+		result := query.Updates(map[string]interface{}{"stripe_refund_id": r.ID, "status": string(r.Status)})
+		if result.Error != nil {
+			fmt.Printf("Failed to update refund %s: %v\n", r.ID, result.Error)
+			continue
+		}
+		if result.RowsAffected > 0 {
+			continue
+		}
+
+		var payment models.Payment
+		if err := h.db.Where("stripe_payment_id = ?", charge.PaymentIntent.ID).First(&payment).Error; err != nil {
+			fmt.Printf("No payment for refunded payment intent %s\n", charge.PaymentIntent.ID)
+			continue
+		}
+		refundID := r.ID
+		refund := models.Refund{
+			BookingID:      payment.BookingID,
+			PaymentID:      payment.ID,
+			StripeRefundID: &refundID,
+			Amount:         r.Amount,
+			Currency:       strings.ToUpper(string(r.Currency)),
+			Status:         string(r.Status),
+			Reason:         "stripe",
+		}
+		if err := h.db.Create(&refund).Error; err != nil {
+			fmt.Printf("Failed to record refund %s: %v\n", r.ID, err)
+		}
+	}
 }
 
 func (h *PaymentHandler) handlePaymentIntentSucceeded(paymentIntent *stripe.PaymentIntent) {
 	// This is handled by checkout.session.completed for now
 	fmt.Printf("Payment intent succeeded: %s\n", paymentIntent.ID)
 }
+
+// handlePaymentIntentFailed puts the booking on the failed payment queue so an
+// agent can help the customer pay.
+func (h *PaymentHandler) handlePaymentIntentFailed(paymentIntent *stripe.PaymentIntent) {
+	bookingID, err := strconv.ParseUint(paymentIntent.Metadata["booking_id"], 10, 32)
+	if err != nil {
+		fmt.Printf("No booking_id in payment intent %s metadata\n", paymentIntent.ID)
+		return
+	}
+
+	reason := "Card payment failed"
+	if paymentIntent.LastPaymentError != nil && paymentIntent.LastPaymentError.Msg != "" {
+		reason += ": " + paymentIntent.LastPaymentError.Msg
+	}
+	if err := placeOnQueue(h.db, uint(bookingID), models.QueueFailedPayment, reason); err != nil {
+		fmt.Printf("Failed to queue booking %d for a failed payment: %v\n", bookingID, err)
+	}
+}
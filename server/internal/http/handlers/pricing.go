@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/money"
+
+	"gorm.io/gorm"
+)
+
+var errPriceIncrease = errors.New("change increases the price of a paid booking")
+
+// settle converts an amount in the booking's fare currency into the currency
+// the customer pays in, using the rate recorded when the booking was made.
+func settle(booking *models.Booking, base money.Money) (money.Money, error) {
+	if booking.FXRate == "" {
+		return base, nil
+	}
+	asOf := time.Time{}
+	if booking.FXRateAsOf != nil {
+		asOf = *booking.FXRateAsOf
+	}
+	rate, err := fx.ParseRate(booking.BaseCurrency, booking.Currency, booking.FXRate, asOf)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return rate.Convert(base)
+}
+
+// adjustBase adds delta (in the fare currency) to the booking's base amount
+// and recomputes the settled total. The booking is updated in memory only.
+func adjustBase(booking *models.Booking, delta money.Money) error {
+	base, err := booking.Base().Add(delta)
+	if err != nil {
+		return err
+	}
+	total, err := settle(booking, base)
+	if err != nil {
+		return err
+	}
+	booking.BaseAmount = base.Amount
+	booking.TotalAmount = total.Amount
+	return nil
+}
+
+// priceBooking recomputes a booking's base amount from current fares, current
+// seat prices, its add-ons and special services, and any baggage bought before
+// the add-on catalog, less baggage that has been waived and the promotion
+// discount it was booked with.
+func priceBooking(db *gorm.DB, booking *models.Booking) (money.Money, error) {
+	partySize, err := countPassengers(db, booking.ID)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	var segments []models.Segment
+	if err := db.Preload("Fare").Preload("SeatAssignments.Seat").
+		Joins("JOIN itineraries ON itineraries.id = segments.itinerary_id").
+		Where("itineraries.booking_id = ?", booking.ID).
+		Find(&segments).Error; err != nil {
+		return money.Money{}, err
+	}
+
+	base := money.Zero(booking.Base().Currency)
+	for _, segment := range segments {
+		if base, err = base.Add(segment.FarePrice().Mul(int64(partySize))); err != nil {
+			return money.Money{}, err
+		}
+		for _, assignment := range segment.SeatAssignments {
+			if base, err = base.Add(seatPrice(assignment.Seat, base.Currency)); err != nil {
+				return money.Money{}, err
+			}
+		}
+	}
+
+	var baggage, waived int64
+	if err := db.Model(&models.Baggage{}).Where("booking_id = ? AND purchase_id IS NULL", booking.ID).
+		Select("COALESCE(SUM(price), 0)").Scan(&baggage).Error; err != nil {
+		return money.Money{}, err
+	}
+	if err := db.Model(&models.Waiver{}).Where("booking_id = ? AND type = ? AND status = ?", booking.ID, models.WaiverBaggage, models.WaiverApplied).
+		Select("COALESCE(SUM(amount), 0)").Scan(&waived).Error; err != nil {
+		return money.Money{}, err
+	}
+	ancillaries, err := bookingAncillaryCharges(db, booking.ID, base.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	ssrs, err := bookingSSRCharges(db, booking.ID, base.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	discount, err := bookingDiscount(db, booking.ID, base.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return money.New(base.Amount+baggage+ancillaries.Amount+ssrs.Amount-waived-discount.Amount, base.Currency), nil
+}
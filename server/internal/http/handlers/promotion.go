@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
+	"skyliner/internal/promotions"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PromotionHandler struct {
+	db *gorm.DB
+}
+
+func NewPromotionHandler(db *gorm.DB) *PromotionHandler {
+	return &PromotionHandler{db: db}
+}
+
+// PromotionRequest creates or replaces a promotion. See models.Promotion for
+// what each rule means.
+type PromotionRequest struct {
+	Code           string              `json:"code" binding:"required"`
+	Description    string              `json:"description"`
+	DiscountType   models.DiscountType `json:"discount_type" binding:"required"`
+	Value          int64               `json:"value" binding:"required"`
+	Currency       string              `json:"currency"`
+	Routes         []string            `json:"routes"`
+	Cabins         []string            `json:"cabins"`
+	FareTypes      []string            `json:"fare_types"`
+	TravelFrom     *time.Time          `json:"travel_from"`
+	TravelTo       *time.Time          `json:"travel_to"`
+	BookingFrom    *time.Time          `json:"booking_from"`
+	BookingTo      *time.Time          `json:"booking_to"`
+	MinSpend       int64               `json:"min_spend"`
+	FirstBooking   bool                `json:"first_booking_only"`
+	MaxUses        int                 `json:"max_uses"`
+	MaxUsesPerUser int                 `json:"max_uses_per_user"`
+	Active         *bool               `json:"active"` // defaults to true
+}
+
+func (r PromotionRequest) promotion() models.Promotion {
+	active := r.Active == nil || *r.Active
+	return models.Promotion{
+		Code:           promotions.NormalizeCode(r.Code),
+		Description:    r.Description,
+		DiscountType:   r.DiscountType,
+		Value:          r.Value,
+		Currency:       strings.ToUpper(r.Currency),
+		Routes:         r.Routes,
+		Cabins:         r.Cabins,
+		FareTypes:      r.FareTypes,
+		TravelFrom:     r.TravelFrom,
+		TravelTo:       r.TravelTo,
+		BookingFrom:    r.BookingFrom,
+		BookingTo:      r.BookingTo,
+		MinSpend:       r.MinSpend,
+		FirstBooking:   r.FirstBooking,
+		MaxUses:        r.MaxUses,
+		MaxUsesPerUser: r.MaxUsesPerUser,
+		Active:         active,
+	}
+}
+
+// GetPromotions lists every promotion with how often it has been used.
+func (h *PromotionHandler) GetPromotions(c *gin.Context) {
+	var promos []models.Promotion
+	if err := h.db.Order("id").Find(&promos).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promotions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"promotions": promos})
+}
+
+// CreatePromotion starts a campaign. Admins only.
+func (h *PromotionHandler) CreatePromotion(c *gin.Context) {
+	if c.GetString("role") != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+
+	var req PromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	promo := req.promotion()
+	if err := promotions.Validate(promo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.db.Create(&promo).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Promo code already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create promotion"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"promotion": promo})
+}
+
+// UpdatePromotion replaces a promotion's rules, e.g. to end a campaign early
+// with active false. Its usage count is kept. Admins only.
+func (h *PromotionHandler) UpdatePromotion(c *gin.Context) {
+	if c.GetString("role") != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+
+	promoID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promotion ID"})
+		return
+	}
+	var req PromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	update := req.promotion()
+	if err := promotions.Validate(update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var promo models.Promotion
+	if err := h.db.First(&promo, uint(promoID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promotion"})
+		return
+	}
+	update.ID = promo.ID
+	update.Uses = promo.Uses
+	update.CreatedAt = promo.CreatedAt
+
+	if err := h.db.Save(&update).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Promo code already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update promotion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"promotion": update})
+}
+
+// applyPromotion prices code against a new booking and, if it qualifies,
+// records the discount and takes it off the booking's totals. segments must
+// have their fares loaded. Usage limits are claimed here, inside the booking's
+// transaction, so a code cannot be used more often than allowed.
+func applyPromotion(tx *gorm.DB, booking *models.Booking, code string, segments []models.Segment, partySize int, now time.Time) (*models.PromotionRedemption, error) {
+	var promo models.Promotion
+	if err := tx.Where("code = ?", promotions.NormalizeCode(code)).First(&promo).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: unknown code", promotions.ErrNotEligible)
+		}
+		return nil, err
+	}
+
+	lines := make([]promotions.Line, len(segments))
+	for i, segment := range segments {
+		var flight models.Flight
+		if err := tx.Preload("Origin").Preload("Destination").First(&flight, segment.FlightID).Error; err != nil {
+			return nil, err
+		}
+		lines[i] = promotions.Line{
+			Route:     flight.Origin.Code + "-" + flight.Destination.Code,
+			Cabin:     segment.Fare.Class,
+			FareType:  segment.Fare.FareType,
+			Departure: flight.DepartureTime,
+			Amount:    segment.FarePrice().Mul(int64(partySize)),
+		}
+	}
+
+	var others int64
+	if err := tx.Model(&models.Booking{}).
+		Where("user_id = ? AND id <> ? AND status <> ?", booking.UserID, booking.ID, models.StatusCancelled).
+		Count(&others).Error; err != nil {
+		return nil, err
+	}
+
+	discount, err := promotions.Discount(promo, promotions.Booking{
+		Lines:        lines,
+		Subtotal:     booking.Base(),
+		FirstBooking: others == 0,
+		Now:          now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := countPromotionUse(tx, promo, booking.UserID); err != nil {
+		return nil, err
+	}
+	result := tx.Model(&models.Promotion{}).
+		Where("id = ? AND (max_uses = 0 OR uses < max_uses)", promo.ID).
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: code has been fully used", promotions.ErrNotEligible)
+	}
+
+	redemption := models.PromotionRedemption{
+		PromotionID: promo.ID,
+		BookingID:   booking.ID,
+		UserID:      booking.UserID,
+		Code:        promo.Code,
+		Description: promo.Description,
+		Amount:      discount.Amount,
+		Currency:    discount.Currency,
+	}
+	if err := tx.Create(&redemption).Error; err != nil {
+		return nil, err
+	}
+
+	if err := adjustBase(booking, discount.Neg()); err != nil {
+		return nil, err
+	}
+	if err := tx.Model(booking).Updates(map[string]interface{}{
+		"base_amount":  booking.BaseAmount,
+		"total_amount": booking.TotalAmount,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return &redemption, nil
+}
+
+// releasePromotion gives back the use of a promo code on a booking that was
+// cancelled before it was paid.
+func releasePromotion(tx *gorm.DB, bookingID uint) error {
+	var redemption models.PromotionRedemption
+	if err := tx.Where("booking_id = ?", bookingID).First(&redemption).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if err := tx.Delete(&redemption).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.PromotionUsage{}).
+		Where("promotion_id = ? AND user_id = ? AND uses > 0", redemption.PromotionID, redemption.UserID).
+		Update("uses", gorm.Expr("uses - 1")).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.Promotion{}).Where("id = ? AND uses > 0", redemption.PromotionID).
+		Update("uses", gorm.Expr("uses - 1")).Error
+}
+
+// countPromotionUse adds one to the user's uses of a promotion, unless they
+// are at its per-user limit. Like the overall limit it is a conditional
+// update, so concurrent checkouts cannot both take the last use.
+func countPromotionUse(tx *gorm.DB, promo models.Promotion, userID uint) error {
+	// Redemptions from before uses were counted per user are the start
+	var redeemed int64
+	if err := tx.Model(&models.PromotionRedemption{}).
+		Where("promotion_id = ? AND user_id = ?", promo.ID, userID).
+		Count(&redeemed).Error; err != nil {
+		return err
+	}
+	usage := models.PromotionUsage{PromotionID: promo.ID, UserID: userID, Uses: int(redeemed)}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&usage).Error; err != nil {
+		return err
+	}
+
+	query := tx.Model(&models.PromotionUsage{}).Where("promotion_id = ? AND user_id = ?", promo.ID, userID)
+	if promo.MaxUsesPerUser > 0 {
+		query = query.Where("uses < ?", promo.MaxUsesPerUser)
+	}
+	result := query.Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: you have already used this code", promotions.ErrNotEligible)
+	}
+	return nil
+}
+
+// bookingDiscount is the promotion discount taken off a booking, in its fare
+// currency.
+func bookingDiscount(db *gorm.DB, bookingID uint, currency string) (money.Money, error) {
+	var amount int64
+	if err := db.Model(&models.PromotionRedemption{}).
+		Where("booking_id = ? AND currency = ?", bookingID, currency).
+		Select("COALESCE(SUM(amount), 0)").Scan(&amount).Error; err != nil {
+		return money.Money{}, err
+	}
+	return money.New(amount, currency), nil
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/payments"
+	"skyliner/internal/promotions"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupPromotionTestRouter(db *gorm.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	db.Create(&models.User{Email: "agent@example.com", PasswordHash: "x", Role: models.RoleAgent})
+	db.Create(&models.User{Email: "admin@example.com", PasswordHash: "x", Role: models.RoleAdmin})
+	promotionHandler := NewPromotionHandler(db)
+
+	router := gin.New()
+	admin := router.Group("/admin")
+	admin.Use(testStaffRole)
+	admin.GET("/promotions", promotionHandler.GetPromotions)
+	admin.POST("/promotions", promotionHandler.CreatePromotion)
+	admin.PUT("/promotions/:id", promotionHandler.UpdatePromotion)
+	return router
+}
+
+func bookWithPromo(router *gin.Engine, code string) (int, string) {
+	w := postBooking(router, map[string]interface{}{
+		"segments":   []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers": []map[string]interface{}{{"first_name": "Ada", "last_name": "Lovelace"}, {"first_name": "Alan", "last_name": "Turing"}},
+		"promo_code": code,
+	})
+	return w.Code, w.Body.String()
+}
+
+func TestPromotionHandler_CreatePromotion(t *testing.T) {
+	db := setupBookingTestDB()
+	admin := setupPromotionTestRouter(db)
+
+	promo := gin.H{"code": " spring10 ", "discount_type": "percent", "value": 1000}
+	w := adminRequest(admin, "POST", "/admin/promotions", promo, false)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = adminRequest(admin, "POST", "/admin/promotions", gin.H{"code": "BAD", "discount_type": "fixed", "value": 500}, true)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = adminRequest(admin, "POST", "/admin/promotions", promo, true)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"code":"SPRING10"`)
+	w = adminRequest(admin, "POST", "/admin/promotions", promo, true)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Ending the campaign keeps it listed
+	promo["active"] = false
+	w = adminRequest(admin, "PUT", "/admin/promotions/1", promo, true)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = adminRequest(admin, "GET", "/admin/promotions", nil, false)
+	assert.Contains(t, w.Body.String(), `"active":false`)
+}
+
+func TestBookingHandler_CreateBookingWithPromo(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupBookingTestRouter(db)
+	db.Create(&models.Promotion{Code: "LONDON10", Description: "10% off to London", DiscountType: models.DiscountPercent, Value: 1000,
+		Routes: []string{"JFK-LHR"}, MaxUsesPerUser: 1, Active: true})
+
+	code, body := bookWithPromo(router, "london10")
+	assert.Equal(t, http.StatusCreated, code, body)
+	assert.Contains(t, body, `"total_amount":{"amount":71998,"currency":"USD"}`)
+	assert.Contains(t, body, `"discounts":[{`)
+	assert.Contains(t, body, `"code":"LONDON10","description":"10% off to London","amount":8000`)
+
+	var promo models.Promotion
+	db.First(&promo)
+	assert.Equal(t, 1, promo.Uses)
+
+	// One use per customer
+	code, body = bookWithPromo(router, "LONDON10")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Contains(t, body, "already used")
+
+	// Cancelling the unpaid booking gives the use back
+	w := cancelBooking(setupRefundTestRouter(db, payments.NewFakeClient()), "1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	db.First(&promo)
+	assert.Equal(t, 0, promo.Uses)
+	code, body = bookWithPromo(router, "LONDON10")
+	assert.Equal(t, http.StatusCreated, code, body)
+	var usage models.PromotionUsage
+	db.First(&usage, "promotion_id = ? AND user_id = ?", promo.ID, 1)
+	assert.Equal(t, 1, usage.Uses)
+}
+
+func TestCountPromotionUse(t *testing.T) {
+	db := setupBookingTestDB()
+	promo := models.Promotion{Code: "TWICE", DiscountType: models.DiscountPercent, Value: 1000, MaxUsesPerUser: 2, Active: true}
+	db.Create(&promo)
+
+	// A redemption from before uses were counted per user still counts
+	db.Create(&models.PromotionRedemption{PromotionID: promo.ID, BookingID: 99, UserID: 1, Code: "TWICE", Amount: 100, Currency: "USD"})
+	assert.NoError(t, countPromotionUse(db, promo, 1))
+	assert.ErrorIs(t, countPromotionUse(db, promo, 1), promotions.ErrNotEligible)
+	assert.NoError(t, countPromotionUse(db, promo, 2))
+}
+
+func TestBookingHandler_PromoLimits(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupBookingTestRouter(db)
+	db.Create(&models.Promotion{Code: "ONCE", DiscountType: models.DiscountFixed, Value: 5000, Currency: "USD", MaxUses: 1, Active: true})
+	db.Create(&models.Promotion{Code: "WELCOME", DiscountType: models.DiscountPercent, Value: 500, FirstBooking: true, Active: true})
+	db.Create(&models.Promotion{Code: "BIGSPEND", DiscountType: models.DiscountPercent, Value: 500, Currency: "USD", MinSpend: 100000, Active: true})
+
+	code, body := bookWithPromo(router, "NOPE")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Contains(t, body, "unknown code")
+	code, body = bookWithPromo(router, "BIGSPEND")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Contains(t, body, "minimum spend of 1000.00 USD")
+
+	code, body = bookWithPromo(router, "ONCE")
+	assert.Equal(t, http.StatusCreated, code, body)
+	assert.Contains(t, body, `"total_amount":{"amount":74998,"currency":"USD"}`)
+
+	code, body = bookWithPromo(router, "ONCE")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Contains(t, body, "fully used")
+	code, body = bookWithPromo(router, "WELCOME")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Contains(t, body, "first booking")
+
+	// A rejected code leaves no booking or held inventory behind
+	var bookings int64
+	db.Model(&models.Booking{}).Count(&bookings)
+	assert.Equal(t, int64(1), bookings)
+	assert.Equal(t, 28, fareAvailable(db, 1))
+}
+
+func TestBookingHandler_PromoRefund(t *testing.T) {
+	db := setupBookingTestDB()
+	pay := payments.NewFakeClient()
+	db.Create(&models.Promotion{Code: "HALF", DiscountType: models.DiscountPercent, Value: 5000, Active: true})
+	code, body := bookWithPromo(setupBookingTestRouter(db), "HALF")
+	assert.Equal(t, http.StatusCreated, code, body)
+	db.Model(&models.Booking{}).Where("id = ?", 1).Update("status", models.StatusPaid)
+	db.Create(&models.Payment{BookingID: 1, StripePaymentID: "pi_booking", Amount: 39999, Currency: "USD", Status: "succeeded"})
+
+	// The 25% fee is kept from the fares as filed, but only the discounted
+	// fares are given back
+	w := cancelBooking(setupRefundTestRouter(db, pay), "1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"fare_value":{"amount":39999,"currency":"USD"}`)
+	if assert.Len(t, pay.Refunds, 1) {
+		assert.Equal(t, int64(39999-20000), pay.Refunds[0].Amount.Amount)
+	}
+}
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/ws"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RemarkRequest struct {
+	Text       string                  `json:"text" binding:"required,max=2000"`
+	Visibility models.RemarkVisibility `json:"visibility" binding:"omitempty,oneof=internal customer"` // defaults to internal
+}
+
+type CompleteQueueItemRequest struct {
+	Resolution string `json:"resolution" binding:"max=2000"`
+}
+
+// RescheduleFlightRequest gives a flight new times.
+type RescheduleFlightRequest struct {
+	DepartureTime time.Time `json:"departure_time" binding:"required"`
+	ArrivalTime   time.Time `json:"arrival_time" binding:"required"`
+}
+
+type QueueSummary struct {
+	Queue   models.QueueName `json:"queue"`
+	Open    int64            `json:"open"`
+	Claimed int64            `json:"claimed"`
+}
+
+var (
+	errQueueItemClaimed   = errors.New("queue item is claimed by another agent")
+	errQueueItemCompleted = errors.New("queue item is already completed")
+	errQueueItemUnclaimed = errors.New("queue item must be claimed first")
+)
+
+// AddRemark notes something on a booking. Customer remarks are shown to the
+// traveler with the booking; internal ones only to agents.
+func (h *BookingHandler) AddRemark(c *gin.Context) {
+	bookingID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID"})
+		return
+	}
+	var req RemarkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Visibility == "" {
+		req.Visibility = models.RemarkInternal
+	}
+
+	var booking models.Booking
+	if err := h.db.First(&booking, uint(bookingID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return
+	}
+
+	authorID := c.GetUint("user_id")
+	remark := models.BookingRemark{
+		BookingID:  booking.ID,
+		Visibility: req.Visibility,
+		Text:       req.Text,
+		AuthorID:   &authorID,
+	}
+	if err := h.db.Create(&remark).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add remark"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"remark": remark})
+}
+
+// GetRemarks lists all of a booking's remarks, oldest first.
+func (h *BookingHandler) GetRemarks(c *gin.Context) {
+	bookingID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID"})
+		return
+	}
+	var remarks []models.BookingRemark
+	if err := h.db.Where("booking_id = ?", uint(bookingID)).Order("id").Find(&remarks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch remarks"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"remarks": remarks})
+}
+
+// GetQueues counts the work waiting on each agent queue.
+func (h *BookingHandler) GetQueues(c *gin.Context) {
+	var counts []struct {
+		Queue  models.QueueName
+		Status models.QueueItemStatus
+		Count  int64
+	}
+	if err := h.db.Model(&models.QueueItem{}).Select("queue, status, COUNT(*) AS count").
+		Where("status <> ?", models.QueueItemCompleted).Group("queue, status").Scan(&counts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch queues"})
+		return
+	}
+
+	summaries := make([]QueueSummary, len(models.Queues))
+	for i, queue := range models.Queues {
+		summaries[i].Queue = queue
+		for _, count := range counts {
+			switch {
+			case count.Queue != queue:
+			case count.Status == models.QueueItemOpen:
+				summaries[i].Open = count.Count
+			case count.Status == models.QueueItemClaimed:
+				summaries[i].Claimed = count.Count
+			}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"queues": summaries})
+}
+
+// GetQueueItems lists a queue's items, oldest first, with their bookings.
+// Without ?status= it shows the work still to do; ?mine=true limits it to
+// items the caller has claimed.
+func (h *BookingHandler) GetQueueItems(c *gin.Context) {
+	queue := models.QueueName(c.Param("queue"))
+	known := false
+	for _, q := range models.Queues {
+		known = known || q == queue
+	}
+	if !known {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Queue not found"})
+		return
+	}
+
+	query := h.db.Preload("Booking").Where("queue = ?", queue).Order("id")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	} else {
+		query = query.Where("status <> ?", models.QueueItemCompleted)
+	}
+	if c.Query("mine") == "true" {
+		query = query.Where("claimed_by = ?", c.GetUint("user_id"))
+	}
+
+	var items []models.QueueItem
+	if err := query.Find(&items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch queue"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// ClaimQueueItem assigns an open item to the calling agent. Claiming an item
+// the agent already holds is a no-op.
+func (h *BookingHandler) ClaimQueueItem(c *gin.Context) {
+	agentID := c.GetUint("user_id")
+	h.updateQueueItem(c, func(tx *gorm.DB, item *models.QueueItem) error {
+		switch {
+		case item.Status == models.QueueItemCompleted:
+			return errQueueItemCompleted
+		case item.Status == models.QueueItemClaimed && *item.ClaimedBy == agentID:
+			return nil
+		case item.Status == models.QueueItemClaimed:
+			return errQueueItemClaimed
+		}
+		// Another agent may have claimed it since it was read
+		result := tx.Model(item).Where("status = ?", models.QueueItemOpen).Updates(map[string]interface{}{
+			"status":     models.QueueItemClaimed,
+			"claimed_by": agentID,
+			"claimed_at": time.Now(),
+		})
+		if result.Error == nil && result.RowsAffected == 0 {
+			return errQueueItemClaimed
+		}
+		return result.Error
+	})
+}
+
+// CompleteQueueItem closes an item the calling agent has claimed. Admins may
+// close anyone's.
+func (h *BookingHandler) CompleteQueueItem(c *gin.Context) {
+	var req CompleteQueueItemRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	agentID := c.GetUint("user_id")
+	isAdmin := c.GetString("role") == string(models.RoleAdmin)
+	h.updateQueueItem(c, func(tx *gorm.DB, item *models.QueueItem) error {
+		switch {
+		case item.Status == models.QueueItemCompleted:
+			return errQueueItemCompleted
+		case item.Status == models.QueueItemOpen:
+			return errQueueItemUnclaimed
+		case *item.ClaimedBy != agentID && !isAdmin:
+			return errQueueItemClaimed
+		}
+		result := tx.Model(item).Where("status = ?", models.QueueItemClaimed).Updates(map[string]interface{}{
+			"status":       models.QueueItemCompleted,
+			"completed_by": agentID,
+			"completed_at": time.Now(),
+			"resolution":   req.Resolution,
+		})
+		if result.Error == nil && result.RowsAffected == 0 {
+			return errQueueItemCompleted
+		}
+		return result.Error
+	})
+}
+
+func (h *BookingHandler) updateQueueItem(c *gin.Context, update func(tx *gorm.DB, item *models.QueueItem) error) {
+	itemID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid queue item ID"})
+		return
+	}
+
+	var item models.QueueItem
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&item, uint(itemID)).Error; err != nil {
+			return err
+		}
+		return update(tx, &item)
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Queue item not found"})
+		return
+	case errors.Is(err, errQueueItemClaimed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Queue item is claimed by another agent"})
+		return
+	case errors.Is(err, errQueueItemCompleted):
+		c.JSON(http.StatusConflict, gin.H{"error": "Queue item is already completed"})
+		return
+	case errors.Is(err, errQueueItemUnclaimed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Queue item must be claimed first"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update queue item"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"item": item})
+}
+
+// RescheduleFlight gives a flight new times. Every live booking on it is put
+// on the schedule change queue for an agent to contact the traveler, and
+// gets a customer remark with the new departure.
+func (h *BookingHandler) RescheduleFlight(c *gin.Context) {
+	if c.GetString("role") != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+
+	flightID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid flight ID"})
+		return
+	}
+	var req RescheduleFlightRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.ArrivalTime.After(req.DepartureTime) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Arrival must be after departure"})
+		return
+	}
+
+	var flight models.Flight
+	if err := h.db.Preload("Origin").First(&flight, uint(flightID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Flight not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch flight"})
+		return
+	}
+	if !flight.DepartureTime.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Flight has departed"})
+		return
+	}
+
+	local := flight.Origin.Location()
+	reason := fmt.Sprintf("%s now departs %s, was %s", flight.Number,
+		req.DepartureTime.In(local).Format("2 Jan 2006 15:04"), flight.DepartureTime.In(local).Format("2 Jan 2006 15:04"))
+
+	var bookings []models.Booking
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&flight).Updates(map[string]interface{}{
+			"departure_time": req.DepartureTime,
+			"arrival_time":   req.ArrivalTime,
+			"duration":       int(req.ArrivalTime.Sub(req.DepartureTime).Minutes()),
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("status IN ?", []models.BookingStatus{models.StatusHold, models.StatusPaid, models.StatusTicketed}).
+			Where("id IN (?)", tx.Model(&models.Itinerary{}).Select("itineraries.booking_id").
+				Joins("JOIN segments ON segments.itinerary_id = itineraries.id").
+				Where("segments.flight_id = ?", flight.ID)).
+			Find(&bookings).Error; err != nil {
+			return err
+		}
+		for _, booking := range bookings {
+			if err := placeOnQueue(tx, booking.ID, models.QueueScheduleChange, reason); err != nil {
+				return err
+			}
+			remark := models.BookingRemark{BookingID: booking.ID, Visibility: models.RemarkCustomer, Text: "Schedule change: " + reason}
+			if err := tx.Create(&remark).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update flight"})
+		return
+	}
+
+	if h.events != nil {
+		for _, booking := range bookings {
+			h.events.Publish(ws.NewBookingStatusEvent(booking.ID, booking.PNR, string(booking.Status), "Schedule change: "+reason))
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"flight": flight, "bookings_affected": len(bookings)})
+}
+
+// placeOnQueue puts a booking on an agent queue, unless it is already waiting
+// there.
+func placeOnQueue(tx *gorm.DB, bookingID uint, queue models.QueueName, reason string) error {
+	var waiting int64
+	if err := tx.Model(&models.QueueItem{}).
+		Where("booking_id = ? AND queue = ? AND status <> ?", bookingID, queue, models.QueueItemCompleted).
+		Count(&waiting).Error; err != nil || waiting > 0 {
+		return err
+	}
+	return tx.Create(&models.QueueItem{
+		BookingID: bookingID,
+		Queue:     queue,
+		Status:    models.QueueItemOpen,
+		Reason:    reason,
+	}).Error
+}
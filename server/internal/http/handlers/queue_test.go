@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v76"
+	"gorm.io/gorm"
+)
+
+// setupQueueTest serves the traveler routes as user 1 and the admin routes as
+// the agent, or the admin with X-Test-Admin.
+func setupQueueTest(db *gorm.DB) (*BookingHandler, *gin.Engine, *recordedEvents) {
+	gin.SetMode(gin.TestMode)
+	db.Create(&models.User{Email: "agent@example.com", PasswordHash: "x", Role: models.RoleAgent})
+	db.Create(&models.User{Email: "admin@example.com", PasswordHash: "x", Role: models.RoleAdmin})
+	db.Create(&models.SSRType{Code: "WCHR", Name: "Wheelchair to the aircraft door", Category: models.SSRMobility})
+	db.Create(&models.AirlineSSR{AirlineID: 1, Code: "WCHR"})
+
+	events := &recordedEvents{}
+	h := NewBookingHandler(db, &config.Config{}, fx.Default(), payments.NewFakeClient(), events)
+
+	router := gin.New()
+	protected := router.Group("")
+	protected.Use(func(c *gin.Context) {
+		c.Set("user_id", uint(1))
+		c.Next()
+	})
+	protected.POST("/bookings", h.CreateBooking)
+	protected.GET("/bookings/:id", h.GetBooking)
+
+	admin := router.Group("/admin")
+	admin.Use(testStaffRole)
+	admin.GET("/bookings/:id/remarks", h.GetRemarks)
+	admin.POST("/bookings/:id/remarks", h.AddRemark)
+	admin.GET("/queues", h.GetQueues)
+	admin.GET("/queues/:queue", h.GetQueueItems)
+	admin.POST("/queue-items/:id/claim", h.ClaimQueueItem)
+	admin.POST("/queue-items/:id/complete", h.CompleteQueueItem)
+	admin.PUT("/flights/:id/schedule", h.RescheduleFlight)
+	return h, router, events
+}
+
+func queueItems(db *gorm.DB, queue models.QueueName) []models.QueueItem {
+	var items []models.QueueItem
+	db.Where("queue = ?", queue).Order("id").Find(&items)
+	return items
+}
+
+func TestBookingHandler_RemarksAndQueues(t *testing.T) {
+	db := setupBookingTestDB()
+	_, router, _ := setupQueueTest(db)
+	booking := createTestBooking(t, router, ssrBooking(map[string]interface{}{"passenger": 0, "segment": 0, "code": "WCHR"}))
+
+	// The wheelchair request waits for an agent
+	items := queueItems(db, models.QueueSSRPending)
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, booking.ID, items[0].BookingID)
+		assert.Equal(t, "WCHR requested", items[0].Reason)
+	}
+	w := adminRequest(router, "GET", "/admin/queues", nil, false)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var summary struct {
+		Queues []QueueSummary `json:"queues"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &summary))
+	assert.Contains(t, summary.Queues, QueueSummary{Queue: models.QueueSSRPending, Open: 1})
+	assert.Contains(t, summary.Queues, QueueSummary{Queue: models.QueueScheduleChange})
+
+	// Only customer remarks are shown to the traveler
+	w = adminRequest(router, "POST", "/admin/bookings/1/remarks", gin.H{"text": "Called the traveler about the wheelchair"}, false)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = adminRequest(router, "POST", "/admin/bookings/1/remarks", gin.H{"text": "Wheelchair confirmed with the airport", "visibility": "customer"}, true)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = adminRequest(router, "POST", "/admin/bookings/1/remarks", gin.H{"text": "x", "visibility": "public"}, false)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = adminRequest(router, "POST", "/admin/bookings/99/remarks", gin.H{"text": "x"}, false)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = request(router, "GET", "/bookings/1")
+	var response BookingResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	if assert.Len(t, response.Booking.Remarks, 1) {
+		assert.Equal(t, "Wheelchair confirmed with the airport", response.Booking.Remarks[0].Text)
+	}
+	w = adminRequest(router, "GET", "/admin/bookings/1/remarks", nil, false)
+	var remarks struct {
+		Remarks []models.BookingRemark `json:"remarks"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &remarks))
+	if assert.Len(t, remarks.Remarks, 2) {
+		assert.Equal(t, models.RemarkInternal, remarks.Remarks[0].Visibility)
+		assert.Equal(t, agentID, *remarks.Remarks[0].AuthorID)
+	}
+
+	// Work is claimed before it is completed, by one agent at a time
+	path := "/admin/queue-items/1"
+	w = adminRequest(router, "POST", path+"/complete", nil, false)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = adminRequest(router, "POST", path+"/claim", nil, false)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = adminRequest(router, "POST", path+"/claim", nil, true)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = adminRequest(router, "POST", path+"/claim", nil, false)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = adminRequest(router, "GET", "/admin/queues/ssr_pending?mine=true", nil, false)
+	var list struct {
+		Items []models.QueueItem `json:"items"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	if assert.Len(t, list.Items, 1) && assert.NotNil(t, list.Items[0].Booking) {
+		assert.Equal(t, booking.PNR, list.Items[0].Booking.PNR)
+		assert.Equal(t, models.QueueItemClaimed, list.Items[0].Status)
+	}
+
+	w = adminRequest(router, "POST", path+"/complete", gin.H{"resolution": "Confirmed"}, false)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = adminRequest(router, "POST", path+"/complete", nil, false)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	item := queueItems(db, models.QueueSSRPending)[0]
+	assert.Equal(t, models.QueueItemCompleted, item.Status)
+	assert.Equal(t, "Confirmed", item.Resolution)
+	if assert.NotNil(t, item.CompletedBy) {
+		assert.Equal(t, agentID, *item.CompletedBy)
+	}
+
+	w = adminRequest(router, "GET", "/admin/queues/ssr_pending", nil, false)
+	assert.JSONEq(t, `{"items":[]}`, w.Body.String())
+	w = adminRequest(router, "GET", "/admin/queues/lost_bags", nil, false)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestBookingHandler_QueuedBySystemEvents(t *testing.T) {
+	db := setupBookingTestDB()
+	h, router, events := setupQueueTest(db)
+	booking := createTestBooking(t, router, nil)
+
+	// A retimed flight puts its bookings on the schedule change queue
+	departure := time.Now().Add(31 * 24 * time.Hour).Truncate(time.Minute)
+	body := gin.H{"departure_time": departure, "arrival_time": departure.Add(7*time.Hour + 30*time.Minute)}
+	w := adminRequest(router, "PUT", "/admin/flights/1/schedule", body, false)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = adminRequest(router, "PUT", "/admin/flights/1/schedule", gin.H{"departure_time": departure, "arrival_time": departure}, true)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = adminRequest(router, "PUT", "/admin/flights/1/schedule", body, true)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var flight models.Flight
+	db.First(&flight, 1)
+	assert.True(t, departure.Equal(flight.DepartureTime))
+	assert.Equal(t, 450, flight.Duration)
+	if items := queueItems(db, models.QueueScheduleChange); assert.Len(t, items, 1) {
+		assert.Equal(t, booking.ID, items[0].BookingID)
+		assert.Contains(t, items[0].Reason, "BA200 now departs")
+	}
+	var remark models.BookingRemark
+	db.First(&remark)
+	assert.Equal(t, models.RemarkCustomer, remark.Visibility)
+	assert.Nil(t, remark.AuthorID)
+	assert.Len(t, events.list(), 1)
+
+	// A hold that ran out is chased once
+	db.Model(&models.Booking{}).Where("id = ?", booking.ID).Update("hold_expires_at", time.Now().Add(-time.Hour))
+	assert.NoError(t, h.sweep(time.Now()))
+	assert.NoError(t, h.sweep(time.Now()))
+	if items := queueItems(db, models.QueueUnpaidHold); assert.Len(t, items, 1) {
+		assert.Contains(t, items[0].Reason, "without payment")
+	}
+
+	// So is a declined card
+	pay := NewPaymentHandler(db, &config.Config{})
+	pay.handlePaymentIntentFailed(&stripe.PaymentIntent{
+		ID:               "pi_declined",
+		Metadata:         map[string]string{"booking_id": "1"},
+		LastPaymentError: &stripe.Error{Msg: "Your card was declined."},
+	})
+	if items := queueItems(db, models.QueueFailedPayment); assert.Len(t, items, 1) {
+		assert.Equal(t, "Card payment failed: Your card was declined.", items[0].Reason)
+	}
+}
@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/farerules"
+	"skyliner/internal/money"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errBookingCancelled = errors.New("booking already cancelled")
+	errTravelStarted    = errors.New("passengers have checked in")
+)
+
+// RefundQuote is what cancelling a booking now would return. Amounts are in
+// the booking's settlement currency.
+type RefundQuote struct {
+	Paid       money.Money `json:"paid"`       // collected so far, net of earlier refunds
+	FareValue  money.Money `json:"fare_value"` // fares for the whole party, less any promotion
+	Penalty    money.Money `json:"penalty"`    // kept under the fare rules, after waivers
+	Waived     money.Money `json:"waived"`
+	Refundable money.Money `json:"refundable"`
+	Credit     money.Money `json:"credit"` // non-refundable fare value kept as travel credit
+	WaiverIDs  []uint      `json:"waiver_ids,omitempty"`
+}
+
+// GetRefundQuote shows how much would be refunded if the booking were
+// cancelled now.
+func (h *BookingHandler) GetRefundQuote(c *gin.Context) {
+	booking, ok := h.loadOwnedBooking(c, h.db)
+	if !ok {
+		return
+	}
+
+	quote, err := quoteRefund(h.db, &booking, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate refund"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"refund": quote})
+}
+
+// quoteRefund applies the cancellation rules of each booked fare. Seats and
+// other extras are not refunded, and the refund never exceeds what was paid.
+func quoteRefund(db *gorm.DB, booking *models.Booking, now time.Time) (RefundQuote, error) {
+	currency := booking.Total().Currency
+	quote := RefundQuote{
+		Paid:       money.Zero(currency),
+		FareValue:  money.Zero(currency),
+		Penalty:    money.Zero(currency),
+		Waived:     money.Zero(currency),
+		Refundable: money.Zero(currency),
+		Credit:     money.Zero(currency),
+	}
+	if booking.Status == models.StatusHold || booking.Status == models.StatusCancelled {
+		return quote, nil
+	}
+
+	paid, err := netPaid(db, booking.ID, currency)
+	if err != nil {
+		return RefundQuote{}, err
+	}
+	quote.Paid = paid
+
+	partySize, err := countPassengers(db, booking.ID)
+	if err != nil {
+		return RefundQuote{}, err
+	}
+
+	var segments []models.Segment
+	if err := db.Preload("Flight").Preload("Fare").
+		Joins("JOIN itineraries ON itineraries.id = segments.itinerary_id").
+		Where("itineraries.booking_id = ?", booking.ID).
+		Find(&segments).Error; err != nil {
+		return RefundQuote{}, err
+	}
+
+	baseCurrency := booking.Base().Currency
+	fares := money.Zero(baseCurrency)
+	penalty := money.Zero(baseCurrency)
+	credit := money.Zero(baseCurrency)
+	for _, segment := range segments {
+		rules := farerules.For(segment.Fare.FareType)
+		fare := segment.FarePrice()
+		if fares, err = fares.Add(fare.Mul(int64(partySize))); err != nil {
+			return RefundQuote{}, err
+		}
+		kept := rules.CancellationPenalty(fare, segment.Flight.DepartureTime, now)
+		if penalty, err = penalty.Add(kept.Mul(int64(partySize))); err != nil {
+			return RefundQuote{}, err
+		}
+		if credit, err = credit.Add(rules.CancellationCredit(fare, segment.Flight.DepartureTime, now).Mul(int64(partySize))); err != nil {
+			return RefundQuote{}, err
+		}
+	}
+
+	// Fares are worth what was paid for them after any promotion
+	discount, err := bookingDiscount(db, booking.ID, baseCurrency)
+	if err != nil {
+		return RefundQuote{}, err
+	}
+	if fares, err = fares.Sub(discount); err != nil {
+		return RefundQuote{}, err
+	}
+
+	waived, waiverIDs, err := claimWaivers(db, booking.ID, models.WaiverCancellationPenalty, penalty)
+	if err != nil {
+		return RefundQuote{}, err
+	}
+	if penalty, err = penalty.Sub(waived); err != nil {
+		return RefundQuote{}, err
+	}
+	if quote.Waived, err = settle(booking, waived); err != nil {
+		return RefundQuote{}, err
+	}
+	quote.WaiverIDs = waiverIDs
+
+	if quote.FareValue, err = settle(booking, fares); err != nil {
+		return RefundQuote{}, err
+	}
+	if quote.Penalty, err = settle(booking, penalty); err != nil {
+		return RefundQuote{}, err
+	}
+
+	refundable, err := quote.FareValue.Sub(quote.Penalty)
+	if err != nil {
+		return RefundQuote{}, err
+	}
+	if refundable.Amount > paid.Amount {
+		refundable = paid
+	}
+	if refundable.IsNegative() {
+		refundable = money.Zero(currency)
+	}
+	quote.Refundable = refundable
+
+	// Credit makes up what the fare rules keep, never more than was paid
+	if quote.Credit, err = settle(booking, credit); err != nil {
+		return RefundQuote{}, err
+	}
+	if left := paid.Amount - refundable.Amount; quote.Credit.Amount > left {
+		quote.Credit = money.New(max(left, 0), currency)
+	}
+	return quote, nil
+}
+
+// netPaid is what has been collected on a booking less what has been, or is
+// being, refunded. Shares of payments moved by a split count for the booking
+// they were moved to.
+func netPaid(db *gorm.DB, bookingID uint, currency string) (money.Money, error) {
+	var paid, movedIn, movedOut, refunded int64
+	if err := db.Model(&models.Payment{}).
+		Where("booking_id = ? AND status = ? AND currency = ?", bookingID, "succeeded", currency).
+		Select("COALESCE(SUM(amount), 0)").Scan(&paid).Error; err != nil {
+		return money.Money{}, err
+	}
+	if err := db.Model(&models.PaymentAllocation{}).
+		Where("to_booking_id = ? AND currency = ?", bookingID, currency).
+		Select("COALESCE(SUM(amount), 0)").Scan(&movedIn).Error; err != nil {
+		return money.Money{}, err
+	}
+	if err := db.Model(&models.PaymentAllocation{}).
+		Where("from_booking_id = ? AND currency = ?", bookingID, currency).
+		Select("COALESCE(SUM(amount), 0)").Scan(&movedOut).Error; err != nil {
+		return money.Money{}, err
+	}
+	if err := db.Model(&models.Refund{}).
+		Where("booking_id = ? AND status IN ? AND currency = ?", bookingID, []string{payments.RefundPending, payments.RefundSucceeded}, currency).
+		Select("COALESCE(SUM(amount), 0)").Scan(&refunded).Error; err != nil {
+		return money.Money{}, err
+	}
+	return money.New(paid+movedIn-movedOut-refunded, currency), nil
+}
+
+// bookingPayments selects the succeeded payments in currency that a booking
+// has a share of: those made on it and those allocated to it by a split.
+func bookingPayments(db *gorm.DB, bookingID uint, currency string) *gorm.DB {
+	return db.Where("(booking_id = ? OR id IN (?)) AND status = ? AND currency = ?", bookingID,
+		db.Session(&gorm.Session{NewDB: true}).Model(&models.PaymentAllocation{}).Select("payment_id").Where("to_booking_id = ?", bookingID),
+		"succeeded", currency)
+}
+
+// paymentAvailable is what is left of a booking's share of a payment after
+// the booking's refunds, and never more than is left of the payment itself.
+func paymentAvailable(db *gorm.DB, payment models.Payment, bookingID uint) (int64, error) {
+	share := int64(0)
+	if payment.BookingID == bookingID {
+		share = payment.Amount
+	}
+	var movedIn, movedOut, refundedHere, refundedAll int64
+	if err := db.Model(&models.PaymentAllocation{}).Where("payment_id = ? AND to_booking_id = ?", payment.ID, bookingID).
+		Select("COALESCE(SUM(amount), 0)").Scan(&movedIn).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Model(&models.PaymentAllocation{}).Where("payment_id = ? AND from_booking_id = ?", payment.ID, bookingID).
+		Select("COALESCE(SUM(amount), 0)").Scan(&movedOut).Error; err != nil {
+		return 0, err
+	}
+	refunds := []string{payments.RefundPending, payments.RefundSucceeded}
+	if err := db.Model(&models.Refund{}).Where("payment_id = ? AND booking_id = ? AND status IN ?", payment.ID, bookingID, refunds).
+		Select("COALESCE(SUM(amount), 0)").Scan(&refundedHere).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Model(&models.Refund{}).Where("payment_id = ? AND status IN ?", payment.ID, refunds).
+		Select("COALESCE(SUM(amount), 0)").Scan(&refundedAll).Error; err != nil {
+		return 0, err
+	}
+	return min(share+movedIn-movedOut-refundedHere, payment.Amount-refundedAll), nil
+}
+
+// allocateRefund records pending refunds for amount against the booking's
+// card payments, most recent first, without exceeding what is left of its
+// share of each. Whatever the cards cannot take back, e.g. the part paid with
+// credit, is left for the caller.
+func allocateRefund(tx *gorm.DB, booking *models.Booking, amount money.Money, reason string) ([]models.Refund, error) {
+	var paymentsMade []models.Payment
+	if err := bookingPayments(tx, booking.ID, amount.Currency).Where("stripe_payment_id <> ''").
+		Order("id DESC").Find(&paymentsMade).Error; err != nil {
+		return nil, err
+	}
+
+	var refunds []models.Refund
+	remaining := amount.Amount
+	for _, payment := range paymentsMade {
+		if remaining <= 0 {
+			break
+		}
+		available, err := paymentAvailable(tx, payment, booking.ID)
+		if err != nil {
+			return nil, err
+		}
+		if available <= 0 {
+			continue
+		}
+		portion := min(available, remaining)
+
+		refund := models.Refund{
+			BookingID: booking.ID,
+			PaymentID: payment.ID,
+			Amount:    portion,
+			Currency:  amount.Currency,
+			Status:    payments.RefundPending,
+			Reason:    reason,
+		}
+		if err := tx.Create(&refund).Error; err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+		remaining -= portion
+	}
+	return refunds, nil
+}
+
+// issueRefunds sends recorded refunds to the payment provider. It runs after
+// the transaction that recorded them commits; a refund the provider rejects
+// is marked failed rather than undoing the cancellation or waiver behind it.
+func (h *BookingHandler) issueRefunds(ctx context.Context, refunds []models.Refund) {
+	for i := range refunds {
+		refund := &refunds[i]
+
+		var payment models.Payment
+		if err := h.db.First(&payment, refund.PaymentID).Error; err != nil {
+			log.Printf("Failed to load payment %d for refund %d: %v", refund.PaymentID, refund.ID, err)
+			continue
+		}
+
+		issued, err := h.payments.Refund(ctx, payments.RefundParams{
+			PaymentIntentID: payment.StripePaymentID,
+			Amount:          refund.Money(),
+			IdempotencyKey:  fmt.Sprintf("refund-%d", refund.ID),
+			Metadata: map[string]string{
+				"booking_id": fmt.Sprint(refund.BookingID),
+				"refund_id":  fmt.Sprint(refund.ID),
+			},
+		})
+		updates := map[string]interface{}{}
+		if err != nil {
+			log.Printf("Refund %d for booking %d failed: %v", refund.ID, refund.BookingID, err)
+			refund.Status = payments.RefundFailed
+		} else {
+			refund.Status = issued.Status
+			refund.StripeRefundID = &issued.ID
+			updates["stripe_refund_id"] = issued.ID
+		}
+		updates["status"] = refund.Status
+		if err := h.db.Model(refund).Updates(updates).Error; err != nil {
+			log.Printf("Failed to record refund %d: %v", refund.ID, err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v76"
+	"gorm.io/gorm"
+)
+
+func setupRefundTestRouter(db *gorm.DB, pay payments.Client) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	bookingHandler := NewBookingHandler(db, &config.Config{}, fx.Default(), pay, nil)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", uint(1))
+		c.Next()
+	})
+	router.GET("/bookings/:id/refund-quote", bookingHandler.GetRefundQuote)
+	router.POST("/bookings/:id/cancel", bookingHandler.CancelBooking)
+	return router
+}
+
+// paidBooking books one passenger on fare and records a Stripe payment for it.
+func paidBooking(t *testing.T, db *gorm.DB, fareID uint) models.Booking {
+	t.Helper()
+	booking := createTestBooking(t, setupBookingTestRouter(db), map[string]interface{}{
+		"segments":   []map[string]interface{}{{"flight_id": 1, "fare_id": fareID}},
+		"passengers": []map[string]interface{}{{"first_name": "Ada", "last_name": "Lovelace"}},
+	})
+	db.Model(&booking).Update("status", models.StatusPaid)
+	db.Create(&models.Payment{BookingID: booking.ID, StripePaymentID: "pi_booking", Amount: booking.TotalAmount, Currency: "USD", Status: "succeeded"})
+	return booking
+}
+
+func cancelBooking(router *gin.Engine, id string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/bookings/"+id+"/cancel", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestBookingHandler_CancelRefundsPaidBooking(t *testing.T) {
+	db := setupBookingTestDB()
+	pay := payments.NewFakeClient()
+	router := setupRefundTestRouter(db, pay)
+	paidBooking(t, db, 1)
+
+	// Standard fares keep a 25% cancellation fee
+	req, _ := http.NewRequest("GET", "/bookings/1/refund-quote", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"refundable":{"amount":29999,"currency":"USD"}`)
+
+	w = cancelBooking(router, "1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	if assert.Len(t, pay.Refunds, 1) {
+		assert.Equal(t, "pi_booking", pay.Refunds[0].PaymentIntentID)
+		assert.Equal(t, int64(29999), pay.Refunds[0].Amount.Amount)
+	}
+	var refund models.Refund
+	db.First(&refund)
+	assert.Equal(t, payments.RefundSucceeded, refund.Status)
+	assert.Equal(t, "re_fake_1", *refund.StripeRefundID)
+
+	var booking models.Booking
+	db.First(&booking, 1)
+	assert.Equal(t, models.StatusCancelled, booking.Status)
+}
+
+func TestBookingHandler_CancelSplitsRefundAcrossPayments(t *testing.T) {
+	db := setupBookingTestDB()
+	pay := payments.NewFakeClient()
+	router := setupRefundTestRouter(db, pay)
+	paidBooking(t, db, 1)
+	db.Create(&models.Payment{BookingID: 1, StripePaymentID: "pi_exchange", Amount: 16000, Currency: "USD", Status: "succeeded"})
+
+	w := cancelBooking(router, "1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// The most recent payment is refunded first
+	if assert.Len(t, pay.Refunds, 2) {
+		assert.Equal(t, "pi_exchange", pay.Refunds[0].PaymentIntentID)
+		assert.Equal(t, int64(16000), pay.Refunds[0].Amount.Amount)
+		assert.Equal(t, "pi_booking", pay.Refunds[1].PaymentIntentID)
+		assert.Equal(t, int64(13999), pay.Refunds[1].Amount.Amount)
+	}
+}
+
+func TestBookingHandler_CancelNonRefundable(t *testing.T) {
+	db := setupBookingTestDB()
+	db.Create(&models.Fare{FlightID: 1, Class: "economy", FareType: "basic", BasePrice: 19999, Currency: "USD", Available: 10})
+	pay := payments.NewFakeClient()
+	router := setupRefundTestRouter(db, pay)
+	paidBooking(t, db, 2)
+
+	w := cancelBooking(router, "1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, pay.Refunds)
+
+	var count int64
+	db.Model(&models.Refund{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestBookingHandler_CancelRefundFailure(t *testing.T) {
+	db := setupBookingTestDB()
+	pay := payments.NewFakeClient()
+	pay.Err = errors.New("card_declined")
+	router := setupRefundTestRouter(db, pay)
+	paidBooking(t, db, 1)
+
+	w := cancelBooking(router, "1")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var refund models.Refund
+	db.First(&refund)
+	assert.Equal(t, payments.RefundFailed, refund.Status)
+
+	var booking models.Booking
+	db.First(&booking, 1)
+	assert.Equal(t, models.StatusCancelled, booking.Status)
+}
+
+func TestPaymentHandler_ChargeRefunded(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupRefundTestRouter(db, payments.NewFakeClient())
+	paidBooking(t, db, 1)
+	cancelBooking(router, "1")
+
+	h := NewPaymentHandler(db, &config.Config{})
+	h.handleChargeRefunded(&stripe.Charge{
+		PaymentIntent: &stripe.PaymentIntent{ID: "pi_booking"},
+		Refunds: &stripe.RefundList{Data: []*stripe.Refund{
+			{ID: "re_fake_1", Amount: 29999, Currency: "usd", Status: stripe.RefundStatusSucceeded, Metadata: map[string]string{"refund_id": "1"}},
+			{ID: "re_dashboard", Amount: 1000, Currency: "usd", Status: stripe.RefundStatusPending},
+		}},
+	})
+
+	var refunds []models.Refund
+	db.Order("id").Find(&refunds)
+	if assert.Len(t, refunds, 2) {
+		assert.Equal(t, payments.RefundSucceeded, refunds[0].Status)
+		assert.Equal(t, "re_dashboard", *refunds[1].StripeRefundID)
+		assert.Equal(t, int64(1000), refunds[1].Amount)
+		assert.Equal(t, "USD", refunds[1].Currency)
+	}
+}
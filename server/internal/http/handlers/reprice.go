@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
+	"skyliner/internal/ws"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// EventPublisher delivers realtime events to subscribed clients.
+type EventPublisher interface {
+	Publish(event *ws.Event)
+}
+
+// RepriceRequest selects the bookings to reprice. Empty fields match
+// everything; statuses default to hold. Only held bookings are ever changed,
+// other statuses are reported so the diff shows what they would cost now.
+type RepriceRequest struct {
+	FlightIDs     []uint                 `json:"flight_ids"`
+	DepartureFrom *time.Time             `json:"departure_from"`
+	DepartureTo   *time.Time             `json:"departure_to"`
+	Statuses      []models.BookingStatus `json:"statuses"`
+	DryRun        bool                   `json:"dry_run"`
+}
+
+// RepriceBookings starts a job that recalculates booking totals from current
+// fares. It returns straight away; poll the job for progress and the diff.
+func (h *BookingHandler) RepriceBookings(c *gin.Context) {
+	var req RepriceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Statuses) == 0 {
+		req.Statuses = []models.BookingStatus{models.StatusHold}
+	}
+	for _, status := range req.Statuses {
+		switch status {
+		case models.StatusHold, models.StatusPaid, models.StatusTicketed:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot reprice bookings with status " + string(status)})
+			return
+		}
+	}
+	if req.DepartureFrom != nil && req.DepartureTo != nil && req.DepartureTo.Before(*req.DepartureFrom) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "departure_to is before departure_from"})
+		return
+	}
+
+	filter, err := json.Marshal(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reprice job"})
+		return
+	}
+	job := models.RepriceJob{
+		Status:      models.JobQueued,
+		DryRun:      req.DryRun,
+		Filter:      string(filter),
+		RequestedBy: c.GetUint("user_id"),
+	}
+	if err := h.db.Create(&job).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reprice job"})
+		return
+	}
+
+	go h.runRepriceJob(job.ID, req)
+
+	c.JSON(http.StatusAccepted, gin.H{"job": job})
+}
+
+// GetRepriceJob reports a job's progress and, once finished, its diff.
+func (h *BookingHandler) GetRepriceJob(c *gin.Context) {
+	jobID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	var job models.RepriceJob
+	if err := h.db.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("booking_id")
+	}).First(&job, uint(jobID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
+
+func (h *BookingHandler) runRepriceJob(jobID uint, req RepriceRequest) {
+	var job models.RepriceJob
+	if err := h.db.First(&job, jobID).Error; err != nil {
+		log.Printf("Reprice job %d not found: %v", jobID, err)
+		return
+	}
+
+	started := time.Now()
+	job.Status = models.JobRunning
+	job.StartedAt = &started
+	h.db.Save(&job)
+
+	defer func() {
+		if r := recover(); r != nil {
+			job.Status = models.JobFailed
+			job.Error = fmt.Sprint(r)
+		}
+		finished := time.Now()
+		job.FinishedAt = &finished
+		if err := h.db.Omit("Items").Save(&job).Error; err != nil {
+			log.Printf("Failed to save reprice job %d: %v", job.ID, err)
+		}
+	}()
+
+	if err := h.repriceMatching(&job, req); err != nil {
+		job.Status = models.JobFailed
+		job.Error = err.Error()
+		return
+	}
+	job.Status = models.JobCompleted
+}
+
+// repriceMatching walks the bookings selected by req, records a diff line for
+// each one whose total would change and applies it to held bookings.
+func (h *BookingHandler) repriceMatching(job *models.RepriceJob, req RepriceRequest) error {
+	query := h.db.Where("status IN ?", req.Statuses)
+	if len(req.FlightIDs) > 0 || req.DepartureFrom != nil || req.DepartureTo != nil {
+		onFlights := h.db.Table("itineraries").Select("itineraries.booking_id").
+			Joins("JOIN segments ON segments.itinerary_id = itineraries.id").
+			Joins("JOIN flights ON flights.id = segments.flight_id")
+		if len(req.FlightIDs) > 0 {
+			onFlights = onFlights.Where("flights.id IN ?", req.FlightIDs)
+		}
+		if req.DepartureFrom != nil {
+			onFlights = onFlights.Where("flights.departure_time >= ?", *req.DepartureFrom)
+		}
+		if req.DepartureTo != nil {
+			onFlights = onFlights.Where("flights.departure_time <= ?", *req.DepartureTo)
+		}
+		query = query.Where("id IN (?)", onFlights)
+	}
+
+	var bookings []models.Booking
+	if err := query.Order("id").Find(&bookings).Error; err != nil {
+		return err
+	}
+	job.Matched = len(bookings)
+
+	for i := range bookings {
+		booking := &bookings[i]
+
+		base, err := priceBooking(h.db, booking)
+		if err != nil {
+			return fmt.Errorf("failed to price booking %d: %w", booking.ID, err)
+		}
+		total, err := settle(booking, base)
+		if err != nil {
+			return fmt.Errorf("failed to price booking %d: %w", booking.ID, err)
+		}
+		if total.Amount == booking.TotalAmount {
+			continue
+		}
+		job.Changed++
+
+		item := models.RepriceJobItem{
+			JobID:     job.ID,
+			BookingID: booking.ID,
+			PNR:       booking.PNR,
+			Status:    booking.Status,
+			OldTotal:  booking.TotalAmount,
+			NewTotal:  total.Amount,
+			Currency:  total.Currency,
+		}
+		switch {
+		case job.DryRun:
+		case booking.Status != models.StatusHold:
+			item.SkipReason = "booking is " + string(booking.Status)
+		default:
+			applied, err := h.applyReprice(booking, base, total)
+			if err != nil {
+				return fmt.Errorf("failed to reprice booking %d: %w", booking.ID, err)
+			}
+			if applied {
+				item.Applied = true
+				job.Applied++
+			} else {
+				item.SkipReason = "booking is no longer on hold"
+			}
+		}
+
+		if err := h.db.Create(&item).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyReprice writes the new totals if the booking is still on hold, and
+// tells the customer. Any checkout session for the old amount is dropped.
+func (h *BookingHandler) applyReprice(booking *models.Booking, base, total money.Money) (bool, error) {
+	result := h.db.Model(&models.Booking{}).
+		Where("id = ? AND status = ?", booking.ID, models.StatusHold).
+		Updates(map[string]interface{}{
+			"base_amount":       base.Amount,
+			"base_currency":     base.Currency,
+			"total_amount":      total.Amount,
+			"stripe_session_id": nil,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	if h.events != nil {
+		message := fmt.Sprintf("Price updated from %s to %s", booking.Total(), total)
+		h.events.Publish(ws.NewBookingStatusEvent(booking.ID, booking.PNR, string(booking.Status), message))
+	}
+	return true, nil
+}
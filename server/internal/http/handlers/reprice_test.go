@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/payments"
+	"skyliner/internal/ws"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type recordedEvents struct {
+	mu     sync.Mutex
+	events []*ws.Event
+}
+
+func (r *recordedEvents) Publish(event *ws.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordedEvents) list() []*ws.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*ws.Event(nil), r.events...)
+}
+
+func setupRepriceTestRouter(db *gorm.DB, events EventPublisher) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	// The job runs on its own goroutine; one connection keeps it on the same
+	// in-memory database as the test
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+
+	bookingHandler := NewBookingHandler(db, &config.Config{}, fx.Default(), payments.NewFakeClient(), events)
+	router := gin.New()
+	router.POST("/admin/reprice", bookingHandler.RepriceBookings)
+	router.GET("/admin/reprice/:id", bookingHandler.GetRepriceJob)
+	return router
+}
+
+// runRepriceJob starts a job and waits for it to finish.
+func runRepriceJob(t *testing.T, router *gin.Engine, body gin.H) models.RepriceJob {
+	t.Helper()
+	jsonData, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", "/admin/reprice", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if !assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String()) {
+		t.FailNow()
+	}
+
+	var started struct {
+		Job models.RepriceJob `json:"job"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &started))
+
+	var response struct {
+		Job models.RepriceJob `json:"job"`
+	}
+	assert.Eventually(t, func() bool {
+		req, _ := http.NewRequest("GET", "/admin/reprice/"+strconv.FormatUint(uint64(started.Job.ID), 10), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Job.Status == models.JobCompleted || response.Job.Status == models.JobFailed
+	}, 5*time.Second, 10*time.Millisecond)
+	return response.Job
+}
+
+func TestBookingHandler_RepriceBookings(t *testing.T) {
+	db := setupBookingTestDB()
+	booking := setupBookingTestRouter(db)
+	createTestBooking(t, booking, nil)
+	createTestBooking(t, booking, twoPassengerBooking([]map[string]interface{}{
+		{"passenger": 0, "segment": 0, "seat_id": 3},
+	}))
+	paid := createTestBooking(t, booking, nil)
+	db.Model(&paid).Update("status", models.StatusPaid)
+
+	events := &recordedEvents{}
+	router := setupRepriceTestRouter(db, events)
+	db.Model(&models.Fare{}).Where("id = ?", 1).Update("base_price", 45000)
+
+	// A dry run reports the diff without touching anything
+	job := runRepriceJob(t, router, gin.H{"flight_ids": []uint{1}, "statuses": []string{"hold", "paid"}, "dry_run": true})
+	assert.Equal(t, models.JobCompleted, job.Status, job.Error)
+	assert.Equal(t, 3, job.Matched)
+	assert.Equal(t, 3, job.Changed)
+	assert.Equal(t, 0, job.Applied)
+	if assert.Len(t, job.Items, 3) {
+		assert.Equal(t, int64(39999), job.Items[0].OldTotal)
+		assert.Equal(t, int64(45000), job.Items[0].NewTotal)
+		assert.Equal(t, int64(2*39999+2500), job.Items[1].OldTotal)
+		assert.Equal(t, int64(2*45000+2500), job.Items[1].NewTotal)
+	}
+	var unchanged models.Booking
+	db.First(&unchanged, 1)
+	assert.Equal(t, int64(39999), unchanged.TotalAmount)
+	assert.Empty(t, events.list())
+
+	// A real run changes only the held bookings
+	job = runRepriceJob(t, router, gin.H{"statuses": []string{"hold", "paid"}})
+	assert.Equal(t, models.JobCompleted, job.Status, job.Error)
+	assert.Equal(t, 3, job.Changed)
+	assert.Equal(t, 2, job.Applied)
+	if assert.Len(t, job.Items, 3) {
+		assert.False(t, job.Items[2].Applied)
+		assert.Equal(t, "booking is paid", job.Items[2].SkipReason)
+	}
+
+	var repriced, skipped models.Booking
+	db.First(&repriced, 1)
+	assert.Equal(t, int64(45000), repriced.TotalAmount)
+	db.First(&skipped, 3)
+	assert.Equal(t, int64(39999), skipped.TotalAmount)
+
+	if assert.Len(t, events.list(), 2) {
+		assert.Equal(t, "bookingStatus:1", events.list()[0].Channel)
+		assert.Equal(t, "Price updated from 399.99 USD to 450.00 USD", events.list()[0].Data.(ws.BookingStatusData).Message)
+	}
+
+	// Nothing left to change
+	job = runRepriceJob(t, router, gin.H{})
+	assert.Equal(t, 2, job.Matched)
+	assert.Equal(t, 0, job.Changed)
+}
+
+func TestBookingHandler_RepriceFilter(t *testing.T) {
+	db := setupBookingTestDB()
+	createTestBooking(t, setupBookingTestRouter(db), nil)
+	router := setupRepriceTestRouter(db, nil)
+	db.Model(&models.Fare{}).Where("id = ?", 1).Update("base_price", 45000)
+
+	future := time.Now().Add(60 * 24 * time.Hour)
+	job := runRepriceJob(t, router, gin.H{"departure_from": future, "dry_run": true})
+	assert.Equal(t, 0, job.Matched)
+
+	job = runRepriceJob(t, router, gin.H{"flight_ids": []uint{2}, "dry_run": true})
+	assert.Equal(t, 0, job.Matched)
+
+	req, _ := http.NewRequest("POST", "/admin/reprice", bytes.NewBufferString(`{"statuses":["cancelled"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -3,20 +3,24 @@ package handlers
 import (
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/money"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type SearchHandler struct {
-	db *gorm.DB
+	db    *gorm.DB
+	rates fx.Provider
 }
 
-func NewSearchHandler(db *gorm.DB) *SearchHandler {
-	return &SearchHandler{db: db}
+func NewSearchHandler(db *gorm.DB, rates fx.Provider) *SearchHandler {
+	return &SearchHandler{db: db, rates: rates}
 }
 
 type SearchRequest struct {
@@ -25,6 +29,7 @@ type SearchRequest struct {
 	Passengers  int    `json:"passengers" binding:"required,min=1,max=9"`
 	Cabin       string `json:"cabin"`       // economy, business, first
 	Flexibility int    `json:"flexibility"` // days
+	Currency    string `json:"currency"`    // display currency, defaults to the fare currency
 }
 
 type Leg struct {
@@ -52,12 +57,14 @@ type FlightResult struct {
 }
 
 type FareResult struct {
-	ID        uint    `json:"id"`
-	Class     string  `json:"class"`
-	FareType  string  `json:"fare_type"`
-	BasePrice float64 `json:"base_price"`
-	Currency  string  `json:"currency"`
-	Available int     `json:"available"`
+	ID           uint         `json:"id"`
+	Class        string       `json:"class"`
+	FareType     string       `json:"fare_type"`
+	BasePrice    int64        `json:"base_price"` // minor units of Currency
+	Currency     string       `json:"currency"`
+	Available    int          `json:"available"`
+	DisplayPrice *money.Money `json:"display_price,omitempty"` // BasePrice in the requested currency
+	FXRate       string       `json:"fx_rate,omitempty"`
 }
 
 func (h *SearchHandler) GetAirports(c *gin.Context) {
@@ -93,6 +100,14 @@ func (h *SearchHandler) SearchFlights(c *gin.Context) {
 		return
 	}
 
+	// Reject unknown display currencies up front rather than per fare
+	if req.Currency != "" {
+		if _, err := h.rates.Rate(c.Request.Context(), req.Currency, req.Currency); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency: " + req.Currency})
+			return
+		}
+	}
+
 	leg := req.Legs[0]
 	departureDate, err := time.Parse("2006-01-02", leg.Date)
 	if err != nil {
@@ -133,18 +148,39 @@ func (h *SearchHandler) SearchFlights(c *gin.Context) {
 	}
 
 	// Convert to response format
+	rates := map[string]fx.Rate{}
 	var results []FlightResult
 	for _, flight := range flights {
 		var fares []FareResult
 		for _, fare := range flight.Fares {
-			fares = append(fares, FareResult{
+			result := FareResult{
 				ID:        fare.ID,
 				Class:     fare.Class,
 				FareType:  fare.FareType,
 				BasePrice: fare.BasePrice,
 				Currency:  fare.Currency,
 				Available: fare.Available,
-			})
+			}
+
+			if req.Currency != "" && !strings.EqualFold(req.Currency, fare.Currency) {
+				rate, ok := rates[fare.Currency]
+				if !ok {
+					if rate, err = h.rates.Rate(c.Request.Context(), fare.Currency, req.Currency); err != nil {
+						c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency: " + fare.Currency})
+						return
+					}
+					rates[fare.Currency] = rate
+				}
+				display, err := rate.Convert(fare.Price())
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert currency"})
+					return
+				}
+				result.DisplayPrice = &display
+				result.FXRate = rate.String()
+			}
+
+			fares = append(fares, result)
 		}
 
 		results = append(results, FlightResult{
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -67,9 +68,9 @@ func setupSearchTestDB() *gorm.DB {
 	db.Create(&flights)
 
 	fares := []models.Fare{
-		{FlightID: flights[0].ID, Class: "economy", FareType: "basic", BasePrice: 299.99, Currency: "USD", Available: 10},
-		{FlightID: flights[0].ID, Class: "business", FareType: "flexible", BasePrice: 899.99, Currency: "USD", Available: 5},
-		{FlightID: flights[1].ID, Class: "economy", FareType: "standard", BasePrice: 349.99, Currency: "USD", Available: 8},
+		{FlightID: flights[0].ID, Class: "economy", FareType: "basic", BasePrice: 29999, Currency: "USD", Available: 10},
+		{FlightID: flights[0].ID, Class: "business", FareType: "flexible", BasePrice: 89999, Currency: "USD", Available: 5},
+		{FlightID: flights[1].ID, Class: "economy", FareType: "standard", BasePrice: 34999, Currency: "USD", Available: 8},
 	}
 	db.Create(&fares)
 
@@ -79,7 +80,7 @@ func setupSearchTestDB() *gorm.DB {
 func setupSearchTestRouter() *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	db := setupSearchTestDB()
-	searchHandler := NewSearchHandler(db)
+	searchHandler := NewSearchHandler(db, fx.Default())
 
 	router := gin.New()
 	router.GET("/airports", searchHandler.GetAirports)
@@ -261,6 +262,55 @@ func TestSearchHandler_SearchFlights(t *testing.T) {
 	}
 }
 
+func TestSearchHandler_SearchFlightsCurrency(t *testing.T) {
+	router := setupSearchTestRouter()
+
+	search := func(currency string) *httptest.ResponseRecorder {
+		body := map[string]interface{}{
+			"trip_type": "one-way",
+			"legs": []map[string]string{
+				{"origin": "JFK", "destination": "LAX", "date": "2024-12-25"},
+			},
+			"passengers": 1,
+			"currency":   currency,
+		}
+		jsonData, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", "/search", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := search("JPY")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response SearchResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, response.Flights)
+	for _, flight := range response.Flights {
+		for _, fare := range flight.Fares {
+			assert.Equal(t, "USD", fare.Currency)
+			if assert.NotNil(t, fare.DisplayPrice) {
+				assert.Equal(t, "JPY", fare.DisplayPrice.Currency)
+				assert.Greater(t, fare.DisplayPrice.Amount, fare.BasePrice/100)
+			}
+			assert.NotEmpty(t, fare.FXRate)
+		}
+	}
+
+	// Same currency as the fares needs no conversion
+	w = search("USD")
+	assert.Equal(t, http.StatusOK, w.Code)
+	response = SearchResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Nil(t, response.Flights[0].Fares[0].DisplayPrice)
+
+	w = search("XXX")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
 func TestSearchHandler_GetSeatMap(t *testing.T) {
 	router := setupSearchTestRouter()
 
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errSeatNotFound    = errors.New("seat not found")
+	errSeatWrongFlight = errors.New("seat is not on this segment's flight")
+	errSeatWrongCabin  = errors.New("seat is not in the booked cabin")
+	errSeatTaken       = errors.New("seat is no longer available")
+	errSeatCheckedIn   = errors.New("passenger has checked in, so their seat can no longer be changed")
+)
+
+type UpdateSeatsRequest struct {
+	Assignments []SeatAssignmentRequest `json:"assignments" binding:"required,min=1,dive"`
+}
+
+type SeatAssignmentRequest struct {
+	PassengerID uint `json:"passenger_id" binding:"required"`
+	SegmentID   uint `json:"segment_id" binding:"required"`
+	SeatID      uint `json:"seat_id" binding:"required"`
+}
+
+// GetSeatAssignments lists who sits where on each segment of a booking.
+func (h *BookingHandler) GetSeatAssignments(c *gin.Context) {
+	booking, ok := h.loadOwnedBooking(c, h.db)
+	if !ok {
+		return
+	}
+
+	var assignments []models.SeatAssignment
+	if err := h.db.Preload("Seat").Where("booking_id = ?", booking.ID).Find(&assignments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch seat assignments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"seat_assignments": assignments})
+}
+
+// UpdateSeatAssignments assigns or changes seats after booking. All changes
+// apply together or not at all. Unpaid bookings are repriced; paid bookings
+// may only move to seats that cost no more than the one being given up.
+func (h *BookingHandler) UpdateSeatAssignments(c *gin.Context) {
+	var req UpdateSeatsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := h.db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	booking, ok := h.loadOwnedBooking(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+	if booking.Status == models.StatusCancelled {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Booking is cancelled"})
+		return
+	}
+
+	charges := money.Zero(booking.Base().Currency)
+	for _, a := range req.Assignments {
+		var passenger models.Passenger
+		if err := tx.Where("id = ? AND booking_id = ?", a.PassengerID, booking.ID).First(&passenger).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Passenger is not on this booking"})
+			return
+		}
+
+		var segment models.Segment
+		if err := tx.Preload("Fare").
+			Joins("JOIN itineraries ON itineraries.id = segments.itinerary_id").
+			Where("segments.id = ? AND itineraries.booking_id = ?", a.SegmentID, booking.ID).
+			First(&segment).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Segment is not on this booking"})
+			return
+		}
+
+		charge, err := assignSeat(tx, &booking, passenger.ID, &segment, a.SeatID)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(seatErrorStatus(err), gin.H{"error": seatErrorMessage(err)})
+			return
+		}
+		if charges, err = charges.Add(charge); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Seat prices must be in the fare currency"})
+			return
+		}
+	}
+
+	if !charges.IsZero() {
+		switch {
+		case booking.Status == models.StatusHold:
+			if err := adjustBase(&booking, charges); err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price seats"})
+				return
+			}
+			if err := tx.Model(&booking).Updates(map[string]interface{}{
+				"base_amount":  booking.BaseAmount,
+				"total_amount": booking.TotalAmount,
+			}).Error; err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update booking total"})
+				return
+			}
+		case charges.IsNegative():
+			// Moving a paid passenger to a cheaper seat is allowed but not refunded
+		default:
+			tx.Rollback()
+			c.JSON(http.StatusConflict, gin.H{"error": "Seat change requires additional payment"})
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update seats"})
+		return
+	}
+
+	var assignments []models.SeatAssignment
+	h.db.Preload("Seat").Where("booking_id = ?", booking.ID).Find(&assignments)
+	c.JSON(http.StatusOK, gin.H{
+		"seat_assignments": assignments,
+		"total_amount":     booking.Total(),
+	})
+}
+
+// assignSeat puts a passenger in a seat on a segment, releasing any seat they
+// already held there. segment.Fare must be loaded. It returns the change in
+// seat charges in the fare currency.
+func assignSeat(tx *gorm.DB, booking *models.Booking, passengerID uint, segment *models.Segment, seatID uint) (money.Money, error) {
+	currency := segment.Fare.Currency
+
+	var seat models.Seat
+	if err := tx.Preload("SeatMap").First(&seat, seatID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return money.Money{}, errSeatNotFound
+		}
+		return money.Money{}, err
+	}
+	if seat.SeatMap.FlightID != segment.FlightID {
+		return money.Money{}, errSeatWrongFlight
+	}
+	if seat.Class != segment.Fare.Class {
+		return money.Money{}, errSeatWrongCabin
+	}
+
+	charge := money.Zero(currency)
+
+	boarded, err := checkedIn(tx, passengerID, segment.ID)
+	if err != nil {
+		return money.Money{}, err
+	}
+	if boarded {
+		return money.Money{}, errSeatCheckedIn
+	}
+
+	var existing models.SeatAssignment
+	err = tx.Preload("Seat").Where("passenger_id = ? AND segment_id = ?", passengerID, segment.ID).First(&existing).Error
+	switch {
+	case err == nil:
+		if existing.SeatID == seatID {
+			return charge, nil
+		}
+		if err := releaseSeat(tx, &existing); err != nil {
+			return money.Money{}, err
+		}
+		charge = seatPrice(existing.Seat, currency).Neg()
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return money.Money{}, err
+	}
+
+	// Conditional update so two bookings racing for a seat cannot both win
+	result := tx.Model(&models.Seat{}).
+		Where("id = ? AND status = ?", seat.ID, models.SeatAvailable).
+		Update("status", models.SeatSelected)
+	if result.Error != nil {
+		return money.Money{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return money.Money{}, errSeatTaken
+	}
+
+	assignment := models.SeatAssignment{
+		BookingID:   booking.ID,
+		PassengerID: passengerID,
+		SegmentID:   segment.ID,
+		SeatID:      seat.ID,
+	}
+	if err := tx.Create(&assignment).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return money.Money{}, errSeatTaken
+		}
+		return money.Money{}, err
+	}
+
+	return money.New(charge.Amount+seatPrice(seat, currency).Amount, currency), nil
+}
+
+// releaseSeat frees the seat behind an assignment and removes the assignment.
+func releaseSeat(tx *gorm.DB, assignment *models.SeatAssignment) error {
+	if err := tx.Model(&models.Seat{}).Where("id = ?", assignment.SeatID).Update("status", models.SeatAvailable).Error; err != nil {
+		return err
+	}
+	return tx.Delete(assignment).Error
+}
+
+// releaseBookingSeats frees every seat held by a booking.
+func releaseBookingSeats(tx *gorm.DB, bookingID uint) error {
+	var assignments []models.SeatAssignment
+	if err := tx.Where("booking_id = ?", bookingID).Find(&assignments).Error; err != nil {
+		return err
+	}
+	for i := range assignments {
+		if err := releaseSeat(tx, &assignments[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func seatPrice(seat models.Seat, currency string) money.Money {
+	if seat.Price == nil {
+		return money.Zero(currency)
+	}
+	return money.New(*seat.Price, currency)
+}
+
+func seatErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errSeatNotFound), errors.Is(err, errSeatWrongFlight), errors.Is(err, errSeatWrongCabin):
+		return http.StatusBadRequest
+	case errors.Is(err, errSeatTaken), errors.Is(err, errSeatCheckedIn):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func seatErrorMessage(err error) string {
+	if seatErrorStatus(err) == http.StatusInternalServerError {
+		return "Failed to assign seat"
+	}
+	return err.Error()
+}
+
+// loadOwnedBooking fetches the :id booking for the signed-in user, writing the
+// error response itself when it cannot.
+func (h *BookingHandler) loadOwnedBooking(c *gin.Context, db *gorm.DB) (models.Booking, bool) {
+	var booking models.Booking
+	bookingID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID"})
+		return booking, false
+	}
+
+	if err := db.Where("id = ? AND user_id = ?", uint(bookingID), c.GetUint("user_id")).First(&booking).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return booking, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return booking, false
+	}
+	return booking, true
+}
@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"skyliner/internal/apis"
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SplitBookingRequest names the passengers to move to a new booking.
+type SplitBookingRequest struct {
+	PassengerIDs []uint `json:"passenger_ids" binding:"required,min=1"`
+}
+
+var (
+	errSplitInvalid = errors.New("invalid split")
+	errSplitBlocked = errors.New("split not possible")
+)
+
+// SplitBooking moves some of the booking's passengers to a booking of their
+// own, so their plans can change without touching the rest of the party.
+func (h *BookingHandler) SplitBooking(c *gin.Context) {
+	booking, ok := h.loadOwnedBooking(c, h.db)
+	if !ok {
+		return
+	}
+	h.splitBooking(c, booking)
+}
+
+// AgentSplitBooking is SplitBooking for any customer's booking.
+func (h *BookingHandler) AgentSplitBooking(c *gin.Context) {
+	bookingID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID"})
+		return
+	}
+	var booking models.Booking
+	if err := h.db.First(&booking, uint(bookingID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return
+	}
+	h.splitBooking(c, booking)
+}
+
+func (h *BookingHandler) splitBooking(c *gin.Context, booking models.Booking) {
+	var req SplitBookingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var split models.Booking
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		split, err = splitBooking(tx, &booking, req.PassengerIDs, c.GetUint("user_id"), time.Now())
+		return err
+	})
+	var invalid apisError
+	switch {
+	case errors.Is(err, errSplitInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": strings.TrimPrefix(err.Error(), errSplitInvalid.Error()+": ")})
+		return
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		return
+	case errors.Is(err, errSplitBlocked):
+		c.JSON(http.StatusConflict, gin.H{"error": strings.TrimPrefix(err.Error(), errSplitBlocked.Error()+": ")})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to split booking"})
+		return
+	}
+
+	if err := withBookingDetails(h.db).First(&booking, booking.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return
+	}
+	if err := withBookingDetails(h.db).First(&split, split.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"booking": BookingResponse{Booking: booking, TotalAmount: booking.Total()},
+		"split":   BookingResponse{Booking: split, TotalAmount: split.Total()},
+	})
+}
+
+// splitBooking moves the passengers, with their seats, add-ons, special
+// services and tickets, to a new booking under its own PNR.
+// Their share of the price goes with them, and of what was paid on a paid
+// booking. Fare inventory is held per passenger and segment, so it is the
+// same after the split.
+func splitBooking(tx *gorm.DB, booking *models.Booking, passengerIDs []uint, agentID uint, now time.Time) (models.Booking, error) {
+	blocked := func(message string) error { return fmt.Errorf("%w: %s", errSplitBlocked, message) }
+
+	if booking.Status == models.StatusCancelled {
+		return models.Booking{}, fmt.Errorf("%w: Booking is cancelled", errSplitInvalid)
+	}
+	if booking.HoldExpired(now) {
+		return models.Booking{}, blocked("Booking hold has expired")
+	}
+	var count int64
+	if err := tx.Model(&models.GroupTerms{}).Where("booking_id = ?", booking.ID).Count(&count).Error; err != nil {
+		return models.Booking{}, err
+	}
+	if count > 0 {
+		return models.Booking{}, blocked("Group bookings are split by the group desk")
+	}
+	if err := tx.Model(&models.Exchange{}).Where("booking_id = ? AND status = ?", booking.ID, models.ExchangePendingPayment).Count(&count).Error; err != nil {
+		return models.Booking{}, err
+	}
+	if count > 0 {
+		return models.Booking{}, blocked("Pay for or cancel the pending exchange first")
+	}
+
+	var passengers []models.Passenger
+	if err := tx.Where("booking_id = ?", booking.ID).Order("id").Find(&passengers).Error; err != nil {
+		return models.Booking{}, err
+	}
+	moving := uniqueIDs(passengerIDs)
+	var stay, move []*time.Time
+	for _, p := range passengers {
+		if moving[p.ID] {
+			move = append(move, p.DateOfBirth)
+		} else {
+			stay = append(stay, p.DateOfBirth)
+		}
+	}
+	if len(move) != len(moving) {
+		return models.Booking{}, fmt.Errorf("%w: Passengers must be on the booking", errSplitInvalid)
+	}
+	if len(stay) == 0 {
+		return models.Booking{}, fmt.Errorf("%w: At least one passenger must stay on the booking", errSplitInvalid)
+	}
+	ids := make([]uint, 0, len(moving))
+	for id := range moving {
+		ids = append(ids, id)
+	}
+
+	legs, err := itineraryLegs(tx, booking.ID)
+	if err != nil {
+		return models.Booking{}, err
+	}
+	if len(legs) > 0 {
+		for _, party := range [][]*time.Time{stay, move} {
+			if err := apis.CheckParty(party, legs[0].Departure); err != nil {
+				return models.Booking{}, apisError{err}
+			}
+		}
+	}
+
+	// Add-ons waiting for their own payment would be paid to the wrong booking
+	if err := tx.Model(&models.AncillaryPurchase{}).
+		Where("passenger_id IN ? AND status = ?", ids, models.AncillaryPendingPayment).
+		Count(&count).Error; err != nil {
+		return models.Booking{}, err
+	}
+	if count > 0 {
+		return models.Booking{}, blocked("Pay for or remove pending add-ons first")
+	}
+
+	// Boarding passes carry the PNR in their barcode, so passengers who have
+	// checked in stay with the booking they were issued under
+	for _, id := range ids {
+		var boarded int64
+		if err := tx.Model(&models.Coupon{}).
+			Where("status IN ? AND ticket_id IN (?)", []models.CouponStatus{models.CouponCheckedIn, models.CouponFlown},
+				tx.Model(&models.Ticket{}).Select("id").Where("passenger_id = ?", id)).
+			Count(&boarded).Error; err != nil {
+			return models.Booking{}, err
+		}
+		if boarded == 0 {
+			if err := tx.Model(&models.BoardingPass{}).Where("passenger_id = ?", id).Count(&boarded).Error; err != nil {
+				return models.Booking{}, err
+			}
+		}
+		if boarded > 0 {
+			return models.Booking{}, blocked("Passengers who have checked in cannot be split off")
+		}
+	}
+
+	var segments []models.Segment
+	if err := tx.Preload("Fare").Preload("SeatAssignments.Seat").
+		Joins("JOIN itineraries ON itineraries.id = segments.itinerary_id").
+		Where("itineraries.booking_id = ?", booking.ID).
+		Order("segments.id").
+		Find(&segments).Error; err != nil {
+		return models.Booking{}, err
+	}
+
+	// What the moved passengers account for, in the fare currency, less
+	// their share of the promotion discount
+	moved, err := passengerCharges(tx, booking, segments, ids)
+	if err != nil {
+		return models.Booking{}, err
+	}
+	var redemption models.PromotionRedemption
+	discount := money.Zero(moved.Currency)
+	err = tx.Where("booking_id = ? AND currency = ?", booking.ID, moved.Currency).First(&redemption).Error
+	if err == nil {
+		discount = money.New(redemption.Amount*int64(len(ids))/int64(len(passengers)), redemption.Currency)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Booking{}, err
+	}
+	if moved, err = moved.Sub(discount); err != nil {
+		return models.Booking{}, err
+	}
+	movedTotal, err := settle(booking, moved)
+	if err != nil {
+		return models.Booking{}, err
+	}
+
+	split := models.Booking{
+		UserID:        booking.UserID,
+		Status:        booking.Status,
+		TotalAmount:   movedTotal.Amount,
+		Currency:      booking.Currency,
+		BaseAmount:    moved.Amount,
+		BaseCurrency:  booking.BaseCurrency,
+		FXRate:        booking.FXRate,
+		FXRateAsOf:    booking.FXRateAsOf,
+		HoldExpiresAt: booking.HoldExpiresAt,
+	}
+	if err := createWithPNR(tx, &split); err != nil {
+		return models.Booking{}, err
+	}
+	itinerary := models.Itinerary{BookingID: split.ID}
+	if err := tx.Create(&itinerary).Error; err != nil {
+		return models.Booking{}, err
+	}
+
+	for _, segment := range segments {
+		copied := models.Segment{
+			ItineraryID: itinerary.ID,
+			FlightID:    segment.FlightID,
+			FareID:      segment.FareID,
+			Price:       segment.Price,
+		}
+		if err := tx.Create(&copied).Error; err != nil {
+			return models.Booking{}, err
+		}
+		moveTo := map[string]interface{}{"booking_id": split.ID, "segment_id": copied.ID}
+		for _, model := range []interface{}{&models.SeatAssignment{}, &models.AncillaryPurchase{}, &models.SSRRequest{}} {
+			if err := tx.Model(model).Where("segment_id = ? AND passenger_id IN ?", segment.ID, ids).Updates(moveTo).Error; err != nil {
+				return models.Booking{}, err
+			}
+		}
+		if err := tx.Model(&models.Coupon{}).
+			Where("segment_id = ? AND ticket_id IN (?)", segment.ID, tx.Model(&models.Ticket{}).Select("id").Where("passenger_id IN ?", ids)).
+			Update("segment_id", copied.ID).Error; err != nil {
+			return models.Booking{}, err
+		}
+	}
+	if err := tx.Model(&models.Passenger{}).Where("id IN ?", ids).Update("booking_id", split.ID).Error; err != nil {
+		return models.Booking{}, err
+	}
+	for _, model := range []interface{}{&models.APISRecord{}, &models.Ticket{}} {
+		if err := tx.Model(model).Where("passenger_id IN ?", ids).Update("booking_id", split.ID).Error; err != nil {
+			return models.Booking{}, err
+		}
+	}
+	// Bags bought through a moved add-on go with it
+	if err := tx.Model(&models.Baggage{}).
+		Where("purchase_id IN (?)", tx.Model(&models.AncillaryPurchase{}).Select("id").Where("booking_id = ?", split.ID)).
+		Update("booking_id", split.ID).Error; err != nil {
+		return models.Booking{}, err
+	}
+
+	if !discount.IsZero() {
+		if err := tx.Model(&redemption).Update("amount", redemption.Amount-discount.Amount).Error; err != nil {
+			return models.Booking{}, err
+		}
+		redemption.ID = 0
+		redemption.BookingID = split.ID
+		redemption.Amount = discount.Amount
+		if err := tx.Create(&redemption).Error; err != nil {
+			return models.Booking{}, err
+		}
+	}
+	if booking.Status == models.StatusHold {
+		// Credits are spent on the whole booking; they go back to the account
+		// to be applied again at checkout
+		if err := releaseCredits(tx, booking.ID); err != nil {
+			return models.Booking{}, err
+		}
+	} else if err := splitPayments(tx, booking, split.ID, movedTotal); err != nil {
+		return models.Booking{}, err
+	}
+
+	// Settling each part can round differently, so the original keeps the rest
+	total := booking.TotalAmount - movedTotal.Amount
+	if err := adjustBase(booking, moved.Neg()); err != nil {
+		return models.Booking{}, err
+	}
+	booking.TotalAmount = total
+	if err := tx.Model(booking).Updates(map[string]interface{}{
+		"base_amount":  booking.BaseAmount,
+		"total_amount": booking.TotalAmount,
+	}).Error; err != nil {
+		return models.Booking{}, err
+	}
+
+	return split, tx.Create(&models.BookingSplit{
+		BookingID:      booking.ID,
+		PNR:            booking.PNR,
+		SplitBookingID: split.ID,
+		SplitPNR:       split.PNR,
+		Passengers:     len(ids),
+		Amount:         movedTotal.Amount,
+		Currency:       movedTotal.Currency,
+		CreatedBy:      agentID,
+	}).Error
+}
+
+// passengerCharges is what the passengers' fares, seats, add-ons and special
+// services cost, in the booking's fare currency. segments must have their
+// fares and seat assignments loaded.
+func passengerCharges(db *gorm.DB, booking *models.Booking, segments []models.Segment, passengerIDs []uint) (money.Money, error) {
+	included := uniqueIDs(passengerIDs)
+	charges := money.Zero(booking.Base().Currency)
+	var err error
+	for _, segment := range segments {
+		if charges, err = charges.Add(segment.FarePrice().Mul(int64(len(passengerIDs)))); err != nil {
+			return money.Money{}, err
+		}
+		for _, assignment := range segment.SeatAssignments {
+			if !included[assignment.PassengerID] {
+				continue
+			}
+			if charges, err = charges.Add(seatPrice(assignment.Seat, charges.Currency)); err != nil {
+				return money.Money{}, err
+			}
+		}
+	}
+
+	var ancillaries, ssrs int64
+	if err := db.Model(&models.AncillaryPurchase{}).
+		Where("booking_id = ? AND passenger_id IN ? AND currency = ? AND status = ?", booking.ID, passengerIDs, charges.Currency, models.AncillaryActive).
+		Select("COALESCE(SUM(price), 0)").Scan(&ancillaries).Error; err != nil {
+		return money.Money{}, err
+	}
+	if err := db.Model(&models.SSRRequest{}).
+		Where("booking_id = ? AND passenger_id IN ? AND currency = ? AND status IN ?", booking.ID, passengerIDs, charges.Currency, activeSSRStatuses).
+		Select("COALESCE(SUM(price), 0)").Scan(&ssrs).Error; err != nil {
+		return money.Money{}, err
+	}
+	return money.New(charges.Amount+ancillaries+ssrs, charges.Currency), nil
+}
+
+// splitPayments gives the split its share of each payment, in proportion to
+// its total. The payments are left as captured; the shares are recorded as
+// allocations, so either booking can refund its part.
+func splitPayments(tx *gorm.DB, booking *models.Booking, splitID uint, moved money.Money) error {
+	if booking.TotalAmount <= 0 {
+		return nil
+	}
+	var paymentsMade []models.Payment
+	if err := bookingPayments(tx, booking.ID, moved.Currency).Order("id").Find(&paymentsMade).Error; err != nil {
+		return err
+	}
+	for _, payment := range paymentsMade {
+		available, err := paymentAvailable(tx, payment, booking.ID)
+		if err != nil {
+			return err
+		}
+		share := available * moved.Amount / booking.TotalAmount
+		if share <= 0 {
+			continue
+		}
+		if err := tx.Create(&models.PaymentAllocation{
+			PaymentID:     payment.ID,
+			FromBookingID: booking.ID,
+			ToBookingID:   splitID,
+			Amount:        share,
+			Currency:      payment.Currency,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupSplitTestRouter(db *gorm.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	bookingHandler := NewBookingHandler(db, &config.Config{}, fx.Default(), payments.NewFakeClient(), nil)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", uint(1))
+		c.Next()
+	})
+	router.POST("/bookings/:id/split", bookingHandler.SplitBooking)
+	router.POST("/bookings/:id/cancel", bookingHandler.CancelBooking)
+	return router
+}
+
+type splitResponse struct {
+	Booking BookingResponse `json:"booking"`
+	Split   BookingResponse `json:"split"`
+}
+
+func splitPassengers(t *testing.T, router *gin.Engine, ids ...uint) splitResponse {
+	t.Helper()
+	w := postJSON(router, "/bookings/1/split", gin.H{"passenger_ids": ids})
+	if !assert.Equal(t, http.StatusCreated, w.Code, w.Body.String()) {
+		t.FailNow()
+	}
+	var response splitResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func TestBookingHandler_SplitPaidBooking(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupSplitTestRouter(db)
+	createTestBooking(t, setupBookingTestRouter(db), map[string]interface{}{
+		"segments": []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers": []map[string]interface{}{
+			documentedPassenger("Ada", "Lovelace"),
+			documentedPassenger("Charles", "Babbage"),
+			documentedPassenger("Mary", "Somerville"),
+		},
+		"seats": []map[string]interface{}{
+			{"passenger": 0, "segment": 0, "seat_id": 1},
+			{"passenger": 1, "segment": 0, "seat_id": 3},
+		},
+	})
+	db.Model(&models.Booking{}).Where("id = ?", 1).Update("status", models.StatusPaid)
+	db.Create(&models.Payment{BookingID: 1, StripePaymentID: "pi_party", Amount: 3*39999 + 2500, Currency: "USD", Status: "succeeded"})
+	_, err := ticketBooking(db, 1)
+	assert.NoError(t, err)
+
+	response := splitPassengers(t, router, 2)
+	original, split := response.Booking.Booking, response.Split.Booking
+	assert.NotEqual(t, original.PNR, split.PNR)
+	assert.Equal(t, models.StatusTicketed, split.Status)
+
+	// Charles takes his fare and exit row seat, and his share of the payment
+	assert.Equal(t, int64(2*39999), original.TotalAmount)
+	assert.Equal(t, int64(39999+2500), split.TotalAmount)
+	assert.Empty(t, split.Payments)
+	if assert.Len(t, split.PaymentsIn, 1) && assert.NotNil(t, split.PaymentsIn[0].Payment) {
+		assert.Equal(t, int64(39999+2500), split.PaymentsIn[0].Amount)
+		assert.Equal(t, "pi_party", split.PaymentsIn[0].Payment.StripePaymentID)
+	}
+	// The payment itself stays as captured
+	if assert.Len(t, original.Payments, 1) {
+		assert.Equal(t, int64(3*39999+2500), original.Payments[0].Amount)
+	}
+	if assert.Len(t, original.PaymentsOut, 1) {
+		assert.Equal(t, split.ID, original.PaymentsOut[0].ToBookingID)
+	}
+
+	if assert.Len(t, split.Passengers, 1) {
+		assert.Equal(t, "Babbage", split.Passengers[0].LastName)
+		assert.NotNil(t, split.Passengers[0].APIS)
+	}
+	assert.Len(t, original.Passengers, 2)
+	segment := split.Itinerary.Segments[0]
+	if assert.Len(t, segment.SeatAssignments, 1) {
+		assert.Equal(t, uint(3), segment.SeatAssignments[0].SeatID)
+	}
+	if assert.Len(t, split.Tickets, 1) && assert.Len(t, split.Tickets[0].Coupons, 1) {
+		assert.Equal(t, segment.ID, split.Tickets[0].Coupons[0].SegmentID)
+	}
+	assert.Len(t, original.Tickets, 2)
+
+	// Each booking points at the other
+	if assert.Len(t, original.Splits, 1) {
+		assert.Equal(t, split.ID, original.Splits[0].SplitBookingID)
+		assert.Equal(t, split.PNR, original.Splits[0].SplitPNR)
+		assert.Equal(t, 1, original.Splits[0].Passengers)
+	}
+	if assert.NotNil(t, split.SplitFrom) {
+		assert.Equal(t, original.PNR, split.SplitFrom.PNR)
+	}
+	assert.Equal(t, 27, fareAvailable(db, 1))
+
+	// The new booking is cancelled and refunded on its own
+	w := cancelBooking(router, "2")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var refunds []models.Refund
+	db.Find(&refunds)
+	if assert.Len(t, refunds, 1) {
+		assert.Equal(t, original.Payments[0].ID, refunds[0].PaymentID)
+		assert.Equal(t, split.ID, refunds[0].BookingID)
+		assert.LessOrEqual(t, refunds[0].Amount, int64(39999+2500))
+	}
+	assert.Equal(t, 28, fareAvailable(db, 1))
+	assert.Equal(t, models.SeatAvailable, seatStatus(db, 3))
+	assert.Equal(t, models.SeatSelected, seatStatus(db, 1))
+
+	// The original can then still refund no more than what is left of its share
+	w = cancelBooking(router, "1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var refunded int64
+	db.Model(&models.Refund{}).Where("payment_id = ?", original.Payments[0].ID).Select("SUM(amount)").Scan(&refunded)
+	assert.LessOrEqual(t, refunded, original.Payments[0].Amount)
+}
+
+func TestBookingHandler_SplitHeldBooking(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupSplitTestRouter(db)
+	createTestBooking(t, setupBookingTestRouter(db), twoPassengerBooking(nil))
+	expires := inDays(1)
+	db.Model(&models.Booking{}).Where("id = ?", 1).Update("hold_expires_at", expires)
+
+	tests := []struct {
+		name   string
+		ids    []uint
+		status int
+	}{
+		{"everyone", []uint{1, 2}, http.StatusBadRequest},
+		{"not on the booking", []uint{3}, http.StatusBadRequest},
+		{"nobody", []uint{}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postJSON(router, "/bookings/1/split", gin.H{"passenger_ids": tt.ids})
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+		})
+	}
+
+	response := splitPassengers(t, router, 1)
+	original, split := response.Booking.Booking, response.Split.Booking
+	assert.Equal(t, models.StatusHold, split.Status)
+	assert.Equal(t, int64(39999), original.TotalAmount)
+	assert.Equal(t, int64(39999), split.TotalAmount)
+	if assert.NotNil(t, split.HoldExpiresAt) {
+		assert.WithinDuration(t, expires, *split.HoldExpiresAt, time.Second)
+	}
+	assert.Equal(t, "Lovelace", split.Passengers[0].LastName)
+	assert.Equal(t, 28, fareAvailable(db, 1))
+
+	w := cancelBooking(router, "1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 29, fareAvailable(db, 1))
+
+	// A cancelled booking has nobody left to split off
+	w = postJSON(router, "/bookings/1/split", gin.H{"passenger_ids": []uint{2}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestBookingHandler_SplitCheckedInPassenger(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupSplitTestRouter(db)
+	createCheckInBooking(t, db)
+
+	// Ada's boarding pass names the original PNR, so she stays on it
+	var ticket models.Ticket
+	db.Where("passenger_id = ?", 1).First(&ticket)
+	db.Model(&models.Coupon{}).Where("ticket_id = ?", ticket.ID).Update("status", models.CouponCheckedIn)
+	w := postJSON(router, "/bookings/1/split", gin.H{"passenger_ids": []uint{1}})
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "checked in")
+
+	response := splitPassengers(t, router, 3)
+	assert.Equal(t, "Somerville", response.Split.Booking.Passengers[0].LastName)
+}
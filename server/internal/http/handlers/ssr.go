@@ -0,0 +1,523 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errSSRUnavailable  = errors.New("service is not offered on this flight")
+	errSSRSoldOut      = errors.New("no more of this service can be requested on this flight")
+	errSSRDuplicate    = errors.New("service has already been requested for this passenger and segment")
+	errSSRSecondMeal   = errors.New("passenger already has a special meal on this segment")
+	errSSRTextRequired = errors.New("service needs details in text")
+	errSSRDecided      = errors.New("service request is not awaiting a decision")
+)
+
+// SSRItemRequest asks for a special service while booking. Like seats, it
+// refers to passengers and segments by their position in the request.
+type SSRItemRequest struct {
+	Passenger int    `json:"passenger" binding:"min=0"` // index into passengers
+	Segment   int    `json:"segment" binding:"min=0"`   // index into segments
+	Code      string `json:"code" binding:"required"`
+	Text      string `json:"text"`
+}
+
+// AddSSRRequest asks for a special service on an existing booking.
+type AddSSRRequest struct {
+	PassengerID uint   `json:"passenger_id" binding:"required"`
+	SegmentID   uint   `json:"segment_id" binding:"required"`
+	Code        string `json:"code" binding:"required"`
+	Text        string `json:"text"`
+}
+
+type DecideSSRRequest struct {
+	Note string `json:"note"`
+}
+
+// SSROffer is a service that can be requested on a flight.
+type SSROffer struct {
+	Code         string             `json:"code"`
+	Name         string             `json:"name"`
+	Category     models.SSRCategory `json:"category"`
+	RequiresText bool               `json:"requires_text"`
+	Price        money.Money        `json:"price"`
+	Remaining    *int               `json:"remaining"` // nil when the flight has no limit
+}
+
+// GetFlightSSRs lists the special services the flight's airline offers, with
+// their price and how many can still be requested.
+func (h *SearchHandler) GetFlightSSRs(c *gin.Context) {
+	flightID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid flight ID"})
+		return
+	}
+
+	var flight models.Flight
+	if err := h.db.Preload("Fares").First(&flight, uint(flightID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Flight not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch flight"})
+		return
+	}
+	currency := "USD"
+	if len(flight.Fares) > 0 {
+		currency = flight.Fares[0].Currency
+	}
+
+	var offers []models.AirlineSSR
+	if err := h.db.Preload("Type").Where("airline_id = ?", flight.AirlineID).Order("code").Find(&offers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch services"})
+		return
+	}
+
+	result := make([]SSROffer, len(offers))
+	for i, offer := range offers {
+		result[i] = SSROffer{
+			Code:         offer.Code,
+			Name:         offer.Type.Name,
+			Category:     offer.Type.Category,
+			RequiresText: offer.Type.RequiresText,
+			Price:        ssrPrice(offer, currency),
+		}
+		if offer.FlightLimit > 0 {
+			used, err := countFlightSSRs(h.db, flight.ID, offer.Code)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch services"})
+				return
+			}
+			remaining := max(offer.FlightLimit-used, 0)
+			result[i].Remaining = &remaining
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ssrs": result})
+}
+
+// GetSSRs lists the special service requests on a booking.
+func (h *BookingHandler) GetSSRs(c *gin.Context) {
+	booking, ok := h.loadOwnedBooking(c, h.db)
+	if !ok {
+		return
+	}
+
+	var ssrs []models.SSRRequest
+	if err := h.db.Where("booking_id = ?", booking.ID).Order("id").Find(&ssrs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch service requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ssrs": ssrs})
+}
+
+// AddSSR requests a special service after booking. Unpaid bookings are
+// repriced; paid bookings may only add services that are free.
+func (h *BookingHandler) AddSSR(c *gin.Context) {
+	var req AddSSRRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := h.db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	booking, ok := h.loadOwnedBooking(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+	if booking.Status == models.StatusCancelled {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Booking is cancelled"})
+		return
+	}
+
+	var passenger models.Passenger
+	if err := tx.Where("id = ? AND booking_id = ?", req.PassengerID, booking.ID).First(&passenger).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Passenger is not on this booking"})
+		return
+	}
+	var segment models.Segment
+	if err := tx.Preload("Fare").
+		Joins("JOIN itineraries ON itineraries.id = segments.itinerary_id").
+		Where("segments.id = ? AND itineraries.booking_id = ?", req.SegmentID, booking.ID).
+		First(&segment).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Segment is not on this booking"})
+		return
+	}
+
+	ssr, err := requestSSR(tx, &booking, passenger.ID, &segment, req.Code, req.Text)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(ssrErrorStatus(err), gin.H{"error": ssrErrorMessage(err)})
+		return
+	}
+	if ssr.Price != 0 {
+		if booking.Status != models.StatusHold {
+			tx.Rollback()
+			c.JSON(http.StatusConflict, gin.H{"error": "Service requires additional payment"})
+			return
+		}
+		if err := chargeBooking(tx, &booking, money.New(ssr.Price, ssr.Currency)); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update booking total"})
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request service"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"ssr": ssr, "total_amount": booking.Total()})
+}
+
+// CancelSSR withdraws a special service request. Charges come off unpaid
+// bookings; services already paid for are not refunded.
+func (h *BookingHandler) CancelSSR(c *gin.Context) {
+	ssrID, err := strconv.ParseUint(c.Param("ssr_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service request ID"})
+		return
+	}
+
+	tx := h.db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	booking, ok := h.loadOwnedBooking(c, tx)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	var ssr models.SSRRequest
+	if err := tx.Where("id = ? AND booking_id = ?", uint(ssrID), booking.ID).First(&ssr).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Service request not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch service request"})
+		return
+	}
+	if !ssr.Active() {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Service request is already closed"})
+		return
+	}
+
+	if err := closeSSR(tx, &booking, &ssr, models.SSRCancelled, nil, ""); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel service request"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel service request"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ssr": ssr, "total_amount": booking.Total()})
+}
+
+// GetSSRQueue lists service requests across bookings for agents to work
+// through, optionally filtered by ?status= and ?flight_id=.
+func (h *BookingHandler) GetSSRQueue(c *gin.Context) {
+	query := h.db.Order("id")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if flight := c.Query("flight_id"); flight != "" {
+		flightID, err := strconv.ParseUint(flight, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid flight ID"})
+			return
+		}
+		query = query.Where("flight_id = ?", uint(flightID))
+	}
+
+	var ssrs []models.SSRRequest
+	if err := query.Find(&ssrs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch service requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ssrs": ssrs})
+}
+
+// ConfirmSSR lets an agent confirm the airline will provide a service.
+func (h *BookingHandler) ConfirmSSR(c *gin.Context) {
+	h.decideSSR(c, models.SSRConfirmed)
+}
+
+// RejectSSR lets an agent turn down a service request. Any charge comes off
+// an unpaid booking; if it was paid for, it comes back as travel credit.
+func (h *BookingHandler) RejectSSR(c *gin.Context) {
+	h.decideSSR(c, models.SSRRejected)
+}
+
+func (h *BookingHandler) decideSSR(c *gin.Context, decision models.SSRStatus) {
+	ssrID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service request ID"})
+		return
+	}
+	// The note is optional, so the body may be empty
+	var req DecideSSRRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var ssr models.SSRRequest
+	var credit *models.Credit
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&ssr, uint(ssrID)).Error; err != nil {
+			return err
+		}
+		if ssr.Status != models.SSRRequested {
+			return errSSRDecided
+		}
+		var booking models.Booking
+		if err := tx.First(&booking, ssr.BookingID).Error; err != nil {
+			return err
+		}
+
+		decidedBy := c.GetUint("user_id")
+		if decision == models.SSRConfirmed {
+			now := time.Now()
+			ssr.Status = models.SSRConfirmed
+			ssr.Note = req.Note
+			ssr.DecidedBy = &decidedBy
+			ssr.DecidedAt = &now
+			return tx.Save(&ssr).Error
+		}
+
+		if err := closeSSR(tx, &booking, &ssr, models.SSRRejected, &decidedBy, req.Note); err != nil {
+			return err
+		}
+		if ssr.Price == 0 || booking.Status == models.StatusHold || booking.Status == models.StatusCancelled {
+			return nil
+		}
+		paid, err := settle(&booking, money.New(ssr.Price, ssr.Currency))
+		if err != nil {
+			return err
+		}
+		credit = &models.Credit{
+			UserID:    booking.UserID,
+			Kind:      models.CreditTravel,
+			Source:    "ssr_rejected",
+			BookingID: &booking.ID,
+			Amount:    paid.Amount,
+			Currency:  paid.Currency,
+			ExpiresAt: creditExpiry(h.cfg.CreditValidity, time.Now()),
+		}
+		return issueCredit(tx, credit)
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service request not found"})
+		return
+	case errors.Is(err, errSSRDecided):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Service request is not awaiting a decision"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update service request"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ssr": ssr, "credit": credit})
+}
+
+// requestSSR records a special service for a passenger on a segment, checking
+// the airline offers it, its limit on the flight, and that the passenger does
+// not already have it. segment.Fare must be loaded. Flight limits are counted
+// in the caller's transaction.
+func requestSSR(tx *gorm.DB, booking *models.Booking, passengerID uint, segment *models.Segment, code, text string) (*models.SSRRequest, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	text = strings.TrimSpace(text)
+
+	var offer models.AirlineSSR
+	if err := tx.Preload("Type").
+		Where("code = ? AND airline_id = (SELECT airline_id FROM flights WHERE id = ?)", code, segment.FlightID).
+		First(&offer).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errSSRUnavailable
+		}
+		return nil, err
+	}
+	if offer.Type.RequiresText && text == "" {
+		return nil, errSSRTextRequired
+	}
+
+	var held []models.SSRRequest
+	if err := tx.Where("passenger_id = ? AND segment_id = ? AND status IN ?", passengerID, segment.ID, activeSSRStatuses).
+		Find(&held).Error; err != nil {
+		return nil, err
+	}
+	for _, other := range held {
+		if other.Code == code {
+			return nil, errSSRDuplicate
+		}
+		if offer.Type.Category == models.SSRMeal {
+			var otherType models.SSRType
+			if err := tx.Where("code = ?", other.Code).First(&otherType).Error; err != nil {
+				return nil, err
+			}
+			if otherType.Category == models.SSRMeal {
+				return nil, errSSRSecondMeal
+			}
+		}
+	}
+
+	if offer.FlightLimit > 0 {
+		used, err := countFlightSSRs(tx, segment.FlightID, code)
+		if err != nil {
+			return nil, err
+		}
+		if used >= offer.FlightLimit {
+			return nil, errSSRSoldOut
+		}
+	}
+
+	price := ssrPrice(offer, segment.Fare.Currency)
+	ssr := models.SSRRequest{
+		BookingID:   booking.ID,
+		PassengerID: passengerID,
+		SegmentID:   segment.ID,
+		FlightID:    segment.FlightID,
+		Code:        code,
+		Text:        text,
+		Status:      models.SSRRequested,
+		Price:       price.Amount,
+		Currency:    price.Currency,
+	}
+	if offer.AutoConfirm {
+		now := time.Now()
+		ssr.Status = models.SSRConfirmed
+		ssr.DecidedAt = &now
+	}
+	if err := tx.Create(&ssr).Error; err != nil {
+		return nil, err
+	}
+	if ssr.Status == models.SSRRequested {
+		if err := placeOnQueue(tx, booking.ID, models.QueueSSRPending, code+" requested"); err != nil {
+			return nil, err
+		}
+	}
+	return &ssr, nil
+}
+
+// closeSSR ends a service request and takes its charge off an unpaid booking.
+func closeSSR(tx *gorm.DB, booking *models.Booking, ssr *models.SSRRequest, status models.SSRStatus, decidedBy *uint, note string) error {
+	wasActive := ssr.Active()
+	ssr.Status = status
+	if decidedBy != nil {
+		now := time.Now()
+		ssr.DecidedBy = decidedBy
+		ssr.DecidedAt = &now
+		ssr.Note = note
+	}
+	if err := tx.Save(ssr).Error; err != nil {
+		return err
+	}
+	if !wasActive || ssr.Price == 0 || booking.Status != models.StatusHold {
+		return nil
+	}
+	return chargeBooking(tx, booking, money.New(ssr.Price, ssr.Currency).Neg())
+}
+
+// cancelSSRs closes the open service requests on a booking, or on one of its
+// segments when segmentID is not zero, so they stop counting against the
+// flight's limit.
+func cancelSSRs(tx *gorm.DB, bookingID, segmentID uint) error {
+	query := tx.Model(&models.SSRRequest{}).Where("booking_id = ? AND status IN ?", bookingID, activeSSRStatuses)
+	if segmentID != 0 {
+		query = query.Where("segment_id = ?", segmentID)
+	}
+	return query.Update("status", models.SSRCancelled).Error
+}
+
+// chargeBooking adds delta (in the fare currency) to a booking and saves the
+// new amounts.
+func chargeBooking(tx *gorm.DB, booking *models.Booking, delta money.Money) error {
+	if err := adjustBase(booking, delta); err != nil {
+		return err
+	}
+	return tx.Model(booking).Updates(map[string]interface{}{
+		"base_amount":  booking.BaseAmount,
+		"total_amount": booking.TotalAmount,
+	}).Error
+}
+
+// bookingSSRCharges is what a booking's open service requests cost, in its
+// fare currency.
+func bookingSSRCharges(db *gorm.DB, bookingID uint, currency string) (money.Money, error) {
+	var amount int64
+	if err := db.Model(&models.SSRRequest{}).
+		Where("booking_id = ? AND currency = ? AND status IN ?", bookingID, currency, activeSSRStatuses).
+		Select("COALESCE(SUM(price), 0)").Scan(&amount).Error; err != nil {
+		return money.Money{}, err
+	}
+	return money.New(amount, currency), nil
+}
+
+var activeSSRStatuses = []models.SSRStatus{models.SSRRequested, models.SSRConfirmed}
+
+func countFlightSSRs(db *gorm.DB, flightID uint, code string) (int, error) {
+	var count int64
+	err := db.Model(&models.SSRRequest{}).
+		Where("flight_id = ? AND code = ? AND status IN ?", flightID, code, activeSSRStatuses).
+		Count(&count).Error
+	return int(count), err
+}
+
+func ssrPrice(offer models.AirlineSSR, currency string) money.Money {
+	if offer.Price == nil {
+		return money.Zero(currency)
+	}
+	return money.New(*offer.Price, currency)
+}
+
+func ssrErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errSSRUnavailable), errors.Is(err, errSSRTextRequired):
+		return http.StatusBadRequest
+	case errors.Is(err, errSSRSoldOut), errors.Is(err, errSSRDuplicate), errors.Is(err, errSSRSecondMeal):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func ssrErrorMessage(err error) string {
+	if ssrErrorStatus(err) == http.StatusInternalServerError {
+		return "Failed to request service"
+	}
+	return err.Error()
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// setupSSRTestRouter serves the traveler routes as user 1 and the admin
+// routes as the agent.
+func setupSSRTestRouter(db *gorm.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	petcPrice := int64(12500)
+	db.Create(&[]models.SSRType{
+		{Code: "WCHR", Name: "Wheelchair to the aircraft door", Category: models.SSRMobility},
+		{Code: "VGML", Name: "Vegetarian meal", Category: models.SSRMeal},
+		{Code: "KSML", Name: "Kosher meal", Category: models.SSRMeal},
+		{Code: "PETC", Name: "Pet in cabin", Category: models.SSRAnimal, RequiresText: true},
+		{Code: "UMNR", Name: "Unaccompanied minor", Category: models.SSRMinor},
+	})
+	db.Create(&[]models.AirlineSSR{
+		{AirlineID: 1, Code: "WCHR"},
+		{AirlineID: 1, Code: "VGML", AutoConfirm: true},
+		{AirlineID: 1, Code: "KSML", AutoConfirm: true},
+		{AirlineID: 1, Code: "PETC", Price: &petcPrice, FlightLimit: 1},
+	})
+
+	bookingHandler := NewBookingHandler(db, &config.Config{}, fx.Default(), payments.NewFakeClient(), nil)
+	searchHandler := NewSearchHandler(db, fx.Default())
+
+	router := gin.New()
+	router.GET("/flights/:id/ssrs", searchHandler.GetFlightSSRs)
+
+	protected := router.Group("")
+	protected.Use(func(c *gin.Context) {
+		c.Set("user_id", uint(1))
+		c.Next()
+	})
+	protected.POST("/bookings", bookingHandler.CreateBooking)
+	protected.POST("/bookings/:id/cancel", bookingHandler.CancelBooking)
+	protected.GET("/bookings/:id/ssrs", bookingHandler.GetSSRs)
+	protected.POST("/bookings/:id/ssrs", bookingHandler.AddSSR)
+	protected.POST("/bookings/:id/ssrs/:ssr_id/cancel", bookingHandler.CancelSSR)
+
+	admin := router.Group("/admin")
+	admin.Use(testStaffRole)
+	admin.GET("/ssrs", bookingHandler.GetSSRQueue)
+	admin.POST("/ssrs/:id/confirm", bookingHandler.ConfirmSSR)
+	admin.POST("/ssrs/:id/reject", bookingHandler.RejectSSR)
+	return router
+}
+
+func ssrBooking(ssrs ...map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"segments":   []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers": []map[string]interface{}{{"first_name": "Ada", "last_name": "Lovelace"}},
+		"ssrs":       ssrs,
+	}
+}
+
+func bookingSSRs(db *gorm.DB, bookingID uint) map[string]models.SSRRequest {
+	var ssrs []models.SSRRequest
+	db.Where("booking_id = ?", bookingID).Find(&ssrs)
+	byCode := make(map[string]models.SSRRequest, len(ssrs))
+	for _, ssr := range ssrs {
+		byCode[ssr.Code] = ssr
+	}
+	return byCode
+}
+
+func postJSON(router *gin.Engine, path string, body interface{}) *httptest.ResponseRecorder {
+	jsonData, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestBookingHandler_CreateBookingWithSSRs(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupSSRTestRouter(db)
+
+	booking := createTestBooking(t, router, ssrBooking(
+		map[string]interface{}{"passenger": 0, "segment": 0, "code": "vgml"},
+		map[string]interface{}{"passenger": 0, "segment": 0, "code": "PETC", "text": "Cat, 4kg, soft carrier"},
+	))
+	assert.Equal(t, int64(39999+12500), booking.TotalAmount)
+	assert.Len(t, booking.Passengers[0].SSRs, 2)
+
+	ssrs := bookingSSRs(db, booking.ID)
+	assert.Equal(t, models.SSRConfirmed, ssrs["VGML"].Status) // meals are confirmed on request
+	assert.Equal(t, models.SSRRequested, ssrs["PETC"].Status)
+	assert.Equal(t, int64(12500), ssrs["PETC"].Price)
+
+	tests := []struct {
+		name   string
+		ssr    map[string]interface{}
+		status int
+	}{
+		{"not offered by the airline", map[string]interface{}{"passenger": 0, "segment": 0, "code": "UMNR"}, http.StatusBadRequest},
+		{"unknown passenger", map[string]interface{}{"passenger": 1, "segment": 0, "code": "WCHR"}, http.StatusBadRequest},
+		{"details missing", map[string]interface{}{"passenger": 0, "segment": 0, "code": "PETC"}, http.StatusBadRequest},
+		{"flight limit reached", map[string]interface{}{"passenger": 0, "segment": 0, "code": "PETC", "text": "Dog, 6kg"}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postBooking(router, ssrBooking(tt.ssr))
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+		})
+	}
+
+	w := postBooking(router, ssrBooking(
+		map[string]interface{}{"passenger": 0, "segment": 0, "code": "VGML"},
+		map[string]interface{}{"passenger": 0, "segment": 0, "code": "KSML"},
+	))
+	assert.Equal(t, http.StatusConflict, w.Code, "one special meal per passenger and segment")
+}
+
+func TestBookingHandler_DecideSSR(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupSSRTestRouter(db)
+
+	booking := createTestBooking(t, router, ssrBooking(
+		map[string]interface{}{"passenger": 0, "segment": 0, "code": "WCHR"},
+		map[string]interface{}{"passenger": 0, "segment": 0, "code": "PETC", "text": "Cat"},
+	))
+	ssrs := bookingSSRs(db, booking.ID)
+
+	req, _ := http.NewRequest("GET", "/admin/ssrs?status=requested", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var queue struct {
+		SSRs []models.SSRRequest `json:"ssrs"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &queue))
+	assert.Len(t, queue.SSRs, 2)
+
+	w = adminRequest(router, "POST", fmt.Sprintf("/admin/ssrs/%d/confirm", ssrs["WCHR"].ID), nil, false)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, models.SSRConfirmed, bookingSSRs(db, booking.ID)["WCHR"].Status)
+	assert.Equal(t, agentID, *bookingSSRs(db, booking.ID)["WCHR"].DecidedBy)
+
+	w = adminRequest(router, "POST", fmt.Sprintf("/admin/ssrs/%d/reject", ssrs["WCHR"].ID), nil, false)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "already decided")
+
+	// Rejecting a priced service on an unpaid booking takes the charge off
+	w = adminRequest(router, "POST", fmt.Sprintf("/admin/ssrs/%d/reject", ssrs["PETC"].ID), gin.H{"note": "Cabin pet quota full"}, false)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	rejected := bookingSSRs(db, booking.ID)["PETC"]
+	assert.Equal(t, models.SSRRejected, rejected.Status)
+	assert.Equal(t, "Cabin pet quota full", rejected.Note)
+
+	var updated models.Booking
+	db.First(&updated, booking.ID)
+	assert.Equal(t, int64(39999), updated.TotalAmount)
+
+	// A rejected request no longer counts against the flight's limit
+	createTestBooking(t, router, ssrBooking(map[string]interface{}{"passenger": 0, "segment": 0, "code": "PETC", "text": "Dog"}))
+}
+
+func TestBookingHandler_RejectPaidSSRIssuesCredit(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupSSRTestRouter(db)
+
+	booking := createTestBooking(t, router, ssrBooking(
+		map[string]interface{}{"passenger": 0, "segment": 0, "code": "PETC", "text": "Cat"},
+	))
+	db.Model(&booking).Update("status", models.StatusPaid)
+	petc := bookingSSRs(db, booking.ID)["PETC"]
+
+	w := adminRequest(router, "POST", fmt.Sprintf("/admin/ssrs/%d/reject", petc.ID), nil, false)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var credit models.Credit
+	assert.NoError(t, db.Where("booking_id = ? AND source = ?", booking.ID, "ssr_rejected").First(&credit).Error)
+	assert.Equal(t, int64(12500), credit.Balance)
+}
+
+func TestBookingHandler_AddAndCancelSSR(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupSSRTestRouter(db)
+
+	booking := createTestBooking(t, router, nil)
+	passengerID := booking.Passengers[0].ID
+	segmentID := booking.Itinerary.Segments[0].ID
+	path := fmt.Sprintf("/bookings/%d/ssrs", booking.ID)
+
+	w := postJSON(router, path, gin.H{"passenger_id": passengerID, "segment_id": segmentID, "code": "PETC", "text": "Cat"})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var added struct {
+		SSR models.SSRRequest `json:"ssr"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &added))
+
+	var updated models.Booking
+	db.First(&updated, booking.ID)
+	assert.Equal(t, int64(39999+12500), updated.TotalAmount)
+
+	var offers struct {
+		SSRs []SSROffer `json:"ssrs"`
+	}
+	req, _ := http.NewRequest("GET", "/flights/1/ssrs", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &offers))
+	assert.Len(t, offers.SSRs, 4)
+	for _, offer := range offers.SSRs {
+		if offer.Code == "PETC" {
+			assert.Equal(t, 0, *offer.Remaining)
+			assert.Equal(t, int64(12500), offer.Price.Amount)
+		} else {
+			assert.Nil(t, offer.Remaining)
+		}
+	}
+
+	w = postJSON(router, fmt.Sprintf("%s/%d/cancel", path, added.SSR.ID), nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	db.First(&updated, booking.ID)
+	assert.Equal(t, int64(39999), updated.TotalAmount)
+
+	w = postJSON(router, fmt.Sprintf("%s/%d/cancel", path, added.SSR.ID), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "already cancelled")
+
+	// Paid bookings can add free services but not priced ones
+	db.Model(&updated).Update("status", models.StatusPaid)
+	w = postJSON(router, path, gin.H{"passenger_id": passengerID, "segment_id": segmentID, "code": "PETC", "text": "Cat"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = postJSON(router, path, gin.H{"passenger_id": passengerID, "segment_id": segmentID, "code": "WCHR"})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+}
+
+func TestBookingHandler_CancelBookingClosesSSRs(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupSSRTestRouter(db)
+
+	booking := createTestBooking(t, router, ssrBooking(
+		map[string]interface{}{"passenger": 0, "segment": 0, "code": "PETC", "text": "Cat"},
+	))
+	w := postJSON(router, fmt.Sprintf("/bookings/%d/cancel", booking.ID), nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, models.SSRCancelled, bookingSSRs(db, booking.ID)["PETC"].Status)
+
+	createTestBooking(t, router, ssrBooking(map[string]interface{}{"passenger": 0, "segment": 0, "code": "PETC", "text": "Dog"}))
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/ticketing"
+
+	"gorm.io/gorm"
+)
+
+var errNoTicketPrefix = errors.New("airline has no ticket prefix")
+
+// ticketBooking issues an e-ticket to every passenger on a paid booking who
+// does not have one yet, with a coupon per segment, and marks the booking
+// ticketed. Issuing again returns the tickets already issued.
+func ticketBooking(db *gorm.DB, bookingID uint) ([]models.Ticket, error) {
+	var tickets []models.Ticket
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var passengers []models.Passenger
+		if err := tx.Where("booking_id = ?", bookingID).Order("id").Find(&passengers).Error; err != nil {
+			return err
+		}
+		var segments []models.Segment
+		if err := tx.Preload("Flight.Airline").
+			Joins("JOIN itineraries ON itineraries.id = segments.itinerary_id").
+			Where("itineraries.booking_id = ?", bookingID).
+			Order("segments.id").
+			Find(&segments).Error; err != nil {
+			return err
+		}
+		if len(segments) == 0 {
+			return errors.New("booking has no segments")
+		}
+
+		var issued []uint
+		if err := tx.Model(&models.Ticket{}).Where("booking_id = ?", bookingID).Pluck("passenger_id", &issued).Error; err != nil {
+			return err
+		}
+		ticketed := make(map[uint]bool, len(issued))
+		for _, id := range issued {
+			ticketed[id] = true
+		}
+
+		// The first segment's airline validates the ticket
+		airline := segments[0].Flight.Airline
+		for _, passenger := range passengers {
+			if ticketed[passenger.ID] {
+				continue
+			}
+			number, err := nextTicketNumber(tx, airline)
+			if err != nil {
+				return err
+			}
+			ticket := models.Ticket{
+				BookingID:   bookingID,
+				PassengerID: passenger.ID,
+				AirlineID:   airline.ID,
+				Number:      number,
+				IssuedAt:    time.Now(),
+			}
+			for i, segment := range segments {
+				ticket.Coupons = append(ticket.Coupons, models.Coupon{
+					Number:    i + 1,
+					SegmentID: segment.ID,
+					FlightID:  segment.FlightID,
+					Status:    models.CouponOpen,
+				})
+			}
+			if err := tx.Create(&ticket).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&models.Booking{}).Where("id = ?", bookingID).Update("status", models.StatusTicketed).Error; err != nil {
+			return err
+		}
+		return tx.Preload("Coupons", func(db *gorm.DB) *gorm.DB { return db.Order("number") }).
+			Where("booking_id = ?", bookingID).Order("id").Find(&tickets).Error
+	})
+	return tickets, err
+}
+
+// nextTicketNumber takes the airline's next serial. The update locks the
+// airline row, so concurrent issues never share a number.
+func nextTicketNumber(tx *gorm.DB, airline models.Airline) (string, error) {
+	if airline.TicketPrefix == "" {
+		return "", fmt.Errorf("%w: %s", errNoTicketPrefix, airline.Code)
+	}
+	if err := tx.Model(&models.Airline{}).Where("id = ?", airline.ID).
+		UpdateColumn("ticket_serial", gorm.Expr("ticket_serial + 1")).Error; err != nil {
+		return "", err
+	}
+	var serial int64
+	if err := tx.Model(&models.Airline{}).Where("id = ?", airline.ID).Pluck("ticket_serial", &serial).Error; err != nil {
+		return "", err
+	}
+	return ticketing.Number(airline.TicketPrefix, serial)
+}
+
+// exchangeCoupons closes the open coupons for a segment that was exchanged and
+// adds one for its replacement to each ticket on the booking.
+func exchangeCoupons(tx *gorm.DB, bookingID, oldSegmentID uint, replacement models.Segment) error {
+	if err := tx.Model(&models.Coupon{}).
+		Where("segment_id = ? AND status = ?", oldSegmentID, models.CouponOpen).
+		Update("status", models.CouponExchanged).Error; err != nil {
+		return err
+	}
+
+	var tickets []models.Ticket
+	if err := tx.Preload("Coupons").Where("booking_id = ?", bookingID).Find(&tickets).Error; err != nil {
+		return err
+	}
+	for _, ticket := range tickets {
+		coupon := models.Coupon{
+			TicketID:  ticket.ID,
+			Number:    len(ticket.Coupons) + 1,
+			SegmentID: replacement.ID,
+			FlightID:  replacement.FlightID,
+			Status:    models.CouponOpen,
+		}
+		if err := tx.Create(&coupon).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refundCoupons closes the coupons still open on a cancelled booking.
+func refundCoupons(tx *gorm.DB, bookingID uint) error {
+	return tx.Model(&models.Coupon{}).
+		Where("status = ? AND ticket_id IN (?)", models.CouponOpen,
+			tx.Model(&models.Ticket{}).Select("id").Where("booking_id = ?", bookingID)).
+		Update("status", models.CouponRefunded).Error
+}
+
+// travelStarted reports whether any passenger on the booking has checked in
+// or flown, after which the booking can no longer be cancelled.
+func travelStarted(db *gorm.DB, bookingID uint) (bool, error) {
+	var count int64
+	if err := db.Model(&models.Coupon{}).
+		Where("status IN ? AND ticket_id IN (?)", []models.CouponStatus{models.CouponCheckedIn, models.CouponFlown},
+			db.Model(&models.Ticket{}).Select("id").Where("booking_id = ?", bookingID)).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count == 0 {
+		if err := db.Model(&models.BoardingPass{}).Where("booking_id = ?", bookingID).Count(&count).Error; err != nil {
+			return false, err
+		}
+	}
+	return count > 0, nil
+}
+
+// checkedIn reports whether a passenger has checked in for, or flown, a
+// segment. Their boarding pass names their seat, so it must not change.
+func checkedIn(db *gorm.DB, passengerID, segmentID uint) (bool, error) {
+	var count int64
+	if err := db.Model(&models.Coupon{}).
+		Where("segment_id = ? AND status IN ? AND ticket_id IN (?)", segmentID, []models.CouponStatus{models.CouponCheckedIn, models.CouponFlown},
+			db.Model(&models.Ticket{}).Select("id").Where("passenger_id = ?", passengerID)).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count == 0 {
+		if err := db.Model(&models.BoardingPass{}).Where("passenger_id = ? AND segment_id = ?", passengerID, segmentID).Count(&count).Error; err != nil {
+			return false, err
+		}
+	}
+	return count > 0, nil
+}
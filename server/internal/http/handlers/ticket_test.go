@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/ticketing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v76"
+	"gorm.io/gorm"
+)
+
+// documentedPassenger has everything needed to ticket an international trip.
+func documentedPassenger(first, last string) map[string]interface{} {
+	return map[string]interface{}{
+		"first_name": first, "last_name": last, "date_of_birth": "1990-12-10T00:00:00Z", "nationality": "GBR",
+		"passport": "C1234567", "passport_expiry": time.Now().AddDate(5, 0, 0), "passport_country": "GBR",
+	}
+}
+
+func createTicketableBooking(t *testing.T, db *gorm.DB) models.Booking {
+	booking := createTestBooking(t, setupBookingTestRouter(db), map[string]interface{}{
+		"segments":   []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers": []map[string]interface{}{documentedPassenger("Ada", "Lovelace"), documentedPassenger("Charles", "Babbage")},
+	})
+	db.Model(&models.Booking{}).Where("id = ?", booking.ID).Update("status", models.StatusPaid)
+	return booking
+}
+
+func issueBooking(router *gin.Engine) (*httptest.ResponseRecorder, []models.Ticket) {
+	req, _ := http.NewRequest("POST", "/bookings/1/issue", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var response struct {
+		Tickets []models.Ticket `json:"tickets"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w, response.Tickets
+}
+
+func TestBookingHandler_IssueTickets(t *testing.T) {
+	db := setupBookingTestDB()
+	router := setupAPISTestRouter(db)
+	createTicketableBooking(t, db)
+
+	w, tickets := issueBooking(router)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	if !assert.Len(t, tickets, 2) {
+		return
+	}
+	for i, ticket := range tickets {
+		assert.True(t, ticketing.Valid(ticket.Number), ticket.Number)
+		assert.True(t, strings.HasPrefix(ticket.Number, "125"), ticket.Number)
+		assert.Equal(t, uint(i+1), ticket.PassengerID)
+		if assert.Len(t, ticket.Coupons, 1) {
+			assert.Equal(t, 1, ticket.Coupons[0].Number)
+			assert.Equal(t, uint(1), ticket.Coupons[0].FlightID)
+			assert.Equal(t, models.CouponOpen, ticket.Coupons[0].Status)
+		}
+	}
+	assert.NotEqual(t, tickets[0].Number, tickets[1].Number)
+
+	var booking models.Booking
+	db.First(&booking, 1)
+	assert.Equal(t, models.StatusTicketed, booking.Status)
+
+	// Issuing again hands back the same tickets
+	w, again := issueBooking(router)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	if assert.Len(t, again, 2) {
+		assert.Equal(t, tickets[0].Number, again[0].Number)
+		assert.Equal(t, tickets[1].Number, again[1].Number)
+	}
+	var count int64
+	db.Model(&models.Ticket{}).Count(&count)
+	assert.Equal(t, int64(2), count)
+
+	req, _ := http.NewRequest("GET", "/bookings/1", nil)
+	w = httptest.NewRecorder()
+	setupBookingTestRouter(db).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"number":"`+tickets[0].Number+`"`)
+	assert.Contains(t, w.Body.String(), `"status":"open"`)
+}
+
+func TestBookingHandler_IssueWithoutTicketPrefix(t *testing.T) {
+	db := setupBookingTestDB()
+	db.Model(&models.Airline{}).Where("id = ?", 1).Update("ticket_prefix", "")
+	router := setupAPISTestRouter(db)
+	createTicketableBooking(t, db)
+
+	w, _ := issueBooking(router)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+	var booking models.Booking
+	db.First(&booking, 1)
+	assert.Equal(t, models.StatusPaid, booking.Status)
+}
+
+func TestBookingHandler_CouponStatus(t *testing.T) {
+	db := setupBookingTestDB()
+	addExchangeFlight(db)
+	router := setupBookingTestRouter(db)
+	createTicketableBooking(t, db)
+	w, _ := issueBooking(setupAPISTestRouter(db))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// A cheaper flight completes straight away and is added to the tickets
+	w = postExchange(router, "/bookings/1/exchanges", 3)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var tickets []models.Ticket
+	db.Preload("Coupons", func(db *gorm.DB) *gorm.DB { return db.Order("number") }).Find(&tickets)
+	for _, ticket := range tickets {
+		if assert.Len(t, ticket.Coupons, 2) {
+			assert.Equal(t, models.CouponExchanged, ticket.Coupons[0].Status)
+			assert.Equal(t, models.CouponOpen, ticket.Coupons[1].Status)
+			assert.Equal(t, uint(2), ticket.Coupons[1].FlightID)
+		}
+	}
+
+	req, _ := http.NewRequest("POST", "/bookings/1/cancel", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var statuses []models.CouponStatus
+	db.Model(&models.Coupon{}).Order("id").Pluck("status", &statuses)
+	assert.Equal(t, []models.CouponStatus{models.CouponExchanged, models.CouponExchanged, models.CouponRefunded, models.CouponRefunded}, statuses)
+}
+
+func TestPaymentHandler_AutoTicket(t *testing.T) {
+	db := setupBookingTestDB()
+	booking := createTicketableBooking(t, db)
+	db.Model(&booking).Update("status", models.StatusHold)
+	incomplete := createTestBooking(t, setupBookingTestRouter(db), nil)
+
+	h := NewPaymentHandler(db, &config.Config{AutoTicket: true})
+	for _, id := range []string{"1", "2"} {
+		session := &stripe.CheckoutSession{
+			Metadata:      map[string]string{"booking_id": id},
+			PaymentIntent: &stripe.PaymentIntent{ID: "pi_" + id},
+			AmountTotal:   39999,
+			Currency:      "usd",
+		}
+		h.handleCheckoutSessionCompleted(session)
+		h.handleCheckoutSessionCompleted(session) // Stripe retries
+	}
+
+	db.First(&booking, booking.ID)
+	assert.Equal(t, models.StatusTicketed, booking.Status)
+	var tickets int64
+	db.Model(&models.Ticket{}).Where("booking_id = ?", booking.ID).Count(&tickets)
+	assert.Equal(t, int64(2), tickets)
+
+	// Missing passport details leave the booking for IssueBooking
+	db.First(&incomplete, incomplete.ID)
+	assert.Equal(t, models.StatusPaid, incomplete.Status)
+	db.Model(&models.Ticket{}).Where("booking_id = ?", incomplete.ID).Count(&tickets)
+	assert.Zero(t, tickets)
+}
@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/mail"
+	"skyliner/internal/ws"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// JoinWaitlistRequest queues the customer for seats in a cabin of a flight
+// that has none left.
+type JoinWaitlistRequest struct {
+	FlightID uint   `json:"flight_id" binding:"required"`
+	Class    string `json:"class" binding:"required,oneof=economy business first"`
+	Seats    int    `json:"seats" binding:"required,min=1,max=9"`
+}
+
+type WaitlistEntryResponse struct {
+	models.WaitlistEntry
+	Position int `json:"position,omitempty"` // place in the queue while waiting, from 1
+}
+
+type LoyaltyTierRequest struct {
+	Tier models.LoyaltyTier `json:"tier" binding:"required,oneof=none silver gold platinum"`
+}
+
+// checkoutSessionLifetime is the longest a Stripe Checkout session stays
+// open. Expired holds are kept this long in case a payment started before
+// expiry still completes.
+const checkoutSessionLifetime = 24 * time.Hour
+
+// JoinWaitlist puts the customer in line for a sold-out cabin.
+func (h *BookingHandler) JoinWaitlist(c *gin.Context) {
+	var req JoinWaitlistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.GetUint("user_id")
+
+	var flight models.Flight
+	if err := h.db.First(&flight, req.FlightID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Flight not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch flight"})
+		return
+	}
+	if !flight.DepartureTime.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Flight has departed"})
+		return
+	}
+
+	var fares, available, active int64
+	if err := h.db.Model(&models.Fare{}).Where("flight_id = ? AND class = ?", flight.ID, req.Class).Count(&fares).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fares"})
+		return
+	}
+	if fares == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Flight has no " + req.Class + " cabin"})
+		return
+	}
+	if err := h.db.Model(&models.Fare{}).Where("flight_id = ? AND class = ? AND available >= ?", flight.ID, req.Class, req.Seats).Count(&available).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fares"})
+		return
+	}
+	if available > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Seats are available in this cabin; book them instead"})
+		return
+	}
+	if err := h.db.Model(&models.WaitlistEntry{}).
+		Where("user_id = ? AND flight_id = ? AND class = ? AND status IN ?", userID, flight.ID, req.Class, []models.WaitlistStatus{models.WaitlistWaiting, models.WaitlistOffered}).
+		Count(&active).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch waitlist"})
+		return
+	}
+	if active > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "You are already on this waitlist"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+	entry := models.WaitlistEntry{
+		UserID:   userID,
+		FlightID: flight.ID,
+		Class:    req.Class,
+		Seats:    req.Seats,
+		Priority: user.LoyaltyTier.Rank(),
+		Status:   models.WaitlistWaiting,
+	}
+	if err := h.db.Create(&entry).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join waitlist"})
+		return
+	}
+
+	position, err := waitlistPosition(h.db, entry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch waitlist"})
+		return
+	}
+	c.JSON(http.StatusCreated, WaitlistEntryResponse{WaitlistEntry: entry, Position: position})
+}
+
+// GetWaitlist lists the customer's waitlist entries, newest first.
+func (h *BookingHandler) GetWaitlist(c *gin.Context) {
+	var entries []models.WaitlistEntry
+	if err := h.db.Where("user_id = ?", c.GetUint("user_id")).Order("id DESC").Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch waitlist"})
+		return
+	}
+
+	response := make([]WaitlistEntryResponse, len(entries))
+	for i, entry := range entries {
+		response[i].WaitlistEntry = entry
+		if entry.Status != models.WaitlistWaiting {
+			continue
+		}
+		position, err := waitlistPosition(h.db, entry)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch waitlist"})
+			return
+		}
+		response[i].Position = position
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": response})
+}
+
+// LeaveWaitlist takes the customer out of line. Seats held for an offer go to
+// the next customer.
+func (h *BookingHandler) LeaveWaitlist(c *gin.Context) {
+	entryID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid waitlist entry ID"})
+		return
+	}
+	var entry models.WaitlistEntry
+	if err := h.db.Where("id = ? AND user_id = ?", uint(entryID), c.GetUint("user_id")).First(&entry).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Waitlist entry not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch waitlist"})
+		return
+	}
+	if entry.Status != models.WaitlistWaiting && entry.Status != models.WaitlistOffered {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Waitlist entry is already closed"})
+		return
+	}
+
+	var offers []models.WaitlistEntry
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&entry).Where("status = ?", entry.Status).Update("status", models.WaitlistCancelled)
+		if result.Error != nil || result.RowsAffected == 0 || entry.FareID == nil {
+			return result.Error
+		}
+		if err := releaseFare(tx, *entry.FareID, entry.Seats); err != nil {
+			return err
+		}
+		var err error
+		offers, err = offerWaitlist(tx, entry.FlightID, h.cfg.WaitlistOfferTTL, time.Now())
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to leave waitlist"})
+		return
+	}
+	h.notifyOffers(offers)
+
+	c.JSON(http.StatusOK, gin.H{"entry": entry})
+}
+
+// GetFlightWaitlist shows agents a flight's waitlist in the order seats will
+// be offered.
+func (h *BookingHandler) GetFlightWaitlist(c *gin.Context) {
+	flightID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid flight ID"})
+		return
+	}
+	var entries []models.WaitlistEntry
+	if err := h.db.Where("flight_id = ? AND status IN ?", uint(flightID), []models.WaitlistStatus{models.WaitlistWaiting, models.WaitlistOffered}).
+		Order("class, priority DESC, id").Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch waitlist"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// SetLoyaltyTier changes a customer's loyalty tier, and with it their place on
+// any waitlist they are on.
+func (h *BookingHandler) SetLoyaltyTier(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var req LoyaltyTierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, uint(userID)).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Update("loyalty_tier", req.Tier).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.WaitlistEntry{}).Where("user_id = ? AND status = ?", user.ID, models.WaitlistWaiting).
+			Update("priority", req.Tier.Rank()).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update loyalty tier"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// RunSweeper releases expired holds and waitlist offers every interval,
+// offers whatever they free up to the waitlist, and queues unpaid holds for
+// agents. It never returns.
+func (h *BookingHandler) RunSweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := h.sweep(now); err != nil {
+			log.Printf("Sweep failed: %v", err)
+		}
+	}
+}
+
+func (h *BookingHandler) sweep(now time.Time) error {
+	// Holds past their deadline give their inventory back. Group bookings
+	// run on their own schedule and are left to the group desk.
+	var expired []models.Booking
+	if err := h.db.Where("status = ? AND hold_expires_at < ?", models.StatusHold, now.Add(-checkoutSessionLifetime)).
+		Where("NOT EXISTS (SELECT 1 FROM group_terms WHERE group_terms.booking_id = bookings.id)").
+		Find(&expired).Error; err != nil {
+		return err
+	}
+	for _, booking := range expired {
+		released := false
+		if err := h.db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			released, err = expireHold(tx, booking.ID)
+			return err
+		}); err != nil {
+			return err
+		}
+		if released && h.events != nil {
+			h.events.Publish(ws.NewBookingStatusEvent(booking.ID, booking.PNR, string(models.StatusCancelled), "Hold expired"))
+		}
+	}
+
+	// Holds that ran out and are not released yet, including overdue group
+	// installments, go to an agent to chase
+	var unpaid []models.Booking
+	if err := h.db.Where("status = ? AND hold_expires_at < ?", models.StatusHold, now).
+		Where("NOT EXISTS (SELECT 1 FROM queue_items WHERE queue_items.booking_id = bookings.id AND queue = ? AND status <> ?)", models.QueueUnpaidHold, models.QueueItemCompleted).
+		Find(&unpaid).Error; err != nil {
+		return err
+	}
+	for _, booking := range unpaid {
+		reason := "Hold expired " + booking.HoldExpiresAt.UTC().Format("2 Jan 2006 15:04 UTC") + " without payment"
+		if err := placeOnQueue(h.db, booking.ID, models.QueueUnpaidHold, reason); err != nil {
+			return err
+		}
+	}
+
+	var offers []models.WaitlistEntry
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var lapsed []models.WaitlistEntry
+		if err := tx.Where("status = ? AND offer_expires_at < ?", models.WaitlistOffered, now).Find(&lapsed).Error; err != nil {
+			return err
+		}
+		for _, entry := range lapsed {
+			// A booking may have taken the offer since it was read
+			result := tx.Model(&entry).Where("status = ?", models.WaitlistOffered).Update("status", models.WaitlistExpired)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected != 1 {
+				continue
+			}
+			if err := releaseFare(tx, *entry.FareID, entry.Seats); err != nil {
+				return err
+			}
+		}
+
+		// Nobody can be offered seats on a flight that has left
+		if err := tx.Model(&models.WaitlistEntry{}).
+			Where("status = ? AND flight_id IN (?)", models.WaitlistWaiting, tx.Model(&models.Flight{}).Select("id").Where("departure_time <= ?", now)).
+			Update("status", models.WaitlistCancelled).Error; err != nil {
+			return err
+		}
+
+		var flightIDs []uint
+		if err := tx.Model(&models.WaitlistEntry{}).Where("status = ?", models.WaitlistWaiting).Distinct().Pluck("flight_id", &flightIDs).Error; err != nil {
+			return err
+		}
+		for _, flightID := range flightIDs {
+			offered, err := offerWaitlist(tx, flightID, h.cfg.WaitlistOfferTTL, now)
+			if err != nil {
+				return err
+			}
+			offers = append(offers, offered...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	h.notifyOffers(offers)
+	return nil
+}
+
+// expireHold cancels a booking whose hold ran out and gives back what it
+// held, unless it was paid in the meantime.
+func expireHold(tx *gorm.DB, bookingID uint) (bool, error) {
+	result := tx.Model(&models.Booking{}).Where("id = ? AND status = ?", bookingID, models.StatusHold).Update("status", models.StatusCancelled)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	for _, release := range []func(*gorm.DB, uint) error{releaseCredits, releasePromotion, releaseBookingInventory, cancelPendingExchanges, releaseBookingSeats} {
+		if err := release(tx, bookingID); err != nil {
+			return false, err
+		}
+	}
+	if err := cancelSSRs(tx, bookingID, 0); err != nil {
+		return false, err
+	}
+	return true, cancelAncillaries(tx, bookingID, 0)
+}
+
+// offerWaitlist holds seats on the flight for waiting customers, best tier
+// first and then in the order they joined. Each gets the cheapest fare in
+// their cabin with room for their whole party; a party that does not fit
+// lets the next one in line go ahead.
+func offerWaitlist(tx *gorm.DB, flightID uint, ttl time.Duration, now time.Time) ([]models.WaitlistEntry, error) {
+	var entries []models.WaitlistEntry
+	if err := tx.Where("flight_id = ? AND status = ?", flightID, models.WaitlistWaiting).
+		Order("priority DESC, id").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	var offers []models.WaitlistEntry
+	for _, entry := range entries {
+		var fare models.Fare
+		err := tx.Where("flight_id = ? AND class = ? AND available >= ?", flightID, entry.Class, entry.Seats).
+			Order("base_price").First(&fare).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := holdFare(tx, fare.ID, entry.Seats); err != nil {
+			if errors.Is(err, errFareSoldOut) {
+				continue
+			}
+			return nil, err
+		}
+
+		expiresAt := now.Add(ttl)
+		entry.Status = models.WaitlistOffered
+		entry.FareID = &fare.ID
+		entry.OfferedAt = &now
+		entry.OfferExpiresAt = &expiresAt
+		if err := tx.Model(&entry).Updates(map[string]interface{}{
+			"status":           entry.Status,
+			"fare_id":          fare.ID,
+			"offered_at":       now,
+			"offer_expires_at": expiresAt,
+		}).Error; err != nil {
+			return nil, err
+		}
+		offers = append(offers, entry)
+	}
+	return offers, nil
+}
+
+// offerBookingWaitlists offers the seats a cancelled booking gave back.
+func offerBookingWaitlists(tx *gorm.DB, bookingID uint, ttl time.Duration, now time.Time) ([]models.WaitlistEntry, error) {
+	var flightIDs []uint
+	if err := tx.Model(&models.Segment{}).
+		Joins("JOIN itineraries ON itineraries.id = segments.itinerary_id").
+		Where("itineraries.booking_id = ?", bookingID).
+		Distinct().Pluck("segments.flight_id", &flightIDs).Error; err != nil {
+		return nil, err
+	}
+	var offers []models.WaitlistEntry
+	for _, flightID := range flightIDs {
+		offered, err := offerWaitlist(tx, flightID, ttl, now)
+		if err != nil {
+			return nil, err
+		}
+		offers = append(offers, offered...)
+	}
+	return offers, nil
+}
+
+// notifyOffers tells customers about seats held for them, over the WebSocket
+// and by email. It runs after the offers are committed; a message that fails
+// to send does not withdraw the offer.
+func (h *BookingHandler) notifyOffers(offers []models.WaitlistEntry) {
+	for _, offer := range offers {
+		if h.events != nil {
+			h.events.Publish(ws.NewWaitlistOfferEvent(offer.UserID, offer.ID, offer.FlightID, *offer.FareID, offer.Seats, *offer.OfferExpiresAt))
+		}
+
+		var user models.User
+		var flight models.Flight
+		if err := h.db.First(&user, offer.UserID).Error; err != nil {
+			log.Printf("Failed to load user %d for waitlist offer %d: %v", offer.UserID, offer.ID, err)
+			continue
+		}
+		if err := h.db.Preload("Origin").Preload("Destination").First(&flight, offer.FlightID).Error; err != nil {
+			log.Printf("Failed to load flight %d for waitlist offer %d: %v", offer.FlightID, offer.ID, err)
+			continue
+		}
+		msg := mail.Message{
+			To:      user.Email,
+			Subject: fmt.Sprintf("Seats on %s are held for you", flight.Number),
+			Body: fmt.Sprintf("%d %s seat(s) on flight %s from %s to %s, departing %s, are now held for you.\n\n"+
+				"Book them by %s with waitlist offer %d, or they will be offered to the next customer in line.\n",
+				offer.Seats, offer.Class, flight.Number, flight.Origin.Code, flight.Destination.Code,
+				flight.DepartureTime.In(flight.Origin.Location()).Format("2 Jan 2006 15:04"),
+				offer.OfferExpiresAt.UTC().Format("2 Jan 2006 15:04 UTC"), offer.ID),
+		}
+		if err := h.mailer.Send(msg); err != nil {
+			log.Printf("Failed to email waitlist offer %d: %v", offer.ID, err)
+		}
+	}
+}
+
+// waitlistPosition is the entry's place in line for its flight and cabin.
+func waitlistPosition(db *gorm.DB, entry models.WaitlistEntry) (int, error) {
+	var ahead int64
+	err := db.Model(&models.WaitlistEntry{}).
+		Where("flight_id = ? AND class = ? AND status = ?", entry.FlightID, entry.Class, models.WaitlistWaiting).
+		Where("priority > ? OR (priority = ? AND id < ?)", entry.Priority, entry.Priority, entry.ID).
+		Count(&ahead).Error
+	return int(ahead) + 1, err
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/mail"
+	"skyliner/internal/payments"
+	"skyliner/internal/ws"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// sentMail records messages instead of sending them.
+type sentMail struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (s *sentMail) Send(msg mail.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func setupWaitlistTest(db *gorm.DB) (*BookingHandler, *recordedEvents, *sentMail) {
+	events := &recordedEvents{}
+	mailer := &sentMail{}
+	h := NewBookingHandler(db, &config.Config{WaitlistOfferTTL: 2 * time.Hour}, fx.Default(), payments.NewFakeClient(), events)
+	h.mailer = mailer
+
+	db.Create(&[]models.User{
+		{Email: "grace@example.com", PasswordHash: "x", FirstName: "Grace", LastName: "Hopper"},
+		{Email: "alan@example.com", PasswordHash: "x", FirstName: "Alan", LastName: "Turing"},
+	})
+	return h, events, mailer
+}
+
+// waitlistRouter serves requests as the given customer.
+func waitlistRouter(h *BookingHandler, userID uint) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Next()
+	})
+	router.POST("/bookings", h.CreateBooking)
+	router.POST("/bookings/:id/cancel", h.CancelBooking)
+	router.GET("/waitlist", h.GetWaitlist)
+	router.POST("/waitlist", h.JoinWaitlist)
+	router.POST("/waitlist/:id/cancel", h.LeaveWaitlist)
+	router.GET("/admin/flights/:id/waitlist", h.GetFlightWaitlist)
+	router.PUT("/admin/users/:id/loyalty-tier", h.SetLoyaltyTier)
+	return router
+}
+
+func joinWaitlist(t *testing.T, router *gin.Engine, seats int) WaitlistEntryResponse {
+	t.Helper()
+	w := postJSON(router, "/waitlist", gin.H{"flight_id": 1, "class": "economy", "seats": seats})
+	if !assert.Equal(t, http.StatusCreated, w.Code, w.Body.String()) {
+		t.FailNow()
+	}
+	var entry WaitlistEntryResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &entry))
+	return entry
+}
+
+func waitlistEntry(db *gorm.DB, id uint) models.WaitlistEntry {
+	var entry models.WaitlistEntry
+	db.First(&entry, id)
+	return entry
+}
+
+func TestBookingHandler_WaitlistOfferOnCancellation(t *testing.T) {
+	db := setupBookingTestDB()
+	h, events, mailer := setupWaitlistTest(db)
+	traveler, grace, alan := waitlistRouter(h, 1), waitlistRouter(h, 2), waitlistRouter(h, 3)
+
+	// Nobody waits while seats are on sale
+	w := postJSON(grace, "/waitlist", gin.H{"flight_id": 1, "class": "economy", "seats": 2})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = postJSON(grace, "/waitlist", gin.H{"flight_id": 1, "class": "first", "seats": 1})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	booking := createTestBooking(t, traveler, twoPassengerBooking(nil))
+	db.Model(&models.Fare{}).Where("id = ?", 1).Update("available", 0)
+
+	graceEntry := joinWaitlist(t, grace, 2)
+	assert.Equal(t, 1, graceEntry.Position)
+	w = postJSON(grace, "/waitlist", gin.H{"flight_id": 1, "class": "economy", "seats": 1})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	alanEntry := joinWaitlist(t, alan, 1)
+	assert.Equal(t, 2, alanEntry.Position)
+
+	// A better tier moves Alan to the front
+	w = adminRequest(traveler, "PUT", "/admin/users/3/loyalty-tier", gin.H{"tier": "gold"}, true)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = request(alan, "GET", "/waitlist")
+	var list struct {
+		Entries []WaitlistEntryResponse `json:"entries"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	if assert.Len(t, list.Entries, 1) {
+		assert.Equal(t, 1, list.Entries[0].Position)
+		assert.Equal(t, models.TierGold.Rank(), list.Entries[0].Priority)
+	}
+
+	// Two seats come back; Alan's party of one is offered first and Grace's
+	// party of two no longer fits
+	w = cancelBooking(traveler, "1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	offer := waitlistEntry(db, alanEntry.ID)
+	assert.Equal(t, models.WaitlistOffered, offer.Status)
+	if assert.NotNil(t, offer.OfferExpiresAt) {
+		assert.WithinDuration(t, time.Now().Add(2*time.Hour), *offer.OfferExpiresAt, time.Minute)
+	}
+	assert.Equal(t, models.WaitlistWaiting, waitlistEntry(db, graceEntry.ID).Status)
+	assert.Equal(t, 1, fareAvailable(db, 1))
+
+	if assert.Len(t, events.list(), 1) {
+		event := events.list()[0]
+		assert.Equal(t, ws.EventWaitlistOffer, event.Type)
+		assert.Equal(t, "waitlistOffer:3", event.Channel)
+	}
+	if assert.Len(t, mailer.messages, 1) {
+		assert.Equal(t, "alan@example.com", mailer.messages[0].To)
+		assert.Contains(t, mailer.messages[0].Subject, "BA200")
+	}
+
+	// Only Alan can take up the offer, and only for the seats it holds
+	body := map[string]interface{}{
+		"segments":    []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers":  []map[string]interface{}{{"first_name": "Alan", "last_name": "Turing"}},
+		"waitlist_id": alanEntry.ID,
+	}
+	w = postBooking(grace, body)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = postBooking(alan, twoPassengerBooking(nil))
+	assert.Equal(t, http.StatusConflict, w.Code, "the last seat is held for the waitlist")
+
+	created := createTestBooking(t, alan, body)
+	assert.NotEqual(t, booking.ID, created.ID)
+	offer = waitlistEntry(db, alanEntry.ID)
+	assert.Equal(t, models.WaitlistBooked, offer.Status)
+	if assert.NotNil(t, offer.BookingID) {
+		assert.Equal(t, created.ID, *offer.BookingID)
+	}
+	assert.Equal(t, 1, fareAvailable(db, 1))
+
+	w = postBooking(alan, body)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestBookingHandler_WaitlistSweep(t *testing.T) {
+	db := setupBookingTestDB()
+	h, _, mailer := setupWaitlistTest(db)
+	traveler, grace, alan := waitlistRouter(h, 1), waitlistRouter(h, 2), waitlistRouter(h, 3)
+
+	createTestBooking(t, traveler, nil)
+	db.Model(&models.Fare{}).Where("id = ?", 1).Update("available", 0)
+	graceEntry := joinWaitlist(t, grace, 1)
+	alanEntry := joinWaitlist(t, alan, 1)
+
+	// A hold still inside the checkout grace period is kept
+	expired := time.Now().Add(-time.Hour)
+	db.Model(&models.Booking{}).Where("id = ?", 1).Update("hold_expires_at", expired)
+	assert.NoError(t, h.sweep(time.Now()))
+	var booking models.Booking
+	db.First(&booking, 1)
+	assert.Equal(t, models.StatusHold, booking.Status)
+
+	// Past it, the seat goes to the first customer in line
+	now := expired.Add(checkoutSessionLifetime + time.Minute)
+	assert.NoError(t, h.sweep(now))
+	db.First(&booking, 1)
+	assert.Equal(t, models.StatusCancelled, booking.Status)
+	assert.Equal(t, models.WaitlistOffered, waitlistEntry(db, graceEntry.ID).Status)
+	assert.Equal(t, 0, fareAvailable(db, 1))
+
+	// Grace lets the offer lapse, so it moves on to Alan
+	assert.NoError(t, h.sweep(now.Add(3*time.Hour)))
+	assert.Equal(t, models.WaitlistExpired, waitlistEntry(db, graceEntry.ID).Status)
+	assert.Equal(t, models.WaitlistOffered, waitlistEntry(db, alanEntry.ID).Status)
+	assert.Equal(t, 0, fareAvailable(db, 1))
+	assert.Len(t, mailer.messages, 2)
+
+	// Alan declines, and with nobody left the seat goes back on sale
+	w := postJSON(alan, "/waitlist/2/cancel", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, models.WaitlistCancelled, waitlistEntry(db, alanEntry.ID).Status)
+	assert.Equal(t, 1, fareAvailable(db, 1))
+	w = postJSON(alan, "/waitlist/2/cancel", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = request(traveler, "GET", "/admin/flights/1/waitlist")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"entries":[]}`, w.Body.String())
+}
+
+// takeOfferAfterRead marks an offer booked right after it is first read, as a
+// second booking racing for it would.
+func takeOfferAfterRead(t *testing.T, db *gorm.DB, entryID uint) {
+	t.Helper()
+	taken := false
+	assert.NoError(t, db.Callback().Query().After("gorm:query").Register("test:take_offer", func(tx *gorm.DB) {
+		if taken || tx.Statement.Table != "waitlist_entries" {
+			return
+		}
+		taken = true
+		tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE waitlist_entries SET status = ? WHERE id = ?", models.WaitlistBooked, entryID)
+	}))
+	t.Cleanup(func() { _ = db.Callback().Query().Remove("test:take_offer") })
+}
+
+func TestBookingHandler_WaitlistOfferRedeemedTwice(t *testing.T) {
+	db := setupBookingTestDB()
+	h, _, _ := setupWaitlistTest(db)
+	traveler, alan := waitlistRouter(h, 1), waitlistRouter(h, 3)
+
+	createTestBooking(t, traveler, nil)
+	db.Model(&models.Fare{}).Where("id = ?", 1).Update("available", 0)
+	entry := joinWaitlist(t, alan, 1)
+	w := cancelBooking(traveler, "1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, models.WaitlistOffered, waitlistEntry(db, entry.ID).Status)
+
+	// Another booking takes the offer after this one has checked it
+	takeOfferAfterRead(t, db, entry.ID)
+	w = postBooking(alan, map[string]interface{}{
+		"segments":    []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers":  []map[string]interface{}{{"first_name": "Alan", "last_name": "Turing"}},
+		"waitlist_id": entry.ID,
+	})
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	var bookings int64
+	db.Model(&models.Booking{}).Count(&bookings)
+	assert.Equal(t, int64(1), bookings)
+	assert.Equal(t, 0, fareAvailable(db, 1))
+}
+
+func TestBookingHandler_WaitlistSweepSkipsTakenOffer(t *testing.T) {
+	db := setupBookingTestDB()
+	h, _, _ := setupWaitlistTest(db)
+	traveler, alan := waitlistRouter(h, 1), waitlistRouter(h, 3)
+
+	createTestBooking(t, traveler, nil)
+	db.Model(&models.Fare{}).Where("id = ?", 1).Update("available", 0)
+	entry := joinWaitlist(t, alan, 1)
+	w := cancelBooking(traveler, "1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// The offer is booked just as the sweeper finds it lapsed, so its seat is
+	// not released a second time
+	takeOfferAfterRead(t, db, entry.ID)
+	assert.NoError(t, h.sweep(time.Now().Add(3*time.Hour)))
+	assert.Equal(t, models.WaitlistBooked, waitlistEntry(db, entry.ID).Status)
+	assert.Equal(t, 0, fareAvailable(db, 1))
+}
@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errWaiverInvalid = errors.New("invalid waiver")
+	errWaiverStale   = errors.New("waiver no longer applies")
+)
+
+type WaiveRequest struct {
+	Type       models.WaiverType `json:"type" binding:"required,oneof=change_fee cancellation_penalty baggage hold_expiry"`
+	ReasonCode string            `json:"reason_code" binding:"required"`
+	Note       string            `json:"note"`
+	Amount     int64             `json:"amount" binding:"min=0"` // minor units of the booking's fare currency
+}
+
+// WaiveBooking lets an agent waive a charge on any booking. Waivers above the
+// configured limit wait for an admin unless an admin asked for them.
+// Baggage and hold expiry waivers take effect straight away; change fee and
+// cancellation penalty waivers are used by the next exchange or cancellation.
+func (h *BookingHandler) WaiveBooking(c *gin.Context) {
+	bookingID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID"})
+		return
+	}
+
+	var req WaiveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.WaiverReasonCodes[req.ReasonCode] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown reason code: " + req.ReasonCode})
+		return
+	}
+
+	var booking models.Booking
+	if err := h.db.First(&booking, uint(bookingID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return
+	}
+
+	waiver := models.Waiver{
+		BookingID:   booking.ID,
+		Type:        req.Type,
+		ReasonCode:  req.ReasonCode,
+		Note:        req.Note,
+		Amount:      req.Amount,
+		Currency:    booking.Base().Currency,
+		Status:      models.WaiverPendingApproval,
+		RequestedBy: c.GetUint("user_id"),
+	}
+	if err := validateWaiver(h.db, &booking, &waiver); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	isAdmin := c.GetString("role") == string(models.RoleAdmin)
+	if !isAdmin && h.needsApproval(c.Request.Context(), waiver.Money()) {
+		if err := h.db.Create(&waiver).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create waiver"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"waiver": waiver})
+		return
+	}
+
+	var refunds []models.Refund
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		approveWaiver(&waiver, waiver.RequestedBy)
+		if err := tx.Create(&waiver).Error; err != nil {
+			return err
+		}
+		var err error
+		refunds, err = h.applyWaiver(tx, &booking, &waiver)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply waiver"})
+		return
+	}
+	h.issueRefunds(c.Request.Context(), refunds)
+
+	c.JSON(http.StatusCreated, gin.H{"waiver": waiver, "refunds": refunds})
+}
+
+// GetWaivers lists waivers, optionally filtered by ?status= or by booking.
+func (h *BookingHandler) GetWaivers(c *gin.Context) {
+	query := h.db.Order("id")
+	if id := c.Param("id"); id != "" {
+		bookingID, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID"})
+			return
+		}
+		query = query.Where("booking_id = ?", uint(bookingID))
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var waivers []models.Waiver
+	if err := query.Find(&waivers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch waivers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"waivers": waivers})
+}
+
+// ApproveWaiver lets an admin approve a waiver above the agent limit.
+func (h *BookingHandler) ApproveWaiver(c *gin.Context) {
+	h.decideWaiver(c, true)
+}
+
+// RejectWaiver lets an admin turn down a waiver above the agent limit.
+func (h *BookingHandler) RejectWaiver(c *gin.Context) {
+	h.decideWaiver(c, false)
+}
+
+func (h *BookingHandler) decideWaiver(c *gin.Context, approve bool) {
+	if c.GetString("role") != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+
+	waiverID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid waiver ID"})
+		return
+	}
+
+	var waiver models.Waiver
+	var refunds []models.Refund
+	var stale error
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&waiver, uint(waiverID)).Error; err != nil {
+			return err
+		}
+		if waiver.Status != models.WaiverPendingApproval {
+			return errWaiverInvalid
+		}
+
+		var booking models.Booking
+		if approve {
+			// The booking may have moved on since the waiver was requested
+			if err := tx.First(&booking, waiver.BookingID).Error; err != nil {
+				return err
+			}
+			if stale = validateWaiver(tx, &booking, &waiver); stale != nil {
+				return errWaiverStale
+			}
+			approveWaiver(&waiver, c.GetUint("user_id"))
+		} else {
+			now := time.Now()
+			decidedBy := c.GetUint("user_id")
+			waiver.Status = models.WaiverRejected
+			waiver.DecidedBy = &decidedBy
+			waiver.DecidedAt = &now
+		}
+
+		// Only one decision wins when two admins act at once
+		result := tx.Model(&models.Waiver{}).Where("id = ? AND status = ?", waiver.ID, models.WaiverPendingApproval).Updates(map[string]interface{}{
+			"status":     waiver.Status,
+			"decided_by": waiver.DecidedBy,
+			"decided_at": waiver.DecidedAt,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errWaiverInvalid
+		}
+		if !approve {
+			return nil
+		}
+		refunds, err = h.applyWaiver(tx, &booking, &waiver)
+		return err
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Waiver not found"})
+		return
+	case errors.Is(err, errWaiverInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Waiver is not awaiting approval"})
+		return
+	case errors.Is(err, errWaiverStale):
+		c.JSON(http.StatusConflict, gin.H{"error": "Waiver no longer applies: " + stale.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update waiver"})
+		return
+	}
+	h.issueRefunds(c.Request.Context(), refunds)
+
+	c.JSON(http.StatusOK, gin.H{"waiver": waiver, "refunds": refunds})
+}
+
+// validateWaiver checks the waiver makes sense for the booking as it stands.
+func validateWaiver(db *gorm.DB, booking *models.Booking, waiver *models.Waiver) error {
+	if booking.Status == models.StatusCancelled {
+		return errors.New("booking is cancelled")
+	}
+
+	switch waiver.Type {
+	case models.WaiverHoldExpiry:
+		if booking.Status != models.StatusHold {
+			return errors.New("only unpaid bookings have a hold to extend")
+		}
+		if waiver.Amount != 0 {
+			return errors.New("hold expiry waivers have no amount")
+		}
+	case models.WaiverBaggage:
+		var charged, waived int64
+		if err := db.Model(&models.Baggage{}).Where("booking_id = ?", booking.ID).
+			Select("COALESCE(SUM(price), 0)").Scan(&charged).Error; err != nil {
+			return err
+		}
+		if err := db.Model(&models.Waiver{}).Where("booking_id = ? AND type = ? AND status = ?", booking.ID, models.WaiverBaggage, models.WaiverApplied).
+			Select("COALESCE(SUM(amount), 0)").Scan(&waived).Error; err != nil {
+			return err
+		}
+		if waiver.Amount <= 0 || waiver.Amount > charged-waived {
+			return errors.New("amount must be positive and no more than the baggage charged")
+		}
+	default:
+		if waiver.Amount <= 0 {
+			return errors.New("amount must be positive")
+		}
+	}
+	return nil
+}
+
+// needsApproval compares an amount against the agent limit, converting it to
+// the limit's currency. Anything that cannot be compared needs approval.
+func (h *BookingHandler) needsApproval(ctx context.Context, amount money.Money) bool {
+	limit := h.cfg.WaiverApprovalLimit
+	if amount.IsZero() {
+		return false
+	}
+	if limit.Currency == "" {
+		return true
+	}
+	if amount.Currency != limit.Currency {
+		rate, err := h.rates.Rate(ctx, amount.Currency, limit.Currency)
+		if err != nil {
+			return true
+		}
+		if amount, err = rate.Convert(amount); err != nil {
+			return true
+		}
+	}
+	return amount.Amount > limit.Amount
+}
+
+func approveWaiver(waiver *models.Waiver, decidedBy uint) {
+	now := time.Now()
+	waiver.Status = models.WaiverApproved
+	waiver.DecidedBy = &decidedBy
+	waiver.DecidedAt = &now
+}
+
+// applyWaiver puts an approved baggage or hold expiry waiver into effect. It
+// returns any refunds to issue once the transaction commits.
+func (h *BookingHandler) applyWaiver(tx *gorm.DB, booking *models.Booking, waiver *models.Waiver) ([]models.Refund, error) {
+	var refunds []models.Refund
+
+	switch waiver.Type {
+	case models.WaiverHoldExpiry:
+		ttl := h.cfg.HoldTTL
+		if ttl <= 0 {
+			ttl = 24 * time.Hour
+		}
+		expiresAt := time.Now().Add(ttl)
+		if err := tx.Model(booking).Update("hold_expires_at", expiresAt).Error; err != nil {
+			return nil, err
+		}
+	case models.WaiverBaggage:
+		if booking.Status == models.StatusHold {
+			if err := adjustBase(booking, waiver.Money().Neg()); err != nil {
+				return nil, err
+			}
+			if err := tx.Model(booking).Updates(map[string]interface{}{
+				"base_amount":       booking.BaseAmount,
+				"total_amount":      booking.TotalAmount,
+				"stripe_session_id": nil,
+			}).Error; err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		amount, err := settle(booking, waiver.Money())
+		if err != nil {
+			return nil, err
+		}
+		paid, err := netPaid(tx, booking.ID, amount.Currency)
+		if err != nil {
+			return nil, err
+		}
+		if amount.Amount > paid.Amount {
+			amount = paid
+		}
+		if amount.Amount > 0 {
+			if refunds, err = allocateRefund(tx, booking, amount, "waiver"); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		// Used by the next exchange or cancellation
+		return nil, nil
+	}
+
+	return refunds, markWaiversApplied(tx, []uint{waiver.ID}, waiver)
+}
+
+// claimWaivers offsets charge with the booking's approved, unused waivers of
+// the given type, oldest first. It returns the amount waived and the waivers
+// used, which the caller marks applied once the charge is committed.
+func claimWaivers(db *gorm.DB, bookingID uint, waiverType models.WaiverType, charge money.Money) (money.Money, []uint, error) {
+	waived := money.Zero(charge.Currency)
+	if charge.Amount <= 0 {
+		return waived, nil, nil
+	}
+
+	var waivers []models.Waiver
+	if err := db.Where("booking_id = ? AND type = ? AND status = ? AND currency = ?", bookingID, waiverType, models.WaiverApproved, charge.Currency).
+		Order("id").Find(&waivers).Error; err != nil {
+		return money.Money{}, nil, err
+	}
+
+	var ids []uint
+	for _, waiver := range waivers {
+		if waived.Amount >= charge.Amount {
+			break
+		}
+		waived = money.New(min(waived.Amount+waiver.Amount, charge.Amount), charge.Currency)
+		ids = append(ids, waiver.ID)
+	}
+	return waived, ids, nil
+}
+
+// markWaiversApplied records that waivers have been used. A waiver passed in
+// is updated in memory too.
+func markWaiversApplied(tx *gorm.DB, ids []uint, loaded ...*models.Waiver) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for _, waiver := range loaded {
+		waiver.Status = models.WaiverApplied
+		waiver.AppliedAt = &now
+	}
+	return tx.Model(&models.Waiver{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+		"status":     models.WaiverApplied,
+		"applied_at": now,
+	}).Error
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"skyliner/internal/config"
+	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
+	"skyliner/internal/money"
+	"skyliner/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+const (
+	agentID uint = 2
+	adminID uint = 3
+)
+
+// setupWaiverTestRouter serves the admin routes as the agent, or as the admin
+// when the request carries X-Test-Admin.
+func setupWaiverTestRouter(db *gorm.DB, pay payments.Client) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	db.Create(&models.User{Email: "agent@example.com", PasswordHash: "x", Role: models.RoleAgent})
+	db.Create(&models.User{Email: "admin@example.com", PasswordHash: "x", Role: models.RoleAdmin})
+
+	cfg := &config.Config{HoldTTL: 24 * time.Hour, WaiverApprovalLimit: money.New(10000, "USD")}
+	bookingHandler := NewBookingHandler(db, cfg, fx.Default(), pay, nil)
+
+	router := gin.New()
+	admin := router.Group("/admin")
+	admin.Use(testStaffRole)
+	admin.POST("/bookings/:id/waive", bookingHandler.WaiveBooking)
+	admin.GET("/waivers", bookingHandler.GetWaivers)
+	admin.POST("/waivers/:id/approve", bookingHandler.ApproveWaiver)
+	admin.POST("/waivers/:id/reject", bookingHandler.RejectWaiver)
+	return router
+}
+
+// testStaffRole signs requests in as the agent, or as the admin when they
+// carry X-Test-Admin.
+func testStaffRole(c *gin.Context) {
+	if c.GetHeader("X-Test-Admin") != "" {
+		c.Set("user_id", adminID)
+		c.Set("role", string(models.RoleAdmin))
+	} else {
+		c.Set("user_id", agentID)
+		c.Set("role", string(models.RoleAgent))
+	}
+	c.Next()
+}
+
+func adminRequest(router *gin.Engine, method, path string, body interface{}, asAdmin bool) *httptest.ResponseRecorder {
+	var reader *bytes.Buffer
+	if body != nil {
+		jsonData, _ := json.Marshal(body)
+		reader = bytes.NewBuffer(jsonData)
+	} else {
+		reader = bytes.NewBuffer(nil)
+	}
+	req, _ := http.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if asAdmin {
+		req.Header.Set("X-Test-Admin", "1")
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func waiverStatus(db *gorm.DB, id uint) models.WaiverStatus {
+	var waiver models.Waiver
+	db.First(&waiver, id)
+	return waiver.Status
+}
+
+func TestBookingHandler_WaiveChangeFee(t *testing.T) {
+	db := setupBookingTestDB()
+	addExchangeFlight(db)
+	admin := setupWaiverTestRouter(db, payments.NewFakeClient())
+	router := setupBookingTestRouter(db)
+	paidBooking(t, db, 1)
+
+	// 60.00 USD is within the agent limit, so no approval is needed
+	w := adminRequest(admin, "POST", "/admin/bookings/1/waive", gin.H{"type": "change_fee", "reason_code": "schedule_change", "amount": 6000}, false)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, models.WaiverApproved, waiverStatus(db, 1))
+
+	w = postExchange(router, "/bookings/1/exchanges", 2)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var response ExchangeResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, int64(0), response.Quote.ChangeFee.Amount)
+	assert.Equal(t, int64(6000), response.Quote.FeeWaived.Amount)
+	assert.Equal(t, int64(10000), response.Quote.AmountDue.Amount)
+	assert.Equal(t, models.WaiverApplied, waiverStatus(db, 1))
+}
+
+func TestBookingHandler_WaiverApproval(t *testing.T) {
+	db := setupBookingTestDB()
+	pay := payments.NewFakeClient()
+	admin := setupWaiverTestRouter(db, pay)
+	paidBooking(t, db, 1)
+
+	// Above the limit an agent's waiver waits for an admin
+	w := adminRequest(admin, "POST", "/admin/bookings/1/waive", gin.H{"type": "cancellation_penalty", "reason_code": "medical", "amount": 10000}, false)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = adminRequest(admin, "POST", "/admin/bookings/1/waive", gin.H{"type": "cancellation_penalty", "reason_code": "medical", "amount": 15000}, false)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal(t, models.WaiverPendingApproval, waiverStatus(db, 2))
+
+	w = adminRequest(admin, "GET", "/admin/waivers?status=pending_approval", nil, false)
+	assert.Contains(t, w.Body.String(), `"id":2`)
+
+	w = adminRequest(admin, "POST", "/admin/waivers/2/approve", nil, false)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = adminRequest(admin, "POST", "/admin/waivers/2/approve", nil, true)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var waiver models.Waiver
+	db.First(&waiver, 2)
+	assert.Equal(t, models.WaiverApproved, waiver.Status)
+	assert.Equal(t, agentID, waiver.RequestedBy)
+	if assert.NotNil(t, waiver.DecidedBy) {
+		assert.Equal(t, adminID, *waiver.DecidedBy)
+	}
+
+	// The 25% penalty is 100.00, so only the first waiver is needed
+	w = cancelBooking(setupRefundTestRouter(db, pay), "1")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	if assert.Len(t, pay.Refunds, 1) {
+		assert.Equal(t, int64(39999), pay.Refunds[0].Amount.Amount)
+	}
+	assert.Equal(t, models.WaiverApplied, waiverStatus(db, 1))
+	assert.Equal(t, models.WaiverApproved, waiverStatus(db, 2))
+}
+
+func TestBookingHandler_RejectWaiver(t *testing.T) {
+	db := setupBookingTestDB()
+	admin := setupWaiverTestRouter(db, payments.NewFakeClient())
+	paidBooking(t, db, 1)
+
+	adminRequest(admin, "POST", "/admin/bookings/1/waive", gin.H{"type": "change_fee", "reason_code": "goodwill", "amount": 50000}, false)
+	w := adminRequest(admin, "POST", "/admin/waivers/1/reject", nil, true)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, models.WaiverRejected, waiverStatus(db, 1))
+
+	w = adminRequest(admin, "POST", "/admin/waivers/1/approve", nil, true)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Nor is a waiver approved for a booking that has since been cancelled
+	w = adminRequest(admin, "POST", "/admin/bookings/1/waive", gin.H{"type": "cancellation_penalty", "reason_code": "medical", "amount": 50000}, false)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	db.Model(&models.Booking{}).Where("id = ?", 1).Update("status", models.StatusCancelled)
+	w = adminRequest(admin, "POST", "/admin/waivers/2/approve", nil, true)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "booking is cancelled")
+	assert.Equal(t, models.WaiverPendingApproval, waiverStatus(db, 2))
+}
+
+func TestBookingHandler_WaiveBaggageAndHold(t *testing.T) {
+	db := setupBookingTestDB()
+	pay := payments.NewFakeClient()
+	admin := setupWaiverTestRouter(db, pay)
+	router := setupBookingTestRouter(db)
+	bag := models.Ancillary{AirlineID: 1, Type: models.AncillaryBaggage, Code: "BAG23", Name: "Checked bag", Price: 5000, Currency: "USD", Weight: 23, Active: true}
+	db.Create(&bag)
+
+	booking := createTestBooking(t, router, map[string]interface{}{
+		"segments":    []map[string]interface{}{{"flight_id": 1, "fare_id": 1}},
+		"passengers":  []map[string]interface{}{{"first_name": "Ada", "last_name": "Lovelace"}},
+		"ancillaries": []map[string]interface{}{{"passenger": 0, "segment": 0, "ancillary_id": bag.ID}},
+	})
+	expired := time.Now().Add(-time.Hour)
+	db.Model(&booking).Update("hold_expires_at", expired)
+
+	w := adminRequest(admin, "POST", "/admin/bookings/1/waive", gin.H{"type": "hold_expiry", "reason_code": "agent_error"}, false)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	db.First(&booking, 1)
+	assert.False(t, booking.HoldExpired(time.Now()))
+
+	// Unpaid baggage comes off the total
+	w = adminRequest(admin, "POST", "/admin/bookings/1/waive", gin.H{"type": "baggage", "reason_code": "goodwill", "amount": 2000}, false)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	db.First(&booking, 1)
+	assert.Equal(t, int64(39999+3000), booking.TotalAmount)
+
+	// Paid baggage is refunded, up to what is left of the charge
+	db.Model(&booking).Update("status", models.StatusPaid)
+	db.Create(&models.Payment{BookingID: 1, StripePaymentID: "pi_booking", Amount: booking.TotalAmount, Currency: "USD", Status: "succeeded"})
+	w = adminRequest(admin, "POST", "/admin/bookings/1/waive", gin.H{"type": "baggage", "reason_code": "goodwill", "amount": 4000}, false)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = adminRequest(admin, "POST", "/admin/bookings/1/waive", gin.H{"type": "baggage", "reason_code": "goodwill", "amount": 3000}, false)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	if assert.Len(t, pay.Refunds, 1) {
+		assert.Equal(t, int64(3000), pay.Refunds[0].Amount.Amount)
+	}
+}
+
+func TestBookingHandler_WaiveValidation(t *testing.T) {
+	db := setupBookingTestDB()
+	admin := setupWaiverTestRouter(db, payments.NewFakeClient())
+	paidBooking(t, db, 1)
+
+	tests := []gin.H{
+		{"type": "change_fee", "reason_code": "because", "amount": 100},
+		{"type": "change_fee", "reason_code": "goodwill"},
+		{"type": "lounge", "reason_code": "goodwill", "amount": 100},
+		{"type": "hold_expiry", "reason_code": "goodwill"}, // already paid
+	}
+	for i, body := range tests {
+		w := adminRequest(admin, "POST", "/admin/bookings/1/waive", body, false)
+		assert.Equal(t, http.StatusBadRequest, w.Code, fmt.Sprintf("case %d: %s", i, w.Body.String()))
+	}
+
+	w := adminRequest(admin, "POST", "/admin/bookings/99/waive", gin.H{"type": "change_fee", "reason_code": "goodwill", "amount": 100}, false)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"skyliner/internal/db/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	defaultIdempotencyTTL    = 24 * time.Hour
+
+	// IdempotencyScopeKey names a context value that narrows keys further
+	// than the user, for callers who share a user_id without sharing a login.
+	IdempotencyScopeKey = "idempotency_scope"
+
+	// IdempotencyReplayKey names a context value a handler can set to the
+	// body retries should get, when its response carries a secret that only
+	// the caller who made the request may see.
+	IdempotencyReplayKey = "idempotency_replay"
+)
+
+// responseRecorder keeps a copy of what the handler writes so it can be
+// stored against the idempotency key.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency makes non-idempotent endpoints safe to retry. A request carrying
+// an Idempotency-Key header is executed once per user and key; retries with
+// the same body get the stored response, retries with a different body are
+// rejected. Requests without the header pass through untouched.
+// Must run after AuthRequired so keys are scoped to the caller, or after
+// whatever sets IdempotencyScopeKey for callers without a login.
+func Idempotency(db *gorm.DB, ttl time.Duration) gin.HandlerFunc {
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID := c.GetUint("user_id")
+		fingerprint := requestFingerprint(c.Request.Method, c.FullPath(), body)
+		if scope := c.GetString(IdempotencyScopeKey); scope != "" {
+			key = scope + " " + key
+		}
+
+		record := models.IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			Method:      c.Request.Method,
+			Path:        c.FullPath(),
+			Fingerprint: fingerprint,
+			ExpiresAt:   time.Now().Add(ttl),
+		}
+
+		// Claim the key. The unique index makes concurrent retries race safely:
+		// exactly one insert wins and the others fall through to the lookup.
+		err = db.Create(&record).Error
+		if err != nil && !errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store idempotency key"})
+			c.Abort()
+			return
+		}
+
+		if err != nil {
+			var existing models.IdempotencyKey
+			if err := db.Where("user_id = ? AND idempotency_key = ?", userID, key).First(&existing).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load idempotency key"})
+				c.Abort()
+				return
+			}
+
+			if time.Now().After(existing.ExpiresAt) {
+				// Stale key: forget it and let the request run as new
+				if err := db.Delete(&existing).Error; err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store idempotency key"})
+					c.Abort()
+					return
+				}
+				if err := db.Create(&record).Error; err != nil {
+					c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is already in progress"})
+					c.Abort()
+					return
+				}
+			} else {
+				replayIdempotentResponse(c, &existing, fingerprint)
+				return
+			}
+		}
+
+		// Unless the response is stored, release the key so the client can
+		// retry: server errors are not final, and a panic or a failed write
+		// would otherwise leave the key in progress until it expires.
+		stored := false
+		defer func() {
+			if !stored {
+				db.Delete(&record)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		body = recorder.body.Bytes()
+		if replay, ok := c.Get(IdempotencyReplayKey); ok {
+			body = replay.([]byte)
+		}
+		if err := db.Model(&record).Updates(map[string]interface{}{
+			"completed":     true,
+			"status_code":   status,
+			"response_body": body,
+			"content_type":  recorder.Header().Get("Content-Type"),
+		}).Error; err != nil {
+			return
+		}
+		stored = true
+	}
+}
+
+func replayIdempotentResponse(c *gin.Context, existing *models.IdempotencyKey, fingerprint string) {
+	switch {
+	case existing.Fingerprint != fingerprint:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request"})
+	case !existing.Completed:
+		c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is already in progress"})
+	default:
+		c.Header(IdempotentReplayedHeader, "true")
+		c.Data(existing.StatusCode, existing.ContentType, existing.ResponseBody)
+	}
+	c.Abort()
+}
+
+func requestFingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"skyliner/internal/db/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupIdempotencyRouter(t *testing.T, ttl time.Duration) (*gin.Engine, *gorm.DB, *int) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{TranslateError: true})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.IdempotencyKey{}))
+
+	calls := 0
+	router := gin.New()
+	router.Use(gin.Recovery(), func(c *gin.Context) {
+		if id, err := strconv.Atoi(c.GetHeader("X-Test-User")); err == nil {
+			c.Set("user_id", uint(id))
+		}
+		if scope := c.GetHeader("X-Test-Scope"); scope != "" {
+			c.Set(IdempotencyScopeKey, scope)
+		}
+		c.Next()
+	})
+	router.POST("/bookings", Idempotency(db, ttl), func(c *gin.Context) {
+		calls++
+		if c.GetHeader("X-Test-Panic") != "" {
+			panic("boom")
+		}
+		if c.GetHeader("X-Test-Fail") != "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "boom"})
+			return
+		}
+		if c.GetHeader("X-Test-Secret") != "" {
+			c.Set(IdempotencyReplayKey, []byte(`{"booking_id":`+strconv.Itoa(calls)+`}`))
+			c.JSON(http.StatusCreated, gin.H{"booking_id": calls, "secret": "s3cret"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"booking_id": calls})
+	})
+	return router, db, &calls
+}
+
+func postWithKey(router *gin.Engine, key, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/bookings", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Test-User", "1")
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyReplaysResponse(t *testing.T) {
+	router, _, calls := setupIdempotencyRouter(t, time.Hour)
+
+	first := postWithKey(router, "key-1", `{"fare_id":1}`, nil)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get(IdempotentReplayedHeader))
+
+	retry := postWithKey(router, "key-1", `{"fare_id":1}`, nil)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, 1, *calls)
+
+	// A new key is a new request
+	other := postWithKey(router, "key-2", `{"fare_id":1}`, nil)
+	assert.Equal(t, http.StatusCreated, other.Code)
+	assert.Equal(t, 2, *calls)
+}
+
+func TestIdempotencyRejectsDifferentBody(t *testing.T) {
+	router, _, calls := setupIdempotencyRouter(t, time.Hour)
+
+	postWithKey(router, "key-1", `{"fare_id":1}`, nil)
+	w := postWithKey(router, "key-1", `{"fare_id":2}`, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestIdempotencyScopedPerUser(t *testing.T) {
+	router, _, calls := setupIdempotencyRouter(t, time.Hour)
+
+	postWithKey(router, "key-1", `{}`, nil)
+	w := postWithKey(router, "key-1", `{}`, map[string]string{"X-Test-User": "2"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, 2, *calls)
+}
+
+func TestIdempotencyScopedWithinUser(t *testing.T) {
+	router, _, calls := setupIdempotencyRouter(t, time.Hour)
+
+	postWithKey(router, "key-1", `{}`, map[string]string{"X-Test-Scope": "booking:1"})
+	w := postWithKey(router, "key-1", `{}`, map[string]string{"X-Test-Scope": "booking:2"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get(IdempotentReplayedHeader))
+	w = postWithKey(router, "key-1", `{}`, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 3, *calls)
+
+	w = postWithKey(router, "key-1", `{}`, map[string]string{"X-Test-Scope": "booking:1"})
+	assert.Equal(t, "true", w.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, 3, *calls)
+}
+
+func TestIdempotencyReplaysWithoutSecret(t *testing.T) {
+	router, _, calls := setupIdempotencyRouter(t, time.Hour)
+
+	secret := map[string]string{"X-Test-Secret": "1"}
+	first := postWithKey(router, "key-1", `{}`, secret)
+	assert.Contains(t, first.Body.String(), "s3cret")
+
+	retry := postWithKey(router, "key-1", `{}`, secret)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get(IdempotentReplayedHeader))
+	assert.JSONEq(t, `{"booking_id":1}`, retry.Body.String())
+	assert.Equal(t, 1, *calls)
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	router, db, calls := setupIdempotencyRouter(t, time.Hour)
+
+	db.Create(&models.IdempotencyKey{
+		UserID:      1,
+		Key:         "key-1",
+		Method:      "POST",
+		Path:        "/bookings",
+		Fingerprint: requestFingerprint("POST", "/bookings", []byte(`{}`)),
+		ExpiresAt:   time.Now().Add(time.Hour),
+	})
+
+	w := postWithKey(router, "key-1", `{}`, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, 0, *calls)
+}
+
+func TestIdempotencyServerErrorReleasesKey(t *testing.T) {
+	router, _, calls := setupIdempotencyRouter(t, time.Hour)
+
+	w := postWithKey(router, "key-1", `{}`, map[string]string{"X-Test-Fail": "1"})
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	w = postWithKey(router, "key-1", `{}`, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 2, *calls)
+}
+
+func TestIdempotencyPanicReleasesKey(t *testing.T) {
+	router, db, calls := setupIdempotencyRouter(t, time.Hour)
+
+	w := postWithKey(router, "key-1", `{}`, map[string]string{"X-Test-Panic": "1"})
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	var keys int64
+	db.Model(&models.IdempotencyKey{}).Count(&keys)
+	assert.Zero(t, keys)
+
+	w = postWithKey(router, "key-1", `{}`, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 2, *calls)
+}
+
+func TestIdempotencyFailedStoreReleasesKey(t *testing.T) {
+	router, db, calls := setupIdempotencyRouter(t, time.Hour)
+
+	assert.NoError(t, db.Callback().Update().Before("gorm:update").Register("test:fail_update", func(tx *gorm.DB) {
+		_ = tx.AddError(errors.New("disk full"))
+	}))
+	w := postWithKey(router, "key-1", `{}`, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NoError(t, db.Callback().Update().Remove("test:fail_update"))
+
+	// Nothing was stored to replay, so the retry runs rather than waiting
+	// on a key left in progress
+	w = postWithKey(router, "key-1", `{}`, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, 2, *calls)
+}
+
+func TestIdempotencyExpiredKey(t *testing.T) {
+	router, db, calls := setupIdempotencyRouter(t, time.Hour)
+
+	postWithKey(router, "key-1", `{}`, nil)
+	db.Model(&models.IdempotencyKey{}).Where("idempotency_key = ?", "key-1").Update("expires_at", time.Now().Add(-time.Minute))
+
+	w := postWithKey(router, "key-1", `{"different":true}`, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 2, *calls)
+}
+
+func TestIdempotencyWithoutHeader(t *testing.T) {
+	router, _, calls := setupIdempotencyRouter(t, time.Hour)
+
+	postWithKey(router, "", `{}`, nil)
+	postWithKey(router, "", `{}`, nil)
+	assert.Equal(t, 2, *calls)
+}
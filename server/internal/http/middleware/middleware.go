@@ -28,7 +28,7 @@ func CORS(origins []string) gin.HandlerFunc {
 			c.Header("Access-Control-Allow-Origin", origin)
 		}
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, Idempotency-Key")
 		c.Header("Access-Control-Allow-Credentials", "true")
 
 		if c.Request.Method == "OPTIONS" {
@@ -105,6 +105,16 @@ func AuthRequired(jwtSecret string) gin.HandlerFunc {
 	}
 }
 
+// Database makes db available to middleware that looks up the current user.
+func Database(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("db", db)
+		c.Next()
+	}
+}
+
+// AdminRequired lets admins and agents through and records their role as
+// "role" for handlers that allow only admins.
 func AdminRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, exists := c.Get("user_id")
@@ -128,6 +138,7 @@ func AdminRequired() gin.HandlerFunc {
 			return
 		}
 
+		c.Set("role", string(user.Role))
 		c.Next()
 	}
 }
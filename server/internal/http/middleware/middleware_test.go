@@ -173,3 +173,29 @@ func TestAdminRequired(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 }
+
+func TestAdminRequiredWithDatabase(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB()
+	agent := models.User{Email: "agent@example.com", Role: models.RoleAgent}
+	db.Create(&agent)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", agent.ID)
+		c.Next()
+	})
+	router.Use(Database(db))
+	router.Use(AdminRequired())
+	router.GET("/admin", func(c *gin.Context) {
+		c.JSON(200, gin.H{"role": c.GetString("role")})
+	})
+
+	req, _ := http.NewRequest("GET", "/admin", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"role":"agent"}`, w.Body.String())
+}
@@ -2,15 +2,17 @@ package http
 
 import (
 	"skyliner/internal/config"
+	"skyliner/internal/fx"
 	"skyliner/internal/http/handlers"
 	"skyliner/internal/http/middleware"
+	"skyliner/internal/payments"
 	"skyliner/internal/ws"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func SetupRoutes(router *gin.Engine, db *gorm.DB, hub *ws.Hub, cfg *config.Config) {
+func SetupRoutes(router *gin.Engine, db *gorm.DB, hub *ws.Hub, rates fx.Provider, pay payments.Client, cfg *config.Config) {
 	// Middleware
 	router.Use(middleware.CORS(cfg.CORSOrigins))
 	router.Use(middleware.Logger())
@@ -27,9 +29,18 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, hub *ws.Hub, cfg *config.Confi
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(db, cfg)
-	searchHandler := handlers.NewSearchHandler(db)
-	bookingHandler := handlers.NewBookingHandler(db, cfg)
+	searchHandler := handlers.NewSearchHandler(db, rates)
+	bookingHandler := handlers.NewBookingHandler(db, cfg, rates, pay, hub)
 	paymentHandler := handlers.NewPaymentHandler(db, cfg)
+	creditHandler := handlers.NewCreditHandler(db, cfg)
+	promotionHandler := handlers.NewPromotionHandler(db)
+	ancillaryHandler := handlers.NewAncillaryHandler(db, cfg)
+	idempotent := middleware.Idempotency(db, cfg.IdempotencyTTL)
+
+	// Release lapsed holds and waitlist offers in the background
+	if cfg.SweepInterval > 0 {
+		go bookingHandler.RunSweeper(cfg.SweepInterval)
+	}
 
 	// API routes
 	api := router.Group("/api/v1")
@@ -42,6 +53,7 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, hub *ws.Hub, cfg *config.Confi
 			auth.POST("/google", authHandler.GoogleAuth)
 			auth.POST("/refresh", authHandler.Refresh)
 			auth.POST("/logout", authHandler.Logout)
+			auth.GET("/verify-email", authHandler.VerifyEmail)
 		}
 
 		// Public routes
@@ -49,6 +61,27 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, hub *ws.Hub, cfg *config.Confi
 		api.GET("/airlines", searchHandler.GetAirlines)
 		api.POST("/search", searchHandler.SearchFlights)
 		api.GET("/flights/:id/seatmap", searchHandler.GetSeatMap)
+		api.GET("/flights/:id/ssrs", searchHandler.GetFlightSSRs)
+		api.GET("/flights/:id/ancillaries", ancillaryHandler.GetFlightAncillaries)
+		api.GET("/bookings/by-pnr/:pnr", bookingHandler.GetBookingByPNR)
+		api.POST("/bookings/by-pnr/:pnr/manage-link", bookingHandler.SendManageLink)
+		api.GET("/calendar-feeds/:token", bookingHandler.GetCalendarFeedEvents)
+
+		// Guest checkout, and the manage-booking link that stands in for a login
+		guest := api.Group("/guest")
+		{
+			guest.POST("/bookings", bookingHandler.GuestCheckout, idempotent, bookingHandler.CreateBooking)
+
+			manage := guest.Group("/manage/:token", bookingHandler.ManageBooking)
+			manage.GET("", bookingHandler.GetBooking)
+			manage.GET("/refund-quote", bookingHandler.GetRefundQuote)
+			manage.POST("/cancel", bookingHandler.CancelBooking)
+			manage.POST("/checkout-session", idempotent, paymentHandler.CreateCheckoutSession)
+			manage.GET("/documents/itinerary.pdf", bookingHandler.GetItineraryDocument)
+			manage.GET("/calendar.ics", bookingHandler.GetBookingCalendar)
+			manage.POST("/check-in", bookingHandler.CheckIn)
+			manage.GET("/boarding-passes", bookingHandler.GetBoardingPasses)
+		}
 
 		// Protected routes
 		protected := api.Group("")
@@ -57,28 +90,90 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, hub *ws.Hub, cfg *config.Confi
 			// Booking routes
 			bookings := protected.Group("/bookings")
 			{
-				bookings.POST("", bookingHandler.CreateBooking)
+				bookings.POST("", idempotent, bookingHandler.CreateBooking)
+				bookings.GET("", bookingHandler.GetBookings)
 				bookings.GET("/:id", bookingHandler.GetBooking)
 				bookings.POST("/:id/issue", bookingHandler.IssueBooking)
+				bookings.GET("/:id/documents/itinerary.pdf", bookingHandler.GetItineraryDocument)
+				bookings.GET("/:id/calendar.ics", bookingHandler.GetBookingCalendar)
+				bookings.POST("/:id/check-in", bookingHandler.CheckIn)
+				bookings.GET("/:id/boarding-passes", bookingHandler.GetBoardingPasses)
+				bookings.GET("/:id/boarding-passes/:pass_id/barcode.png", bookingHandler.GetBoardingPassBarcode)
+				bookings.GET("/:id/boarding-passes/:pass_id/wallet.json", bookingHandler.GetBoardingPassWallet)
+				bookings.GET("/:id/refund-quote", bookingHandler.GetRefundQuote)
 				bookings.POST("/:id/cancel", bookingHandler.CancelBooking)
+				bookings.POST("/:id/split", bookingHandler.SplitBooking)
+				bookings.GET("/:id/seats", bookingHandler.GetSeatAssignments)
+				bookings.PUT("/:id/seats", bookingHandler.UpdateSeatAssignments)
+				bookings.POST("/:id/names", bookingHandler.NameGroupPassengers)
+				bookings.GET("/:id/apis", bookingHandler.GetAPIS)
+				bookings.PUT("/:id/passengers/:passenger_id/apis", bookingHandler.UpdateAPIS)
+				bookings.GET("/:id/ssrs", bookingHandler.GetSSRs)
+				bookings.POST("/:id/ssrs", bookingHandler.AddSSR)
+				bookings.POST("/:id/ssrs/:ssr_id/cancel", bookingHandler.CancelSSR)
+				bookings.GET("/:id/ancillaries", bookingHandler.GetBookingAncillaries)
+				bookings.POST("/:id/ancillaries", bookingHandler.AddAncillaries)
+				bookings.POST("/:id/ancillaries/:purchase_id/cancel", bookingHandler.CancelAncillary)
+				bookings.POST("/:id/exchange/quote", bookingHandler.QuoteExchange)
+				bookings.GET("/:id/exchanges", bookingHandler.GetExchanges)
+				bookings.POST("/:id/exchanges", bookingHandler.CreateExchange)
+				bookings.POST("/:id/exchanges/:exchange_id/cancel", bookingHandler.CancelExchange)
 			}
 
 			// Payment routes
 			payments := protected.Group("/payments")
 			{
-				payments.POST("/checkout-session", paymentHandler.CreateCheckoutSession)
+				payments.POST("/checkout-session", idempotent, paymentHandler.CreateCheckoutSession)
 				payments.POST("/billing-portal", paymentHandler.CreateBillingPortal)
 			}
+
+			protected.GET("/credits", creditHandler.GetCredits)
+			protected.POST("/account/verify-email", authHandler.SendVerificationEmail)
+			protected.POST("/account/attach-guest-bookings", bookingHandler.AttachGuestBookings)
+			protected.GET("/waitlist", bookingHandler.GetWaitlist)
+			protected.POST("/waitlist", bookingHandler.JoinWaitlist)
+			protected.POST("/waitlist/:id/cancel", bookingHandler.LeaveWaitlist)
+			protected.GET("/calendar-feed", bookingHandler.GetCalendarFeed)
+			protected.DELETE("/calendar-feed", bookingHandler.RevokeCalendarFeed)
 		}
 
 		// Admin/Agent routes
 		admin := api.Group("/admin")
 		admin.Use(middleware.AuthRequired(cfg.JWTSecret))
+		admin.Use(middleware.Database(db))
 		admin.Use(middleware.AdminRequired())
 		{
-			admin.GET("/bookings", bookingHandler.GetAllBookings)
+			admin.GET("/bookings", bookingHandler.SearchBookings)
+			admin.GET("/bookings/export", bookingHandler.ExportBookings)
+			admin.POST("/groups", bookingHandler.CreateGroup)
+			admin.POST("/bookings/:id/split", bookingHandler.AgentSplitBooking)
 			admin.POST("/bookings/:id/waive", bookingHandler.WaiveBooking)
+			admin.GET("/bookings/:id/waivers", bookingHandler.GetWaivers)
+			admin.GET("/bookings/:id/remarks", bookingHandler.GetRemarks)
+			admin.POST("/bookings/:id/remarks", bookingHandler.AddRemark)
+			admin.GET("/queues", bookingHandler.GetQueues)
+			admin.GET("/queues/:queue", bookingHandler.GetQueueItems)
+			admin.POST("/queue-items/:id/claim", bookingHandler.ClaimQueueItem)
+			admin.POST("/queue-items/:id/complete", bookingHandler.CompleteQueueItem)
+			admin.GET("/waivers", bookingHandler.GetWaivers)
+			admin.POST("/waivers/:id/approve", bookingHandler.ApproveWaiver)
+			admin.POST("/waivers/:id/reject", bookingHandler.RejectWaiver)
 			admin.POST("/reprice", bookingHandler.RepriceBookings)
+			admin.GET("/reprice/:id", bookingHandler.GetRepriceJob)
+			admin.POST("/users/:id/vouchers", creditHandler.IssueVoucher)
+			admin.GET("/promotions", promotionHandler.GetPromotions)
+			admin.POST("/promotions", promotionHandler.CreatePromotion)
+			admin.PUT("/promotions/:id", promotionHandler.UpdatePromotion)
+			admin.GET("/ssrs", bookingHandler.GetSSRQueue)
+			admin.POST("/ssrs/:id/confirm", bookingHandler.ConfirmSSR)
+			admin.POST("/ssrs/:id/reject", bookingHandler.RejectSSR)
+			admin.GET("/ancillaries", ancillaryHandler.GetAncillaries)
+			admin.POST("/ancillaries", ancillaryHandler.CreateAncillary)
+			admin.PUT("/ancillaries/:id", ancillaryHandler.UpdateAncillary)
+			admin.PUT("/flights/:id/check-in", bookingHandler.UpdateCheckInWindow)
+			admin.PUT("/flights/:id/schedule", bookingHandler.RescheduleFlight)
+			admin.GET("/flights/:id/waitlist", bookingHandler.GetFlightWaitlist)
+			admin.PUT("/users/:id/loyalty-tier", bookingHandler.SetLoyaltyTier)
 		}
 	}
 
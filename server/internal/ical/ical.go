@@ -0,0 +1,230 @@
+// Package ical writes iCalendar (RFC 5545) files of events. Times keep the
+// location they are given in, and every zone used gets a VTIMEZONE built from
+// the Go time zone database.
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusConfirmed Status = "CONFIRMED"
+	StatusTentative Status = "TENTATIVE"
+	StatusCancelled Status = "CANCELLED"
+)
+
+type Event struct {
+	UID         string // stable across exports, so calendars update the event
+	Summary     string
+	Location    string
+	Description string
+	Start, End  time.Time
+	Status      Status
+}
+
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+const (
+	localLayout = "20060102T150405"
+	utcLayout   = "20060102T150405Z"
+)
+
+// WriteTo writes the calendar, stamped with the current time.
+func (c Calendar) WriteTo(w io.Writer) (int64, error) {
+	return c.write(w, time.Now())
+}
+
+func (c Calendar) write(w io.Writer, now time.Time) (int64, error) {
+	var b bytes.Buffer
+	line := func(format string, args ...interface{}) {
+		fold(&b, fmt.Sprintf(format, args...))
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//Skyliner//Itinerary//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	if c.Name != "" {
+		line("X-WR-CALNAME:%s", escape(c.Name))
+	}
+
+	for _, zone := range c.zones() {
+		writeTimezone(line, zone.loc, zone.from, zone.to)
+	}
+
+	for _, e := range c.Events {
+		line("BEGIN:VEVENT")
+		line("UID:%s", e.UID)
+		line("DTSTAMP:%s", now.UTC().Format(utcLayout))
+		line("DTSTART%s", dateTime(e.Start))
+		line("DTEND%s", dateTime(e.End))
+		line("SUMMARY:%s", escape(e.Summary))
+		if e.Location != "" {
+			line("LOCATION:%s", escape(e.Location))
+		}
+		if e.Description != "" {
+			line("DESCRIPTION:%s", escape(e.Description))
+		}
+		if e.Status != "" {
+			line("STATUS:%s", e.Status)
+		}
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+
+	return b.WriteTo(w)
+}
+
+// dateTime formats a DTSTART or DTEND value with its TZID, or in UTC.
+func dateTime(t time.Time) string {
+	if t.Location() == time.UTC || t.Location().String() == "Local" {
+		return ":" + t.UTC().Format(utcLayout)
+	}
+	return fmt.Sprintf(";TZID=%s:%s", t.Location(), t.Format(localLayout))
+}
+
+type zoneSpan struct {
+	loc      *time.Location
+	from, to time.Time
+}
+
+// zones lists the named locations events use, with the span of time each
+// must describe.
+func (c Calendar) zones() []zoneSpan {
+	spans := map[string]*zoneSpan{}
+	add := func(t time.Time) {
+		if t.Location() == time.UTC || t.Location().String() == "Local" {
+			return
+		}
+		name := t.Location().String()
+		span, ok := spans[name]
+		if !ok {
+			spans[name] = &zoneSpan{loc: t.Location(), from: t, to: t}
+			return
+		}
+		if t.Before(span.from) {
+			span.from = t
+		}
+		if t.After(span.to) {
+			span.to = t
+		}
+	}
+	for _, e := range c.Events {
+		add(e.Start)
+		add(e.End)
+	}
+
+	var out []zoneSpan
+	for _, span := range spans {
+		out = append(out, *span)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].loc.String() < out[j].loc.String() })
+	return out
+}
+
+// writeTimezone describes loc between from and to: the offset in force at
+// from, then each change until to.
+func writeTimezone(line func(string, ...interface{}), loc *time.Location, from, to time.Time) {
+	line("BEGIN:VTIMEZONE")
+	line("TZID:%s", loc)
+
+	observance := func(onset time.Time, before, after time.Time) {
+		kind := "STANDARD"
+		if after.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		name, _ := after.Zone()
+		line("BEGIN:%s", kind)
+		// The onset is given in the local time that applied before it
+		line("DTSTART:%s", onset.In(fixedZone(before)).Format(localLayout))
+		line("TZOFFSETFROM:%s", offset(before))
+		line("TZOFFSETTO:%s", offset(after))
+		line("TZNAME:%s", name)
+		line("END:%s", kind)
+	}
+
+	start := from.In(loc)
+	observance(time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), start, start)
+	for _, change := range transitions(loc, from, to) {
+		observance(change, change.Add(-time.Second).In(loc), change.In(loc))
+	}
+	line("END:VTIMEZONE")
+}
+
+// transitions finds the instants loc changes offset between from and to.
+func transitions(loc *time.Location, from, to time.Time) []time.Time {
+	var out []time.Time
+	_, current := from.In(loc).Zone()
+	for t := from; t.Before(to); {
+		next := t.Add(24 * time.Hour)
+		if next.After(to) {
+			next = to
+		}
+		if _, o := next.In(loc).Zone(); o != current {
+			// Narrow the day down to the second the offset changed
+			lo, hi := t, next
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2).Truncate(time.Second)
+				if _, o := mid.In(loc).Zone(); o == current {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			out = append(out, hi)
+			current = o
+		}
+		t = next
+	}
+	return out
+}
+
+func fixedZone(t time.Time) *time.Location {
+	name, seconds := t.Zone()
+	return time.FixedZone(name, seconds)
+}
+
+// offset formats a UTC offset as +HHMM.
+func offset(t time.Time) string {
+	_, seconds := t.Zone()
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+// escape escapes TEXT values.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// fold writes a content line, folding it at 75 octets without splitting a
+// UTF-8 character.
+func fold(b *bytes.Buffer, s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		limit = 74 // the leading space counts
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,84 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalendar_Write(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+	london, _ := time.LoadLocation("Europe/London")
+	cal := Calendar{
+		Name: "Trip ABC234",
+		Events: []Event{{
+			UID:         "segment-1@skyliner",
+			Summary:     "BA200 JFK to LHR (ABC234)",
+			Location:    "John F. Kennedy International Airport, New York",
+			Description: "Seat 20A; confirmation ABC234",
+			Start:       time.Date(2026, 3, 7, 18, 30, 0, 0, newYork),
+			End:         time.Date(2026, 3, 8, 6, 45, 0, 0, london),
+			Status:      StatusConfirmed,
+		}, {
+			UID:     "segment-2@skyliner",
+			Summary: "BA201 LHR to JFK (ABC234)",
+			Start:   time.Date(2026, 3, 14, 10, 0, 0, 0, london),
+			End:     time.Date(2026, 3, 14, 13, 0, 0, 0, newYork),
+		}},
+	}
+
+	var b bytes.Buffer
+	_, err := cal.write(&b, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	assert.NoError(t, err)
+	out := b.String()
+
+	assert.True(t, strings.HasPrefix(out, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	assert.True(t, strings.HasSuffix(out, "END:VCALENDAR\r\n"))
+	assert.Contains(t, out, "DTSTAMP:20260102T030405Z\r\n")
+	assert.Contains(t, out, "DTSTART;TZID=America/New_York:20260307T183000\r\n")
+	assert.Contains(t, out, "DTEND;TZID=Europe/London:20260308T064500\r\n")
+	assert.Contains(t, out, `DESCRIPTION:Seat 20A\; confirmation ABC234`)
+	assert.Contains(t, out, `LOCATION:John F. Kennedy International Airport\, New York`)
+	assert.Equal(t, 2, strings.Count(out, "BEGIN:VTIMEZONE"))
+
+	// New York moves to daylight time on 8 March 2026 at 02:00 local, between
+	// the two trips; London does not change until the end of the month
+	newYorkZone := out[strings.Index(out, "TZID:America/New_York"):]
+	newYorkZone = newYorkZone[:strings.Index(newYorkZone, "END:VTIMEZONE")]
+	assert.Contains(t, newYorkZone, "BEGIN:DAYLIGHT\r\nDTSTART:20260308T020000\r\nTZOFFSETFROM:-0500\r\nTZOFFSETTO:-0400\r\nTZNAME:EDT\r\n")
+	assert.Equal(t, 1, strings.Count(newYorkZone, "BEGIN:DAYLIGHT"))
+	londonZone := out[strings.Index(out, "TZID:Europe/London"):]
+	londonZone = londonZone[:strings.Index(londonZone, "END:VTIMEZONE")]
+	assert.NotContains(t, londonZone, "DAYLIGHT")
+	assert.Contains(t, londonZone, "TZOFFSETTO:+0000")
+}
+
+func TestCalendar_UTC(t *testing.T) {
+	var b bytes.Buffer
+	_, err := Calendar{Events: []Event{{
+		UID:   "x@skyliner",
+		Start: time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC),
+		End:   time.Date(2026, 5, 1, 14, 0, 0, 0, time.UTC),
+	}}}.write(&b, time.Now())
+	assert.NoError(t, err)
+	assert.Contains(t, b.String(), "DTSTART:20260501T120000Z\r\n")
+	assert.NotContains(t, b.String(), "VTIMEZONE")
+}
+
+func TestFold(t *testing.T) {
+	var b bytes.Buffer
+	fold(&b, "DESCRIPTION:"+strings.Repeat("é", 80))
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n")
+	assert.Greater(t, len(lines), 1)
+	for i, line := range lines {
+		assert.LessOrEqual(t, len(line), 75)
+		if i > 0 {
+			assert.True(t, strings.HasPrefix(line, " "))
+		}
+	}
+	// Unfolding gives back the original line
+	assert.Equal(t, "DESCRIPTION:"+strings.Repeat("é", 80), strings.ReplaceAll(strings.TrimSuffix(b.String(), "\r\n"), "\r\n ", ""))
+}
@@ -0,0 +1,84 @@
+// Package mail sends plain text email to customers.
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is one email to one recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers messages.
+type Sender interface {
+	Send(msg Message) error
+}
+
+// New returns a sender for the SMTP relay at host, or one that only logs
+// messages when no host is configured.
+func New(host, port, username, password, from string) Sender {
+	if host == "" {
+		return Log{}
+	}
+	return SMTP{Addr: net.JoinHostPort(host, port), Username: username, Password: password, From: from}
+}
+
+// SMTP sends through a relay, authenticating with PLAIN when a username is
+// set.
+type SMTP struct {
+	Addr     string
+	Username string
+	Password string
+	From     string // e.g. "Skyliner <no-reply@example.com>"
+}
+
+func (s SMTP) Send(msg Message) error {
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", s.From, err)
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := net.SplitHostPort(s.Addr)
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, from.Address, []string{msg.To}, msg.Bytes(s.From, time.Now()))
+}
+
+// Log writes messages to the server log instead of sending them.
+type Log struct{}
+
+func (Log) Send(msg Message) error {
+	log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// Bytes formats the message as RFC 5322 text.
+func (m Message) Bytes(from string, date time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	body := strings.ReplaceAll(m.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if !strings.HasSuffix(body, "\n") {
+		b.WriteString("\r\n")
+	}
+	return b.Bytes()
+}
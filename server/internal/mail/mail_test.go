@@ -0,0 +1,33 @@
+package mail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageBytes(t *testing.T) {
+	msg := Message{
+		To:      "ada@example.com",
+		Subject: "Seats for BA200 – confirm",
+		Body:    "Hello Ada,\nA seat is free.",
+	}
+	date := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+
+	assert.Equal(t, "From: Skyliner <no-reply@example.com>\r\n"+
+		"To: ada@example.com\r\n"+
+		"Subject: =?utf-8?q?Seats_for_BA200_=E2=80=93_confirm?=\r\n"+
+		"Date: Sun, 01 Mar 2026 09:30:00 +0000\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"Content-Transfer-Encoding: 8bit\r\n"+
+		"\r\n"+
+		"Hello Ada,\r\nA seat is free.\r\n", string(msg.Bytes("Skyliner <no-reply@example.com>", date)))
+}
+
+func TestNew(t *testing.T) {
+	assert.Equal(t, Log{}, New("", "587", "", "", "no-reply@example.com"))
+	assert.Equal(t, SMTP{Addr: "smtp.example.com:587", Username: "user", Password: "secret", From: "no-reply@example.com"},
+		New("smtp.example.com", "587", "user", "secret", "no-reply@example.com"))
+}
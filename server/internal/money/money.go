@@ -0,0 +1,194 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrInvalidAmount    = errors.New("invalid amount")
+)
+
+// exponents lists ISO 4217 currencies whose minor unit is not 1/100.
+// Anything not listed here uses two decimal places.
+var exponents = map[string]int{
+	"BHD": 3,
+	"BIF": 0,
+	"CLP": 0,
+	"DJF": 0,
+	"GNF": 0,
+	"IQD": 3,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KMF": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"LYD": 3,
+	"OMR": 3,
+	"PYG": 0,
+	"RWF": 0,
+	"TND": 3,
+	"UGX": 0,
+	"UYI": 0,
+	"VND": 0,
+	"VUV": 0,
+	"XAF": 0,
+	"XOF": 0,
+	"XPF": 0,
+}
+
+// Exponent returns the number of decimal places used by the currency's minor unit.
+func Exponent(currency string) int {
+	if exp, ok := exponents[strings.ToUpper(currency)]; ok {
+		return exp
+	}
+	return 2
+}
+
+// NonStandardExponents returns a copy of the currencies that do not use two decimals.
+func NonStandardExponents() map[string]int {
+	out := make(map[string]int, len(exponents))
+	for code, exp := range exponents {
+		out[code] = exp
+	}
+	return out
+}
+
+// Money is an amount in the minor unit of its currency (cents for USD, yen for JPY).
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+func Zero(currency string) Money {
+	return New(0, currency)
+}
+
+// Parse converts a decimal string such as "19.99" into minor units without
+// going through floating point. More decimals than the currency allows is an error.
+func Parse(s, currency string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	exp := Exponent(currency)
+	// Only the one leading minus is a sign; ParseInt would take another
+	if !digits(whole) || !digits(frac) || whole == "" || len(frac) > exp {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	amount, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if negative {
+		amount = -amount
+	}
+	return New(amount, currency), nil
+}
+
+// FromMajor converts a major-unit float (e.g. an FX-converted value) to minor
+// units, rounding half away from zero.
+func FromMajor(value float64, currency string) Money {
+	return New(int64(math.Round(value*pow10(Exponent(currency)))), currency)
+}
+
+// Major returns the amount in major units for display and conversion only.
+func (m Money) Major() float64 {
+	return float64(m.Amount) / pow10(Exponent(m.Currency))
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	return New(m.Amount+other.Amount, m.Currency), nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	return New(m.Amount-other.Amount, m.Currency), nil
+}
+
+// Mul multiplies by a whole quantity, e.g. a per-passenger fare by party size.
+func (m Money) Mul(n int64) Money {
+	return New(m.Amount*n, m.Currency)
+}
+
+// Percent returns bps/10000 of the amount (1500 is 15%), rounded half away from zero.
+func (m Money) Percent(bps int64) Money {
+	product := m.Amount * bps
+	amount, rem := product/10000, product%10000
+	if rem >= 5000 {
+		amount++
+	} else if rem <= -5000 {
+		amount--
+	}
+	return New(amount, m.Currency)
+}
+
+func (m Money) Neg() Money {
+	return New(-m.Amount, m.Currency)
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// Sum adds amounts that must all be in the given currency.
+func Sum(currency string, amounts ...Money) (Money, error) {
+	total := Zero(currency)
+	for _, amount := range amounts {
+		var err error
+		if total, err = total.Add(amount); err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// String formats the amount with the currency's decimal places, e.g. "19.99 USD".
+func (m Money) String() string {
+	exp := Exponent(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if exp == 0 {
+		return fmt.Sprintf("%s%d %s", sign, amount, m.Currency)
+	}
+	scale := int64(pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/scale, exp, amount%scale, m.Currency)
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func pow10(exp int) float64 {
+	return math.Pow10(exp)
+}
@@ -0,0 +1,96 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponent(t *testing.T) {
+	assert.Equal(t, 2, Exponent("USD"))
+	assert.Equal(t, 2, Exponent("eur"))
+	assert.Equal(t, 0, Exponent("JPY"))
+	assert.Equal(t, 3, Exponent("KWD"))
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		currency string
+		expected int64
+		wantErr  bool
+	}{
+		{name: "cents", input: "19.99", currency: "USD", expected: 1999},
+		{name: "whole dollars", input: "20", currency: "USD", expected: 2000},
+		{name: "single decimal", input: "0.5", currency: "USD", expected: 50},
+		{name: "negative", input: "-3.10", currency: "USD", expected: -310},
+		{name: "yen", input: "15000", currency: "JPY", expected: 15000},
+		{name: "dinar", input: "1.234", currency: "KWD", expected: 1234},
+		{name: "too many decimals", input: "1.999", currency: "USD", wantErr: true},
+		{name: "decimals on yen", input: "100.5", currency: "JPY", wantErr: true},
+		{name: "garbage", input: "abc", currency: "USD", wantErr: true},
+		{name: "empty", input: "", currency: "USD", wantErr: true},
+		{name: "double minus", input: "--5.00", currency: "USD", wantErr: true},
+		{name: "minus plus", input: "-+5", currency: "USD", wantErr: true},
+		{name: "plus", input: "+5", currency: "USD", wantErr: true},
+		{name: "signed decimals", input: "5.-1", currency: "USD", wantErr: true},
+		{name: "letters in decimals", input: "5.x", currency: "USD", wantErr: true},
+		{name: "space inside", input: "- 5", currency: "USD", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Parse(tt.input, tt.currency)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidAmount)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, m.Amount)
+		})
+	}
+}
+
+func TestFromMajorRounds(t *testing.T) {
+	// 19.99 * 100 is 1998.9999999999998 in float64; truncation would lose a cent.
+	assert.Equal(t, int64(1999), FromMajor(19.99, "USD").Amount)
+	assert.Equal(t, int64(1500), FromMajor(1499.6, "JPY").Amount)
+	assert.Equal(t, int64(-1999), FromMajor(-19.99, "USD").Amount)
+}
+
+func TestArithmetic(t *testing.T) {
+	a := New(1999, "usd")
+	b := New(501, "USD")
+
+	sum, err := a.Add(b)
+	assert.NoError(t, err)
+	assert.Equal(t, New(2500, "USD"), sum)
+
+	diff, err := a.Sub(b)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1498), diff.Amount)
+
+	assert.Equal(t, int64(5997), a.Mul(3).Amount)
+	assert.True(t, a.Neg().IsNegative())
+
+	_, err = a.Add(New(100, "JPY"))
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+
+	assert.Equal(t, int64(300), a.Percent(1500).Amount)   // 299.85 rounds up
+	assert.Equal(t, int64(1999), a.Percent(10000).Amount) // 100%
+	assert.Equal(t, int64(-300), a.Neg().Percent(1500).Amount)
+	assert.Equal(t, int64(0), New(3, "USD").Percent(1000).Amount)
+
+	total, err := Sum("USD", a, b, b)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3001), total.Amount)
+}
+
+func TestString(t *testing.T) {
+	assert.Equal(t, "19.99 USD", New(1999, "USD").String())
+	assert.Equal(t, "0.05 USD", New(5, "USD").String())
+	assert.Equal(t, "-1.50 EUR", New(-150, "EUR").String())
+	assert.Equal(t, "15000 JPY", New(15000, "JPY").String())
+	assert.Equal(t, "1.005 KWD", New(1005, "KWD").String())
+}
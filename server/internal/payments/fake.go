@@ -0,0 +1,43 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FakeClient stands in for Stripe in tests and local development. Refunds
+// succeed immediately unless Err is set.
+type FakeClient struct {
+	mu      sync.Mutex
+	Err     error
+	Refunds []RefundParams
+	byKey   map[string]*Refund
+}
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{byKey: map[string]*Refund{}}
+}
+
+func (f *FakeClient) Refund(_ context.Context, params RefundParams) (*Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if r, ok := f.byKey[params.IdempotencyKey]; ok && params.IdempotencyKey != "" {
+		return r, nil
+	}
+
+	f.Refunds = append(f.Refunds, params)
+	r := &Refund{
+		ID:     fmt.Sprintf("re_fake_%d", len(f.Refunds)),
+		Status: RefundSucceeded,
+		Amount: params.Amount,
+	}
+	if params.IdempotencyKey != "" {
+		f.byKey[params.IdempotencyKey] = r
+	}
+	return r, nil
+}
@@ -0,0 +1,76 @@
+package payments
+
+import (
+	"context"
+	"strings"
+
+	"skyliner/internal/money"
+
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/refund"
+)
+
+// Refund statuses as reported by Stripe.
+const (
+	RefundPending   = "pending"
+	RefundSucceeded = "succeeded"
+	RefundFailed    = "failed"
+	RefundCanceled  = "canceled"
+)
+
+type RefundParams struct {
+	PaymentIntentID string
+	Amount          money.Money
+	// IdempotencyKey makes a retried refund safe; Stripe returns the original
+	// refund instead of issuing a second one.
+	IdempotencyKey string
+	Metadata       map[string]string
+}
+
+type Refund struct {
+	ID     string
+	Status string
+	Amount money.Money
+}
+
+// Client is the part of Stripe the booking flows depend on, so tests can run
+// without network access.
+type Client interface {
+	Refund(ctx context.Context, params RefundParams) (*Refund, error)
+}
+
+// StripeClient talks to the Stripe API.
+type StripeClient struct {
+	refunds refund.Client
+}
+
+func NewStripeClient(secretKey string) *StripeClient {
+	return &StripeClient{
+		refunds: refund.Client{B: stripe.GetBackend(stripe.APIBackend), Key: secretKey},
+	}
+}
+
+func (s *StripeClient) Refund(ctx context.Context, params RefundParams) (*Refund, error) {
+	p := &stripe.RefundParams{
+		PaymentIntent: stripe.String(params.PaymentIntentID),
+		Amount:        stripe.Int64(params.Amount.Amount),
+		Reason:        stripe.String(string(stripe.RefundReasonRequestedByCustomer)),
+	}
+	p.Context = ctx
+	if params.IdempotencyKey != "" {
+		p.SetIdempotencyKey(params.IdempotencyKey)
+	}
+	for k, v := range params.Metadata {
+		p.AddMetadata(k, v)
+	}
+
+	r, err := s.refunds.New(p)
+	if err != nil {
+		return nil, err
+	}
+	return &Refund{
+		ID:     r.ID,
+		Status: string(r.Status),
+		Amount: money.New(r.Amount, strings.ToUpper(string(r.Currency))),
+	}, nil
+}
@@ -0,0 +1,46 @@
+package pdf
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocument(t *testing.T) {
+	doc := New()
+	doc.Text(50, 800, Bold, 18, "Itinerary (receipt)")
+	doc.Line(50, 790, 545, 790)
+	doc.AddPage()
+	doc.TextRight(545, 800, Regular, 10, "Zoë \\ 東京")
+	out := string(doc.Bytes())
+
+	assert.True(t, strings.HasPrefix(out, "%PDF-1.4\n"))
+	assert.True(t, strings.HasSuffix(out, "%%EOF\n"))
+	assert.Contains(t, out, "/Count 2")
+	assert.Contains(t, out, `(Itinerary \(receipt\)) Tj`)
+	assert.Contains(t, out, `(Zo\353 \\ ??) Tj`)
+
+	// Every cross-reference entry points at the start of its object
+	xref := regexp.MustCompile(`(?m)^(\d{10}) 00000 n $`).FindAllStringSubmatch(out, -1)
+	assert.Len(t, xref, 8)
+	for i, entry := range xref {
+		offset, _ := strconv.Atoi(entry[1])
+		assert.True(t, strings.HasPrefix(out[offset:], fmt.Sprintf("%d 0 obj\n", i+1)), "object %d", i+1)
+	}
+	start := strings.Index(out, "xref\n")
+	assert.Contains(t, out, fmt.Sprintf("startxref\n%d\n", start))
+}
+
+func TestEmptyDocumentHasAPage(t *testing.T) {
+	assert.Contains(t, string(New().Bytes()), "/Count 1")
+}
+
+func TestWidth(t *testing.T) {
+	assert.InDelta(t, 5.56, Width(Regular, 10, "0"), 0.001)
+	assert.InDelta(t, 12*(722+611)/1000.0, Width(Bold, 12, "AT"), 0.001)
+	assert.Greater(t, Width(Bold, 10, "mint"), Width(Regular, 10, "mint"))
+}
@@ -0,0 +1,57 @@
+package pnr
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+// Length is the size of an airline-style record locator.
+const Length = 6
+
+// Alphabet omits characters that are easy to misread over the phone or on a
+// boarding pass: 0/O, 1/I/L.
+const Alphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// Generate returns a random record locator drawn uniformly from Alphabet.
+func Generate() (string, error) {
+	max := big.NewInt(int64(len(Alphabet)))
+	var b strings.Builder
+	b.Grow(Length)
+	for i := 0; i < Length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(Alphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// Normalize upper-cases and trims user input so lookups are case-insensitive.
+func Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// LegacyPrefix starts the locators issued before they were random: "SKY"
+// followed by the base-36 Unix time of the booking.
+const LegacyPrefix = "SKY"
+
+// Valid reports whether code has the shape of a locator we issue or once
+// issued.
+func Valid(code string) bool {
+	if len(code) == Length && only(code, Alphabet) {
+		return true
+	}
+	rest := strings.TrimPrefix(code, LegacyPrefix)
+	return rest != code && len(rest) > 0 && len(rest) <= 8 && only(rest, "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+}
+
+func only(code, alphabet string) bool {
+	for _, r := range code {
+		if !strings.ContainsRune(alphabet, r) {
+			return false
+		}
+	}
+	return true
+}
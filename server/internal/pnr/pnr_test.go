@@ -0,0 +1,35 @@
+package pnr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		code, err := Generate()
+		assert.NoError(t, err)
+		assert.Len(t, code, Length)
+		assert.True(t, Valid(code), code)
+		assert.False(t, strings.ContainsAny(code, "01ILO"), code)
+		seen[code] = true
+	}
+	// 31^6 is ~887M codes; 1000 draws colliding would point at a broken source
+	assert.Greater(t, len(seen), 995)
+}
+
+func TestNormalizeAndValid(t *testing.T) {
+	assert.Equal(t, "ABC234", Normalize(" abc234 "))
+	assert.True(t, Valid("ABC234"))
+	assert.False(t, Valid("ABC23"))
+	assert.False(t, Valid("ABC230"))
+	assert.False(t, Valid("abc234"))
+
+	assert.True(t, Valid("SKYT3K9ZQ"))
+	assert.False(t, Valid("SKY"))
+	assert.False(t, Valid("SKYt3k9zq"))
+	assert.False(t, Valid("SKY123456789"))
+}
@@ -0,0 +1,148 @@
+package promotions
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
+)
+
+// ErrNotEligible wraps every reason a booking cannot use a promotion, so
+// callers can tell a rejected code from a failure.
+var ErrNotEligible = errors.New("promo code not accepted")
+
+// Line is one booked segment as the rules see it. Amount is the fare for the
+// whole party, in the fare currency.
+type Line struct {
+	Route     string // origin and destination codes, e.g. "JFK-LAX"
+	Cabin     string
+	FareType  string
+	Departure time.Time
+	Amount    money.Money
+}
+
+// Booking is what a promotion is checked against.
+type Booking struct {
+	Lines        []Line
+	Subtotal     money.Money // everything being paid for, before the discount
+	FirstBooking bool        // the customer has no other live booking
+	Now          time.Time
+}
+
+// Discount returns what p takes off booking. Percentage discounts apply to
+// the fares of the eligible segments; fixed discounts never exceed them.
+// Usage limits are left to the caller, which has to count them atomically.
+func Discount(p models.Promotion, booking Booking) (money.Money, error) {
+	if err := checkBooking(p, booking); err != nil {
+		return money.Money{}, err
+	}
+
+	eligible := money.Zero(booking.Subtotal.Currency)
+	for _, line := range booking.Lines {
+		if !lineMatches(p, line) {
+			continue
+		}
+		var err error
+		if eligible, err = eligible.Add(line.Amount); err != nil {
+			return money.Money{}, err
+		}
+	}
+	if eligible.IsZero() {
+		return money.Money{}, notEligible("no flight on this booking qualifies")
+	}
+
+	switch p.DiscountType {
+	case models.DiscountPercent:
+		return eligible.Percent(p.Value), nil
+	case models.DiscountFixed:
+		return money.New(min(p.Value, eligible.Amount), eligible.Currency), nil
+	default:
+		return money.Money{}, fmt.Errorf("unknown discount type %q", p.DiscountType)
+	}
+}
+
+// Validate checks a promotion's own settings before it is saved.
+func Validate(p models.Promotion) error {
+	switch p.DiscountType {
+	case models.DiscountPercent:
+		if p.Value <= 0 || p.Value > 10000 {
+			return errors.New("percent discounts are given in basis points, from 1 to 10000")
+		}
+	case models.DiscountFixed:
+		if p.Value <= 0 {
+			return errors.New("fixed discounts must be positive")
+		}
+		if p.Currency == "" {
+			return errors.New("fixed discounts need a currency")
+		}
+	default:
+		return errors.New("discount_type must be percent or fixed")
+	}
+	if p.MinSpend > 0 && p.Currency == "" {
+		return errors.New("a minimum spend needs a currency")
+	}
+	if p.MinSpend < 0 || p.MaxUses < 0 || p.MaxUsesPerUser < 0 {
+		return errors.New("limits cannot be negative")
+	}
+	if p.TravelFrom != nil && p.TravelTo != nil && p.TravelTo.Before(*p.TravelFrom) {
+		return errors.New("travel_to is before travel_from")
+	}
+	if p.BookingFrom != nil && p.BookingTo != nil && p.BookingTo.Before(*p.BookingFrom) {
+		return errors.New("booking_to is before booking_from")
+	}
+	return nil
+}
+
+// NormalizeCode is how codes are stored and looked up.
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func checkBooking(p models.Promotion, booking Booking) error {
+	if !p.Active {
+		return notEligible("code is not active")
+	}
+	if p.BookingFrom != nil && booking.Now.Before(*p.BookingFrom) {
+		return notEligible("code is not valid yet")
+	}
+	if p.BookingTo != nil && booking.Now.After(*p.BookingTo) {
+		return notEligible("code has expired")
+	}
+	if p.Currency != "" && p.Currency != booking.Subtotal.Currency {
+		return notEligible("not valid for fares in " + booking.Subtotal.Currency)
+	}
+	if booking.Subtotal.Amount < p.MinSpend {
+		return notEligible("booking is below the minimum spend of " + money.New(p.MinSpend, p.Currency).String())
+	}
+	if p.FirstBooking && !booking.FirstBooking {
+		return notEligible("only valid on a first booking")
+	}
+	return nil
+}
+
+func lineMatches(p models.Promotion, line Line) bool {
+	if len(p.Routes) > 0 && !slices.ContainsFunc(p.Routes, func(r string) bool { return strings.EqualFold(r, line.Route) }) {
+		return false
+	}
+	if len(p.Cabins) > 0 && !slices.ContainsFunc(p.Cabins, func(c string) bool { return strings.EqualFold(c, line.Cabin) }) {
+		return false
+	}
+	if len(p.FareTypes) > 0 && !slices.ContainsFunc(p.FareTypes, func(f string) bool { return strings.EqualFold(f, line.FareType) }) {
+		return false
+	}
+	if p.TravelFrom != nil && line.Departure.Before(*p.TravelFrom) {
+		return false
+	}
+	if p.TravelTo != nil && line.Departure.After(*p.TravelTo) {
+		return false
+	}
+	return true
+}
+
+func notEligible(reason string) error {
+	return fmt.Errorf("%w: %s", ErrNotEligible, reason)
+}
@@ -0,0 +1,81 @@
+package promotions
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"skyliner/internal/db/models"
+	"skyliner/internal/money"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testBooking(now time.Time) Booking {
+	return Booking{
+		Lines: []Line{
+			{Route: "JFK-LHR", Cabin: "economy", FareType: "standard", Departure: now.Add(30 * 24 * time.Hour), Amount: money.New(80000, "USD")},
+			{Route: "LHR-JFK", Cabin: "business", FareType: "flexible", Departure: now.Add(37 * 24 * time.Hour), Amount: money.New(200000, "USD")},
+		},
+		Subtotal:     money.New(282500, "USD"),
+		FirstBooking: true,
+		Now:          now,
+	}
+}
+
+func TestDiscount(t *testing.T) {
+	now := time.Now()
+	booking := testBooking(now)
+
+	percent := models.Promotion{DiscountType: models.DiscountPercent, Value: 1000, Active: true}
+	discount, err := Discount(percent, booking)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(28000, "USD"), discount)
+
+	// Only the outbound economy segment qualifies
+	percent.Routes = []string{"jfk-lhr"}
+	discount, err = Discount(percent, booking)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(8000, "USD"), discount)
+
+	fixed := models.Promotion{DiscountType: models.DiscountFixed, Value: 500000, Currency: "USD", Active: true, FareTypes: []string{"standard"}}
+	discount, err = Discount(fixed, booking)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(80000, "USD"), discount, "capped at the eligible fares")
+}
+
+func TestDiscountNotEligible(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	base := models.Promotion{DiscountType: models.DiscountPercent, Value: 1000, Active: true}
+
+	tests := map[string]func(p *models.Promotion, b *Booking){
+		"inactive":      func(p *models.Promotion, b *Booking) { p.Active = false },
+		"not started":   func(p *models.Promotion, b *Booking) { p.BookingFrom = &future },
+		"expired":       func(p *models.Promotion, b *Booking) { p.BookingTo = &past },
+		"currency":      func(p *models.Promotion, b *Booking) { p.Currency = "EUR" },
+		"min spend":     func(p *models.Promotion, b *Booking) { p.Currency, p.MinSpend = "USD", 300000 },
+		"first booking": func(p *models.Promotion, b *Booking) { p.FirstBooking, b.FirstBooking = true, false },
+		"cabin":         func(p *models.Promotion, b *Booking) { p.Cabins = []string{"first"} },
+		"travel window": func(p *models.Promotion, b *Booking) { p.TravelTo = &future },
+		"route and type": func(p *models.Promotion, b *Booking) {
+			p.Routes, p.FareTypes = []string{"JFK-LHR"}, []string{"flexible"}
+		},
+	}
+	for name, setup := range tests {
+		p, b := base, testBooking(now)
+		setup(&p, &b)
+		_, err := Discount(p, b)
+		assert.True(t, errors.Is(err, ErrNotEligible), name)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(models.Promotion{DiscountType: models.DiscountPercent, Value: 2500}))
+	assert.NoError(t, Validate(models.Promotion{DiscountType: models.DiscountFixed, Value: 5000, Currency: "USD"}))
+
+	assert.Error(t, Validate(models.Promotion{DiscountType: models.DiscountPercent, Value: 20000}))
+	assert.Error(t, Validate(models.Promotion{DiscountType: models.DiscountFixed, Value: 5000}))
+	assert.Error(t, Validate(models.Promotion{DiscountType: "bogo", Value: 1}))
+	assert.Error(t, Validate(models.Promotion{DiscountType: models.DiscountPercent, Value: 1000, MinSpend: 100}))
+}
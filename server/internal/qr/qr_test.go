@@ -0,0 +1,149 @@
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The worked example in ISO/IEC 18004 annex I: "01234567" at 1-M.
+func TestReedSolomon(t *testing.T) {
+	data := []byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11}
+	ecc := rsRemainder(data, rsDivisor(10))
+	assert.Equal(t, []byte{0xA5, 0x24, 0xD4, 0xC1, 0xED, 0x36, 0xC7, 0x87, 0x2C, 0x55}, ecc)
+}
+
+func TestFormatBits(t *testing.T) {
+	// Level M with mask 5 is 100000011001110 in the standard's table
+	assert.Equal(t, 0b100000011001110, formatBits(5))
+}
+
+func TestEncodeReadsBack(t *testing.T) {
+	for _, payload := range []string{
+		"HELLO",
+		"M1LOVELACE/ADA        EABC234 JFKLHRBA 0200 325Y012A0001 100",
+		strings.Repeat("M1BABBAGE/CHARLES", 12),
+	} {
+		code, err := Encode([]byte(payload))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, 17+4*code.Version, code.Size)
+		assert.Equal(t, payload, string(readBack(t, code)), "version %d mask %d", code.Version, code.Mask)
+	}
+
+	_, err := Encode(make([]byte, 214))
+	assert.ErrorIs(t, err, ErrTooLong)
+}
+
+func TestImage(t *testing.T) {
+	code, err := Encode([]byte("HELLO"))
+	assert.NoError(t, err)
+	img := code.Image(4)
+	assert.Equal(t, (21+8)*4, img.Bounds().Dx())
+
+	// Top left finder corner is dark, the quiet zone light
+	r, _, _, _ := img.At(16, 16).RGBA()
+	assert.Zero(t, r)
+	r, _, _, _ = img.At(15, 15).RGBA()
+	assert.Equal(t, uint32(0xFFFF), r)
+
+	var out bytes.Buffer
+	assert.NoError(t, png.Encode(&out, img))
+}
+
+// readBack decodes a symbol the way a scanner would once it has found it:
+// read the format information, unmask, collect the codewords, check the error
+// correction of each block and parse the byte-mode segment.
+func readBack(t *testing.T, code *Code) []byte {
+	format := 0
+	for i := 0; i <= 5; i++ {
+		format |= b2i(code.Dark(i, 8)) << i
+	}
+	format |= b2i(code.Dark(7, 8))<<6 | b2i(code.Dark(8, 8))<<7 | b2i(code.Dark(8, 7))<<8
+	for i := 9; i < 15; i++ {
+		format |= b2i(code.Dark(8, 14-i)) << i
+	}
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if formatBits(m) == format {
+			mask = m
+		}
+	}
+	if !assert.NotEqual(t, -1, mask, "format information") {
+		return nil
+	}
+
+	reserved := newCode(code.Version)
+	reserved.drawFunctionPatterns()
+	var bits []bool
+	for right := code.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < code.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = code.Size - 1 - vert
+				}
+				if !reserved.function[y][x] {
+					bits = append(bits, code.Dark(y, x) != masked(mask, x, y))
+				}
+			}
+		}
+	}
+	var codewords []byte
+	for i := 0; i+8 <= len(bits); i += 8 {
+		var b byte
+		for _, bit := range bits[i : i+8] {
+			b = b<<1 | byte(b2i(bit))
+		}
+		codewords = append(codewords, b)
+	}
+
+	// Undo the interleaving and check each block
+	spec := levelM[code.Version]
+	n := spec.shortN + spec.longN
+	blocks := make([][]byte, n)
+	k := 0
+	for i := 0; i <= spec.shortData; i++ {
+		for j := 0; j < n; j++ {
+			if i < spec.shortData || j >= spec.shortN {
+				blocks[j] = append(blocks[j], codewords[k])
+				k++
+			}
+		}
+	}
+	var data []byte
+	for j := 0; j < n; j++ {
+		ecc := make([]byte, spec.ecc)
+		for i := range ecc {
+			ecc[i] = codewords[k+i*n+j]
+		}
+		assert.Equal(t, rsRemainder(blocks[j], rsDivisor(spec.ecc)), ecc, "block %d", j)
+		data = append(data, blocks[j]...)
+	}
+
+	assert.Equal(t, byte(0x4), data[0]>>4, "byte mode")
+	count := countBits(code.Version)
+	var stream bitBuffer
+	for _, b := range data {
+		stream.append(int(b), 8)
+	}
+	length := 0
+	for _, bit := range stream[4 : 4+count] {
+		length = length<<1 | b2i(bit)
+	}
+	return stream[4+count : 4+count+8*length].bytes()
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
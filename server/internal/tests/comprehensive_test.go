@@ -9,7 +9,9 @@ import (
 
 	"skyliner/internal/config"
 	"skyliner/internal/db/models"
+	"skyliner/internal/fx"
 	httpRouter "skyliner/internal/http"
+	"skyliner/internal/payments"
 	"skyliner/internal/ws"
 
 	"github.com/gin-gonic/gin"
@@ -41,12 +43,41 @@ func setupTestRouter() *gin.Engine {
 		&models.Fare{},
 		&models.SeatMap{},
 		&models.Seat{},
+		&models.SSRType{},
+		&models.AirlineSSR{},
+		&models.Ancillary{},
 		&models.Booking{},
 		&models.Itinerary{},
 		&models.Segment{},
+		&models.SeatAssignment{},
+		&models.Exchange{},
+		&models.ExchangeSegment{},
 		&models.Passenger{},
+		&models.APISRecord{},
+		&models.SSRRequest{},
+		&models.AncillaryPurchase{},
+		&models.Ticket{},
+		&models.Coupon{},
+		&models.BoardingPass{},
+		&models.GroupTerms{},
+		&models.GroupInstallment{},
+		&models.BookingSplit{},
+		&models.WaitlistEntry{},
+		&models.BookingRemark{},
+		&models.QueueItem{},
 		&models.Payment{},
+		&models.PaymentAllocation{},
+		&models.Refund{},
+		&models.Credit{},
+		&models.CreditTransaction{},
+		&models.Promotion{},
+		&models.PromotionRedemption{},
+		&models.PromotionUsage{},
+		&models.Waiver{},
+		&models.RepriceJob{},
+		&models.RepriceJobItem{},
 		&models.Baggage{},
+		&models.IdempotencyKey{},
 	)
 	if err != nil {
 		panic("Failed to migrate test database")
@@ -64,7 +95,7 @@ func setupTestRouter() *gin.Engine {
 
 	// Setup router
 	router := gin.Default()
-	httpRouter.SetupRoutes(router, testDB, hub, cfg)
+	httpRouter.SetupRoutes(router, testDB, hub, fx.Default(), payments.NewFakeClient(), cfg)
 
 	return router
 }
@@ -0,0 +1,52 @@
+package ticketing
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Length is the size of an e-ticket number: a 3-digit airline prefix, a
+// 9-digit serial and a check digit.
+const Length = 13
+
+// MaxSerial is the largest serial an airline can issue.
+const MaxSerial = 999999999
+
+// Number formats the ticket number for an airline's serial. The check digit is
+// the serial modulo seven; as on IATA documents, the airline prefix takes no
+// part in it.
+func Number(prefix string, serial int64) (string, error) {
+	if !digits(prefix, 3) {
+		return "", fmt.Errorf("airline prefix %q is not three digits", prefix)
+	}
+	if serial < 1 || serial > MaxSerial {
+		return "", fmt.Errorf("serial %d is out of range", serial)
+	}
+	return fmt.Sprintf("%s%09d%s", prefix, serial, checkDigit(fmt.Sprintf("%09d", serial))), nil
+}
+
+// Valid reports whether number has the shape and check digit of a ticket we issue.
+func Valid(number string) bool {
+	if !digits(number, Length) {
+		return false
+	}
+	return checkDigit(number[3:Length-1]) == number[Length-1:]
+}
+
+// checkDigit is the serial, without the airline prefix, modulo seven.
+func checkDigit(serial string) string {
+	n, _ := strconv.ParseUint(serial, 10, 64)
+	return strconv.FormatUint(n%7, 10)
+}
+
+func digits(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
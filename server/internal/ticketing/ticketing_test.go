@@ -0,0 +1,46 @@
+package ticketing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumber(t *testing.T) {
+	number, err := Number("125", 1)
+	assert.NoError(t, err)
+	// 000000001 % 7 == 1; the prefix does not count
+	assert.Equal(t, "1250000000011", number)
+	assert.Len(t, number, Length)
+	assert.True(t, Valid(number))
+
+	// 123456789 % 7 == 1, whichever airline issues it
+	for _, prefix := range []string{"125", "001", "176"} {
+		number, err = Number(prefix, 123456789)
+		assert.NoError(t, err)
+		assert.Equal(t, prefix+"1234567891", number)
+		assert.True(t, Valid(number))
+	}
+
+	// 999999999 % 7 == 5
+	number, err = Number("001", MaxSerial)
+	assert.NoError(t, err)
+	assert.Equal(t, "0019999999995", number)
+	assert.True(t, Valid(number))
+
+	_, err = Number("12", 1)
+	assert.Error(t, err)
+	_, err = Number("BA1", 1)
+	assert.Error(t, err)
+	_, err = Number("125", 0)
+	assert.Error(t, err)
+	_, err = Number("125", MaxSerial+1)
+	assert.Error(t, err)
+}
+
+func TestValid(t *testing.T) {
+	assert.False(t, Valid("1250000000013"), "wrong check digit")
+	assert.False(t, Valid("1250000000012"), "check digit taken over the airline prefix too")
+	assert.False(t, Valid("125000000001"), "too short")
+	assert.False(t, Valid("125000000001A"))
+}
@@ -12,6 +12,7 @@ const (
 	EventPriceTick     EventType = "priceTick"
 	EventSeatUpdate    EventType = "seatUpdate"
 	EventBookingStatus EventType = "bookingStatus"
+	EventWaitlistOffer EventType = "waitlistOffer"
 )
 
 type Event struct {
@@ -22,12 +23,12 @@ type Event struct {
 }
 
 type PriceTickData struct {
-	SearchHash string  `json:"search_hash"`
-	FlightID   uint    `json:"flight_id"`
-	FareID     uint    `json:"fare_id"`
-	OldPrice   float64 `json:"old_price"`
-	NewPrice   float64 `json:"new_price"`
-	Currency   string  `json:"currency"`
+	SearchHash string `json:"search_hash"`
+	FlightID   uint   `json:"flight_id"`
+	FareID     uint   `json:"fare_id"`
+	OldPrice   int64  `json:"old_price"` // minor units of Currency
+	NewPrice   int64  `json:"new_price"`
+	Currency   string `json:"currency"`
 }
 
 type SeatUpdateData struct {
@@ -46,7 +47,15 @@ type BookingStatusData struct {
 	Message   string `json:"message,omitempty"`
 }
 
-func NewPriceTickEvent(searchHash string, flightID, fareID uint, oldPrice, newPrice float64, currency string) *Event {
+type WaitlistOfferData struct {
+	EntryID   uint      `json:"entry_id"`
+	FlightID  uint      `json:"flight_id"`
+	FareID    uint      `json:"fare_id"`
+	Seats     int       `json:"seats"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func NewPriceTickEvent(searchHash string, flightID, fareID uint, oldPrice, newPrice int64, currency string) *Event {
 	return &Event{
 		Type:    EventPriceTick,
 		Channel: "priceTick:" + searchHash,
@@ -65,7 +74,7 @@ func NewPriceTickEvent(searchHash string, flightID, fareID uint, oldPrice, newPr
 func NewSeatUpdateEvent(flightID, seatID uint, status, row, column, class string) *Event {
 	return &Event{
 		Type:    EventSeatUpdate,
-		Channel: "seatUpdate:" + string(rune(flightID)),
+		Channel: "seatUpdate:" + strconv.FormatUint(uint64(flightID), 10),
 		Data: SeatUpdateData{
 			FlightID: flightID,
 			SeatID:   seatID,
@@ -81,7 +90,7 @@ func NewSeatUpdateEvent(flightID, seatID uint, status, row, column, class string
 func NewBookingStatusEvent(bookingID uint, pnr, status, message string) *Event {
 	return &Event{
 		Type:    EventBookingStatus,
-		Channel: "bookingStatus:" + string(rune(bookingID)),
+		Channel: "bookingStatus:" + strconv.FormatUint(uint64(bookingID), 10),
 		Data: BookingStatusData{
 			BookingID: bookingID,
 			PNR:       pnr,
@@ -92,6 +101,23 @@ func NewBookingStatusEvent(bookingID uint, pnr, status, message string) *Event {
 	}
 }
 
+// NewWaitlistOfferEvent is sent on the customer's channel when seats they
+// waited for are held for them.
+func NewWaitlistOfferEvent(userID, entryID, flightID, fareID uint, seats int, expiresAt time.Time) *Event {
+	return &Event{
+		Type:    EventWaitlistOffer,
+		Channel: "waitlistOffer:" + strconv.FormatUint(uint64(userID), 10),
+		Data: WaitlistOfferData{
+			EntryID:   entryID,
+			FlightID:  flightID,
+			FareID:    fareID,
+			Seats:     seats,
+			ExpiresAt: expiresAt,
+		},
+		Timestamp: time.Now(),
+	}
+}
+
 func (e *Event) ToJSON() ([]byte, error) {
 	return json.Marshal(e)
 }
@@ -19,6 +19,12 @@ type Hub struct {
 	register   chan *Client
 	unregister chan *Client
 	broadcast  chan []byte
+	publish    chan channelMessage
+}
+
+type channelMessage struct {
+	channel string
+	data    []byte
 }
 
 type Client struct {
@@ -34,6 +40,7 @@ func NewHub() *Hub {
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		broadcast:  make(chan []byte),
+		publish:    make(chan channelMessage, 256),
 	}
 }
 
@@ -51,6 +58,9 @@ func (h *Hub) Run() {
 				log.Printf("Client disconnected. Total clients: %d", len(h.clients))
 			}
 
+		case message := <-h.publish:
+			h.BroadcastToChannel(message.channel, message.data)
+
 		case message := <-h.broadcast:
 			for client := range h.clients {
 				select {
@@ -80,6 +90,17 @@ func (h *Hub) BroadcastToChannel(channel string, message []byte) {
 	}
 }
 
+// Publish queues an event for the clients subscribed to its channel. Unlike
+// BroadcastToChannel it is safe to call from any goroutine.
+func (h *Hub) Publish(event *Event) {
+	data, err := event.ToJSON()
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event.Type, err)
+		return
+	}
+	h.publish <- channelMessage{channel: event.Channel, data: data}
+}
+
 func HandleWebSocket(c *gin.Context, hub *Hub) {
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -55,6 +55,26 @@ func TestBroadcastToChannel(t *testing.T) {
 	t.Skip("Skipping TestBroadcastToChannel due to race conditions")
 }
 
+func TestHubPublish(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	subscribed := &Client{hub: hub, send: make(chan []byte, 1), channels: []string{"bookingStatus:42"}}
+	other := &Client{hub: hub, send: make(chan []byte, 1), channels: []string{"bookingStatus:7"}}
+	hub.register <- subscribed
+	hub.register <- other
+
+	hub.Publish(NewBookingStatusEvent(42, "ABC234", "hold", "Price updated"))
+
+	select {
+	case message := <-subscribed.send:
+		assert.Contains(t, string(message), `"channel":"bookingStatus:42"`)
+	case <-time.After(time.Second):
+		t.Fatal("subscribed client did not receive the event")
+	}
+	assert.Empty(t, other.send)
+}
+
 func TestClientSubscribe(t *testing.T) {
 	client := &Client{
 		hub:      nil,